@@ -0,0 +1,200 @@
+package weiroll
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DelegateCallTargets returns the distinct addresses the plan invokes via
+// DELEGATECALL, in order of first use. Commands of nested subplans are
+// included, since they also execute in the VM's context.
+func (p *Planner) DelegateCallTargets() []common.Address {
+	seen := make(map[common.Address]bool)
+	var targets []common.Address
+
+	p.walkCommands(func(cmd *Command) {
+		if cmd.call.flags.CallType() != FlagDelegateCall {
+			return
+		}
+		addr := cmd.call.contract.Address()
+		if !seen[addr] {
+			seen[addr] = true
+			targets = append(targets, addr)
+		}
+	})
+
+	return targets
+}
+
+// MinimalABI returns, per contract address, an ABI containing only the
+// methods the plan invokes, including those in nested subplans.
+// Dynamic contracts have no fixed address and are omitted.
+func (p *Planner) MinimalABI() map[common.Address]abi.ABI {
+	result := make(map[common.Address]abi.ABI)
+
+	p.walkCommands(func(cmd *Command) {
+		contract := cmd.call.contract
+		if contract.IsDynamic() {
+			return
+		}
+		addr := contract.Address()
+		minimal, ok := result[addr]
+		if !ok {
+			minimal = abi.ABI{Methods: make(map[string]abi.Method)}
+		}
+		minimal.Methods[cmd.call.method.Name] = cmd.call.method
+		result[addr] = minimal
+	})
+
+	return result
+}
+
+// UntrustedCalls returns the indices of commands that call an Untrusted
+// contract. A command running a subplan is included if any command in the
+// subplan, or in subplans nested within it, calls an untrusted contract.
+func (p *Planner) UntrustedCalls() []int {
+	var indices []int
+	for i, cmd := range p.commands {
+		untrusted := false
+		single := &Planner{commands: []*Command{cmd}}
+		single.walkCommands(func(c *Command) {
+			if c.call.contract.trust == Untrusted {
+				untrusted = true
+			}
+		})
+		if untrusted {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// CheckAllowlist verifies that every command, including those of nested
+// subplans, calls an allowed contract and method. allowed maps each address
+// to its permitted methods, given by name or signature; an empty list allows
+// any method. DELEGATECALL targets are held to a stricter rule: their
+// methods must be listed explicitly, since delegated code runs with the VM's
+// storage. Dynamic contracts are rejected, as their target is unknown.
+// Returns an error wrapping ErrCallNotAllowed for the first violation.
+func (p *Planner) CheckAllowlist(allowed map[common.Address][]string) error {
+	var err error
+	p.walkCommands(func(cmd *Command) {
+		if err == nil {
+			err = checkAllowed(cmd.call, allowed)
+		}
+	})
+	return err
+}
+
+// checkAllowed checks a single call against an allowlist.
+func checkAllowed(call *Call, allowed map[common.Address][]string) error {
+	method := call.method
+	if call.contract.IsDynamic() {
+		return fmt.Errorf("%w: %s on dynamic contract", ErrCallNotAllowed, method.Sig)
+	}
+
+	addr := call.contract.Address()
+	methods, ok := allowed[addr]
+	if !ok {
+		return fmt.Errorf("%w: contract %s", ErrCallNotAllowed, addr.Hex())
+	}
+
+	if len(methods) == 0 {
+		if call.flags.CallType() == FlagDelegateCall {
+			return fmt.Errorf("%w: delegatecall to %s requires listed methods", ErrCallNotAllowed, addr.Hex())
+		}
+		return nil
+	}
+	for _, m := range methods {
+		if m == method.Name || m == method.Sig {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s on %s", ErrCallNotAllowed, method.Sig, addr.Hex())
+}
+
+// RequiresAtomicity reports whether the plan has a flash-loan structure whose
+// correctness depends on operations completing inside a callback: a subplan,
+// or a call marked with Call.Repays. See CheckAtomicity.
+func (p *Planner) RequiresAtomicity() bool {
+	requires := false
+	p.walkCommands(func(cmd *Command) {
+		requires = requires || cmd.cmdType == CommandTypeSubplan || cmd.call.repays
+	})
+	return requires
+}
+
+// CheckAtomicity checks that flash-loan plans are structured so the borrow
+// wraps the operations that depend on it. The VM runs a plan atomically,
+// but a loan must be repaid within the lender's callback, so repayments
+// (calls marked with Call.Repays) must be inside the subplan that runs there,
+// and that subplan must not be empty. Returns nil for a well-formed plan, or
+// PlanErrors wrapping ErrNotAtomic for each problem, joined with errors.Join.
+func (p *Planner) CheckAtomicity() error {
+	var errs []error
+	for i, cmd := range p.commands {
+		if cmd.call.repays {
+			errs = append(errs, cmd.planError(i,
+				fmt.Errorf("%w: repayment outside a subplan runs after the loan callback returns", ErrNotAtomic)))
+		}
+		if cmd.cmdType != CommandTypeSubplan {
+			continue
+		}
+		for _, arg := range cmd.call.Args() {
+			if sub, ok := arg.(*SubplanValue); ok && sub.subplanner != nil && sub.subplanner.Len() == 0 {
+				errs = append(errs, cmd.planError(i,
+					fmt.Errorf("%w: subplan is empty, so nothing runs inside the callback", ErrNotAtomic)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// walkCommands calls fn for every command in the planner, descending into
+// subplans passed as arguments. Each planner is visited at most once.
+func (p *Planner) walkCommands(fn func(*Command)) {
+	visited := make(map[*Planner]bool)
+
+	var walk func(*Planner)
+	walk = func(pl *Planner) {
+		if pl == nil || visited[pl] {
+			return
+		}
+		visited[pl] = true
+		for _, cmd := range pl.commands {
+			fn(cmd)
+			for _, arg := range cmd.call.Args() {
+				if sub, ok := arg.(*SubplanValue); ok {
+					walk(sub.subplanner)
+				}
+			}
+		}
+	}
+	walk(p)
+}
+
+// walkTopLevel is like walkCommands but also passes fn the index of the
+// top-level command each command belongs to: its own index, or for a
+// command of a nested subplan, that of the command executing the subplan.
+func (p *Planner) walkTopLevel(fn func(index int, cmd *Command)) {
+	visited := make(map[*Planner]bool)
+
+	var walk func(index int, cmd *Command)
+	walk = func(index int, cmd *Command) {
+		fn(index, cmd)
+		for _, arg := range cmd.call.Args() {
+			if sub, ok := arg.(*SubplanValue); ok && !visited[sub.subplanner] {
+				visited[sub.subplanner] = true
+				for _, subCmd := range sub.subplanner.commands {
+					walk(index, subCmd)
+				}
+			}
+		}
+	}
+	for i, cmd := range p.commands {
+		walk(i, cmd)
+	}
+}
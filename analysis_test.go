@@ -0,0 +1,314 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDelegateCallTargets(t *testing.T) {
+	testABI := plannerTestABI()
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	extAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	lib := NewLibrary(libAddr, testABI)
+	ext := NewContract(extAddr, testABI)
+
+	t.Run("returns only library addresses", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(ext.MustInvoke("multiply", sum, big.NewInt(3)))
+		p.Add(lib.MustInvoke("noReturn", sum))
+
+		targets := p.DelegateCallTargets()
+		if len(targets) != 1 {
+			t.Fatalf("Expected 1 target, got %d", len(targets))
+		}
+		if targets[0] != libAddr {
+			t.Errorf("Expected %s, got %s", libAddr.Hex(), targets[0].Hex())
+		}
+	})
+
+	t.Run("includes subplan commands", func(t *testing.T) {
+		otherLibAddr := common.HexToAddress("0x3333333333333333333333333333333333333333")
+		sub := New()
+		sub.Add(NewLibrary(otherLibAddr, testABI).MustInvoke("noReturn", big.NewInt(1)))
+
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		if _, err := p.AddSubplan(ext.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		targets := p.DelegateCallTargets()
+		if len(targets) != 2 || targets[0] != libAddr || targets[1] != otherLibAddr {
+			t.Errorf("Expected [%s %s], got %v", libAddr.Hex(), otherLibAddr.Hex(), targets)
+		}
+	})
+
+	t.Run("empty for external-only plan", func(t *testing.T) {
+		p := New()
+		p.Add(ext.MustInvoke("noReturn", big.NewInt(1)))
+
+		if targets := p.DelegateCallTargets(); len(targets) != 0 {
+			t.Errorf("Expected no targets, got %v", targets)
+		}
+	})
+}
+
+func TestMinimalABI(t *testing.T) {
+	tokenAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	token := NewContract(tokenAddr, MustParseABI(testABIJSON))
+	lib := NewLibrary(libAddr, plannerTestABI())
+
+	t.Run("keeps only invoked methods", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(1)))
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(2)))
+
+		minimal := p.MinimalABI()
+		if len(minimal) != 1 {
+			t.Fatalf("Expected 1 contract, got %d", len(minimal))
+		}
+		tokenABI, ok := minimal[tokenAddr]
+		if !ok {
+			t.Fatal("Expected entry for token address")
+		}
+		if len(tokenABI.Methods) != 1 {
+			t.Fatalf("Expected 1 method, got %d", len(tokenABI.Methods))
+		}
+		method, ok := tokenABI.Methods["transfer"]
+		if !ok {
+			t.Fatal("Expected transfer method")
+		}
+		if method.Sig != "transfer(address,uint256)" {
+			t.Errorf("Unexpected signature %q", method.Sig)
+		}
+	})
+
+	t.Run("groups methods per contract", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+		p.Add(token.MustInvoke("getValue"))
+
+		minimal := p.MinimalABI()
+		if len(minimal) != 2 {
+			t.Fatalf("Expected 2 contracts, got %d", len(minimal))
+		}
+		if len(minimal[libAddr].Methods) != 2 {
+			t.Errorf("Expected 2 library methods, got %d", len(minimal[libAddr].Methods))
+		}
+		if _, ok := minimal[tokenAddr].Methods["getValue"]; !ok {
+			t.Error("Expected getValue method for token")
+		}
+	})
+}
+
+func TestUntrustedCalls(t *testing.T) {
+	testABI := plannerTestABI()
+	trusted := NewContract(common.HexToAddress("0x1111111111111111111111111111111111111111"), testABI)
+	untrusted := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), testABI, WithTrustLevel(Untrusted))
+	untrustedLib := NewLibrary(common.HexToAddress("0x3333333333333333333333333333333333333333"), testABI, WithTrustLevel(Untrusted))
+
+	newPlanner := func() *Planner {
+		p := New()
+		p.Add(trusted.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(untrusted.MustInvoke("noReturn", big.NewInt(2)))
+		return p
+	}
+
+	t.Run("lists untrusted targets", func(t *testing.T) {
+		calls := newPlanner().UntrustedCalls()
+		if len(calls) != 1 || calls[0] != 1 {
+			t.Errorf("Expected [1], got %v", calls)
+		}
+	})
+
+	t.Run("includes subplans with untrusted calls", func(t *testing.T) {
+		sub := New()
+		sub.Add(untrusted.MustInvoke("noReturn", big.NewInt(1)))
+
+		p := New()
+		p.Add(trusted.MustInvoke("noReturn", big.NewInt(1)))
+		if _, err := p.AddSubplan(trusted.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		calls := p.UntrustedCalls()
+		if len(calls) != 1 || calls[0] != 1 {
+			t.Errorf("Expected [1], got %v", calls)
+		}
+	})
+
+	t.Run("require trusted allows untrusted CALL", func(t *testing.T) {
+		if _, err := newPlanner().Plan(WithRequireTrusted()); err != nil {
+			t.Errorf("Expected plan to compile, got %v", err)
+		}
+	})
+
+	t.Run("require trusted rejects untrusted DELEGATECALL", func(t *testing.T) {
+		p := newPlanner()
+		p.Add(untrustedLib.MustInvoke("noReturn", big.NewInt(3)))
+
+		_, err := p.Plan(WithRequireTrusted())
+		if !errors.Is(err, ErrUntrustedDelegateCall) {
+			t.Fatalf("Expected ErrUntrustedDelegateCall, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 2 {
+			t.Errorf("Expected PlanError for command 2, got %v", err)
+		}
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Expected plan to compile without the option, got %v", err)
+		}
+	})
+}
+
+func TestCheckAllowlist(t *testing.T) {
+	testABI := plannerTestABI()
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	extAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	lib := NewLibrary(libAddr, testABI)
+	ext := NewContract(extAddr, testABI)
+
+	newPlanner := func() *Planner {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(ext.MustInvoke("multiply", sum, big.NewInt(3)))
+		return p
+	}
+
+	t.Run("allowed calls pass", func(t *testing.T) {
+		allowed := map[common.Address][]string{
+			libAddr: {"add"},
+			extAddr: nil,
+		}
+		if err := newPlanner().CheckAllowlist(allowed); err != nil {
+			t.Errorf("Expected plan to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("methods match by signature", func(t *testing.T) {
+		allowed := map[common.Address][]string{
+			libAddr: {"add(uint256,uint256)"},
+			extAddr: {"multiply(uint256,uint256)"},
+		}
+		if err := newPlanner().CheckAllowlist(allowed); err != nil {
+			t.Errorf("Expected plan to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("disallowed address", func(t *testing.T) {
+		err := newPlanner().CheckAllowlist(map[common.Address][]string{libAddr: {"add"}})
+		if !errors.Is(err, ErrCallNotAllowed) {
+			t.Errorf("Expected ErrCallNotAllowed, got %v", err)
+		}
+	})
+
+	t.Run("disallowed method", func(t *testing.T) {
+		allowed := map[common.Address][]string{
+			libAddr: {"add"},
+			extAddr: {"add"},
+		}
+		err := newPlanner().CheckAllowlist(allowed)
+		if !errors.Is(err, ErrCallNotAllowed) {
+			t.Errorf("Expected ErrCallNotAllowed, got %v", err)
+		}
+	})
+
+	t.Run("delegatecall needs listed methods", func(t *testing.T) {
+		allowed := map[common.Address][]string{
+			libAddr: nil,
+			extAddr: nil,
+		}
+		err := newPlanner().CheckAllowlist(allowed)
+		if !errors.Is(err, ErrCallNotAllowed) {
+			t.Errorf("Expected ErrCallNotAllowed, got %v", err)
+		}
+	})
+
+	t.Run("checks subplan commands", func(t *testing.T) {
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p := New()
+		if _, err := p.AddSubplan(ext.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		err := p.CheckAllowlist(map[common.Address][]string{libAddr: {"add"}, extAddr: nil})
+		if !errors.Is(err, ErrCallNotAllowed) {
+			t.Errorf("Expected ErrCallNotAllowed, got %v", err)
+		}
+	})
+}
+
+func TestPlannerCheckAtomicity(t *testing.T) {
+	plannerABI := plannerTestABI()
+	lender := NewContract(common.HexToAddress("0x1111111111111111111111111111111111111111"), plannerABI)
+	lib := NewLibrary(common.HexToAddress("0x2222222222222222222222222222222222222222"), plannerABI)
+
+	t.Run("well-formed flash loan passes", func(t *testing.T) {
+		p := New()
+		sub := New()
+		profit := sub.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		sub.Add(lib.MustInvoke("noReturn", profit).Repays())
+		if _, err := p.AddSubplan(lender.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if !p.RequiresAtomicity() {
+			t.Error("Expected flash-loan plan to require atomicity")
+		}
+		if err := p.CheckAtomicity(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("repayment outside the subplan is reported", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if _, err := p.AddSubplan(lender.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(3)).Repays())
+
+		err := p.CheckAtomicity()
+		if !errors.Is(err, ErrNotAtomic) {
+			t.Fatalf("Expected ErrNotAtomic, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 {
+			t.Errorf("Expected PlanError for command 1, got %v", err)
+		}
+	})
+
+	t.Run("empty subplan is reported", func(t *testing.T) {
+		p := New()
+		sub := New()
+		if _, err := p.AddSubplan(lender.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if err := p.CheckAtomicity(); !errors.Is(err, ErrNotAtomic) {
+			t.Errorf("Expected ErrNotAtomic, got %v", err)
+		}
+	})
+
+	t.Run("plain plans need no atomicity", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		if p.RequiresAtomicity() {
+			t.Error("Expected plain plan not to require atomicity")
+		}
+		if err := p.CheckAtomicity(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
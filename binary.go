@@ -0,0 +1,95 @@
+package weiroll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// binaryPlanVersion is the first byte of the MarshalBinary format.
+const binaryPlanVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler with a compact format
+// for storage: a version byte, the command count, each command, the state
+// count and each state entry, with counts and lengths as uvarints.
+func (cp *CompiledPlan) MarshalBinary() ([]byte, error) {
+	size := 1 + 2*binary.MaxVarintLen64
+	for _, cmd := range cp.Commands {
+		size += binary.MaxVarintLen64 + len(cmd)
+	}
+	for _, s := range cp.State {
+		size += binary.MaxVarintLen64 + len(s)
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, binaryPlanVersion)
+	data = appendEntries(data, cp.Commands)
+	data = appendEntries(data, cp.State)
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, decoding the
+// format written by MarshalBinary. It copies data, replacing the plan's
+// commands and state. Malformed input returns an error wrapping
+// ErrInvalidBinaryPlan and leaves the plan unchanged.
+func (cp *CompiledPlan) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != binaryPlanVersion {
+		return fmt.Errorf("%w: unsupported version", ErrInvalidBinaryPlan)
+	}
+	rest := data[1:]
+
+	commands, rest, err := readEntries(rest)
+	if err != nil {
+		return fmt.Errorf("%w: commands: %w", ErrInvalidBinaryPlan, err)
+	}
+	state, rest, err := readEntries(rest)
+	if err != nil {
+		return fmt.Errorf("%w: state: %w", ErrInvalidBinaryPlan, err)
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidBinaryPlan, len(rest))
+	}
+
+	cp.Commands = commands
+	cp.State = state
+	return nil
+}
+
+// appendEntries appends a count followed by each length-prefixed entry.
+func appendEntries(data []byte, entries [][]byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(entries)))
+	for _, e := range entries {
+		data = binary.AppendUvarint(data, uint64(len(e)))
+		data = append(data, e...)
+	}
+	return data
+}
+
+// readEntries reads entries written by appendEntries, copying each one,
+// and returns the remaining data.
+func readEntries(data []byte) ([][]byte, []byte, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, nil, errors.New("invalid count")
+	}
+	data = data[n:]
+	// Each entry takes at least one byte, which bounds the allocation.
+	if count > uint64(len(data)) {
+		return nil, nil, fmt.Errorf("count %d exceeds remaining %d bytes", count, len(data))
+	}
+
+	entries := make([][]byte, count)
+	for i := range entries {
+		length, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("entry %d: invalid length", i)
+		}
+		data = data[n:]
+		if length > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("entry %d: length %d exceeds remaining %d bytes", i, length, len(data))
+		}
+		entries[i] = append([]byte{}, data[:length]...)
+		data = data[length:]
+	}
+	return entries, data, nil
+}
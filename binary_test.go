@@ -0,0 +1,103 @@
+package weiroll
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*CompiledPlan)(nil)
+	_ encoding.BinaryUnmarshaler = (*CompiledPlan)(nil)
+)
+
+func TestCompiledPlanBinary(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), MustParseABI(vectorABI))
+
+	p := New()
+	greeting := p.Add(lib.MustInvoke("concat", "hello, ", "world"))
+	p.Add(lib.MustInvoke("concat", greeting, "!"))
+	p.Add(lib.MustInvoke("sum8",
+		big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4),
+		big.NewInt(5), big.NewInt(6), big.NewInt(7), big.NewInt(8)))
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	data, err := plan.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	t.Run("round-trips byte-exact", func(t *testing.T) {
+		var decoded CompiledPlan
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+		if len(decoded.Commands[2]) != ExtendedCommandSize {
+			t.Errorf("Expected extended command, got %d bytes", len(decoded.Commands[2]))
+		}
+		if !equalByteSlices(decoded.Commands, plan.Commands) || !equalByteSlices(decoded.State, plan.State) {
+			t.Error("Expected decoded plan to equal the original")
+		}
+
+		again, err := decoded.MarshalBinary()
+		if err != nil || !bytes.Equal(again, data) {
+			t.Errorf("Expected re-marshaling to reproduce the same bytes (err %v)", err)
+		}
+	})
+
+	t.Run("copies input", func(t *testing.T) {
+		buf := append([]byte{}, data...)
+		var decoded CompiledPlan
+		if err := decoded.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+		for i := range buf {
+			buf[i] = 0
+		}
+		if decoded.ID() != plan.ID() {
+			t.Error("Expected decoded plan not to alias the input")
+		}
+	})
+
+	t.Run("smaller than JSON", func(t *testing.T) {
+		jsonData, err := plan.ToWeirollJSON()
+		if err != nil {
+			t.Fatalf("ToWeirollJSON failed: %v", err)
+		}
+		t.Logf("binary: %d bytes, JSON: %d bytes", len(data), len(jsonData))
+		if len(data)*2 > len(jsonData) {
+			t.Errorf("Expected binary (%d bytes) under half the JSON size (%d bytes)", len(data), len(jsonData))
+		}
+	})
+
+	t.Run("rejects malformed input", func(t *testing.T) {
+		tests := []struct {
+			name string
+			data []byte
+		}{
+			{"empty", nil},
+			{"unknown version", append([]byte{2}, data[1:]...)},
+			{"truncated", data[:len(data)-1]},
+			{"trailing bytes", append(append([]byte{}, data...), 0)},
+			{"oversized count", []byte{binaryPlanVersion, 0xff, 0x01}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				decoded := CompiledPlan{Commands: [][]byte{{1}}}
+				if err := decoded.UnmarshalBinary(tt.data); !errors.Is(err, ErrInvalidBinaryPlan) {
+					t.Errorf("Expected ErrInvalidBinaryPlan, got %v", err)
+				}
+				if len(decoded.Commands) != 1 {
+					t.Error("Expected plan to be left unchanged")
+				}
+			})
+		}
+	})
+}
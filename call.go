@@ -1,7 +1,9 @@
 package weiroll
 
 import (
+	"fmt"
 	"math/big"
+	"reflect"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 )
@@ -15,6 +17,8 @@ type Call struct {
 	flags     CallFlags
 	value     *big.Int // ETH value for CALL_WITH_VALUE
 	rawReturn bool     // Wrap return as raw bytes
+	allowFail bool     // Continue the plan if this call reverts
+	repays    bool     // Repays a flash loan; see Repays
 }
 
 // newCall creates a Call from a contract, method, and arguments.
@@ -31,6 +35,14 @@ func newCall(contract *Contract, method abi.Method, rawArgs []any) (*Call, error
 	args := make([]Value, len(rawArgs))
 
 	for i, arg := range rawArgs {
+		if isNilArg(arg) {
+			return nil, &ArgumentError{
+				Method: method.Name,
+				Index:  i,
+				Err:    fmt.Errorf("%w for parameter type %s", ErrNilArgument, method.Inputs[i].Type.String()),
+			}
+		}
+
 		val, err := toValue(arg, method.Inputs[i].Type)
 		if err != nil {
 			return nil, &ArgumentError{
@@ -52,6 +64,18 @@ func newCall(contract *Contract, method abi.Method, rawArgs []any) (*Call, error
 	}, nil
 }
 
+// isNilArg reports whether a raw argument is nil, including a nil pointer
+// such as a nil *big.Int, which would otherwise fail deep inside the ABI
+// packer, or a typed-nil Value such as the *ReturnValue Add returns for a
+// method without outputs.
+func isNilArg(arg any) bool {
+	if arg == nil {
+		return true
+	}
+	v := reflect.ValueOf(arg)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
 // Contract returns the target contract for this call.
 func (c *Call) Contract() *Contract {
 	return c.contract
@@ -77,6 +101,15 @@ func (c *Call) EthValue() *big.Int {
 	return c.value
 }
 
+// values returns every Value the call reads: the runtime target address of a
+// dynamic contract, if any, followed by the arguments.
+func (c *Call) values() []Value {
+	if !c.contract.IsDynamic() {
+		return c.args
+	}
+	return append([]Value{c.contract.addressValue}, c.args...)
+}
+
 // HasReturnValue returns true if the method has a return value.
 func (c *Call) HasReturnValue() bool {
 	return len(c.method.Outputs) > 0
@@ -99,7 +132,10 @@ func (c *Call) Selector() [4]byte {
 
 // WithValue attaches ETH value to the call.
 // This converts the call to CALL_WITH_VALUE.
-// Only valid for external (non-library) contracts.
+// Only valid for external (non-library) contracts; Plan fails with
+// ErrInvalidCallType for a library call.
+// The amount is stored as a literal in the first argument slot, ahead of
+// any dynamic target; SplitValueSlot separates it when decoding.
 //
 // Returns a new Call with the value set.
 func (c *Call) WithValue(amount *big.Int) *Call {
@@ -130,6 +166,45 @@ func (c *Call) RawReturn() *Call {
 	return clone
 }
 
+// AllowFailure marks the call as non-fatal: the plan continues if it reverts.
+// The reference weiroll VM has no such mode; the target VM must support it
+// and the plan must be compiled with an EncodingConfig whose
+// AllowFailureFlag names the VM's flag bit, or Plan fails.
+//
+// Returns a new Call marked to allow failure.
+func (c *Call) AllowFailure() *Call {
+	clone := c.clone()
+	clone.allowFail = true
+	return clone
+}
+
+// Repays marks the call as repaying a flash loan, for Planner.CheckAtomicity.
+// It does not affect encoding.
+//
+// Returns a new Call marked as a repayment.
+func (c *Call) Repays() *Call {
+	clone := c.clone()
+	clone.repays = true
+	return clone
+}
+
+// OnContract retargets the call to contract, keeping its arguments and
+// flags. contract must have a method with the same signature, or
+// OnContract returns a MethodNotFoundError.
+//
+// Returns a new Call on contract.
+func (c *Call) OnContract(contract *Contract) (*Call, error) {
+	for _, method := range contract.abi.Methods {
+		if method.Sig == c.method.Sig {
+			clone := c.clone()
+			clone.contract = contract
+			clone.method = method
+			return clone, nil
+		}
+	}
+	return nil, &MethodNotFoundError{Contract: contract.address, Method: c.method.Sig}
+}
+
 // clone creates a shallow copy of the Call.
 func (c *Call) clone() *Call {
 	clone := *c
@@ -139,23 +214,73 @@ func (c *Call) clone() *Call {
 	return &clone
 }
 
-// validate checks if the Call is valid for its call type.
+// equal reports whether two calls encode to the same command: same target,
+// method, flags, ETH value, and arguments. Return value arguments are equal
+// only if they come from the same command.
+func (c *Call) equal(other *Call) bool {
+	return c.equalResolving(other, func(cmd *Command) *Command { return cmd })
+}
+
+// equalResolving is like equal but resolves return value producers through
+// resolve, so that commands collapsed during deduplication compare as
+// identical.
+func (c *Call) equalResolving(other *Call, resolve func(*Command) *Command) bool {
+	if c == other {
+		return true
+	}
+	if c == nil || other == nil {
+		return false
+	}
+	if c.contract.IsDynamic() != other.contract.IsDynamic() ||
+		(c.contract.IsDynamic() && !valuesEqual(c.contract.addressValue, other.contract.addressValue, resolve)) {
+		return false
+	}
+	if c.contract.Address() != other.contract.Address() ||
+		string(c.method.ID) != string(other.method.ID) ||
+		c.flags != other.flags ||
+		c.rawReturn != other.rawReturn ||
+		c.allowFail != other.allowFail {
+		return false
+	}
+	if (c.value == nil) != (other.value == nil) ||
+		(c.value != nil && c.value.Cmp(other.value) != 0) {
+		return false
+	}
+	if len(c.args) != len(other.args) {
+		return false
+	}
+	for i := range c.args {
+		if !valuesEqual(c.args[i], other.args[i], resolve) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks if the Call is valid for its call type. Plan calls it
+// for every command, including those of subplans.
 func (c *Call) validate() error {
 	callType := c.flags.CallType()
 
 	// Value transfer only valid for CALL_WITH_VALUE
 	if c.value != nil && c.value.Sign() > 0 && callType != FlagCallWithValue {
-		return ErrInvalidCallType
+		return fmt.Errorf("%w: value attached to a call without CALL_WITH_VALUE", ErrInvalidCallType)
 	}
 
 	// DELEGATECALL can't send value
 	if callType == FlagDelegateCall && c.value != nil && c.value.Sign() > 0 {
-		return ErrInvalidCallType
+		return fmt.Errorf("%w: DELEGATECALL cannot send value", ErrInvalidCallType)
 	}
 
 	// STATICCALL can't send value
 	if callType == FlagStaticCall && c.value != nil && c.value.Sign() > 0 {
-		return ErrInvalidCallType
+		return fmt.Errorf("%w: STATICCALL cannot send value", ErrInvalidCallType)
+	}
+
+	// Libraries run in the VM's context, so WithValue would turn the
+	// DELEGATECALL into a CALL to the library address.
+	if c.contract.contractType == Library && callType == FlagCallWithValue {
+		return fmt.Errorf("%w: library %s cannot be called with value", ErrInvalidCallType, c.contract.Address().Hex())
 	}
 
 	return nil
@@ -167,6 +292,9 @@ func (c *Call) computeFlags(isExtended bool) CallFlags {
 	if isExtended {
 		flags |= FlagExtendedCommand
 	}
+	if c.contract.IsDynamic() {
+		flags |= FlagDynamicTarget
+	}
 	if c.rawReturn {
 		flags |= FlagTupleReturn
 	}
@@ -1,6 +1,7 @@
 package weiroll
 
 import (
+	"errors"
 	"math/big"
 	"strings"
 	"testing"
@@ -140,6 +141,61 @@ func TestNewCall(t *testing.T) {
 			t.Errorf("Expected STATICCALL, got %v", call.Flags().CallType())
 		}
 	})
+
+	t.Run("rejects nil argument", func(t *testing.T) {
+		contract := NewContract(addr, testABI)
+		_, err := contract.Invoke("add", big.NewInt(1), nil)
+
+		if !errors.Is(err, ErrNilArgument) {
+			t.Fatalf("Expected ErrNilArgument, got %v", err)
+		}
+		argErr, ok := err.(*ArgumentError)
+		if !ok {
+			t.Fatalf("Expected *ArgumentError, got %T", err)
+		}
+		if argErr.Index != 1 {
+			t.Errorf("Expected index 1, got %d", argErr.Index)
+		}
+		if !strings.Contains(err.Error(), "uint256") {
+			t.Errorf("Expected error to name the parameter type, got %q", err.Error())
+		}
+	})
+
+	t.Run("rejects the return value of a void method", func(t *testing.T) {
+		p := New()
+		none := p.Add(NewContract(addr, plannerTestABI()).MustInvoke("noReturn", big.NewInt(1)))
+		_, err := NewContract(addr, testABI).Invoke("add", none, big.NewInt(1))
+
+		if !errors.Is(err, ErrNilArgument) {
+			t.Fatalf("Expected ErrNilArgument, got %v", err)
+		}
+		argErr, ok := err.(*ArgumentError)
+		if !ok {
+			t.Fatalf("Expected *ArgumentError, got %T", err)
+		}
+		if argErr.Index != 0 {
+			t.Errorf("Expected index 0, got %d", argErr.Index)
+		}
+	})
+
+	t.Run("rejects typed-nil Value arguments", func(t *testing.T) {
+		var lit *LiteralValue
+		_, err := NewContract(addr, testABI).Invoke("add", big.NewInt(1), lit)
+
+		if !errors.Is(err, ErrNilArgument) {
+			t.Fatalf("Expected ErrNilArgument, got %v", err)
+		}
+	})
+
+	t.Run("rejects nil *big.Int argument", func(t *testing.T) {
+		contract := NewContract(addr, testABI)
+		var amount *big.Int
+		_, err := contract.Invoke("add", amount, big.NewInt(1))
+
+		if !errors.Is(err, ErrNilArgument) {
+			t.Fatalf("Expected ErrNilArgument, got %v", err)
+		}
+	})
 }
 
 func TestCallContract(t *testing.T) {
@@ -402,6 +458,80 @@ func TestCallRawReturn(t *testing.T) {
 	})
 }
 
+func TestCallAllowFailure(t *testing.T) {
+	testABI := testABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	t.Run("creates new call allowing failure", func(t *testing.T) {
+		original := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		lenient := original.AllowFailure()
+
+		if original.allowFail {
+			t.Error("Original call should not allow failure")
+		}
+		if !lenient.allowFail {
+			t.Error("New call should allow failure")
+		}
+		if original.equal(lenient) {
+			t.Error("Expected calls to differ")
+		}
+	})
+}
+
+func TestCallRepays(t *testing.T) {
+	testABI := testABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	t.Run("creates new call marked as repayment", func(t *testing.T) {
+		original := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		repayment := original.Repays()
+
+		if original.repays {
+			t.Error("Original call should not be a repayment")
+		}
+		if !repayment.repays {
+			t.Error("New call should be a repayment")
+		}
+		if !original.equal(repayment) {
+			t.Error("Expected calls to encode identically")
+		}
+	})
+}
+
+func TestCallOnContract(t *testing.T) {
+	testABI := testABI()
+	deploymentA := NewContract(common.HexToAddress("0x1111111111111111111111111111111111111111"), testABI)
+	deploymentB := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), plannerTestABI())
+
+	t.Run("retargets to a contract with the same method", func(t *testing.T) {
+		original := deploymentA.MustInvoke("add", big.NewInt(1), big.NewInt(2)).Static()
+		retargeted, err := original.OnContract(deploymentB)
+		if err != nil {
+			t.Fatalf("OnContract failed: %v", err)
+		}
+
+		if retargeted.Contract() != deploymentB || original.Contract() != deploymentA {
+			t.Error("Expected only the new call to target deployment B")
+		}
+		if retargeted.Selector() != original.Selector() || retargeted.Flags() != original.Flags() {
+			t.Error("Expected selector and flags to be kept")
+		}
+		if len(retargeted.Args()) != 2 || retargeted.Args()[1] != original.Args()[1] {
+			t.Error("Expected arguments to be reused")
+		}
+	})
+
+	t.Run("rejects a contract without the method", func(t *testing.T) {
+		_, err := deploymentB.MustInvoke("getString").OnContract(deploymentA)
+		var notFound *MethodNotFoundError
+		if !errors.As(err, &notFound) || notFound.Method != "getString()" || notFound.Contract != deploymentA.Address() {
+			t.Errorf("Expected MethodNotFoundError for getString(), got %v", err)
+		}
+	})
+}
+
 func TestCallClone(t *testing.T) {
 	testABI := testABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
@@ -438,6 +568,63 @@ func TestCallClone(t *testing.T) {
 	})
 }
 
+func TestCallEqual(t *testing.T) {
+	testABI := testABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	t.Run("identical calls are equal", func(t *testing.T) {
+		a := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		b := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		if !a.equal(b) {
+			t.Error("Expected identical calls to be equal")
+		}
+	})
+
+	t.Run("different arguments are not equal", func(t *testing.T) {
+		a := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		b := contract.MustInvoke("add", big.NewInt(1), big.NewInt(3))
+		if a.equal(b) {
+			t.Error("Expected calls with different arguments to differ")
+		}
+	})
+
+	t.Run("different targets are not equal", func(t *testing.T) {
+		other := NewContract(common.HexToAddress("0x01"), testABI)
+		a := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		b := other.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		if a.equal(b) {
+			t.Error("Expected calls to different contracts to differ")
+		}
+	})
+
+	t.Run("different flags are not equal", func(t *testing.T) {
+		a := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		if a.equal(a.Static()) {
+			t.Error("Expected static call to differ")
+		}
+		if a.equal(a.WithValue(big.NewInt(1))) {
+			t.Error("Expected call with value to differ")
+		}
+	})
+
+	t.Run("return values compare by producing command", func(t *testing.T) {
+		p := New()
+		first := p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		second := p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		a := contract.MustInvoke("add", first, big.NewInt(1))
+		b := contract.MustInvoke("add", first, big.NewInt(1))
+		c := contract.MustInvoke("add", second, big.NewInt(1))
+		if !a.equal(b) {
+			t.Error("Expected calls using the same return value to be equal")
+		}
+		if a.equal(c) {
+			t.Error("Expected calls using different return values to differ")
+		}
+	})
+}
+
 func TestCallValidate(t *testing.T) {
 	testABI := testABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
@@ -479,6 +666,26 @@ func TestCallValidate(t *testing.T) {
 			t.Errorf("Expected no error, got %v", err)
 		}
 	})
+
+	t.Run("library call with value", func(t *testing.T) {
+		lib := NewLibrary(addr, testABI)
+		call := lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(1))
+
+		if err := call.validate(); !errors.Is(err, ErrInvalidCallType) {
+			t.Errorf("Expected ErrInvalidCallType, got %v", err)
+		}
+	})
+
+	t.Run("STATICCALL with value", func(t *testing.T) {
+		contract := NewContract(addr, testABI)
+		call := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)).
+			WithValue(big.NewInt(1)).
+			Static()
+
+		if err := call.validate(); !errors.Is(err, ErrInvalidCallType) {
+			t.Errorf("Expected ErrInvalidCallType, got %v", err)
+		}
+	})
 }
 
 func TestCallComputeFlags(t *testing.T) {
@@ -0,0 +1,80 @@
+package weiroll
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ChainRegistry maps names such as "WETH" to contract addresses per chain
+// ID, so plans for several chains can look addresses up instead of
+// hardcoding them at each use. It ships empty; callers register the
+// addresses they depend on. A ChainRegistry is safe for concurrent use.
+type ChainRegistry struct {
+	mu        sync.RWMutex
+	addresses map[uint64]map[string]common.Address
+}
+
+// NewChainRegistry creates an empty ChainRegistry.
+func NewChainRegistry() *ChainRegistry {
+	return &ChainRegistry{addresses: make(map[uint64]map[string]common.Address)}
+}
+
+// Register sets the address of name on chainID, replacing any earlier one.
+func (r *ChainRegistry) Register(chainID uint64, name string, addr common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names, ok := r.addresses[chainID]
+	if !ok {
+		names = make(map[string]common.Address)
+		r.addresses[chainID] = names
+	}
+	names[name] = addr
+}
+
+// RegisterHex is like Register but parses addr with the same rules as
+// AddressFromString, so a mistyped or wrongly checksummed address is
+// rejected with ErrInvalidAddress rather than registered.
+func (r *ChainRegistry) RegisterHex(chainID uint64, name, addr string) error {
+	if _, err := AddressFromString(addr); err != nil {
+		return err
+	}
+	r.Register(chainID, name, common.HexToAddress(addr))
+	return nil
+}
+
+// Address returns the address registered for name on chainID, or
+// ErrChainAddressNotFound if there is none.
+func (r *ChainRegistry) Address(chainID uint64, name string) (common.Address, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	addr, ok := r.addresses[chainID][name]
+	if !ok {
+		return common.Address{}, fmt.Errorf("%w: %q on chain %d", ErrChainAddressNotFound, name, chainID)
+	}
+	return addr, nil
+}
+
+// Literal returns an address literal for name on chainID, for passing a
+// registered address as a call argument.
+func (r *ChainRegistry) Literal(chainID uint64, name string) (*LiteralValue, error) {
+	addr, err := r.Address(chainID, name)
+	if err != nil {
+		return nil, err
+	}
+	return Address(addr), nil
+}
+
+// Names returns the names registered on chainID in sorted order.
+func (r *ChainRegistry) Names(chainID uint64) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.addresses[chainID]))
+	for name := range r.addresses[chainID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,97 @@
+package weiroll
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestChainRegistry(t *testing.T) {
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	arbitrumWETH := common.HexToAddress("0x82aF49447D8a07e3bd95BD0d56f35241523fBab1")
+
+	newRegistry := func() *ChainRegistry {
+		r := NewChainRegistry()
+		r.Register(1, "WETH", weth)
+		r.Register(42161, "WETH", arbitrumWETH)
+		return r
+	}
+
+	t.Run("resolves named address per chain", func(t *testing.T) {
+		r := newRegistry()
+		addr, err := r.Address(1, "WETH")
+		if err != nil {
+			t.Fatalf("Address failed: %v", err)
+		}
+		if addr != weth {
+			t.Errorf("Expected %s, got %s", weth.Hex(), addr.Hex())
+		}
+		addr, err = r.Address(42161, "WETH")
+		if err != nil {
+			t.Fatalf("Address failed: %v", err)
+		}
+		if addr != arbitrumWETH {
+			t.Errorf("Expected %s, got %s", arbitrumWETH.Hex(), addr.Hex())
+		}
+	})
+
+	t.Run("errors on unknown name or chain", func(t *testing.T) {
+		r := newRegistry()
+		if _, err := r.Address(1, "USDC"); !errors.Is(err, ErrChainAddressNotFound) {
+			t.Errorf("Expected ErrChainAddressNotFound for unknown name, got %v", err)
+		}
+		if _, err := r.Address(10, "WETH"); !errors.Is(err, ErrChainAddressNotFound) {
+			t.Errorf("Expected ErrChainAddressNotFound for unknown chain, got %v", err)
+		}
+		if _, err := r.Literal(1, "USDC"); !errors.Is(err, ErrChainAddressNotFound) {
+			t.Errorf("Expected ErrChainAddressNotFound from Literal, got %v", err)
+		}
+	})
+
+	t.Run("builds address literal", func(t *testing.T) {
+		lit, err := newRegistry().Literal(1, "WETH")
+		if err != nil {
+			t.Fatalf("Literal failed: %v", err)
+		}
+		if lit.Type().String() != "address" {
+			t.Errorf("Expected address literal, got %s", lit.Type().String())
+		}
+		if !bytes.Equal(lit.Data(), common.LeftPadBytes(weth.Bytes(), 32)) {
+			t.Errorf("Expected padded WETH address, got %x", lit.Data())
+		}
+	})
+
+	t.Run("register hex validates address", func(t *testing.T) {
+		r := NewChainRegistry()
+		if err := r.RegisterHex(1, "WETH", weth.Hex()); err != nil {
+			t.Fatalf("RegisterHex failed: %v", err)
+		}
+		if addr, _ := r.Address(1, "WETH"); addr != weth {
+			t.Errorf("Expected %s, got %s", weth.Hex(), addr.Hex())
+		}
+
+		// Mistyped: one hex digit short, then a broken checksum
+		for _, s := range []string{"0x6B175474E89094C44Da98b954EedeAC495271d0", "0x6b175474E89094C44Da98b954EedeAC495271d0F"} {
+			if err := r.RegisterHex(1, "DAI", s); !errors.Is(err, ErrInvalidAddress) {
+				t.Errorf("%s: expected ErrInvalidAddress, got %v", s, err)
+			}
+		}
+		if _, err := r.Address(1, "DAI"); !errors.Is(err, ErrChainAddressNotFound) {
+			t.Errorf("Expected rejected address not to be registered, got %v", err)
+		}
+	})
+
+	t.Run("lists names", func(t *testing.T) {
+		r := newRegistry()
+		r.Register(1, "DAI", common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"))
+		if names := r.Names(1); !reflect.DeepEqual(names, []string{"DAI", "WETH"}) {
+			t.Errorf("Expected [DAI WETH], got %v", names)
+		}
+		if names := r.Names(10); len(names) != 0 {
+			t.Errorf("Expected no names for unknown chain, got %v", names)
+		}
+	})
+}
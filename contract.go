@@ -2,6 +2,7 @@ package weiroll
 
 import (
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -22,11 +23,28 @@ const (
 	StaticExternal
 )
 
+// TrustLevel records how far a contract's code is trusted, for static
+// analysis of plans. It does not affect encoding.
+type TrustLevel uint8
+
+const (
+	// Trusted contracts are assumed safe to call in any way. This is the default.
+	Trusted TrustLevel = iota
+
+	// Untrusted contracts may run arbitrary code; see WithRequireTrusted.
+	Untrusted
+)
+
 // Contract wraps an Ethereum contract for use with the weiroll planner.
 type Contract struct {
 	address      common.Address
 	abi          abi.ABI
 	contractType ContractType
+	addressValue Value // Runtime target address for dynamic contracts
+	trust        TrustLevel
+	natspec      map[string]string // Method documentation by name or signature
+	proxy        bool              // Set by NewProxyContract
+	impl         common.Address    // Implementation behind a proxy, if known
 }
 
 // ContractOption configures a Contract.
@@ -39,6 +57,34 @@ func WithStaticCalls() ContractOption {
 	}
 }
 
+// WithTrustLevel records the contract's trust level for static analysis.
+// See Planner.UntrustedCalls and WithRequireTrusted.
+func WithTrustLevel(level TrustLevel) ContractOption {
+	return func(c *Contract) {
+		c.trust = level
+	}
+}
+
+// WithNatSpec attaches method documentation, such as natspec @notice text
+// from a compiler's userdoc or devdoc output, which go-ethereum's ABI types
+// don't carry. docs is keyed by method name or signature; a signature key
+// takes precedence, to document overloads separately.
+// See Command.Documentation.
+func WithNatSpec(docs map[string]string) ContractOption {
+	return func(c *Contract) {
+		c.natspec = docs
+	}
+}
+
+// WithImplementation records the implementation address behind a proxy
+// created with NewProxyContract, for descriptions. It does not affect
+// encoding.
+func WithImplementation(address common.Address) ContractOption {
+	return func(c *Contract) {
+		c.impl = address
+	}
+}
+
 // NewLibrary creates a Contract wrapper for library contracts.
 // Library contracts are called via DELEGATECALL, meaning they execute
 // in the context of the weiroll VM contract.
@@ -68,11 +114,88 @@ func NewContract(address common.Address, contractABI abi.ABI, opts ...ContractOp
 	return c
 }
 
+// NewProxyContract creates a Contract wrapper for an external contract
+// deployed behind a proxy: calls target proxyAddress but are encoded with
+// implABI, the implementation's ABI. It encodes exactly like NewContract;
+// the proxy marking, and the implementation address if given with
+// WithImplementation, only appear in descriptions such as Planner.Describe.
+func NewProxyContract(proxyAddress common.Address, implABI abi.ABI, opts ...ContractOption) *Contract {
+	c := NewContract(proxyAddress, implABI, opts...)
+	c.proxy = true
+	return c
+}
+
+// NewDynamicContract creates a Contract wrapper for an external contract whose
+// address is only known at execution time, such as one returned by a factory.
+// addressValue must have ABI type address.
+//
+// Calls on a dynamic contract set FlagDynamicTarget, leave the command's
+// address field zero, and pass the address slot as the first argument slot.
+// The reference weiroll VM does not implement this convention; the plan must
+// run on a VM (or behind a dispatcher) that pops the first argument as the
+// call target when the flag is set. Dynamic contracts can't be libraries,
+// since delegating to an address chosen at runtime is unsafe.
+func NewDynamicContract(addressValue Value, contractABI abi.ABI, opts ...ContractOption) (*Contract, error) {
+	if addressValue == nil {
+		return nil, &TypeMismatchError{Expected: "address", Got: "nil"}
+	}
+	if got := addressValue.Type().String(); got != "address" {
+		return nil, &TypeMismatchError{Expected: "address", Got: got}
+	}
+
+	c := &Contract{
+		abi:          contractABI,
+		contractType: External,
+		addressValue: addressValue,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
 // Address returns the contract address.
+// For dynamic contracts this is the zero address; see AddressValue.
 func (c *Contract) Address() common.Address {
 	return c.address
 }
 
+// IsProxy reports whether the contract was created with NewProxyContract.
+func (c *Contract) IsProxy() bool {
+	return c.proxy
+}
+
+// Implementation returns the implementation address set with
+// WithImplementation, or the zero address if none was set.
+func (c *Contract) Implementation() common.Address {
+	return c.impl
+}
+
+// AddressValue returns the runtime address of a dynamic contract,
+// or nil for contracts with a fixed address.
+func (c *Contract) AddressValue() Value {
+	return c.addressValue
+}
+
+// IsDynamic returns true if the contract address is resolved at runtime.
+func (c *Contract) IsDynamic() bool {
+	return c.addressValue != nil
+}
+
+// TrustLevel returns the trust level set with WithTrustLevel.
+func (c *Contract) TrustLevel() TrustLevel {
+	return c.trust
+}
+
+// Documentation returns the documentation set with WithNatSpec for
+// method, or "" if there is none.
+func (c *Contract) Documentation(method abi.Method) string {
+	if doc, ok := c.natspec[method.Sig]; ok {
+		return doc
+	}
+	return c.natspec[method.Name]
+}
+
 // ABI returns the contract ABI.
 func (c *Contract) ABI() abi.ABI {
 	return c.abi
@@ -88,7 +211,7 @@ func (c *Contract) Type() ContractType {
 func (c *Contract) Invoke(methodName string, args ...any) (*Call, error) {
 	method, ok := c.abi.Methods[methodName]
 	if !ok {
-		return nil, &MethodNotFoundError{Contract: c.address, Method: methodName}
+		return nil, c.methodNotFound(methodName)
 	}
 
 	return newCall(c, method, args)
@@ -118,6 +241,85 @@ func (c *Contract) MethodNames() []string {
 	return names
 }
 
+// MethodInfo describes a method of a contract ABI. See Contract.MethodTable.
+type MethodInfo struct {
+	Name            string
+	Signature       string // Canonical signature, e.g. "transfer(address,uint256)"
+	Selector        [4]byte
+	Inputs          []string // ABI types of the parameters
+	Outputs         []string // ABI types of the return values
+	StateMutability string   // "pure", "view", "nonpayable" or "payable"
+}
+
+// MethodTable describes every method in the contract ABI, sorted by name,
+// for building selector lookup tables and method pickers.
+func (c *Contract) MethodTable() []MethodInfo {
+	table := make([]MethodInfo, 0, len(c.abi.Methods))
+	for _, method := range c.abi.Methods {
+		info := MethodInfo{
+			Name:            method.Name,
+			Signature:       method.Sig,
+			Inputs:          make([]string, len(method.Inputs)),
+			Outputs:         make([]string, len(method.Outputs)),
+			StateMutability: method.StateMutability,
+		}
+		copy(info.Selector[:], method.ID)
+		for i, input := range method.Inputs {
+			info.Inputs[i] = input.Type.String()
+		}
+		for i, output := range method.Outputs {
+			info.Outputs[i] = output.Type.String()
+		}
+		table = append(table, info)
+	}
+	sort.Slice(table, func(i, j int) bool {
+		return table[i].Name < table[j].Name
+	})
+	return table
+}
+
+// methodNotFound returns a MethodNotFoundError for name, suggesting the
+// contract's methods within a small edit distance of it.
+func (c *Contract) methodNotFound(name string) *MethodNotFoundError {
+	maxDistance := max(1, len(name)/3)
+	best := maxDistance + 1
+	var suggestions []string
+	for candidate := range c.abi.Methods {
+		d := levenshtein(name, candidate)
+		switch {
+		case d < best:
+			best = d
+			suggestions = []string{candidate}
+		case d == best:
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	sort.Strings(suggestions)
+	return &MethodNotFoundError{Contract: c.address, Method: name, Suggestions: suggestions}
+}
+
+// levenshtein returns the edit distance between a and b, counting
+// single-byte insertions, deletions and substitutions.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
 // defaultFlags returns the default call flags based on contract type.
 func (c *Contract) defaultFlags() CallFlags {
 	switch c.contractType {
@@ -2,6 +2,7 @@ package weiroll
 
 import (
 	"math/big"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -146,6 +147,134 @@ func TestNewContract(t *testing.T) {
 	})
 }
 
+func TestNewProxyContract(t *testing.T) {
+	parsed := MustParseABI(testABIJSON)
+	proxyAddr := common.HexToAddress("0xabcdef1234567890abcdef1234567890abcdef12")
+	implAddr := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678")
+
+	t.Run("encodes the proxy address", func(t *testing.T) {
+		proxy := NewProxyContract(proxyAddr, parsed, WithImplementation(implAddr))
+		if !proxy.IsProxy() || proxy.Implementation() != implAddr || proxy.Type() != External {
+			t.Errorf("Unexpected proxy contract %+v", proxy)
+		}
+
+		p := New()
+		p.Add(proxy.MustInvoke("getValue"))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		direct := New()
+		direct.Add(NewContract(proxyAddr, parsed).MustInvoke("getValue"))
+		want, err := direct.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if !equalByteSlices(plan.Commands, want.Commands) {
+			t.Error("Expected a proxy to encode like NewContract")
+		}
+		if _, _, _, _, addr, _ := DecodeCommand(plan.Commands[0]); addr != proxyAddr {
+			t.Errorf("Expected proxy address %s, got %s", proxyAddr.Hex(), addr.Hex())
+		}
+	})
+
+	t.Run("implementation is optional", func(t *testing.T) {
+		proxy := NewProxyContract(proxyAddr, parsed)
+		if !proxy.IsProxy() || proxy.Implementation() != (common.Address{}) {
+			t.Errorf("Expected proxy without implementation, got %+v", proxy)
+		}
+		if NewContract(proxyAddr, parsed).IsProxy() {
+			t.Error("Expected NewContract not to be a proxy")
+		}
+	})
+}
+
+func TestNewDynamicContract(t *testing.T) {
+	factoryABI := MustParseABI(`[{
+		"name": "create",
+		"type": "function",
+		"stateMutability": "nonpayable",
+		"inputs": [],
+		"outputs": [{"name": "", "type": "address"}]
+	}]`)
+	tokenABI := MustParseABI(testABIJSON)
+	factory := NewContract(common.HexToAddress("0x1111111111111111111111111111111111111111"), factoryABI)
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	t.Run("wires a factory's returned address into calls", func(t *testing.T) {
+		p := New()
+		deployed := p.Add(factory.MustInvoke("create"))
+
+		token, err := NewDynamicContract(deployed, tokenABI)
+		if err != nil {
+			t.Fatalf("NewDynamicContract failed: %v", err)
+		}
+		if !token.IsDynamic() || token.AddressValue() != Value(deployed) {
+			t.Error("Expected dynamic contract with the deployed address value")
+		}
+		if token.Type() != External {
+			t.Errorf("Expected External, got %d", token.Type())
+		}
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(100)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, _, createReturn, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		_, flags, argSlots, _, address, err := DecodeCommand(plan.Commands[1])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if !flags.HasDynamicTarget() {
+			t.Error("Expected FlagDynamicTarget to be set")
+		}
+		if flags.CallType() != FlagCall {
+			t.Errorf("Expected CALL, got %d", flags.CallType())
+		}
+		if address != (common.Address{}) {
+			t.Errorf("Expected zero address field, got %s", address.Hex())
+		}
+		if len(argSlots) != 3 {
+			t.Fatalf("Expected target slot plus 2 arguments, got %v", argSlots)
+		}
+		if argSlots[0] != createReturn {
+			t.Errorf("Expected target slot %d, got %d", createReturn, argSlots[0])
+		}
+	})
+
+	t.Run("literal address value", func(t *testing.T) {
+		token, err := NewDynamicContract(Address(recipient), tokenABI, WithStaticCalls())
+		if err != nil {
+			t.Fatalf("NewDynamicContract failed: %v", err)
+		}
+		if token.defaultFlags() != FlagStaticCall {
+			t.Errorf("Expected options to apply, got flags %d", token.defaultFlags())
+		}
+	})
+
+	t.Run("rejects non-address value", func(t *testing.T) {
+		if _, err := NewDynamicContract(Uint256(big.NewInt(1)), tokenABI); err == nil {
+			t.Error("Expected error for uint256 address value")
+		}
+		if _, err := NewDynamicContract(nil, tokenABI); err == nil {
+			t.Error("Expected error for nil address value")
+		}
+	})
+
+	t.Run("static contracts are not dynamic", func(t *testing.T) {
+		c := NewContract(recipient, tokenABI)
+		if c.IsDynamic() || c.AddressValue() != nil {
+			t.Error("Expected fixed-address contract not to be dynamic")
+		}
+	})
+}
+
 func TestContractAddress(t *testing.T) {
 	parsed := MustParseABI(testABIJSON)
 	addr := common.HexToAddress("0xdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
@@ -156,6 +285,23 @@ func TestContractAddress(t *testing.T) {
 	}
 }
 
+func TestContractTrustLevel(t *testing.T) {
+	parsed := MustParseABI(testABIJSON)
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+
+	t.Run("trusted by default", func(t *testing.T) {
+		if level := NewContract(addr, parsed).TrustLevel(); level != Trusted {
+			t.Errorf("Expected Trusted, got %d", level)
+		}
+	})
+
+	t.Run("WithTrustLevel sets level", func(t *testing.T) {
+		if level := NewLibrary(addr, parsed, WithTrustLevel(Untrusted)).TrustLevel(); level != Untrusted {
+			t.Errorf("Expected Untrusted, got %d", level)
+		}
+	})
+}
+
 func TestContractABI(t *testing.T) {
 	parsed := MustParseABI(testABIJSON)
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
@@ -211,6 +357,24 @@ func TestContractInvoke(t *testing.T) {
 		if notFound.Contract != addr {
 			t.Errorf("Expected contract %s, got %s", addr.Hex(), notFound.Contract.Hex())
 		}
+		if len(notFound.Suggestions) != 0 {
+			t.Errorf("Expected no suggestions, got %v", notFound.Suggestions)
+		}
+	})
+
+	t.Run("suggests near-miss method names", func(t *testing.T) {
+		_, err := contract.Invoke("tranfer", addr, big.NewInt(1))
+
+		notFound, ok := err.(*MethodNotFoundError)
+		if !ok {
+			t.Fatalf("Expected *MethodNotFoundError, got %T", err)
+		}
+		if len(notFound.Suggestions) != 1 || notFound.Suggestions[0] != "transfer" {
+			t.Errorf("Expected suggestion 'transfer', got %v", notFound.Suggestions)
+		}
+		if !strings.HasSuffix(err.Error(), "; did you mean transfer?") {
+			t.Errorf("Expected suggestion in message, got %q", err.Error())
+		}
 	})
 
 	t.Run("returns error for wrong argument count", func(t *testing.T) {
@@ -340,6 +504,41 @@ func TestContractMethodNames(t *testing.T) {
 	}
 }
 
+func TestContractMethodTable(t *testing.T) {
+	contract := NewContract(common.HexToAddress("0x1234567890123456789012345678901234567890"), MustParseABI(testABIJSON))
+
+	table := contract.MethodTable()
+	expected := []MethodInfo{
+		{
+			Name:            "add",
+			Signature:       "add(uint256,uint256)",
+			Selector:        [4]byte{0x77, 0x16, 0x02, 0xf7},
+			Inputs:          []string{"uint256", "uint256"},
+			Outputs:         []string{"uint256"},
+			StateMutability: "pure",
+		},
+		{
+			Name:            "getValue",
+			Signature:       "getValue()",
+			Selector:        [4]byte{0x20, 0x96, 0x52, 0x55},
+			Inputs:          []string{},
+			Outputs:         []string{"uint256"},
+			StateMutability: "view",
+		},
+		{
+			Name:            "transfer",
+			Signature:       "transfer(address,uint256)",
+			Selector:        [4]byte{0xa9, 0x05, 0x9c, 0xbb},
+			Inputs:          []string{"address", "uint256"},
+			Outputs:         []string{"bool"},
+			StateMutability: "nonpayable",
+		},
+	}
+	if !reflect.DeepEqual(table, expected) {
+		t.Errorf("Expected method table %+v, got %+v", expected, table)
+	}
+}
+
 func TestContractDefaultFlags(t *testing.T) {
 	parsed := MustParseABI(testABIJSON)
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
@@ -454,6 +653,45 @@ func TestWithStaticCalls(t *testing.T) {
 	})
 }
 
+func TestWithNatSpec(t *testing.T) {
+	parsed := MustParseABI(testABIJSON)
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, parsed, WithNatSpec(map[string]string{
+		"add":                       "Adds two numbers.",
+		"transfer(address,uint256)": "Moves tokens to a recipient.",
+		"transfer":                  "Overridden by the signature entry.",
+	}))
+
+	t.Run("documents commands by method name", func(t *testing.T) {
+		p := New()
+		p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		if doc := p.CommandAt(0).Documentation(); doc != "Adds two numbers." {
+			t.Errorf("Expected add documentation, got %q", doc)
+		}
+		if doc := p.Describe().Commands[0].Doc; doc != "Adds two numbers." {
+			t.Errorf("Expected documentation in description, got %q", doc)
+		}
+	})
+
+	t.Run("prefers signature keys", func(t *testing.T) {
+		call := contract.MustInvoke("transfer", addr, big.NewInt(1))
+		if doc := contract.Documentation(call.Method()); doc != "Moves tokens to a recipient." {
+			t.Errorf("Expected signature documentation, got %q", doc)
+		}
+	})
+
+	t.Run("empty for undocumented methods", func(t *testing.T) {
+		call := contract.MustInvoke("getValue")
+		if doc := contract.Documentation(call.Method()); doc != "" {
+			t.Errorf("Expected no documentation, got %q", doc)
+		}
+		if doc := NewContract(addr, parsed).Documentation(call.Method()); doc != "" {
+			t.Errorf("Expected no documentation without WithNatSpec, got %q", doc)
+		}
+	})
+}
+
 func TestContractWithDifferentABIs(t *testing.T) {
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
 
@@ -549,3 +787,25 @@ func TestParseABIWithStringsReader(t *testing.T) {
 		t.Errorf("Expected 3 methods, got %d", len(parsed.Methods))
 	}
 }
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"add", "", 3},
+		{"", "add", 3},
+		{"transfer", "transfer", 0},
+		{"tranfer", "transfer", 1},
+		{"getValeu", "getValue", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := levenshtein(tt.a, tt.b); got != tt.want {
+				t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package weiroll
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// reservedFlags returns the flag bits not assigned by the weiroll VM, by
+// this package's FlagDynamicTarget extension, or by cfg.AllowFailureFlag.
+func reservedFlags(cfg EncodingConfig) CallFlags {
+	return ^(FlagCallTypeMask | FlagDynamicTarget | FlagExtendedCommand | FlagTupleReturn | cfg.AllowFailureFlag)
+}
+
+// DecodePlan wraps raw on-chain commands and state into a CompiledPlan.
+// It is the inverse of CommandsAsBytes32 and StateAsBytes: extended commands
+// are reassembled from their two words, and every command is validated for
+// reserved flag bits and slot references outside the state array.
+func DecodePlan(commands [][32]byte, state [][]byte) (*CompiledPlan, error) {
+	return DecodePlanWithConfig(commands, state, DefaultEncodingConfig())
+}
+
+// DecodePlanWithConfig is like DecodePlan but validates commands against
+// cfg, for plans compiled with WithEncodingConstants. cfg.AllowFailureFlag
+// is accepted as a flag bit, and the slot markers and argument padding are
+// read from cfg.
+func DecodePlanWithConfig(commands [][32]byte, state [][]byte, cfg EncodingConfig) (*CompiledPlan, error) {
+	decoded := make([][]byte, 0, len(commands))
+
+	for i := 0; i < len(commands); i++ {
+		cmd := make([]byte, CommandSize, ExtendedCommandSize)
+		copy(cmd, commands[i][:])
+
+		index := len(decoded)
+		flags := CallFlags(cmd[4])
+		if flags.IsExtended() {
+			if i+1 >= len(commands) {
+				return nil, &PlanError{
+					CommandIndex: index,
+					Err:          fmt.Errorf("%w: extended command missing argument word", ErrInvalidCommand),
+				}
+			}
+			i++
+			cmd = append(cmd, commands[i][:]...)
+		}
+
+		if err := validateDecodedCommand(cmd, len(state), cfg); err != nil {
+			return nil, &PlanError{CommandIndex: index, Err: err}
+		}
+		decoded = append(decoded, cmd)
+	}
+
+	stateCopy := make([][]byte, len(state))
+	for i, s := range state {
+		stateCopy[i] = append([]byte(nil), s...)
+	}
+
+	return &CompiledPlan{
+		Commands: decoded,
+		State:    stateCopy,
+	}, nil
+}
+
+// ValidateVMABI checks that methodName in vmABI can execute weiroll plans,
+// before the library is configured against a VM contract. The method must
+// take (bytes32[], bytes[]), the commands and state of a plan, and either
+// return the final state as bytes[], like the reference VM, or return
+// nothing, like wrappers that discard it. Otherwise the error wraps
+// ErrIncompatibleVMABI and names the mismatch.
+func ValidateVMABI(vmABI abi.ABI, methodName string) error {
+	method, ok := vmABI.Methods[methodName]
+	if !ok {
+		return fmt.Errorf("%w: no method %q", ErrIncompatibleVMABI, methodName)
+	}
+	if err := checkExecuteInputs(&method); err != nil {
+		return err
+	}
+	switch {
+	case len(method.Outputs) == 0:
+	case len(method.Outputs) == 1 && method.Outputs[0].Type.String() == "bytes[]":
+	default:
+		return fmt.Errorf("%w: method %s returns %s, want bytes[] or nothing",
+			ErrIncompatibleVMABI, method.Sig, argumentTypes(method.Outputs))
+	}
+	return nil
+}
+
+// checkExecuteInputs verifies that method takes (bytes32[], bytes[]).
+func checkExecuteInputs(method *abi.Method) error {
+	if len(method.Inputs) != 2 ||
+		method.Inputs[0].Type.String() != "bytes32[]" ||
+		method.Inputs[1].Type.String() != "bytes[]" {
+		return fmt.Errorf("%w: method %s takes %s, want (bytes32[], bytes[])",
+			ErrIncompatibleVMABI, method.Sig, argumentTypes(method.Inputs))
+	}
+	return nil
+}
+
+// argumentTypes formats the types of args as a parenthesized list.
+func argumentTypes(args abi.Arguments) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type.String()
+	}
+	return "(" + strings.Join(types, ", ") + ")"
+}
+
+// DecodeExecuteCalldata unpacks the calldata of a VM execute transaction into
+// a CompiledPlan. The selector must match a method in vmABI taking
+// (bytes32[], bytes[]); the unpacked arrays are then validated by DecodePlan.
+func DecodeExecuteCalldata(vmABI abi.ABI, calldata []byte) (*CompiledPlan, error) {
+	return DecodeExecuteCalldataWithConfig(vmABI, calldata, DefaultEncodingConfig())
+}
+
+// DecodeExecuteCalldataWithConfig is like DecodeExecuteCalldata but
+// validates the unpacked arrays with DecodePlanWithConfig.
+func DecodeExecuteCalldataWithConfig(vmABI abi.ABI, calldata []byte, cfg EncodingConfig) (*CompiledPlan, error) {
+	if len(calldata) < 4 {
+		return nil, fmt.Errorf("%w: calldata shorter than a selector", ErrInvalidCalldata)
+	}
+
+	method, err := vmABI.MethodById(calldata[:4])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalldata, err)
+	}
+	if err := checkExecuteInputs(method); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCalldata, err)
+	}
+
+	args, err := method.Inputs.Unpack(calldata[4:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalldata, err)
+	}
+	commands, ok := args[0].([][32]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected commands type %T", ErrInvalidCalldata, args[0])
+	}
+	state, ok := args[1].([][]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected state type %T", ErrInvalidCalldata, args[1])
+	}
+
+	return DecodePlanWithConfig(commands, state, cfg)
+}
+
+// subplanCommands interprets state slot contents as a subplan: a bytes32[]
+// literal stored as [length][words]. Extended commands are reassembled from
+// their two words. Returns nil if data doesn't have that shape.
+func subplanCommands(data []byte) [][]byte {
+	if len(data) < 32 {
+		return nil
+	}
+	n := new(big.Int).SetBytes(data[:32])
+	if !n.IsInt64() || n.Int64() != int64(len(data)/32-1) || len(data)%32 != 0 {
+		return nil
+	}
+
+	var commands [][]byte
+	for i := 32; i < len(data); i += 32 {
+		end := i + CommandSize
+		if CallFlags(data[i+4]).IsExtended() && i+ExtendedCommandSize <= len(data) {
+			end = i + ExtendedCommandSize
+		}
+		commands = append(commands, data[i:end])
+		i = end - 32
+	}
+	return commands
+}
+
+// validateDecodedCommand checks a single reassembled command against the
+// size of the state array it will execute with.
+func validateDecodedCommand(cmd []byte, stateLen int, cfg EncodingConfig) error {
+	_, flags, argSlots, returnSlot, _, err := DecodeCommandWithConfig(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	if flags&reservedFlags(cfg) != 0 {
+		return fmt.Errorf("%w: reserved flag bits set (0x%02x)", ErrInvalidCommand, uint8(flags))
+	}
+
+	for i, slot := range argSlots {
+		if !slotInRange(slot, stateLen, cfg) {
+			return fmt.Errorf("%w: argument %d references slot %d beyond state length %d",
+				ErrInvalidCommand, i, slot&^cfg.DynamicSlotFlag, stateLen)
+		}
+	}
+
+	if returnSlot != cfg.NoReturnSlot && !slotInRange(returnSlot, stateLen, cfg) {
+		return fmt.Errorf("%w: return slot %d beyond state length %d",
+			ErrInvalidCommand, returnSlot&^cfg.DynamicSlotFlag, stateLen)
+	}
+
+	return nil
+}
+
+// slotInRange reports whether an encoded slot refers to a valid state entry.
+// The state marker is always valid since it refers to the whole state array.
+func slotInRange(slot uint8, stateLen int, cfg EncodingConfig) bool {
+	if slot == cfg.StateSlotMarker {
+		return true
+	}
+	return int(slot&^cfg.DynamicSlotFlag) < stateLen
+}
@@ -0,0 +1,352 @@
+package weiroll
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDecodePlan(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewLibrary(addr, testABI)
+
+	t.Run("Round-trips a compiled plan", func(t *testing.T) {
+		p := New()
+		sum := p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(contract.MustInvoke("multiply", sum, big.NewInt(10)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		decoded, err := DecodePlan(plan.CommandsAsBytes32(), plan.StateAsBytes())
+		if err != nil {
+			t.Fatalf("DecodePlan failed: %v", err)
+		}
+
+		if len(decoded.Commands) != len(plan.Commands) {
+			t.Fatalf("Expected %d commands, got %d", len(plan.Commands), len(decoded.Commands))
+		}
+		for i := range plan.Commands {
+			if !bytes.Equal(decoded.Commands[i], plan.Commands[i]) {
+				t.Errorf("Command %d mismatch: expected %x, got %x", i, plan.Commands[i], decoded.Commands[i])
+			}
+		}
+
+		if len(decoded.State) != len(plan.State) {
+			t.Fatalf("Expected %d state slots, got %d", len(plan.State), len(decoded.State))
+		}
+		for i := range plan.State {
+			if !bytes.Equal(decoded.State[i], plan.State[i]) {
+				t.Errorf("State %d mismatch", i)
+			}
+		}
+	})
+
+	t.Run("Round-trips allow-failure commands", func(t *testing.T) {
+		cfg := DefaultEncodingConfig()
+		cfg.AllowFailureFlag = 0x20
+
+		p := New()
+		sum := p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(contract.MustInvoke("multiply", sum, big.NewInt(10)).AllowFailure())
+		plan, err := p.Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		if _, err := DecodePlan(plan.CommandsAsBytes32(), plan.StateAsBytes()); !errors.Is(err, ErrInvalidCommand) {
+			t.Errorf("Expected the default encoding to reject the flag, got %v", err)
+		}
+		decoded, err := DecodePlanWithConfig(plan.CommandsAsBytes32(), plan.StateAsBytes(), cfg)
+		if err != nil {
+			t.Fatalf("DecodePlanWithConfig failed: %v", err)
+		}
+		if !equalByteSlices(decoded.Commands, plan.Commands) || !equalByteSlices(decoded.State, plan.State) {
+			t.Error("Expected decoded plan to equal the original")
+		}
+	})
+
+	t.Run("Round-trips custom encoding constants", func(t *testing.T) {
+		cfg := EncodingConfig{
+			DynamicSlotFlag: 0x40,
+			StateSlotMarker: 0x3E,
+			NoReturnSlot:    0x3D,
+			UnusedSlot:      0x3F,
+		}
+
+		p := New()
+		p.Add(contract.MustInvoke("getString"))
+		p.Add(contract.MustInvoke("noReturn", big.NewInt(1)))
+		sub := New()
+		sub.Add(contract.MustInvoke("noReturn", big.NewInt(2)))
+		if _, err := p.AddSubplan(contract.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		plan, err := p.Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		decoded, err := DecodePlanWithConfig(plan.CommandsAsBytes32(), plan.StateAsBytes(), cfg)
+		if err != nil {
+			t.Fatalf("DecodePlanWithConfig failed: %v", err)
+		}
+		if !equalByteSlices(decoded.Commands, plan.Commands) || !equalByteSlices(decoded.State, plan.State) {
+			t.Error("Expected decoded plan to equal the original")
+		}
+	})
+
+	t.Run("Reassembles extended commands", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		argSlots := []uint8{0, 1, 0, 1, 0, 1, 0}
+		ext := encoder.EncodeExtended([4]byte{1, 2, 3, 4}, FlagCall, argSlots, NoReturnSlot, addr)
+		std := encoder.Encode([4]byte{5, 6, 7, 8}, FlagCall, []uint8{0}, NoReturnSlot, addr)
+		plan := &CompiledPlan{
+			Commands: [][]byte{ext, std},
+			State:    [][]byte{make([]byte, 32), make([]byte, 32)},
+		}
+
+		decoded, err := DecodePlan(plan.CommandsAsBytes32(), plan.State)
+		if err != nil {
+			t.Fatalf("DecodePlan failed: %v", err)
+		}
+		if decoded.CommandCount() != 2 {
+			t.Fatalf("Expected 2 commands, got %d", decoded.CommandCount())
+		}
+		if !bytes.Equal(decoded.Commands[0], ext) {
+			t.Error("Extended command should be reassembled from both words")
+		}
+		if !bytes.Equal(decoded.Commands[1], std) {
+			t.Error("Standard command should follow extended command")
+		}
+	})
+
+	t.Run("Rejects truncated extended command", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		ext := encoder.EncodeExtended([4]byte{}, FlagCall, make([]uint8, 7), NoReturnSlot, addr)
+		var word [32]byte
+		copy(word[:], ext[:32])
+
+		_, err := DecodePlan([][32]byte{word}, [][]byte{make([]byte, 32)})
+		if !errors.Is(err, ErrInvalidCommand) {
+			t.Errorf("Expected ErrInvalidCommand, got %v", err)
+		}
+	})
+
+	t.Run("Rejects reserved flag bits", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		cmd := encoder.Encode([4]byte{}, FlagCall|0x04, []uint8{0}, NoReturnSlot, addr)
+		var word [32]byte
+		copy(word[:], cmd)
+
+		_, err := DecodePlan([][32]byte{word}, [][]byte{make([]byte, 32)})
+		if !errors.Is(err, ErrInvalidCommand) {
+			t.Errorf("Expected ErrInvalidCommand, got %v", err)
+		}
+	})
+
+	t.Run("Rejects out-of-range slots", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		tests := []struct {
+			name       string
+			argSlots   []uint8
+			returnSlot uint8
+		}{
+			{"argument", []uint8{3}, NoReturnSlot},
+			{"dynamic argument", []uint8{3 | DynamicSlotFlag}, NoReturnSlot},
+			{"return", []uint8{0}, 5},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cmd := encoder.Encode([4]byte{}, FlagCall, tt.argSlots, tt.returnSlot, addr)
+				var word [32]byte
+				copy(word[:], cmd)
+
+				_, err := DecodePlan([][32]byte{word}, [][]byte{make([]byte, 32)})
+				if !errors.Is(err, ErrInvalidCommand) {
+					t.Errorf("Expected ErrInvalidCommand, got %v", err)
+				}
+				var planErr *PlanError
+				if !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+					t.Errorf("Expected PlanError for command 0, got %v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("Accepts state marker argument", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		cmd := encoder.Encode([4]byte{}, FlagCall, []uint8{StateSlotMarker}, NoReturnSlot, addr)
+		var word [32]byte
+		copy(word[:], cmd)
+
+		if _, err := DecodePlan([][32]byte{word}, nil); err != nil {
+			t.Errorf("Expected state marker to be accepted, got %v", err)
+		}
+	})
+}
+
+func TestValidateVMABI(t *testing.T) {
+	vmABI, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"execute","stateMutability":"payable",
+		 "inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],
+		 "outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"executeShortcut","stateMutability":"payable",
+		 "inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],
+		 "outputs":[]},
+		{"type":"function","name":"executeSwapped","stateMutability":"payable",
+		 "inputs":[{"name":"state","type":"bytes[]"},{"name":"commands","type":"bytes32[]"}],
+		 "outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"executeOne","stateMutability":"payable",
+		 "inputs":[{"name":"commands","type":"bytes32[]"}],
+		 "outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"executeCount","stateMutability":"payable",
+		 "inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],
+		 "outputs":[{"name":"","type":"uint256"}]}
+	]`))
+	if err != nil {
+		t.Fatalf("Failed to parse VM ABI: %v", err)
+	}
+
+	t.Run("accepts compatible methods", func(t *testing.T) {
+		for _, name := range []string{"execute", "executeShortcut"} {
+			if err := ValidateVMABI(vmABI, name); err != nil {
+				t.Errorf("%s: expected no error, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("rejects wrong inputs", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"executeSwapped": "takes (bytes[], bytes32[])",
+			"executeOne":     "takes (bytes32[])",
+		} {
+			err := ValidateVMABI(vmABI, name)
+			if !errors.Is(err, ErrIncompatibleVMABI) {
+				t.Fatalf("%s: expected ErrIncompatibleVMABI, got %v", name, err)
+			}
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected error to contain %q, got %q", name, want, err.Error())
+			}
+		}
+	})
+
+	t.Run("rejects wrong output", func(t *testing.T) {
+		err := ValidateVMABI(vmABI, "executeCount")
+		if !errors.Is(err, ErrIncompatibleVMABI) {
+			t.Fatalf("Expected ErrIncompatibleVMABI, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "returns (uint256)") {
+			t.Errorf("Expected error to name the output, got %q", err.Error())
+		}
+	})
+
+	t.Run("rejects missing method", func(t *testing.T) {
+		if err := ValidateVMABI(vmABI, "run"); !errors.Is(err, ErrIncompatibleVMABI) {
+			t.Errorf("Expected ErrIncompatibleVMABI, got %v", err)
+		}
+	})
+}
+
+func TestDecodeExecuteCalldata(t *testing.T) {
+	vmABI, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"execute","stateMutability":"payable",
+		 "inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],
+		 "outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"owner","stateMutability":"view",
+		 "inputs":[],"outputs":[{"name":"","type":"address"}]}
+	]`))
+	if err != nil {
+		t.Fatalf("Failed to parse VM ABI: %v", err)
+	}
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewLibrary(addr, plannerTestABI())
+
+	t.Run("Round-trips execute calldata", func(t *testing.T) {
+		p := New()
+		sum := p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(contract.MustInvoke("multiply", sum, big.NewInt(10)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		calldata, err := vmABI.Pack("execute", plan.CommandsAsBytes32(), plan.StateAsBytes())
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+
+		decoded, err := DecodeExecuteCalldata(vmABI, calldata)
+		if err != nil {
+			t.Fatalf("DecodeExecuteCalldata failed: %v", err)
+		}
+		if decoded.ID() != plan.ID() {
+			t.Error("Expected decoded plan to equal the original")
+		}
+	})
+
+	t.Run("Decodes with custom encoding constants", func(t *testing.T) {
+		cfg := DefaultEncodingConfig()
+		cfg.AllowFailureFlag = 0x20
+
+		p := New()
+		p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)).AllowFailure())
+		plan, err := p.Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		calldata, err := vmABI.Pack("execute", plan.CommandsAsBytes32(), plan.StateAsBytes())
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+
+		decoded, err := DecodeExecuteCalldataWithConfig(vmABI, calldata, cfg)
+		if err != nil {
+			t.Fatalf("DecodeExecuteCalldataWithConfig failed: %v", err)
+		}
+		if decoded.ID() != plan.ID() {
+			t.Error("Expected decoded plan to equal the original")
+		}
+	})
+
+	t.Run("Rejects wrong method", func(t *testing.T) {
+		calldata, err := vmABI.Pack("owner")
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+
+		_, err = DecodeExecuteCalldata(vmABI, calldata)
+		if !errors.Is(err, ErrInvalidCalldata) || !errors.Is(err, ErrIncompatibleVMABI) {
+			t.Errorf("Expected ErrInvalidCalldata wrapping ErrIncompatibleVMABI, got %v", err)
+		}
+	})
+
+	t.Run("Rejects unknown selector and short calldata", func(t *testing.T) {
+		for _, calldata := range [][]byte{{0xde, 0xad, 0xbe, 0xef}, {0x01}} {
+			if _, err := DecodeExecuteCalldata(vmABI, calldata); !errors.Is(err, ErrInvalidCalldata) {
+				t.Errorf("Expected ErrInvalidCalldata for %x, got %v", calldata, err)
+			}
+		}
+	})
+
+	t.Run("Rejects truncated arguments", func(t *testing.T) {
+		calldata, err := vmABI.Pack("execute", [][32]byte{{}}, [][]byte{})
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+
+		_, err = DecodeExecuteCalldata(vmABI, calldata[:40])
+		if !errors.Is(err, ErrInvalidCalldata) {
+			t.Errorf("Expected ErrInvalidCalldata, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package weiroll
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// PlanDescription is a structured, JSON-serializable view of a planner's
+// commands, for UIs and audit tools. See Planner.Describe.
+type PlanDescription struct {
+	Commands []CommandDescription `json:"commands"`
+}
+
+// CommandDescription describes one command of a PlanDescription.
+type CommandDescription struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`            // "call", "replaceState" or "subplan"
+	Label string `json:"label,omitempty"` // See WithLabel
+
+	// Target is the contract address, or the zero address for a dynamic
+	// contract, whose runtime address is described by DynamicTarget.
+	Target        common.Address  `json:"target"`
+	DynamicTarget *ArgDescription `json:"dynamicTarget,omitempty"`
+
+	// Proxy is set if Target is a proxy; see NewProxyContract. Implementation
+	// is the address behind it, if known.
+	Proxy          bool            `json:"proxy,omitempty"`
+	Implementation *common.Address `json:"implementation,omitempty"`
+
+	CallType string           `json:"callType"`      // "delegatecall", "call", "staticcall" or "callWithValue"
+	Method   string           `json:"method"`        // Method signature, e.g. "add(uint256,uint256)"
+	Doc      string           `json:"doc,omitempty"` // See Command.Documentation
+	Args     []ArgDescription `json:"args"`
+	Value    *big.Int         `json:"value,omitempty"` // ETH value sent with the call
+
+	// Returns is the ABI type of the return value, empty if there is none.
+	Returns string `json:"returns,omitempty"`
+
+	// Consumers are the indices of later commands in the same planner that
+	// use this command's return value.
+	Consumers []int `json:"consumers,omitempty"`
+}
+
+// ArgDescription describes one argument of a CommandDescription.
+type ArgDescription struct {
+	Kind string `json:"kind"` // "literal", "return", "state", "subplan", "param" or "slot"
+	Type string `json:"type"`
+
+	// Value is the encoded state slot contents of a literal.
+	Value hexutil.Bytes `json:"value,omitempty"`
+
+	// Producer is the index of the command whose return value is used.
+	// It is nil if the value comes from an enclosing planner.
+	Producer *int `json:"producer,omitempty"`
+
+	Name    string           `json:"name,omitempty"`    // Name of a template parameter
+	Slot    *uint8           `json:"slot,omitempty"`    // State slot of a caller-filled value
+	Subplan *PlanDescription `json:"subplan,omitempty"` // Commands of a nested subplan
+}
+
+// Describe returns a structured description of the plan, linking each
+// return value to the commands that consume it. It works on the planner
+// as built, without compiling, so slot allocation and optimizations are
+// not reflected. Subplans are described recursively; producer and
+// consumer indices refer to commands within the same planner.
+func (p *Planner) Describe() PlanDescription {
+	indices := make(map[*Command]int, len(p.commands))
+	for i, cmd := range p.commands {
+		indices[cmd] = i
+	}
+
+	desc := PlanDescription{Commands: make([]CommandDescription, len(p.commands))}
+	for i, cmd := range p.commands {
+		call := cmd.call
+		d := CommandDescription{
+			Index:    i,
+			Type:     describeCommandType(cmd.cmdType),
+			Target:   call.contract.Address(),
+			CallType: describeCallType(call.flags.CallType()),
+			Method:   call.method.Sig,
+			Doc:      cmd.Documentation(),
+			Label:    cmd.label,
+			Args:     make([]ArgDescription, len(call.args)),
+			Value:    call.EthValue(),
+		}
+		if call.contract.IsProxy() {
+			d.Proxy = true
+			if impl := call.contract.Implementation(); impl != (common.Address{}) {
+				d.Implementation = &impl
+			}
+		}
+		if cmd.cmdType != CommandTypeRawCall && call.HasReturnValue() {
+			d.Returns = cmd.returnTypeString()
+		}
+
+		if addr := call.contract.addressValue; addr != nil {
+			target := describeArg(addr, indices)
+			d.DynamicTarget = &target
+			linkConsumer(desc.Commands, addr, indices, i)
+		}
+		for j, arg := range call.args {
+			d.Args[j] = describeArg(arg, indices)
+			linkConsumer(desc.Commands, arg, indices, i)
+		}
+		desc.Commands[i] = d
+	}
+	return desc
+}
+
+// describeArg describes a single value. indices maps the commands of the
+// planner being described to their positions.
+func describeArg(v Value, indices map[*Command]int) ArgDescription {
+	arg := ArgDescription{Type: v.Type().String()}
+	switch val := v.(type) {
+	case *LiteralValue:
+		arg.Kind = "literal"
+		arg.Value = val.Data()
+	case *ReturnValue:
+		arg.Kind = "return"
+		if i, ok := indices[val.command]; ok {
+			arg.Producer = &i
+		}
+	case *StateValue:
+		arg.Kind = "state"
+	case *SubplanValue:
+		arg.Kind = "subplan"
+		sub := val.subplanner.Describe()
+		arg.Subplan = &sub
+	case *ParamValue:
+		arg.Kind = "param"
+		arg.Name = val.name
+	case *SlotValue:
+		arg.Kind = "slot"
+		slot := val.slot
+		arg.Slot = &slot
+	}
+	return arg
+}
+
+// linkConsumer records command consumer as a consumer of v, if v is the
+// return value of an earlier command in commands.
+func linkConsumer(commands []CommandDescription, v Value, indices map[*Command]int, consumer int) {
+	ret, ok := v.(*ReturnValue)
+	if !ok {
+		return
+	}
+	if i, ok := indices[ret.command]; ok && i < consumer {
+		commands[i].Consumers = append(commands[i].Consumers, consumer)
+	}
+}
+
+// describeCommandType names a command type for PlanDescription.
+func describeCommandType(t CommandType) string {
+	switch t {
+	case CommandTypeRawCall:
+		return "replaceState"
+	case CommandTypeSubplan:
+		return "subplan"
+	default:
+		return "call"
+	}
+}
+
+// describeCallType names a call type for PlanDescription.
+func describeCallType(f CallFlags) string {
+	switch f {
+	case FlagDelegateCall:
+		return "delegatecall"
+	case FlagStaticCall:
+		return "staticcall"
+	case FlagCallWithValue:
+		return "callWithValue"
+	default:
+		return "call"
+	}
+}
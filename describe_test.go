@@ -0,0 +1,128 @@
+package weiroll
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPlannerDescribe(t *testing.T) {
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	extAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	lib := NewLibrary(libAddr, plannerTestABI())
+	ext := NewContract(extAddr, plannerTestABI())
+
+	t.Run("links producers to consumers", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		product := p.Add(ext.MustInvoke("multiply", sum, big.NewInt(3)))
+		p.Add(lib.MustInvoke("add", sum, product))
+
+		desc := p.Describe()
+		if len(desc.Commands) != 3 {
+			t.Fatalf("Expected 3 commands, got %d", len(desc.Commands))
+		}
+
+		first := desc.Commands[0]
+		if first.Target != libAddr || first.CallType != "delegatecall" || first.Method != "add(uint256,uint256)" {
+			t.Errorf("Unexpected first command %+v", first)
+		}
+		if first.Returns != "uint256" {
+			t.Errorf("Expected uint256 return, got %q", first.Returns)
+		}
+		if len(first.Consumers) != 2 || first.Consumers[0] != 1 || first.Consumers[1] != 2 {
+			t.Errorf("Expected consumers [1 2], got %v", first.Consumers)
+		}
+		if arg := first.Args[0]; arg.Kind != "literal" || arg.Producer != nil || new(big.Int).SetBytes(arg.Value).Int64() != 1 {
+			t.Errorf("Expected literal 1, got %+v", arg)
+		}
+
+		second := desc.Commands[1]
+		if second.CallType != "call" {
+			t.Errorf("Expected call, got %q", second.CallType)
+		}
+		if arg := second.Args[0]; arg.Kind != "return" || arg.Producer == nil || *arg.Producer != 0 {
+			t.Errorf("Expected return of command 0, got %+v", arg)
+		}
+		if len(second.Consumers) != 1 || second.Consumers[0] != 2 {
+			t.Errorf("Expected consumers [2], got %v", second.Consumers)
+		}
+
+		third := desc.Commands[2]
+		if *third.Args[0].Producer != 0 || *third.Args[1].Producer != 1 {
+			t.Errorf("Expected producers 0 and 1, got %+v", third.Args)
+		}
+		if len(third.Consumers) != 0 {
+			t.Errorf("Expected no consumers, got %v", third.Consumers)
+		}
+	})
+
+	t.Run("describes subplans recursively", func(t *testing.T) {
+		p := New()
+		outer := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("multiply", outer, big.NewInt(2)))
+		if _, err := p.AddSubplan(ext.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		cmd := p.Describe().Commands[1]
+		if cmd.Type != "subplan" {
+			t.Errorf("Expected subplan command, got %q", cmd.Type)
+		}
+		if cmd.Args[0].Kind != "subplan" || cmd.Args[0].Subplan == nil {
+			t.Fatalf("Expected nested description, got %+v", cmd.Args[0])
+		}
+		if cmd.Args[1].Kind != "state" {
+			t.Errorf("Expected state argument, got %q", cmd.Args[1].Kind)
+		}
+		nested := cmd.Args[0].Subplan.Commands
+		if len(nested) != 1 || nested[0].Args[0].Kind != "return" || nested[0].Args[0].Producer != nil {
+			t.Errorf("Expected outer return without local producer, got %+v", nested)
+		}
+	})
+
+	t.Run("notes proxy implementations", func(t *testing.T) {
+		implAddr := common.HexToAddress("0x3333333333333333333333333333333333333333")
+		p := New()
+		p.Add(NewProxyContract(extAddr, plannerTestABI(), WithImplementation(implAddr)).MustInvoke("getString"))
+		p.Add(ext.MustInvoke("getString"))
+
+		desc := p.Describe()
+		proxied := desc.Commands[0]
+		if proxied.Target != extAddr || !proxied.Proxy || proxied.Implementation == nil || *proxied.Implementation != implAddr {
+			t.Errorf("Expected proxy %s for %s, got %+v", extAddr.Hex(), implAddr.Hex(), proxied)
+		}
+		if plain := desc.Commands[1]; plain.Proxy || plain.Implementation != nil {
+			t.Errorf("Expected no proxy details, got %+v", plain)
+		}
+		if md := p.ToMarkdown(); !strings.Contains(md, extAddr.Hex()+" (proxy for "+implAddr.Hex()+")") {
+			t.Errorf("Expected markdown to note the implementation, got:\n%s", md)
+		}
+	})
+
+	t.Run("serializes to JSON", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, Param("factor", "uint256")))
+
+		data, err := json.Marshal(p.Describe())
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var decoded PlanDescription
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if arg := decoded.Commands[1].Args[1]; arg.Kind != "param" || arg.Name != "factor" {
+			t.Errorf("Expected param factor, got %+v", arg)
+		}
+		if decoded.Commands[0].Target != libAddr {
+			t.Errorf("Expected target %s, got %s", libAddr.Hex(), decoded.Commands[0].Target.Hex())
+		}
+	})
+}
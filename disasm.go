@@ -0,0 +1,137 @@
+package weiroll
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SelectorResolver maps 4-byte function selectors to signatures, for
+// disassembling plans whose contract ABIs aren't all available. Callers
+// can back it with a signature database such as 4byte.directory.
+type SelectorResolver interface {
+	// ResolveSelector returns the signature for selector, e.g.
+	// "transfer(address,uint256)", or false if it is unknown.
+	ResolveSelector(selector [4]byte) (string, bool)
+}
+
+// SelectorMap is a SelectorResolver backed by a local map.
+type SelectorMap map[[4]byte]string
+
+// ResolveSelector implements SelectorResolver.
+func (m SelectorMap) ResolveSelector(selector [4]byte) (string, bool) {
+	sig, ok := m[selector]
+	return sig, ok
+}
+
+// DisassembleOption configures Disassemble.
+type DisassembleOption func(*disasmConfig)
+
+// disasmConfig holds configuration for Disassemble.
+type disasmConfig struct {
+	resolver SelectorResolver
+}
+
+// WithSelectorResolver annotates each command's selector with the
+// signature resolver returns for it. Unresolved selectors are shown as is.
+func WithSelectorResolver(resolver SelectorResolver) DisassembleOption {
+	return func(c *disasmConfig) {
+		c.resolver = resolver
+	}
+}
+
+// Disassemble returns a human-readable line for each command of plan, e.g.
+//
+//	[0] DELEGATECALL 0x1111111111111111111111111111111111111111 selector=0x771602f7 args=[slot0, slot1] -> slot2
+//
+// An extended command is listed as one line whether plan holds it as a
+// single 64-byte entry or, as in the flattened bytes32[] the VM receives,
+// as two 32-byte entries; lines are numbered by logical command.
+func Disassemble(plan *CompiledPlan, opts ...DisassembleOption) ([]string, error) {
+	cfg := &disasmConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	lines := make([]string, 0, len(plan.Commands))
+	for next := 0; next < len(plan.Commands); next++ {
+		i := len(lines)
+		cmd := plan.Commands[next]
+		if len(cmd) >= CommandSize && CallFlags(cmd[4]).IsExtended() && len(cmd) < ExtendedCommandSize {
+			if len(cmd) != CommandSize || next+1 >= len(plan.Commands) || len(plan.Commands[next+1]) != CommandSize {
+				return nil, &PlanError{
+					CommandIndex: i,
+					Err:          fmt.Errorf("%w: extended command missing argument word", ErrInvalidCommand),
+				}
+			}
+			next++
+			cmd = append(slices.Clip(cmd), plan.Commands[next]...)
+		}
+
+		selector, flags, argSlots, returnSlot, address, err := DecodeCommand(cmd)
+		if err != nil {
+			return nil, &PlanError{CommandIndex: i, Err: err}
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "[%d] %s %s selector=%s", i, disasmCallType(flags), address.Hex(), hexutil.Encode(selector[:]))
+		if cfg.resolver != nil {
+			if sig, ok := cfg.resolver.ResolveSelector(selector); ok {
+				fmt.Fprintf(&b, " (%s)", sig)
+			}
+		}
+
+		valueSlot, argSlots, hasValue := SplitValueSlot(flags, argSlots)
+		if flags.HasDynamicTarget() && len(argSlots) > 0 {
+			fmt.Fprintf(&b, " target=%s", disasmSlot(argSlots[0]))
+			argSlots = argSlots[1:]
+		}
+
+		args := make([]string, len(argSlots))
+		for j, slot := range argSlots {
+			args[j] = disasmSlot(slot)
+		}
+		fmt.Fprintf(&b, " args=[%s]", strings.Join(args, ", "))
+		if hasValue {
+			fmt.Fprintf(&b, " value=%s", disasmSlot(valueSlot))
+		}
+
+		if returnSlot != NoReturnSlot {
+			fmt.Fprintf(&b, " -> %s", disasmSlot(returnSlot))
+			if flags.HasTupleReturn() {
+				b.WriteString(" (raw)")
+			}
+		}
+		lines = append(lines, b.String())
+	}
+	return lines, nil
+}
+
+// disasmCallType names a command's call type for Disassemble.
+func disasmCallType(flags CallFlags) string {
+	switch flags.CallType() {
+	case FlagDelegateCall:
+		return "DELEGATECALL"
+	case FlagCall:
+		return "CALL"
+	case FlagStaticCall:
+		return "STATICCALL"
+	default:
+		return "CALL_WITH_VALUE"
+	}
+}
+
+// disasmSlot formats an encoded slot for Disassemble, marking dynamic
+// slots with a trailing '*'.
+func disasmSlot(slot uint8) string {
+	if slot == StateSlotMarker {
+		return "state"
+	}
+	s := SlotIndex(slot)
+	if s.IsDynamic() {
+		return fmt.Sprintf("slot%d*", s.Index())
+	}
+	return fmt.Sprintf("slot%d", s.Index())
+}
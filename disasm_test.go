@@ -0,0 +1,117 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDisassemble(t *testing.T) {
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	extAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	t.Run("lists each command", func(t *testing.T) {
+		p := New()
+		sum := p.Add(NewLibrary(libAddr, plannerTestABI()).MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(NewContract(extAddr, plannerTestABI()).MustInvoke("multiply", sum, big.NewInt(3)).WithValue(big.NewInt(1)))
+		p.Add(NewContract(extAddr, plannerTestABI()).MustInvoke("noReturn", big.NewInt(4)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		lines, err := Disassemble(plan)
+		if err != nil {
+			t.Fatalf("Disassemble failed: %v", err)
+		}
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 lines, got %d: %v", len(lines), lines)
+		}
+		if want := "[0] DELEGATECALL " + libAddr.Hex() + " selector=0x771602f7 args=[slot1, slot2] -> slot0"; lines[0] != want {
+			t.Errorf("Line 0:\n got %q\nwant %q", lines[0], want)
+		}
+		if !strings.HasPrefix(lines[1], "[1] CALL_WITH_VALUE "+extAddr.Hex()) || !strings.Contains(lines[1], " value=slot") {
+			t.Errorf("Expected CALL_WITH_VALUE with value slot, got %q", lines[1])
+		}
+		if strings.Contains(lines[2], "->") {
+			t.Errorf("Expected no return slot, got %q", lines[2])
+		}
+	})
+
+	t.Run("resolves selectors", func(t *testing.T) {
+		swap := [4]byte{0x38, 0xed, 0x17, 0x39}
+		cmd := NewCommandEncoder().Encode(swap, FlagCall, []uint8{0, 1}, NewSlotIndex(2, true).Byte(), extAddr)
+		var word [32]byte
+		copy(word[:], cmd)
+		plan, err := DecodePlan([][32]byte{word}, [][]byte{make([]byte, 32), make([]byte, 32), nil})
+		if err != nil {
+			t.Fatalf("DecodePlan failed: %v", err)
+		}
+
+		resolver := SelectorMap{swap: "swapExactTokensForTokens(uint256,uint256,address[],address,uint256)"}
+		lines, err := Disassemble(plan, WithSelectorResolver(resolver))
+		if err != nil {
+			t.Fatalf("Disassemble failed: %v", err)
+		}
+		if !strings.Contains(lines[0], "selector=0x38ed1739 (swapExactTokensForTokens(uint256,uint256,address[],address,uint256))") {
+			t.Errorf("Expected resolved signature, got %q", lines[0])
+		}
+		if !strings.HasSuffix(lines[0], "-> slot2*") {
+			t.Errorf("Expected dynamic return slot, got %q", lines[0])
+		}
+
+		lines, err = Disassemble(plan, WithSelectorResolver(SelectorMap{}))
+		if err != nil {
+			t.Fatalf("Disassemble failed: %v", err)
+		}
+		if strings.Contains(lines[0], "(") {
+			t.Errorf("Expected unresolved selector to be shown as is, got %q", lines[0])
+		}
+	})
+
+	t.Run("pairs extended command words", func(t *testing.T) {
+		selector := [4]byte{0xde, 0xad, 0xbe, 0xef}
+		args := []uint8{0, 1, 2, 3, 4, 5, 6, NewSlotIndex(7, true).Byte()}
+		extended := NewCommandEncoder().EncodeExtended(selector, FlagCall, args, NoReturnSlot, extAddr)
+		standard := NewCommandEncoder().Encode(selector, FlagStaticCall, []uint8{0}, StateSlotMarker, extAddr)
+		want := []string{
+			"[0] CALL " + extAddr.Hex() + " selector=0xdeadbeef args=[slot0, slot1, slot2, slot3, slot4, slot5, slot6, slot7*]",
+			"[1] STATICCALL " + extAddr.Hex() + " selector=0xdeadbeef args=[slot0] -> state",
+		}
+
+		for name, commands := range map[string][][]byte{
+			"64-byte entry":   {extended, standard},
+			"32-byte entries": {extended[:CommandSize], extended[CommandSize:], standard},
+		} {
+			lines, err := Disassemble(&CompiledPlan{Commands: commands})
+			if err != nil {
+				t.Fatalf("%s: Disassemble failed: %v", name, err)
+			}
+			if len(lines) != len(want) {
+				t.Fatalf("%s: expected %d lines, got %v", name, len(want), lines)
+			}
+			for i := range want {
+				if lines[i] != want[i] {
+					t.Errorf("%s: line %d:\n got %q\nwant %q", name, i, lines[i], want[i])
+				}
+			}
+		}
+
+		_, err := Disassemble(&CompiledPlan{Commands: [][]byte{standard, extended[:CommandSize]}})
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 || !errors.Is(err, ErrInvalidCommand) {
+			t.Errorf("Expected ErrInvalidCommand for command 1, got %v", err)
+		}
+	})
+
+	t.Run("reports malformed commands", func(t *testing.T) {
+		_, err := Disassemble(&CompiledPlan{Commands: [][]byte{{0x01}}})
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+			t.Errorf("Expected PlanError for command 0, got %v", err)
+		}
+	})
+}
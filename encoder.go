@@ -1,6 +1,8 @@
 package weiroll
 
 import (
+	"fmt"
+
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -53,6 +55,11 @@ const (
 	// FlagCallTypeMask masks the call type bits.
 	FlagCallTypeMask CallFlags = 0x03
 
+	// FlagDynamicTarget takes the call target from the first argument slot
+	// instead of the address field. This is an extension to the weiroll VM;
+	// see NewDynamicContract.
+	FlagDynamicTarget CallFlags = 0x10
+
 	// FlagExtendedCommand indicates an extended command (>6 args).
 	FlagExtendedCommand CallFlags = 0x40
 
@@ -70,17 +77,77 @@ func (f CallFlags) IsExtended() bool {
 	return f&FlagExtendedCommand != 0
 }
 
+// HasDynamicTarget returns true if the target address is read from state.
+func (f CallFlags) HasDynamicTarget() bool {
+	return f&FlagDynamicTarget != 0
+}
+
 // HasTupleReturn returns true if return values are wrapped as bytes.
 func (f CallFlags) HasTupleReturn() bool {
 	return f&FlagTupleReturn != 0
 }
 
+// EncodingConfig holds the sentinel slot values written into commands.
+// Forked VMs may use different values than the reference weiroll VM.
+type EncodingConfig struct {
+	// DynamicSlotFlag is OR'd with slot index to mark dynamic types.
+	DynamicSlotFlag uint8
+
+	// StateSlotMarker is the slot value for planner state reference.
+	StateSlotMarker uint8
+
+	// NoReturnSlot indicates no return value is stored.
+	NoReturnSlot uint8
+
+	// UnusedSlot is used to pad unused argument slots.
+	UnusedSlot uint8
+
+	// AllowFailureFlag is set on commands marked with Call.AllowFailure,
+	// for VMs that continue past a reverting command. Zero, the default,
+	// means the VM always aborts on revert and Plan rejects such commands.
+	AllowFailureFlag CallFlags
+}
+
+// DefaultEncodingConfig returns the sentinel values of the weiroll VM.
+func DefaultEncodingConfig() EncodingConfig {
+	return EncodingConfig{
+		DynamicSlotFlag: DynamicSlotFlag,
+		StateSlotMarker: StateSlotMarker,
+		NoReturnSlot:    NoReturnSlot,
+		UnusedSlot:      UnusedSlot,
+	}
+}
+
+// slotIndex returns the state index of an encoded slot, without c's dynamic flag.
+func (c EncodingConfig) slotIndex(slot uint8) uint8 {
+	return slot &^ c.DynamicSlotFlag
+}
+
+// isDynamicSlot reports whether an encoded slot carries c's dynamic flag.
+func (c EncodingConfig) isDynamicSlot(slot uint8) bool {
+	return slot&c.DynamicSlotFlag != 0
+}
+
+// storesReturn reports whether an encoded return slot names a state slot,
+// rather than c's markers for no return value or a replaced state.
+func (c EncodingConfig) storesReturn(returnSlot uint8) bool {
+	return returnSlot != c.NoReturnSlot && returnSlot != c.StateSlotMarker
+}
+
 // CommandEncoder handles the encoding of weiroll commands.
-type CommandEncoder struct{}
+type CommandEncoder struct {
+	constants EncodingConfig
+}
 
 // NewCommandEncoder creates a new command encoder.
 func NewCommandEncoder() *CommandEncoder {
-	return &CommandEncoder{}
+	return &CommandEncoder{constants: DefaultEncodingConfig()}
+}
+
+// NewCommandEncoderWithConfig creates a command encoder that pads argument
+// slots with the given config's UnusedSlot value.
+func NewCommandEncoderWithConfig(cfg EncodingConfig) *CommandEncoder {
+	return &CommandEncoder{constants: cfg}
 }
 
 // Encode produces a 32-byte standard command encoding.
@@ -105,7 +172,7 @@ func (e *CommandEncoder) Encode(
 		if i < len(argSlots) {
 			cmd[5+i] = argSlots[i]
 		} else {
-			cmd[5+i] = UnusedSlot
+			cmd[5+i] = e.constants.UnusedSlot
 		}
 	}
 
@@ -145,7 +212,7 @@ func (e *CommandEncoder) EncodeExtended(
 		if i < len(argSlots) {
 			cmd[5+i] = argSlots[i]
 		} else {
-			cmd[5+i] = UnusedSlot
+			cmd[5+i] = e.constants.UnusedSlot
 		}
 	}
 
@@ -162,7 +229,7 @@ func (e *CommandEncoder) EncodeExtended(
 		if argIdx < len(argSlots) {
 			cmd[32+i] = argSlots[argIdx]
 		} else {
-			cmd[32+i] = UnusedSlot
+			cmd[32+i] = e.constants.UnusedSlot
 		}
 	}
 
@@ -188,6 +255,31 @@ func (e *CommandEncoder) EncodeCommand(
 	return e.EncodeExtended(selector, flags, argSlots, returnSlot, address), nil
 }
 
+// ToExtended re-encodes a command in the 64-byte extended form, regardless
+// of how many arguments it has. Extended commands are returned as a copy.
+// Returns nil if cmd is too short to be a command.
+func (e *CommandEncoder) ToExtended(cmd []byte) []byte {
+	selector, flags, argSlots, returnSlot, address, err := DecodeCommandWithConfig(cmd, e.constants)
+	if err != nil {
+		return nil
+	}
+	return e.EncodeExtended(selector, flags, argSlots, returnSlot, address)
+}
+
+// ToStandard re-encodes a command in the 32-byte standard form. Standard
+// commands are returned as a copy. Returns ErrTooManyArguments if the
+// command has more arguments than fit in a standard command.
+func (e *CommandEncoder) ToStandard(cmd []byte) ([]byte, error) {
+	selector, flags, argSlots, returnSlot, address, err := DecodeCommandWithConfig(cmd, e.constants)
+	if err != nil {
+		return nil, err
+	}
+	if len(argSlots) > MaxStandardArgs {
+		return nil, fmt.Errorf("%w: %d arguments do not fit a standard command", ErrTooManyArguments, len(argSlots))
+	}
+	return e.Encode(selector, flags&^FlagExtendedCommand, argSlots, returnSlot, address), nil
+}
+
 // DecodeCommand decodes a command byte slice into its components.
 // Useful for debugging and testing.
 func DecodeCommand(cmd []byte) (
@@ -197,6 +289,19 @@ func DecodeCommand(cmd []byte) (
 	returnSlot uint8,
 	address common.Address,
 	err error,
+) {
+	return DecodeCommandWithConfig(cmd, DefaultEncodingConfig())
+}
+
+// DecodeCommandWithConfig is like DecodeCommand but treats cfg.UnusedSlot
+// as argument padding, for commands encoded for a forked VM.
+func DecodeCommandWithConfig(cmd []byte, cfg EncodingConfig) (
+	selector [4]byte,
+	flags CallFlags,
+	argSlots []uint8,
+	returnSlot uint8,
+	address common.Address,
+	err error,
 ) {
 	if len(cmd) < CommandSize {
 		err = ErrTooManyArguments // Reusing error, could create a new one
@@ -210,12 +315,12 @@ func DecodeCommand(cmd []byte) (
 		// Extended command: 6 args in first word + up to 32 in second
 		argSlots = make([]uint8, 0, MaxExtendedArgs)
 		for i := 0; i < MaxStandardArgs; i++ {
-			if cmd[5+i] != UnusedSlot {
+			if cmd[5+i] != cfg.UnusedSlot {
 				argSlots = append(argSlots, cmd[5+i])
 			}
 		}
 		for i := 0; i < 32; i++ {
-			if cmd[32+i] != UnusedSlot {
+			if cmd[32+i] != cfg.UnusedSlot {
 				argSlots = append(argSlots, cmd[32+i])
 			}
 		}
@@ -223,7 +328,7 @@ func DecodeCommand(cmd []byte) (
 		// Standard command: up to 6 args
 		argSlots = make([]uint8, 0, MaxStandardArgs)
 		for i := 0; i < MaxStandardArgs; i++ {
-			if cmd[5+i] != UnusedSlot {
+			if cmd[5+i] != cfg.UnusedSlot {
 				argSlots = append(argSlots, cmd[5+i])
 			}
 		}
@@ -235,6 +340,19 @@ func DecodeCommand(cmd []byte) (
 	return
 }
 
+// SplitValueSlot separates the slot holding the ETH amount of a
+// CALL_WITH_VALUE command from its ABI argument slots, as decoded by
+// DecodeCommand. The VM reads the value from the first slot, ahead of a
+// dynamic target and the arguments; see Call.WithValue. ok is false for
+// other call types, or if argSlots is empty, in which case args is argSlots
+// unchanged.
+func SplitValueSlot(flags CallFlags, argSlots []uint8) (valueSlot uint8, args []uint8, ok bool) {
+	if flags.CallType() != FlagCallWithValue || len(argSlots) == 0 {
+		return 0, argSlots, false
+	}
+	return argSlots[0], argSlots[1:], true
+}
+
 // SlotIndex represents a state slot index with optional dynamic flag.
 type SlotIndex uint8
 
@@ -3,6 +3,8 @@ package weiroll
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -464,6 +466,119 @@ func TestNewCommandEncoder(t *testing.T) {
 	}
 }
 
+func TestEncodingConfig(t *testing.T) {
+	t.Run("default matches weiroll VM constants", func(t *testing.T) {
+		cfg := DefaultEncodingConfig()
+		if cfg.DynamicSlotFlag != DynamicSlotFlag || cfg.StateSlotMarker != StateSlotMarker ||
+			cfg.NoReturnSlot != NoReturnSlot || cfg.UnusedSlot != UnusedSlot {
+			t.Errorf("Unexpected default config: %+v", cfg)
+		}
+	})
+
+	t.Run("encoder pads with configured unused slot", func(t *testing.T) {
+		cfg := DefaultEncodingConfig()
+		cfg.UnusedSlot = 0x7F
+		encoder := NewCommandEncoderWithConfig(cfg)
+		cmd := encoder.Encode([4]byte{}, FlagCall, []uint8{1}, 0x7F, common.Address{})
+
+		for i := 6; i < 11; i++ {
+			if cmd[i] != 0x7F {
+				t.Errorf("Byte %d: expected padding 0x7F, got 0x%02x", i, cmd[i])
+			}
+		}
+
+		_, _, argSlots, _, _, err := DecodeCommandWithConfig(cmd, cfg)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if len(argSlots) != 1 || argSlots[0] != 1 {
+			t.Errorf("Expected [1], got %v", argSlots)
+		}
+	})
+
+	t.Run("plan compiles with altered markers", func(t *testing.T) {
+		lib := NewLibrary(common.HexToAddress("0x01"), plannerTestABI())
+		cfg := EncodingConfig{
+			DynamicSlotFlag: 0x40,
+			StateSlotMarker: 0x3E,
+			NoReturnSlot:    0x3D,
+			UnusedSlot:      0x3F,
+		}
+
+		p := New()
+		p.Add(lib.MustInvoke("getString"))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(2)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		plan, err := p.Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, _, returnSlot, _, err := DecodeCommandWithConfig(plan.Commands[0], cfg)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if returnSlot != cfg.NoReturnSlot {
+			t.Errorf("Expected unused return slot 0x%02x, got 0x%02x", cfg.NoReturnSlot, returnSlot)
+		}
+
+		_, _, argSlots, _, _, err := DecodeCommandWithConfig(plan.Commands[1], cfg)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if len(argSlots) != 1 {
+			t.Fatalf("Expected 1 argument, got %v", argSlots)
+		}
+
+		_, _, argSlots, _, _, err = DecodeCommandWithConfig(plan.Commands[2], cfg)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if len(argSlots) != 2 {
+			t.Fatalf("Expected 2 arguments, got %v", argSlots)
+		}
+		if argSlots[0]&cfg.DynamicSlotFlag == 0 {
+			t.Errorf("Expected subplan slot flagged dynamic with 0x%02x, got 0x%02x", cfg.DynamicSlotFlag, argSlots[0])
+		}
+		if argSlots[1] != cfg.StateSlotMarker {
+			t.Errorf("Expected state marker 0x%02x, got 0x%02x", cfg.StateSlotMarker, argSlots[1])
+		}
+	})
+
+	t.Run("dynamic flag is configurable", func(t *testing.T) {
+		lib := NewLibrary(common.HexToAddress("0x01"), testABI())
+		cfg := DefaultEncodingConfig()
+		cfg.DynamicSlotFlag = 0x40
+
+		p := New()
+		out := p.Add(lib.MustInvoke("dynamicArgs", "hello", []byte{1}))
+		p.Add(lib.MustInvoke("dynamicArgs", "world", out))
+
+		plan, err := p.Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, argSlots, returnSlot, _, err := DecodeCommandWithConfig(plan.Commands[0], cfg)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if returnSlot&0x40 == 0 || returnSlot&0x80 != 0 {
+			t.Errorf("Expected return slot flagged with 0x40, got 0x%02x", returnSlot)
+		}
+		for i, slot := range argSlots {
+			if slot&0x40 == 0 || slot&0x80 != 0 {
+				t.Errorf("Argument %d: expected slot flagged with 0x40, got 0x%02x", i, slot)
+			}
+		}
+	})
+}
+
 func TestEncodeEmptyArgs(t *testing.T) {
 	encoder := NewCommandEncoder()
 	selector := [4]byte{0x12, 0x34, 0x56, 0x78}
@@ -552,3 +667,144 @@ func TestEncodeCommandRoundtrip(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandFormConversion(t *testing.T) {
+	encoder := NewCommandEncoder()
+	selector := [4]byte{0x12, 0x34, 0x56, 0x78}
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+
+	t.Run("standard round-trips through extended", func(t *testing.T) {
+		std := encoder.Encode(selector, FlagCall|FlagTupleReturn, []uint8{0, 1 | DynamicSlotFlag}, 2, addr)
+
+		ext := encoder.ToExtended(std)
+		if len(ext) != ExtendedCommandSize {
+			t.Fatalf("Expected %d bytes, got %d", ExtendedCommandSize, len(ext))
+		}
+		if !CallFlags(ext[4]).IsExtended() {
+			t.Error("Expected extended flag to be set")
+		}
+
+		back, err := encoder.ToStandard(ext)
+		if err != nil {
+			t.Fatalf("ToStandard failed: %v", err)
+		}
+		if !bytes.Equal(back, std) {
+			t.Errorf("Expected %x, got %x", std, back)
+		}
+	})
+
+	t.Run("extended with few args downgrades", func(t *testing.T) {
+		ext := encoder.EncodeExtended(selector, FlagStaticCall, []uint8{0, 1, 2}, NoReturnSlot, addr)
+
+		std, err := encoder.ToStandard(ext)
+		if err != nil {
+			t.Fatalf("ToStandard failed: %v", err)
+		}
+		expected := encoder.Encode(selector, FlagStaticCall, []uint8{0, 1, 2}, NoReturnSlot, addr)
+		if !bytes.Equal(std, expected) {
+			t.Errorf("Expected %x, got %x", expected, std)
+		}
+		if !bytes.Equal(encoder.ToExtended(std), ext) {
+			t.Error("Expected ToExtended to restore the original command")
+		}
+	})
+
+	t.Run("already in target form", func(t *testing.T) {
+		std := encoder.Encode(selector, FlagCall, []uint8{0}, NoReturnSlot, addr)
+		same, err := encoder.ToStandard(std)
+		if err != nil || !bytes.Equal(same, std) {
+			t.Errorf("Expected unchanged standard command, got %x (%v)", same, err)
+		}
+
+		ext := encoder.EncodeExtended(selector, FlagCall, make([]uint8, 8), NoReturnSlot, addr)
+		if !bytes.Equal(encoder.ToExtended(ext), ext) {
+			t.Error("Expected unchanged extended command")
+		}
+	})
+
+	t.Run("standard conversion that would lose arguments", func(t *testing.T) {
+		ext := encoder.EncodeExtended(selector, FlagCall, []uint8{0, 1, 2, 3, 4, 5, 6}, NoReturnSlot, addr)
+
+		_, err := encoder.ToStandard(ext)
+		if !errors.Is(err, ErrTooManyArguments) {
+			t.Errorf("Expected ErrTooManyArguments, got %v", err)
+		}
+	})
+
+	t.Run("malformed command", func(t *testing.T) {
+		if encoder.ToExtended([]byte{0x01}) != nil {
+			t.Error("Expected nil for short command")
+		}
+		if _, err := encoder.ToStandard([]byte{0x01}); err == nil {
+			t.Error("Expected error for short command")
+		}
+	})
+}
+
+func TestSplitValueSlot(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI())
+
+	t.Run("separates the value slot of a payable command", func(t *testing.T) {
+		p := New()
+		p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(1000)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, flags, argSlots, _, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		valueSlot, args, ok := SplitValueSlot(flags, argSlots)
+		if !ok {
+			t.Fatal("Expected a value slot")
+		}
+		if valueSlot != argSlots[0] {
+			t.Errorf("Expected the value in the first slot of %v, got %d", argSlots, valueSlot)
+		}
+		if len(args) != 2 {
+			t.Errorf("Expected 2 argument slots, got %v", args)
+		}
+		if got := new(big.Int).SetBytes(plan.State[valueSlot]); got.Int64() != 1000 {
+			t.Errorf("Expected value slot to hold 1000, got %s", got)
+		}
+		for _, slot := range args {
+			if slot == valueSlot {
+				t.Errorf("Expected value slot %d excluded from arguments %v", valueSlot, args)
+			}
+		}
+	})
+
+	t.Run("zero value still takes the first slot", func(t *testing.T) {
+		p := New()
+		p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(0)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, flags, argSlots, _, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		valueSlot, args, ok := SplitValueSlot(flags, argSlots)
+		if !ok || len(args) != 2 {
+			t.Fatalf("Expected a value slot and 2 arguments, got ok=%v args=%v", ok, args)
+		}
+		if new(big.Int).SetBytes(plan.State[valueSlot]).Sign() != 0 {
+			t.Errorf("Expected value slot to hold 0, got %x", plan.State[valueSlot])
+		}
+	})
+
+	t.Run("ignores other call types", func(t *testing.T) {
+		slots := []uint8{0, 1}
+		if _, args, ok := SplitValueSlot(FlagCall, slots); ok || len(args) != 2 {
+			t.Errorf("Expected no value slot for CALL, got ok=%v args=%v", ok, args)
+		}
+		if _, _, ok := SplitValueSlot(FlagCallWithValue, nil); ok {
+			t.Error("Expected no value slot without argument slots")
+		}
+	})
+}
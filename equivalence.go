@@ -0,0 +1,167 @@
+package weiroll
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// EquivalentPlans reports whether a and b make the same calls with the same
+// arguments, ignoring how their state slots are assigned, e.g. to check
+// that WithSlotOptimization or WithSlotRange changed only the layout.
+//
+// Each argument slot is mapped back to the value it holds when the command
+// runs: a literal by its contents, a return value by the index of the
+// command producing it, and a caller-filled slot by its number. Subplans are
+// compared command by command in the same way. a and b must have been
+// compiled from aSrc and bSrc without options that drop or add commands.
+func EquivalentPlans(a, b *CompiledPlan, aSrc, bSrc *Planner) (bool, error) {
+	na, err := a.normalize(aSrc)
+	if err != nil {
+		return false, err
+	}
+	nb, err := b.normalize(bSrc)
+	if err != nil {
+		return false, err
+	}
+	if len(na) != len(nb) {
+		return false, nil
+	}
+	for i := range na {
+		if na[i] != nb[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// normalize renders each command of cp, including those of subplans, as a
+// string independent of the slot layout.
+func (cp *CompiledPlan) normalize(sourcePlanner *Planner) ([]string, error) {
+	if len(cp.Commands) != sourcePlanner.Len() {
+		return nil, fmt.Errorf("%w: %d compiled commands, %d planned",
+			ErrPlanMismatch, len(cp.Commands), sourcePlanner.Len())
+	}
+	n := &normalizer{plan: cp, writers: make(map[uint8]string)}
+	if err := n.commands(cp.Commands, sourcePlanner.commands, ""); err != nil {
+		return nil, err
+	}
+	return n.out, nil
+}
+
+// normalizer tracks, while walking a plan in execution order, which command
+// last wrote each slot.
+type normalizer struct {
+	plan     *CompiledPlan
+	writers  map[uint8]string // Slot index to the step that last wrote it
+	replaced string           // Step of the last state replacement, if any
+	out      []string
+}
+
+// commands normalizes encoded, compiled from source, numbering the steps
+// under prefix.
+func (n *normalizer) commands(encoded [][]byte, source []*Command, prefix string) error {
+	for i, data := range encoded {
+		cmd := source[i]
+		step := fmt.Sprintf("%s%d", prefix, i)
+
+		selector, flags, argSlots, returnSlot, address, err := DecodeCommand(data)
+		if err != nil {
+			return cmd.planError(i, err)
+		}
+		if selector != cmd.call.Selector() {
+			return cmd.planError(i, ErrPlanMismatch)
+		}
+
+		args := cmd.call.Args()
+		if flags.HasDynamicTarget() {
+			args = append([]Value{cmd.call.contract.addressValue}, args...)
+		}
+		if flags.CallType() == FlagCallWithValue {
+			args = append([]Value{nil}, args...)
+		}
+		if len(argSlots) < len(args) {
+			return cmd.planError(i, ErrPlanMismatch)
+		}
+
+		tokens := make([]string, len(argSlots))
+		for j, slot := range argSlots {
+			var arg Value
+			if j < len(args) {
+				arg = args[j]
+			}
+			tokens[j], err = n.arg(slot, arg)
+			if err != nil {
+				return cmd.planError(i, err)
+			}
+			// A subplan runs during the command, before its result is written
+			if sub, ok := arg.(*SubplanValue); ok {
+				data, err := n.plan.slotData(slot)
+				if err != nil {
+					return cmd.planError(i, err)
+				}
+				words := subplanCommands(data)
+				if len(words) != sub.subplanner.Len() {
+					return cmd.planError(i, ErrPlanMismatch)
+				}
+				if err := n.commands(words, sub.subplanner.commands, step+"."); err != nil {
+					return err
+				}
+			}
+		}
+
+		var ret string
+		switch returnSlot {
+		case NoReturnSlot:
+			ret = "none"
+		case StateSlotMarker:
+			ret = "state"
+			n.writers = make(map[uint8]string)
+			n.replaced = step
+		default:
+			ret = "value"
+			if SlotIndex(returnSlot).IsDynamic() {
+				ret += "*"
+			}
+			n.writers[SlotIndex(returnSlot).Index()] = step
+		}
+
+		n.out = append(n.out, fmt.Sprintf("%s %x flags=0x%02x target=%s args=[%s] -> %s",
+			step, selector, uint8(flags&^FlagExtendedCommand), address.Hex(), strings.Join(tokens, ", "), ret))
+	}
+	return nil
+}
+
+// arg returns a layout-independent token for an argument slot holding arg,
+// which is nil for the value slot of a CALL_WITH_VALUE.
+func (n *normalizer) arg(slot uint8, arg Value) (string, error) {
+	if slot == StateSlotMarker {
+		return "state", nil
+	}
+	if _, ok := arg.(*SubplanValue); ok {
+		return "subplan", nil
+	}
+
+	index := SlotIndex(slot).Index()
+	var token string
+	if v, ok := arg.(*SlotValue); ok {
+		token = fmt.Sprintf("slot %d", v.slot)
+	} else if writer, ok := n.writers[index]; ok {
+		token = "$" + writer
+	} else if n.replaced != "" {
+		// The contents come from a replaced state, which only the layout
+		// identifies.
+		token = fmt.Sprintf("state@%s[%d]", n.replaced, index)
+	} else {
+		data, err := n.plan.slotData(slot)
+		if err != nil {
+			return "", err
+		}
+		token = hexutil.Encode(data)
+	}
+	if SlotIndex(slot).IsDynamic() {
+		token += "*"
+	}
+	return token, nil
+}
@@ -0,0 +1,135 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEquivalentPlans(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	build := func(last int64) *Planner {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("multiply", a, big.NewInt(3)))
+		c := p.Add(lib.MustInvoke("add", b, big.NewInt(4)))
+		d := p.Add(lib.MustInvoke("multiply", c, big.NewInt(5)))
+		p.Add(lib.MustInvoke("noReturn", d))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(last)))
+		return p
+	}
+
+	t.Run("optimized and unoptimized plans are equivalent", func(t *testing.T) {
+		p := build(6)
+		optimized, err := p.Plan(WithSlotOptimization(true))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		unoptimized, err := p.Plan(WithSlotOptimization(false))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if equalByteSlices(optimized.Commands, unoptimized.Commands) {
+			t.Fatal("Expected slot optimization to change the layout")
+		}
+
+		equivalent, err := EquivalentPlans(optimized, unoptimized, p, p)
+		if err != nil {
+			t.Fatalf("EquivalentPlans failed: %v", err)
+		}
+		if !equivalent {
+			t.Error("Expected plans to be equivalent")
+		}
+	})
+
+	t.Run("different literal is not equivalent", func(t *testing.T) {
+		a, b := build(6), build(7)
+		planA, err := a.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		planB, err := b.Plan(WithSlotOptimization(false))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		equivalent, err := EquivalentPlans(planA, planB, a, b)
+		if err != nil {
+			t.Fatalf("EquivalentPlans failed: %v", err)
+		}
+		if equivalent {
+			t.Error("Expected plans with different literals not to be equivalent")
+		}
+	})
+
+	t.Run("different producer is not equivalent", func(t *testing.T) {
+		a := New()
+		x := a.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		a.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		a.Add(lib.MustInvoke("noReturn", x))
+
+		b := New()
+		b.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		y := b.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b.Add(lib.MustInvoke("noReturn", y))
+
+		planA, err := a.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		planB, err := b.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		equivalent, err := EquivalentPlans(planA, planB, a, b)
+		if err != nil {
+			t.Fatalf("EquivalentPlans failed: %v", err)
+		}
+		if equivalent {
+			t.Error("Expected plans reading different commands not to be equivalent")
+		}
+	})
+
+	t.Run("compares subplans", func(t *testing.T) {
+		p := New()
+		v := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		sub := New()
+		w := sub.Add(lib.MustInvoke("multiply", v, big.NewInt(5)))
+		sub.Add(lib.MustInvoke("noReturn", w))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		optimized, err := p.Plan(WithSlotOptimization(true))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		shifted, err := p.Plan(WithSlotRange(10, 127))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		equivalent, err := EquivalentPlans(optimized, shifted, p, p)
+		if err != nil {
+			t.Fatalf("EquivalentPlans failed: %v", err)
+		}
+		if !equivalent {
+			t.Error("Expected plans to be equivalent")
+		}
+	})
+
+	t.Run("rejects plan from another planner", func(t *testing.T) {
+		p := build(6)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if _, err := EquivalentPlans(plan, plan, p, New()); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+	})
+}
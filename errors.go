@@ -5,6 +5,7 @@ package weiroll
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -31,16 +32,127 @@ var (
 
 	// ErrNoReturnValue indicates the function has no return value to capture.
 	ErrNoReturnValue = errors.New("weiroll: function has no return value")
+
+	// ErrInvalidCommand indicates an encoded command is malformed.
+	ErrInvalidCommand = errors.New("weiroll: malformed command encoding")
+
+	// ErrInvalidAddress indicates an address string is malformed or mis-checksummed.
+	ErrInvalidAddress = errors.New("weiroll: invalid address")
+
+	// ErrUnboundParam indicates a template parameter has no value.
+	ErrUnboundParam = errors.New("weiroll: unbound template parameter")
+
+	// ErrNoSafeSplit indicates a plan can't be partitioned within the given limits.
+	ErrNoSafeSplit = errors.New("weiroll: no safe split point within limits")
+
+	// ErrNameInUse indicates a return value name is already registered.
+	ErrNameInUse = errors.New("weiroll: name already in use")
+
+	// ErrNameNotFound indicates no return value is registered under a name.
+	ErrNameNotFound = errors.New("weiroll: name not found")
+
+	// ErrPlanMismatch indicates a compiled plan doesn't match its source planner.
+	ErrPlanMismatch = errors.New("weiroll: compiled plan does not match planner")
+
+	// ErrStaticSlotSize indicates a static state slot isn't exactly 32 bytes.
+	ErrStaticSlotSize = errors.New("weiroll: static state slot is not 32 bytes")
+
+	// ErrNilArgument indicates a nil Go value was passed as a call argument.
+	ErrNilArgument = errors.New("weiroll: nil is not permitted")
+
+	// ErrCommandIndexOutOfRange indicates a command index outside the plan.
+	ErrCommandIndexOutOfRange = errors.New("weiroll: command index out of range")
+
+	// ErrReturnSuppressed indicates a return value whose capture was disabled is used.
+	ErrReturnSuppressed = errors.New("weiroll: return value capture suppressed but value is used")
+
+	// ErrInvalidCalldata indicates calldata isn't a call to a weiroll execute method.
+	ErrInvalidCalldata = errors.New("weiroll: calldata is not an execute call")
+
+	// ErrIncompatibleVMABI indicates an ABI method can't execute weiroll plans.
+	ErrIncompatibleVMABI = errors.New("weiroll: method is not a weiroll execute method")
+
+	// ErrCalldataTooLarge indicates a plan's execute calldata exceeds the configured budget.
+	ErrCalldataTooLarge = errors.New("weiroll: execute calldata exceeds size limit")
+
+	// ErrUntrustedDelegateCall indicates a DELEGATECALL to an Untrusted contract.
+	ErrUntrustedDelegateCall = errors.New("weiroll: delegatecall to untrusted contract")
+
+	// ErrAllowFailureUnsupported indicates a call allows failure but the encoding has no flag for it.
+	ErrAllowFailureUnsupported = errors.New("weiroll: allow-failure commands not supported by encoding")
+
+	// ErrPlannerFrozen indicates a command was added to a frozen planner.
+	ErrPlannerFrozen = errors.New("weiroll: planner is frozen")
+
+	// ErrCallNotAllowed indicates a command targets a contract or method outside an allowlist.
+	ErrCallNotAllowed = errors.New("weiroll: call not in allowlist")
+
+	// ErrSlotNotReserved indicates a SlotValue refers to a slot outside the reserved range.
+	ErrSlotNotReserved = errors.New("weiroll: slot is not reserved")
+
+	// ErrIntegerOutOfRange indicates an integer literal doesn't fit its ABI type.
+	ErrIntegerOutOfRange = errors.New("weiroll: integer out of range")
+
+	// ErrNonLiteralArgument indicates a call argument must be a literal but isn't.
+	ErrNonLiteralArgument = errors.New("weiroll: argument is not a literal")
+
+	// ErrStaleSlotRead indicates a command reads a return value whose slot was already reused.
+	ErrStaleSlotRead = errors.New("weiroll: return value read after its slot was reused")
+
+	// ErrInvalidBinaryPlan indicates data passed to UnmarshalBinary is malformed.
+	ErrInvalidBinaryPlan = errors.New("weiroll: malformed binary plan")
+
+	// ErrInvalidJSONPlan indicates data passed to UnmarshalJSON is malformed.
+	ErrInvalidJSONPlan = errors.New("weiroll: malformed JSON plan")
+
+	// ErrStateEntryTooLarge indicates a state entry exceeds the WithMaxStateEntryBytes limit.
+	ErrStateEntryTooLarge = errors.New("weiroll: state entry exceeds size limit")
+
+	// ErrNotAtomic indicates a flash-loan plan doesn't wrap dependent operations in its callback.
+	ErrNotAtomic = errors.New("weiroll: operation not enclosed by flash-loan callback")
+
+	// ErrDivisionByZero indicates a Ratio literal with a zero denominator.
+	ErrDivisionByZero = errors.New("weiroll: division by zero")
+
+	// ErrForeignValue indicates a state or subplan value of another planner.
+	ErrForeignValue = errors.New("weiroll: value belongs to another planner")
+
+	// ErrOutputIndexOutOfRange indicates a ReturnValue.At index beyond the method's outputs.
+	ErrOutputIndexOutOfRange = errors.New("weiroll: return value index out of range")
+
+	// ErrTupleReturnRequired indicates ReturnValue.At on a multi-value call without RawReturn.
+	ErrTupleReturnRequired = errors.New("weiroll: multiple return values require RawReturn")
+
+	// ErrReturnValueStillReferenced indicates RemoveAt of a command whose return value is in use.
+	ErrReturnValueStillReferenced = errors.New("weiroll: return value still referenced")
+
+	// ErrChainAddressNotFound indicates no address is registered under a name for a chain.
+	ErrChainAddressNotFound = errors.New("weiroll: chain address not found")
+
+	// ErrFeePaymentNotLast indicates a command labelled FeePaymentLabel is followed by other commands.
+	ErrFeePaymentNotLast = errors.New("weiroll: fee payment is not the last command")
+
+	// ErrRawReturnArgument indicates a RawReturn call's output passed as a call argument.
+	ErrRawReturnArgument = errors.New("weiroll: raw return value used as an argument")
+
+	// ErrAddHookPanicked indicates an add hook rejected a command added through SafePlanner.
+	ErrAddHookPanicked = errors.New("weiroll: add hook panicked")
 )
 
 // MethodNotFoundError indicates the contract doesn't have the requested method.
+// Suggestions lists the contract's closest method names, if any are near.
 type MethodNotFoundError struct {
-	Contract common.Address
-	Method   string
+	Contract    common.Address
+	Method      string
+	Suggestions []string
 }
 
 func (e *MethodNotFoundError) Error() string {
-	return fmt.Sprintf("weiroll: method %q not found in contract %s", e.Method, e.Contract.Hex())
+	msg := fmt.Sprintf("weiroll: method %q not found in contract %s", e.Method, e.Contract.Hex())
+	if len(e.Suggestions) > 0 {
+		msg += "; did you mean " + strings.Join(e.Suggestions, " or ") + "?"
+	}
+	return msg
 }
 
 // ArgumentError indicates an issue with a function argument.
@@ -72,14 +184,19 @@ func (e *TypeMismatchError) Error() string {
 type PlanError struct {
 	CommandIndex int
 	Method       string
+	Source       string // file:line that added the command, if tracked
 	Err          error
 }
 
 func (e *PlanError) Error() string {
+	location := fmt.Sprintf("command %d", e.CommandIndex)
 	if e.Method != "" {
-		return fmt.Sprintf("weiroll: command %d (%s): %v", e.CommandIndex, e.Method, e.Err)
+		location += fmt.Sprintf(" (%s)", e.Method)
+	}
+	if e.Source != "" {
+		location += " at " + e.Source
 	}
-	return fmt.Sprintf("weiroll: command %d: %v", e.CommandIndex, e.Err)
+	return fmt.Sprintf("weiroll: %s: %v", location, e.Err)
 }
 
 func (e *PlanError) Unwrap() error {
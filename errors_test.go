@@ -20,6 +20,41 @@ func TestSentinelErrors(t *testing.T) {
 		{"ErrReturnValueNotVisible", ErrReturnValueNotVisible, "weiroll: return value not visible at this point"},
 		{"ErrInvalidCallType", ErrInvalidCallType, "weiroll: invalid operation for this call type"},
 		{"ErrNoReturnValue", ErrNoReturnValue, "weiroll: function has no return value"},
+		{"ErrInvalidCommand", ErrInvalidCommand, "weiroll: malformed command encoding"},
+		{"ErrInvalidAddress", ErrInvalidAddress, "weiroll: invalid address"},
+		{"ErrUnboundParam", ErrUnboundParam, "weiroll: unbound template parameter"},
+		{"ErrNoSafeSplit", ErrNoSafeSplit, "weiroll: no safe split point within limits"},
+		{"ErrNameInUse", ErrNameInUse, "weiroll: name already in use"},
+		{"ErrNameNotFound", ErrNameNotFound, "weiroll: name not found"},
+		{"ErrPlanMismatch", ErrPlanMismatch, "weiroll: compiled plan does not match planner"},
+		{"ErrStaticSlotSize", ErrStaticSlotSize, "weiroll: static state slot is not 32 bytes"},
+		{"ErrNilArgument", ErrNilArgument, "weiroll: nil is not permitted"},
+		{"ErrCommandIndexOutOfRange", ErrCommandIndexOutOfRange, "weiroll: command index out of range"},
+		{"ErrReturnSuppressed", ErrReturnSuppressed, "weiroll: return value capture suppressed but value is used"},
+		{"ErrInvalidCalldata", ErrInvalidCalldata, "weiroll: calldata is not an execute call"},
+		{"ErrCalldataTooLarge", ErrCalldataTooLarge, "weiroll: execute calldata exceeds size limit"},
+		{"ErrUntrustedDelegateCall", ErrUntrustedDelegateCall, "weiroll: delegatecall to untrusted contract"},
+		{"ErrAllowFailureUnsupported", ErrAllowFailureUnsupported, "weiroll: allow-failure commands not supported by encoding"},
+		{"ErrPlannerFrozen", ErrPlannerFrozen, "weiroll: planner is frozen"},
+		{"ErrCallNotAllowed", ErrCallNotAllowed, "weiroll: call not in allowlist"},
+		{"ErrSlotNotReserved", ErrSlotNotReserved, "weiroll: slot is not reserved"},
+		{"ErrIntegerOutOfRange", ErrIntegerOutOfRange, "weiroll: integer out of range"},
+		{"ErrNonLiteralArgument", ErrNonLiteralArgument, "weiroll: argument is not a literal"},
+		{"ErrStaleSlotRead", ErrStaleSlotRead, "weiroll: return value read after its slot was reused"},
+		{"ErrInvalidBinaryPlan", ErrInvalidBinaryPlan, "weiroll: malformed binary plan"},
+		{"ErrStateEntryTooLarge", ErrStateEntryTooLarge, "weiroll: state entry exceeds size limit"},
+		{"ErrNotAtomic", ErrNotAtomic, "weiroll: operation not enclosed by flash-loan callback"},
+		{"ErrDivisionByZero", ErrDivisionByZero, "weiroll: division by zero"},
+		{"ErrForeignValue", ErrForeignValue, "weiroll: value belongs to another planner"},
+		{"ErrOutputIndexOutOfRange", ErrOutputIndexOutOfRange, "weiroll: return value index out of range"},
+		{"ErrTupleReturnRequired", ErrTupleReturnRequired, "weiroll: multiple return values require RawReturn"},
+		{"ErrReturnValueStillReferenced", ErrReturnValueStillReferenced, "weiroll: return value still referenced"},
+		{"ErrChainAddressNotFound", ErrChainAddressNotFound, "weiroll: chain address not found"},
+		{"ErrInvalidJSONPlan", ErrInvalidJSONPlan, "weiroll: malformed JSON plan"},
+		{"ErrIncompatibleVMABI", ErrIncompatibleVMABI, "weiroll: method is not a weiroll execute method"},
+		{"ErrFeePaymentNotLast", ErrFeePaymentNotLast, "weiroll: fee payment is not the last command"},
+		{"ErrRawReturnArgument", ErrRawReturnArgument, "weiroll: raw return value used as an argument"},
+		{"ErrAddHookPanicked", ErrAddHookPanicked, "weiroll: add hook panicked"},
 	}
 
 	for _, tt := range tests {
@@ -42,6 +77,12 @@ func TestMethodNotFoundError(t *testing.T) {
 	if err.Error() != expected {
 		t.Errorf("Expected error message %q, got %q", expected, err.Error())
 	}
+
+	err.Suggestions = []string{"approve", "transferFrom"}
+	expected += "; did you mean approve or transferFrom?"
+	if err.Error() != expected {
+		t.Errorf("Expected error message %q, got %q", expected, err.Error())
+	}
 }
 
 func TestArgumentError(t *testing.T) {
@@ -122,6 +163,20 @@ func TestPlanError(t *testing.T) {
 		}
 	})
 
+	t.Run("with source location", func(t *testing.T) {
+		err := &PlanError{
+			CommandIndex: 2,
+			Method:       "add",
+			Source:       "plan.go:42",
+			Err:          errors.New("bad"),
+		}
+
+		expected := "weiroll: command 2 (add) at plan.go:42: bad"
+		if err.Error() != expected {
+			t.Errorf("Expected error message %q, got %q", expected, err.Error())
+		}
+	})
+
 	t.Run("error chain with errors.Is", func(t *testing.T) {
 		err := &PlanError{
 			CommandIndex: 0,
@@ -201,6 +256,41 @@ func TestErrorsAreDistinct(t *testing.T) {
 		ErrReturnValueNotVisible,
 		ErrInvalidCallType,
 		ErrNoReturnValue,
+		ErrInvalidCommand,
+		ErrInvalidAddress,
+		ErrUnboundParam,
+		ErrNoSafeSplit,
+		ErrNameInUse,
+		ErrNameNotFound,
+		ErrPlanMismatch,
+		ErrStaticSlotSize,
+		ErrNilArgument,
+		ErrCommandIndexOutOfRange,
+		ErrReturnSuppressed,
+		ErrInvalidCalldata,
+		ErrCalldataTooLarge,
+		ErrUntrustedDelegateCall,
+		ErrAllowFailureUnsupported,
+		ErrPlannerFrozen,
+		ErrCallNotAllowed,
+		ErrSlotNotReserved,
+		ErrIntegerOutOfRange,
+		ErrNonLiteralArgument,
+		ErrStaleSlotRead,
+		ErrInvalidBinaryPlan,
+		ErrStateEntryTooLarge,
+		ErrNotAtomic,
+		ErrDivisionByZero,
+		ErrForeignValue,
+		ErrOutputIndexOutOfRange,
+		ErrTupleReturnRequired,
+		ErrReturnValueStillReferenced,
+		ErrChainAddressNotFound,
+		ErrInvalidJSONPlan,
+		ErrIncompatibleVMABI,
+		ErrFeePaymentNotLast,
+		ErrRawReturnArgument,
+		ErrAddHookPanicked,
 	}
 
 	for i, err1 := range sentinelErrors {
@@ -109,7 +109,7 @@ func main() {
 	uniswapRouter := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D") // Uniswap V2 Router02
 	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")          // WETH
 	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")          // USDC
-	dai := common.HexToAddress("0x6B175474E89094C44Da98b954EesedcDAE6Bf26")            // DAI
+	dai := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")           // DAI
 	helperLib := common.HexToAddress("0x1111111111111111111111111111111111111111")     // Helper library (deploy your own)
 	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")     // Final recipient
 
@@ -0,0 +1,47 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CompareStrategies compiles the plan with and without slot optimization and
+// estimates the gas of executing each on the VM at vmAddr, sent from from.
+// opts are applied to both compilations before the optimization setting.
+// Plans carrying ETH value are estimated with their RequiredValue.
+func (p *Planner) CompareStrategies(
+	ctx context.Context,
+	client ethereum.GasEstimator,
+	vmAddr, from common.Address,
+	opts ...PlanOption,
+) (optimizedGas, unoptimizedGas uint64, err error) {
+	estimate := func(optimize bool) (uint64, error) {
+		plan, err := p.Plan(append(opts[:len(opts):len(opts)], WithSlotOptimization(optimize))...)
+		if err != nil {
+			return 0, err
+		}
+		data, err := plan.executeCalldata()
+		if err != nil {
+			return 0, err
+		}
+
+		msg := ethereum.CallMsg{From: from, To: &vmAddr, Data: data}
+		if value, _ := plan.RequiredValue(); value.Sign() > 0 {
+			msg.Value = value
+		}
+		return client.EstimateGas(ctx, msg)
+	}
+
+	if optimizedGas, err = estimate(true); err != nil {
+		return 0, 0, fmt.Errorf("weiroll: estimating optimized plan: %w", err)
+	}
+	if unoptimizedGas, err = estimate(false); err != nil {
+		return 0, 0, fmt.Errorf("weiroll: estimating unoptimized plan: %w", err)
+	}
+	return optimizedGas, unoptimizedGas, nil
+}
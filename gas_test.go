@@ -0,0 +1,86 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// calldataGasEstimator charges a fixed amount per calldata byte.
+type calldataGasEstimator struct {
+	msgs []ethereum.CallMsg
+	err  error
+}
+
+func (e *calldataGasEstimator) EstimateGas(_ context.Context, msg ethereum.CallMsg) (uint64, error) {
+	e.msgs = append(e.msgs, msg)
+	if e.err != nil {
+		return 0, e.err
+	}
+	return 21000 + 16*uint64(len(msg.Data)), nil
+}
+
+func TestPlannerCompareStrategies(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	vmAddr := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	from := common.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	p := New()
+	acc := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	for i := 0; i < 6; i++ {
+		acc = p.Add(lib.MustInvoke("multiply", acc, big.NewInt(int64(i+3))))
+	}
+
+	t.Run("estimates both strategies", func(t *testing.T) {
+		estimator := &calldataGasEstimator{}
+		optimized, unoptimized, err := p.CompareStrategies(context.Background(), estimator, vmAddr, from)
+		if err != nil {
+			t.Fatalf("CompareStrategies failed: %v", err)
+		}
+		if optimized >= unoptimized {
+			t.Errorf("Expected optimized gas %d below unoptimized %d", optimized, unoptimized)
+		}
+
+		if len(estimator.msgs) != 2 {
+			t.Fatalf("Expected 2 estimates, got %d", len(estimator.msgs))
+		}
+		msg := estimator.msgs[0]
+		if msg.From != from || msg.To == nil || *msg.To != vmAddr {
+			t.Errorf("Unexpected call message %+v", msg)
+		}
+		if !bytesHavePrefix(msg.Data, executeSelector) {
+			t.Errorf("Expected execute selector, got %x", msg.Data[:4])
+		}
+	})
+
+	t.Run("calldata matches size estimate", func(t *testing.T) {
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		data, err := plan.executeCalldata()
+		if err != nil {
+			t.Fatalf("executeCalldata failed: %v", err)
+		}
+		if len(data) != plan.calldataSize() {
+			t.Errorf("Expected %d bytes, got %d", plan.calldataSize(), len(data))
+		}
+	})
+
+	t.Run("propagates estimation errors", func(t *testing.T) {
+		estimator := &calldataGasEstimator{err: errors.New("execution reverted")}
+		if _, _, err := p.CompareStrategies(context.Background(), estimator, vmAddr, from); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
+
+func bytesHavePrefix(data, prefix []byte) bool {
+	return len(data) >= len(prefix) && string(data[:len(prefix)]) == string(prefix)
+}
@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.19;
+
+/**
+ * @title Recorder
+ * @notice Emits an event for each value it records, for testing receipt parsing
+ */
+contract Recorder {
+    event Recorded(address indexed caller, uint256 value);
+
+    function record(uint256 value) external returns (uint256) {
+        emit Recorded(msg.sender, value);
+        return value;
+    }
+}
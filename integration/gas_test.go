@@ -0,0 +1,69 @@
+//go:build rpc
+
+package integration
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+
+	weiroll "github.com/branched-services/go-weiroll"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func TestCompareStrategies(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") != "1" {
+		t.Skip("Set INTEGRATION_TEST=1 to run integration tests")
+	}
+
+	ctx := context.Background()
+
+	client, err := ethclient.Dial("http://localhost:8545")
+	if err != nil {
+		t.Fatalf("Failed to connect to Anvil: %v", err)
+	}
+	defer client.Close()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	privateKey, err := crypto.HexToECDSA(testPrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		t.Fatalf("Failed to create transactor: %v", err)
+	}
+
+	mathLibAddr, err := deployContract(ctx, client, auth, privateKey, "MathLib")
+	if err != nil {
+		t.Fatalf("Failed to deploy MathLib: %v", err)
+	}
+	vmAddr, err := deployContract(ctx, client, auth, privateKey, "WeirollVM")
+	if err != nil {
+		t.Fatalf("Failed to deploy WeirollVM: %v", err)
+	}
+
+	// A long chain where each intermediate value dies immediately, so
+	// optimization can recycle every return slot.
+	mathLib := weiroll.NewLibrary(mathLibAddr, weiroll.MustParseABI(mathLibABI))
+	planner := weiroll.New()
+	acc := planner.Add(mathLib.MustInvoke("add", big.NewInt(5), big.NewInt(3)))
+	for i := 0; i < 10; i++ {
+		acc = planner.Add(mathLib.MustInvoke("add", acc, big.NewInt(int64(i+1))))
+	}
+
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+	optimized, unoptimized, err := planner.CompareStrategies(ctx, client, vmAddr, from)
+	if err != nil {
+		t.Fatalf("CompareStrategies failed: %v", err)
+	}
+
+	t.Logf("Optimized: %d gas, unoptimized: %d gas, difference: %d",
+		optimized, unoptimized, int64(unoptimized)-int64(optimized))
+}
@@ -0,0 +1,127 @@
+//go:build rpc
+
+package integration
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+
+	weiroll "github.com/branched-services/go-weiroll"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const recorderABI = `[
+	{
+		"inputs": [{"name": "value", "type": "uint256"}],
+		"name": "record",
+		"outputs": [{"name": "", "type": "uint256"}],
+		"stateMutability": "nonpayable",
+		"type": "function"
+	}
+]`
+
+func TestParseReceipt(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") != "1" {
+		t.Skip("Set INTEGRATION_TEST=1 to run integration tests")
+	}
+
+	ctx := context.Background()
+
+	client, err := ethclient.Dial("http://localhost:8545")
+	if err != nil {
+		t.Fatalf("Failed to connect to Anvil: %v", err)
+	}
+	defer client.Close()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	privateKey, err := crypto.HexToECDSA(testPrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		t.Fatalf("Failed to create transactor: %v", err)
+	}
+
+	mathLibAddr, err := deployContract(ctx, client, auth, privateKey, "MathLib")
+	if err != nil {
+		t.Fatalf("Failed to deploy MathLib: %v", err)
+	}
+	firstAddr, err := deployContract(ctx, client, auth, privateKey, "Recorder")
+	if err != nil {
+		t.Fatalf("Failed to deploy Recorder: %v", err)
+	}
+	secondAddr, err := deployContract(ctx, client, auth, privateKey, "Recorder")
+	if err != nil {
+		t.Fatalf("Failed to deploy Recorder: %v", err)
+	}
+	vmAddr, err := deployContract(ctx, client, auth, privateKey, "WeirollVM")
+	if err != nil {
+		t.Fatalf("Failed to deploy WeirollVM: %v", err)
+	}
+
+	// The library call emits nothing; each record call emits one log from
+	// the recorder it targets.
+	mathLib := weiroll.NewLibrary(mathLibAddr, weiroll.MustParseABI(mathLibABI))
+	first := weiroll.NewContract(firstAddr, weiroll.MustParseABI(recorderABI))
+	second := weiroll.NewContract(secondAddr, weiroll.MustParseABI(recorderABI))
+
+	planner := weiroll.New()
+	sum := planner.Add(mathLib.MustInvoke("add", big.NewInt(5), big.NewInt(3)))
+	planner.Add(first.MustInvoke("record", sum))
+	planner.Add(second.MustInvoke("record", big.NewInt(10)))
+	planner.Add(first.MustInvoke("record", big.NewInt(20)))
+
+	plan, err := planner.Plan()
+	if err != nil {
+		t.Fatalf("Failed to compile plan: %v", err)
+	}
+
+	fromAddress := crypto.PubkeyToAddress(privateKey.PublicKey)
+	nonce, err := client.PendingNonceAt(ctx, fromAddress)
+	if err != nil {
+		t.Fatalf("Failed to get nonce: %v", err)
+	}
+	auth.Nonce = big.NewInt(int64(nonce))
+
+	vmContract := bind.NewBoundContract(vmAddr, weiroll.MustParseABI(weirollVMABI), client, client, client)
+	tx, err := vmContract.Transact(auth, "execute", plan.CommandsAsBytes32(), plan.StateAsBytes())
+	if err != nil {
+		t.Fatalf("Failed to execute plan: %v", err)
+	}
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	if err != nil {
+		t.Fatalf("Failed to mine transaction: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("Transaction failed: status=%d", receipt.Status)
+	}
+
+	summary, err := plan.ParseReceipt(receipt, planner)
+	if err != nil {
+		t.Fatalf("ParseReceipt failed: %v", err)
+	}
+	if !summary.Succeeded || summary.GasUsed != receipt.GasUsed {
+		t.Errorf("Expected successful execution using %d gas, got %+v", receipt.GasUsed, summary)
+	}
+
+	expected := []int{1, 2, 3}
+	if len(summary.Logs) != len(expected) {
+		t.Fatalf("Expected %d logs, got %d", len(expected), len(summary.Logs))
+	}
+	for i, index := range expected {
+		log := summary.Logs[i]
+		if log.CommandIndex != index || !log.Direct {
+			t.Errorf("Log %d: expected command %d, got %d (direct %v)", i, index, log.CommandIndex, log.Direct)
+		}
+		t.Logf("Log %d from %s attributed to command %d", i, log.Log.Address.Hex(), log.CommandIndex)
+	}
+}
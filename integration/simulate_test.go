@@ -0,0 +1,80 @@
+//go:build rpc
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	weiroll "github.com/branched-services/go-weiroll"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func TestCompiledPlanSimulate(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") != "1" {
+		t.Skip("Set INTEGRATION_TEST=1 to run integration tests")
+	}
+
+	ctx := context.Background()
+
+	client, err := ethclient.Dial("http://localhost:8545")
+	if err != nil {
+		t.Fatalf("Failed to connect to Anvil: %v", err)
+	}
+	defer client.Close()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	privateKey, err := crypto.HexToECDSA(testPrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		t.Fatalf("Failed to create transactor: %v", err)
+	}
+
+	mathLibAddr, err := deployContract(ctx, client, auth, privateKey, "MathLib")
+	if err != nil {
+		t.Fatalf("Failed to deploy MathLib: %v", err)
+	}
+	vmAddr, err := deployContract(ctx, client, auth, privateKey, "WeirollVM")
+	if err != nil {
+		t.Fatalf("Failed to deploy WeirollVM: %v", err)
+	}
+
+	// Plan: (5 + 3) * 10 - 20 = 60
+	mathLib := weiroll.NewLibrary(mathLibAddr, weiroll.MustParseABI(mathLibABI))
+	planner := weiroll.New()
+	sum := planner.Add(mathLib.MustInvoke("add", big.NewInt(5), big.NewInt(3)))
+	product := planner.Add(mathLib.MustInvoke("multiply", sum, big.NewInt(10)))
+	planner.Add(mathLib.MustInvoke("subtract", product, big.NewInt(20)))
+
+	plan, err := planner.Plan()
+	if err != nil {
+		t.Fatalf("Failed to compile plan: %v", err)
+	}
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	if err := plan.Simulate(ctx, client, vmAddr, from, weiroll.WithCommandTimeout(30*time.Second)); err != nil {
+		t.Fatalf("Simulate failed: %v", err)
+	}
+
+	err = plan.Simulate(ctx, client, vmAddr, from, weiroll.WithCommandTimeout(time.Nanosecond))
+	var timeoutErr *weiroll.SimulationTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected SimulationTimeoutError, got %v", err)
+	}
+	if timeoutErr.CommandIndex != 0 || timeoutErr.Commands != len(plan.Commands) {
+		t.Errorf("Expected timeout at command 0 of %d, got %d of %d",
+			len(plan.Commands), timeoutErr.CommandIndex, timeoutErr.Commands)
+	}
+}
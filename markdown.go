@@ -0,0 +1,141 @@
+package weiroll
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ToMarkdown renders the plan as a markdown table, one row per command, for
+// reviewing plans in pull requests and docs. Literal arguments are shown
+// decoded, return values as references to the producing step (e.g. "$0"),
+// and the output column lists the return type and the steps that consume
+// it. Subplan commands are followed by their own tables. Like Describe, it
+// works on the planner as built, without compiling.
+func (p *Planner) ToMarkdown() string {
+	var b strings.Builder
+	writeMarkdown(&b, p.Describe(), "")
+	return b.String()
+}
+
+// writeMarkdown writes desc as a table, then the tables of any subplans,
+// numbering their steps under prefix.
+func writeMarkdown(b *strings.Builder, desc PlanDescription, prefix string) {
+	b.WriteString("| Step | Call type | Contract | Method | Arguments | Output |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+
+	type nested struct {
+		prefix string
+		desc   PlanDescription
+	}
+	var subplans []nested
+
+	for _, cmd := range desc.Commands {
+		step := fmt.Sprintf("%s%d", prefix, cmd.Index)
+		stepCell := step
+		if cmd.Label != "" {
+			stepCell += " (" + cmd.Label + ")"
+		}
+
+		contract := cmd.Target.Hex()
+		if cmd.DynamicTarget != nil {
+			contract = "dynamic " + markdownArg(*cmd.DynamicTarget)
+		}
+		if cmd.Implementation != nil {
+			contract += " (proxy for " + cmd.Implementation.Hex() + ")"
+		} else if cmd.Proxy {
+			contract += " (proxy)"
+		}
+
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = markdownArg(arg)
+			if arg.Subplan != nil {
+				subplans = append(subplans, nested{prefix: step + ".", desc: *arg.Subplan})
+			}
+		}
+		if cmd.Value != nil {
+			args = append(args, "value="+cmd.Value.String())
+		}
+
+		output := cmd.Returns
+		if cmd.Type == "replaceState" {
+			output = "state"
+		}
+		if len(cmd.Consumers) > 0 {
+			consumers := make([]string, len(cmd.Consumers))
+			for i, c := range cmd.Consumers {
+				consumers[i] = fmt.Sprintf("%s%d", prefix, c)
+			}
+			output += " → " + strings.Join(consumers, ", ")
+		}
+
+		fmt.Fprintf(b, "| %s | %s | %s | `%s` | %s | %s |\n",
+			markdownEscape(stepCell),
+			cmd.CallType,
+			markdownEscape(contract),
+			markdownEscape(cmd.Method),
+			markdownEscape(strings.Join(args, ", ")),
+			markdownEscape(output))
+	}
+
+	for _, sub := range subplans {
+		fmt.Fprintf(b, "\nSubplan of step %s:\n\n", strings.TrimSuffix(sub.prefix, "."))
+		writeMarkdown(b, sub.desc, sub.prefix)
+	}
+}
+
+// markdownArg formats a single argument for a markdown cell.
+func markdownArg(arg ArgDescription) string {
+	switch arg.Kind {
+	case "literal":
+		return markdownLiteral(arg.Type, arg.Value)
+	case "return":
+		if arg.Producer == nil {
+			return "$outer"
+		}
+		return fmt.Sprintf("$%d", *arg.Producer)
+	case "state":
+		return "state"
+	case "subplan":
+		return fmt.Sprintf("subplan(%d commands)", len(arg.Subplan.Commands))
+	case "param":
+		return "{" + arg.Name + "}"
+	case "slot":
+		return fmt.Sprintf("slot %d", *arg.Slot)
+	default:
+		return arg.Kind
+	}
+}
+
+// markdownLiteral decodes a literal's slot contents for display, falling
+// back to hex if the type can't be parsed or the data doesn't decode.
+func markdownLiteral(typ string, data []byte) string {
+	t, err := abi.NewType(typ, "", nil)
+	if err != nil {
+		return hexutil.Encode(data)
+	}
+	switch v := decodeSlot(t, data).(type) {
+	case nil:
+		return hexutil.Encode(data)
+	case []byte:
+		return hexutil.Encode(v)
+	case [32]byte:
+		return hexutil.Encode(v[:])
+	case common.Address:
+		return v.Hex()
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// markdownEscape escapes characters that would break a table cell.
+func markdownEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
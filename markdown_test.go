@@ -0,0 +1,83 @@
+package weiroll
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPlannerToMarkdown(t *testing.T) {
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	extAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	lib := NewLibrary(libAddr, plannerTestABI())
+	ext := NewContract(extAddr, plannerTestABI())
+
+	t.Run("one row per command", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(ext.MustInvoke("multiply", sum, big.NewInt(3)))
+		p.Add(ext.MustInvoke("getString"))
+
+		md := p.ToMarkdown()
+		lines := strings.Split(strings.TrimSpace(md), "\n")
+		if len(lines) != 5 {
+			t.Fatalf("Expected header, separator and 3 rows, got:\n%s", md)
+		}
+		if !strings.HasPrefix(lines[0], "| Step | Call type | Contract | Method | Arguments | Output |") {
+			t.Errorf("Unexpected header %q", lines[0])
+		}
+
+		want := []string{
+			"| 0 | delegatecall | " + libAddr.Hex() + " | `add(uint256,uint256)` | 1, 2 | uint256 → 1 |",
+			"| 1 | call | " + extAddr.Hex() + " | `multiply(uint256,uint256)` | $0, 3 | uint256 |",
+			"| 2 | call | " + extAddr.Hex() + " | `getString()` |  | string |",
+		}
+		for i, row := range want {
+			if lines[i+2] != row {
+				t.Errorf("Row %d:\n got %q\nwant %q", i, lines[i+2], row)
+			}
+		}
+	})
+
+	t.Run("shows ETH value and string literals", func(t *testing.T) {
+		parsed, err := abi.JSON(strings.NewReader(`[{"type":"function","name":"pay","inputs":[{"name":"memo","type":"string"}],"outputs":[]}]`))
+		if err != nil {
+			t.Fatalf("Failed to parse ABI: %v", err)
+		}
+		p := New()
+		p.Add(NewContract(extAddr, parsed).MustInvoke("pay", "a|b").WithValue(big.NewInt(5)))
+
+		md := p.ToMarkdown()
+		if !strings.Contains(md, "| callWithValue |") {
+			t.Errorf("Expected callWithValue call type, got:\n%s", md)
+		}
+		if !strings.Contains(md, `"a\|b", value=5`) {
+			t.Errorf("Expected escaped string literal and value, got:\n%s", md)
+		}
+	})
+
+	t.Run("renders subplans as nested tables", func(t *testing.T) {
+		p := New()
+		outer := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("multiply", outer, big.NewInt(2)))
+		if _, err := p.AddSubplan(ext.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		md := p.ToMarkdown()
+		if !strings.Contains(md, "subplan(1 commands), state") {
+			t.Errorf("Expected subplan argument, got:\n%s", md)
+		}
+		if !strings.Contains(md, "Subplan of step 1:") {
+			t.Errorf("Expected nested table heading, got:\n%s", md)
+		}
+		if !strings.Contains(md, "| 1.0 | delegatecall | "+libAddr.Hex()+" | `multiply(uint256,uint256)` | $outer, 2 |") {
+			t.Errorf("Expected nested multiply row, got:\n%s", md)
+		}
+	})
+}
@@ -1,8 +1,67 @@
 package weiroll
 
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
 // PlannerOption configures a Planner.
 type PlannerOption func(*Planner)
 
+// WithSourceTracking records the file and line of the code that adds each
+// command, exposed via Command.Source and included in PlanError messages.
+// Off by default, since capturing the caller costs a stack walk per Add.
+func WithSourceTracking() PlannerOption {
+	return func(p *Planner) {
+		p.trackSource = true
+	}
+}
+
+// WithAddHook registers a function called with every command appended by
+// Add, AddCapture, AddNamed, AddSubplan or ReplaceState, after it has been
+// appended with its label and other options set, and with every command
+// inserted by InsertAt, once it is at its index. Hooks run in registration
+// order. A policy hook can reject a command by panicking.
+func WithAddHook(hook func(cmd *Command)) PlannerOption {
+	return func(p *Planner) {
+		p.addHooks = append(p.addHooks, hook)
+	}
+}
+
+// AddOption configures a single Planner.Add.
+type AddOption func(*addConfig)
+
+// addConfig holds configuration for a single Add.
+type addConfig struct {
+	returnType *abi.Type
+	label      string
+	capture    captureMode // Set by AddCapture
+}
+
+// WithReturnType overrides the type of the returned ReturnValue for
+// assignability checks, for outputs the ABI declares imprecisely (e.g.
+// bytes that hold an address). Encoding is unchanged: the return slot keeps
+// the declared type's layout, and Plan still rejects reading a dynamic slot
+// as a static parameter or vice versa. It has no effect on calls without a
+// return value.
+func WithReturnType(t abi.Type) AddOption {
+	return func(c *addConfig) {
+		c.returnType = &t
+	}
+}
+
+// WithLabel attaches a label to the command, such as "fee" or "swap", which
+// Describe and ToMarkdown report to tell commands apart when auditing a
+// plan. Labels are not encoded in the compiled plan, but a command labelled
+// FeePaymentLabel must be the last one; see Planner.AddFeePayment.
+func WithLabel(label string) AddOption {
+	return func(c *addConfig) {
+		c.label = label
+	}
+}
+
 // PlanOption configures the Plan() operation.
 type PlanOption func(*planConfig)
 
@@ -11,6 +70,22 @@ type planConfig struct {
 	optimizeSlots bool
 	maxCommands   int
 	maxStateSlots int
+	minSlot       uint8 // Slots below this are left empty
+	dedupCommands bool
+	encoding      EncodingConfig
+	maxCalldata   int // 0 means unlimited
+	maxEntry      int // Dynamic literal size limit in bytes; 0 means unlimited
+	requireTrust  bool
+	literalKey    func([]byte) string    // Literal deduplication key; nil uses the raw bytes
+	private       map[*LiteralValue]bool // Literals keyed by identity; set by CompileTemplate
+	terminator    *terminatorCommand     // Appended after the last command; nil means none
+	ctx           context.Context        // Checked before each command; set by PlanContext
+}
+
+// terminatorCommand is the target of a WithTerminatorCommand command.
+type terminatorCommand struct {
+	selector [4]byte
+	address  common.Address
 }
 
 // defaultPlanConfig returns the default plan configuration.
@@ -19,9 +94,56 @@ func defaultPlanConfig() *planConfig {
 		optimizeSlots: true,
 		maxCommands:   256,
 		maxStateSlots: MaxStateSlots,
+		encoding:      DefaultEncodingConfig(),
 	}
 }
 
+// PlanConfigSnapshot records the effective options a plan was compiled
+// with. See CompiledPlan.Config.
+type PlanConfigSnapshot struct {
+	SlotOptimization     bool
+	MaxCommands          int
+	MaxStateSlots        int
+	MinSlot              uint8 // Slots below this were reserved; see WithSlotRange
+	CommandDeduplication bool
+	Encoding             EncodingConfig
+	MaxCalldataBytes     int // 0 means unlimited
+	MaxStateEntryBytes   int // 0 means unlimited
+	RequireTrusted       bool
+	CustomLiteralHasher  bool // Set by WithLiteralHasher
+
+	// Terminator is the encoded trailing command set with
+	// WithTerminatorCommand, or all zero if there is none.
+	Terminator [CommandSize]byte
+}
+
+// snapshot returns a read-only copy of the configuration.
+func (c *planConfig) snapshot() PlanConfigSnapshot {
+	return PlanConfigSnapshot{
+		SlotOptimization:     c.optimizeSlots,
+		MaxCommands:          c.maxCommands,
+		MaxStateSlots:        c.maxStateSlots,
+		MinSlot:              c.minSlot,
+		CommandDeduplication: c.dedupCommands,
+		Encoding:             c.encoding,
+		MaxCalldataBytes:     c.maxCalldata,
+		MaxStateEntryBytes:   c.maxEntry,
+		RequireTrusted:       c.requireTrust,
+		CustomLiteralHasher:  c.literalKey != nil,
+		Terminator:           [CommandSize]byte(c.encodeTerminator()),
+	}
+}
+
+// encodeTerminator returns the WithTerminatorCommand command, or all zero
+// bytes if there is none.
+func (c *planConfig) encodeTerminator() []byte {
+	if c.terminator == nil {
+		return make([]byte, CommandSize)
+	}
+	return NewCommandEncoderWithConfig(c.encoding).
+		Encode(c.terminator.selector, FlagStaticCall, nil, c.encoding.NoReturnSlot, c.terminator.address)
+}
+
 // WithSlotOptimization enables or disables aggressive slot reuse.
 // When enabled (default), slots are recycled after their last usage.
 func WithSlotOptimization(enabled bool) PlanOption {
@@ -48,3 +170,89 @@ func WithMaxStateSlots(max int) PlanOption {
 		c.maxStateSlots = max
 	}
 }
+
+// WithSlotRange restricts allocation to slots min through max inclusive, for
+// VMs that reserve low and high slots for their own use. Slots below min
+// are zero-filled so the state array stays contiguous from 0, and Plan
+// fails with ErrSlotExhausted once the range is full.
+// max is capped at MaxStateSlots-1; it replaces any WithMaxStateSlots limit.
+func WithSlotRange(min, max uint8) PlanOption {
+	return func(c *planConfig) {
+		c.minSlot = min
+		c.maxStateSlots = int(max) + 1
+		if c.maxStateSlots > MaxStateSlots {
+			c.maxStateSlots = MaxStateSlots
+		}
+	}
+}
+
+// WithLiteralHasher replaces the key used to deduplicate literals. By default
+// the key is the literal's bytes, which is exact but holds a copy of every
+// literal. A hash such as keccak256 keeps keys small for plans with large
+// literals, at the risk that a collision silently merges two different
+// literals into one slot; only use a hash with negligible collision odds.
+func WithLiteralHasher(hasher func(data []byte) string) PlanOption {
+	return func(c *planConfig) {
+		c.literalKey = hasher
+	}
+}
+
+// WithCommandDeduplication collapses repeated view and pure calls into a
+// single command whose return value is shared by every duplicate.
+// Side-effecting calls are never collapsed, and a view call is only reused
+// if no side-effecting command ran in between.
+func WithCommandDeduplication() PlanOption {
+	return func(c *planConfig) {
+		c.dedupCommands = true
+	}
+}
+
+// WithEncodingConstants sets the sentinel slot values used when encoding
+// commands, for VMs that differ from the reference weiroll VM.
+// Default is DefaultEncodingConfig().
+func WithEncodingConstants(cfg EncodingConfig) PlanOption {
+	return func(c *planConfig) {
+		c.encoding = cfg
+	}
+}
+
+// WithMaxCalldataBytes rejects plans whose execute(bytes32[], bytes[])
+// calldata would exceed n bytes, including the selector and ABI offsets.
+// Useful for sequencers and bundlers that cap transaction size.
+func WithMaxCalldataBytes(n int) PlanOption {
+	return func(c *planConfig) {
+		c.maxCalldata = n
+	}
+}
+
+// WithMaxStateEntryBytes rejects plans with a dynamic literal, including an
+// encoded subplan, whose state entry exceeds n bytes, for VMs that cap the
+// size of individual state entries.
+func WithMaxStateEntryBytes(n int) PlanOption {
+	return func(c *planConfig) {
+		c.maxEntry = n
+	}
+}
+
+// WithTerminatorCommand appends a STATICCALL of selector on addr, with no
+// arguments and no return slot, after the last top-level command. It is
+// for VM deployments that require a sentinel terminator or an even number
+// of commands; the reference weiroll VM needs neither. The call must
+// succeed, so addr should be a contract whose selector is a view no-op, or
+// an address without code. Plans compiled with it have one more command
+// than their planner, so they can't be used with ExpectedCalls or
+// StateDiff.
+func WithTerminatorCommand(selector [4]byte, addr common.Address) PlanOption {
+	return func(c *planConfig) {
+		c.terminator = &terminatorCommand{selector: selector, address: addr}
+	}
+}
+
+// WithRequireTrusted rejects plans that DELEGATECALL an Untrusted contract,
+// since delegated code runs with the VM's storage and balance.
+// Regular and static calls to untrusted contracts are still allowed.
+func WithRequireTrusted() PlanOption {
+	return func(c *planConfig) {
+		c.requireTrust = true
+	}
+}
@@ -2,6 +2,8 @@ package weiroll
 
 import (
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func TestDefaultPlanConfig(t *testing.T) {
@@ -133,6 +135,66 @@ func TestWithMaxStateSlots(t *testing.T) {
 	})
 }
 
+func TestWithSlotRange(t *testing.T) {
+	t.Run("sets range", func(t *testing.T) {
+		config := defaultPlanConfig()
+		WithSlotRange(4, 9)(config)
+
+		if config.minSlot != 4 || config.maxStateSlots != 10 {
+			t.Errorf("Expected slots 4-9, got minSlot %d and maxStateSlots %d", config.minSlot, config.maxStateSlots)
+		}
+	})
+
+	t.Run("caps at MaxStateSlots", func(t *testing.T) {
+		config := defaultPlanConfig()
+		WithSlotRange(0, 200)(config)
+
+		if config.maxStateSlots != MaxStateSlots {
+			t.Errorf("Expected maxStateSlots to be capped at %d, got %d", MaxStateSlots, config.maxStateSlots)
+		}
+	})
+}
+
+func TestWithLiteralHasher(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.literalKey != nil {
+		t.Error("Expected no literal hasher by default")
+	}
+
+	WithLiteralHasher(func(data []byte) string { return "k" })(config)
+
+	if config.literalKey == nil || config.literalKey(nil) != "k" {
+		t.Error("Expected literal hasher to be set")
+	}
+}
+
+func TestWithCommandDeduplication(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.dedupCommands {
+		t.Error("Expected command deduplication to be disabled by default")
+	}
+
+	WithCommandDeduplication()(config)
+
+	if !config.dedupCommands {
+		t.Error("Expected command deduplication to be enabled")
+	}
+}
+
+func TestWithEncodingConstants(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.encoding != DefaultEncodingConfig() {
+		t.Errorf("Expected default encoding constants, got %+v", config.encoding)
+	}
+
+	custom := EncodingConfig{DynamicSlotFlag: 0x40, StateSlotMarker: 0x3E, NoReturnSlot: 0x3F, UnusedSlot: 0x3F}
+	WithEncodingConstants(custom)(config)
+
+	if config.encoding != custom {
+		t.Errorf("Expected %+v, got %+v", custom, config.encoding)
+	}
+}
+
 func TestMultipleOptions(t *testing.T) {
 	config := defaultPlanConfig()
 
@@ -194,6 +256,70 @@ func TestPlanConfigIndependence(t *testing.T) {
 	})
 }
 
+func TestWithMaxCalldataBytes(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.maxCalldata != 0 {
+		t.Errorf("Expected no calldata limit by default, got %d", config.maxCalldata)
+	}
+
+	WithMaxCalldataBytes(1024)(config)
+
+	if config.maxCalldata != 1024 {
+		t.Errorf("Expected maxCalldata to be 1024, got %d", config.maxCalldata)
+	}
+}
+
+func TestWithMaxStateEntryBytes(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.maxEntry != 0 {
+		t.Errorf("Expected no state entry limit by default, got %d", config.maxEntry)
+	}
+
+	WithMaxStateEntryBytes(256)(config)
+
+	if config.maxEntry != 256 {
+		t.Errorf("Expected maxEntry to be 256, got %d", config.maxEntry)
+	}
+}
+
+func TestWithRequireTrusted(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.requireTrust {
+		t.Error("Expected trust checks to be disabled by default")
+	}
+
+	WithRequireTrusted()(config)
+
+	if !config.requireTrust {
+		t.Error("Expected requireTrust to be true")
+	}
+}
+
+func TestWithTerminatorCommand(t *testing.T) {
+	config := defaultPlanConfig()
+	if config.terminator != nil {
+		t.Error("Expected no terminator by default")
+	}
+
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	WithTerminatorCommand([4]byte{0xde, 0xad, 0xbe, 0xef}, addr)(config)
+
+	if config.terminator == nil || config.terminator.selector != [4]byte{0xde, 0xad, 0xbe, 0xef} || config.terminator.address != addr {
+		t.Errorf("Expected terminator to be set, got %+v", config.terminator)
+	}
+}
+
+func TestWithSourceTracking(t *testing.T) {
+	t.Run("enables source tracking", func(t *testing.T) {
+		if New().trackSource {
+			t.Error("Expected source tracking to be off by default")
+		}
+		if !New(WithSourceTracking()).trackSource {
+			t.Error("Expected trackSource to be true")
+		}
+	})
+}
+
 func TestPlannerOptionType(t *testing.T) {
 	// PlannerOption is a function that takes *Planner
 	// This test just verifies the type exists and is usable
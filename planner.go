@@ -1,5 +1,20 @@
 package weiroll
 
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
 // CommandType specifies the type of command operation.
 type CommandType uint8
 
@@ -14,11 +29,26 @@ const (
 	CommandTypeSubplan
 )
 
+// captureMode overrides whether a command's return value is stored.
+type captureMode uint8
+
+const (
+	captureAuto     captureMode = iota // Store only if a later command uses it
+	captureForce                       // Always store, in a slot that is never recycled
+	captureSuppress                    // Never store; using the value is an error
+)
+
 // Command represents a single operation in the plan.
 type Command struct {
 	call       *Call
 	cmdType    CommandType
 	returnSlot int // -1 if no return value stored
+	capture    captureMode
+	output     bool      // Read by the parent planner after the subplan runs; see Output
+	label      string    // See WithLabel
+	returnType *abi.Type // See WithReturnType
+	sourceFile string    // Caller of Add, if source tracking is enabled
+	sourceLine int
 }
 
 // Call returns the underlying function call.
@@ -31,10 +61,42 @@ func (c *Command) Type() CommandType {
 	return c.cmdType
 }
 
+// Label returns the label given with WithLabel, or "" if there is none.
+func (c *Command) Label() string {
+	return c.label
+}
+
+// Documentation returns the documentation of the command's method, as
+// given to its contract with WithNatSpec, or "" if there is none.
+func (c *Command) Documentation() string {
+	return c.call.contract.Documentation(c.call.method)
+}
+
+// Source returns the file and line of the code that added this command.
+// Returns "" and 0 unless the planner was created WithSourceTracking.
+func (c *Command) Source() (file string, line int) {
+	return c.sourceFile, c.sourceLine
+}
+
+// planError wraps err in a PlanError for this command at index i.
+func (c *Command) planError(i int, err error) *PlanError {
+	pe := &PlanError{CommandIndex: i, Method: c.call.method.Name, Err: err}
+	if c.sourceFile != "" {
+		pe.Source = fmt.Sprintf("%s:%d", c.sourceFile, c.sourceLine)
+	}
+	return pe
+}
+
 // Planner builds a sequence of weiroll commands.
 type Planner struct {
-	commands []*Command
-	parent   *Planner // For subplan validation and cycle detection
+	commands    []*Command
+	parent      *Planner                // For subplan validation and cycle detection
+	names       map[string]*ReturnValue // Named return values registered by AddNamed
+	trackSource bool                    // Record the caller of each Add
+	addHooks    []func(*Command)        // Called after each command is appended
+	frozen      bool                    // Set by Freeze; rejects further commands
+	mutables    []*LiteralValue         // Literals with private slots, allocated first
+	origin      *Planner                // Planner this one was derived from by Split
 }
 
 // New creates a new Planner with the given options.
@@ -50,29 +112,159 @@ func New(opts ...PlannerOption) *Planner {
 
 // Add adds a function call to the plan and returns its return value (if any).
 // Returns nil if the function has no return value.
-func (p *Planner) Add(call *Call) *ReturnValue {
-	cmd := &Command{
-		call:       call,
-		cmdType:    CommandTypeCall,
-		returnSlot: -1,
+// Panics with ErrPlannerFrozen if the planner has been frozen.
+func (p *Planner) Add(call *Call, opts ...AddOption) *ReturnValue {
+	var cfg addConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return p.add(call, cfg)
+}
+
+// add appends a call command configured by cfg. It must be called directly
+// from an exported method so that insertCommand attributes the command to
+// the right caller. Panics with ErrPlannerFrozen if the planner is frozen.
+func (p *Planner) add(call *Call, cfg addConfig) *ReturnValue {
+	if p.frozen {
+		panic(ErrPlannerFrozen)
+	}
+	return p.insertCommand(len(p.commands), call, CommandTypeCall, cfg).returnValue()
+}
+
+// insert is like add but places the call command at index i. It must be
+// called directly from an exported method, like add.
+func (p *Planner) insert(i int, call *Call) *ReturnValue {
+	return p.insertCommand(i, call, CommandTypeCall, addConfig{}).returnValue()
+}
+
+// AddCapture adds a function call, overriding the automatic decision of
+// whether to store its return value. With capture true the value is always
+// stored, in a slot that is never recycled, so it can be inspected in the
+// final state. With capture false the command is encoded with no return slot,
+// and Plan fails if a later command uses the value.
+func (p *Planner) AddCapture(call *Call, capture bool) *ReturnValue {
+	cfg := addConfig{capture: captureSuppress}
+	if capture {
+		cfg.capture = captureForce
+	}
+	return p.add(call, cfg)
+}
+
+// Repeat unrolls a loop by adding the call returned by body for each
+// iteration from 0 to n-1, in order, so each iteration can build its own
+// arguments. Returns each iteration's return value, with nil entries for
+// calls that have none.
+func (p *Planner) Repeat(n int, body func(iteration int) *Call) []*ReturnValue {
+	results := make([]*ReturnValue, 0, n)
+	for i := 0; i < n; i++ {
+		results = append(results, p.add(body(i), addConfig{}))
+	}
+	return results
+}
+
+// InsertAt inserts a function call before the command at index i, shifting
+// it and later commands up by one, and returns its return value (if any).
+// i may equal Len to append. Existing return values keep referring to
+// their commands. The call may only read return values of commands before
+// i; reading a later one fails with ErrReturnValueNotVisible.
+func (p *Planner) InsertAt(i int, call *Call) (*ReturnValue, error) {
+	if p.frozen {
+		return nil, ErrPlannerFrozen
+	}
+	n := len(p.commands)
+	if i < 0 || i > n {
+		return nil, fmt.Errorf("%w: %d (planner has %d commands)", ErrCommandIndexOutOfRange, i, n)
 	}
-	p.commands = append(p.commands, cmd)
+	for _, cmd := range p.commands[i:] {
+		for _, v := range call.values() {
+			if rv, ok := v.(*ReturnValue); ok && rv.command == cmd {
+				return nil, fmt.Errorf("%w: call reads the return value of a command after index %d", ErrReturnValueNotVisible, i)
+			}
+		}
+	}
+
+	return p.insert(i, call), nil
+}
 
+// RemoveAt removes the command at index i, shifting later commands down by
+// one. It fails with ErrReturnValueStillReferenced if a command of the
+// planner, its subplans or the planners enclosing it reads the removed
+// command's return value. Names registered for the value with AddNamed are
+// dropped.
+func (p *Planner) RemoveAt(i int) error {
+	if p.frozen {
+		return ErrPlannerFrozen
+	}
+	if i < 0 || i >= len(p.commands) {
+		return fmt.Errorf("%w: %d (planner has %d commands)", ErrCommandIndexOutOfRange, i, len(p.commands))
+	}
+	removed := p.commands[i]
+
+	root := p
+	for root.parent != nil {
+		root = root.parent
+	}
+	var reader *Command
+	root.walkCommands(func(cmd *Command) {
+		for _, v := range cmd.call.values() {
+			if rv, ok := v.(*ReturnValue); ok && rv.command == removed && reader == nil {
+				reader = cmd
+			}
+		}
+	})
+	if reader != nil {
+		return fmt.Errorf("%w: read by %s", ErrReturnValueStillReferenced, reader.call.method.Sig)
+	}
+
+	for name, rv := range p.names {
+		if rv.command == removed {
+			delete(p.names, name)
+		}
+	}
+	p.commands = append(p.commands[:i], p.commands[i+1:]...)
+	return nil
+}
+
+// AddNamed adds a function call and registers its return value under name,
+// so later commands can refer to it with Ref. Names must be unique within
+// the planner, and the call must have a return value.
+func (p *Planner) AddNamed(name string, call *Call) (*ReturnValue, error) {
+	if p.frozen {
+		return nil, ErrPlannerFrozen
+	}
+	if _, exists := p.names[name]; exists {
+		return nil, fmt.Errorf("%w: %q", ErrNameInUse, name)
+	}
 	if !call.HasReturnValue() {
-		return nil
+		return nil, ErrNoReturnValue
 	}
 
-	return &ReturnValue{
-		command: cmd,
-		abiType: *call.ReturnType(),
-		index:   0,
+	rv := p.add(call, addConfig{})
+	if p.names == nil {
+		p.names = make(map[string]*ReturnValue)
+	}
+	p.names[name] = rv
+	return rv, nil
+}
+
+// Ref returns the return value registered under name by AddNamed.
+func (p *Planner) Ref(name string) (*ReturnValue, error) {
+	rv, ok := p.names[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNameNotFound, name)
 	}
+	return rv, nil
 }
 
 // AddSubplan adds a subplan execution for callbacks like flash loans.
-// The call must accept a bytes32[] argument for the subplan commands
-// and may accept a bytes[] argument for the state.
+// The call must pass subplanner.Subplan() once, as a bytes32[] argument,
+// and may pass the planner's State() once, as a bytes[] argument. Other
+// arguments may come before, between or after them. Plan fails with
+// ErrForeignValue if the state is not that of p or a planner enclosing it.
 func (p *Planner) AddSubplan(call *Call, subplanner *Planner) (*ReturnValue, error) {
+	if p.frozen {
+		return nil, ErrPlannerFrozen
+	}
 	if err := validateSubplan(call, subplanner); err != nil {
 		return nil, err
 	}
@@ -85,43 +277,241 @@ func (p *Planner) AddSubplan(call *Call, subplanner *Planner) (*ReturnValue, err
 	// Mark subplan's parent for cycle detection
 	subplanner.parent = p
 
-	cmd := &Command{
-		call:       call,
-		cmdType:    CommandTypeSubplan,
-		returnSlot: -1,
-	}
-	p.commands = append(p.commands, cmd)
-
-	if !call.HasReturnValue() {
-		return nil, nil
-	}
-
-	return &ReturnValue{
-		command: cmd,
-		abiType: *call.ReturnType(),
-		index:   0,
-	}, nil
+	return p.insertCommand(len(p.commands), call, CommandTypeSubplan, addConfig{}).returnValue(), nil
 }
 
 // ReplaceState adds a call that replaces the planner state.
-// The function must return bytes[].
+// The function must have exactly one output, of type bytes[]; the output
+// may be named.
 func (p *Planner) ReplaceState(call *Call) error {
+	if p.frozen {
+		return ErrPlannerFrozen
+	}
 	if !call.HasReturnValue() {
 		return ErrNoReturnValue
 	}
 
-	retType := call.ReturnType()
-	if retType.String() != "bytes[]" {
-		return &TypeMismatchError{Expected: "bytes[]", Got: retType.String()}
+	outputs := call.method.Outputs
+	if len(outputs) != 1 || outputs[0].Type.String() != "bytes[]" {
+		types := make([]string, len(outputs))
+		for i, out := range outputs {
+			types[i] = out.Type.String()
+		}
+		got := types[0]
+		if len(types) > 1 {
+			got = "(" + strings.Join(types, ",") + ")"
+		}
+		return &TypeMismatchError{Expected: "bytes[]", Got: got}
 	}
 
+	p.insertCommand(len(p.commands), call, CommandTypeRawCall, addConfig{})
+	return nil
+}
+
+// insertCommand adds a command to the planner at index i and then runs the
+// add hooks, so they see the command in its final position. When source
+// tracking is enabled it records the caller of the exported method that was
+// invoked, skipping that method and, for call commands, the shared add or
+// insert helper.
+func (p *Planner) insertCommand(i int, call *Call, cmdType CommandType, cfg addConfig) *Command {
 	cmd := &Command{
 		call:       call,
-		cmdType:    CommandTypeRawCall,
+		cmdType:    cmdType,
 		returnSlot: -1,
+		capture:    cfg.capture,
+		label:      cfg.label,
+		returnType: cfg.returnType,
 	}
-	p.commands = append(p.commands, cmd)
-	return nil
+	if p.trackSource {
+		skip := 2
+		if cmdType == CommandTypeCall {
+			skip = 3
+		}
+		if _, file, line, ok := runtime.Caller(skip); ok {
+			cmd.sourceFile, cmd.sourceLine = file, line
+		}
+	}
+	p.commands = slices.Insert(p.commands, i, cmd)
+	for _, hook := range p.addHooks {
+		hook(cmd)
+	}
+	return cmd
+}
+
+// returnValue returns the value of the command's call result, or nil if the
+// call has no return value.
+func (c *Command) returnValue() *ReturnValue {
+	if !c.call.HasReturnValue() {
+		return nil
+	}
+	return &ReturnValue{
+		command:  c,
+		abiType:  *c.call.ReturnType(),
+		index:    0,
+		override: c.returnType,
+	}
+}
+
+// Freeze marks the planner immutable, guarding a plan that has been
+// compiled or approved against accidental changes. Afterwards AddNamed,
+// AddSubplan and ReplaceState return ErrPlannerFrozen, and Add and the other
+// methods without an error result panic with it. Plan is still allowed.
+func (p *Planner) Freeze() {
+	p.frozen = true
+}
+
+// IsFrozen reports whether Freeze has been called.
+func (p *Planner) IsFrozen() bool {
+	return p.frozen
+}
+
+// Clone returns an independent copy of the planner, for forking a base plan
+// into variants. Commands and calls are reallocated, and return values,
+// state values and names are rewired to the copy, so adding to either
+// planner leaves the other unchanged. Subplans are cloned too, with the
+// copies as their parents. Add hooks and source tracking carry over; the
+// clone has no parent and is not frozen. Contracts and literals are shared.
+func (p *Planner) Clone() *Planner {
+	c := &cloner{
+		commands: make(map[*Command]*Command),
+		planners: make(map[*Planner]*Planner),
+	}
+	return c.planner(p, nil)
+}
+
+// cloner deep-copies planners, remembering the copy of each command and
+// planner so that values referring to them can be rewired.
+type cloner struct {
+	commands map[*Command]*Command
+	planners map[*Planner]*Planner
+}
+
+// planner clones p with the given parent, or returns the existing copy.
+func (c *cloner) planner(p, parent *Planner) *Planner {
+	if clone, ok := c.planners[p]; ok {
+		return clone
+	}
+	clone := &Planner{
+		commands:    make([]*Command, 0, len(p.commands)),
+		parent:      parent,
+		trackSource: p.trackSource,
+		addHooks:    slices.Clone(p.addHooks),
+		mutables:    slices.Clone(p.mutables),
+	}
+	c.planners[p] = clone
+	if p.origin != nil {
+		c.planners[p.origin] = clone
+	}
+
+	for _, cmd := range p.commands {
+		copied := *cmd
+		copied.call = c.call(cmd.call)
+		c.commands[cmd] = &copied
+		clone.commands = append(clone.commands, &copied)
+	}
+	if p.names != nil {
+		clone.names = make(map[string]*ReturnValue, len(p.names))
+		for name, rv := range p.names {
+			clone.names[name] = c.value(rv).(*ReturnValue)
+		}
+	}
+	return clone
+}
+
+// call copies call with its values rewired. A dynamic contract whose
+// address is a rewired value is copied as well.
+func (c *cloner) call(call *Call) *Call {
+	clone := call.clone()
+	for i, arg := range clone.args {
+		clone.args[i] = c.value(arg)
+	}
+	if addr := call.contract.addressValue; addr != nil {
+		if mapped := c.value(addr); mapped != addr {
+			contract := *call.contract
+			contract.addressValue = mapped
+			clone.contract = &contract
+		}
+	}
+	return clone
+}
+
+// value returns v rewired to the cloned commands and planners. Values
+// referring to anything outside the cloned planners are returned as is.
+func (c *cloner) value(v Value) Value {
+	switch v := v.(type) {
+	case *ReturnValue:
+		if cmd, ok := c.commands[v.command]; ok {
+			rv := *v
+			rv.command = cmd
+			return &rv
+		}
+	case *StateValue:
+		if planner, ok := c.planners[v.planner]; ok {
+			return &StateValue{planner: planner}
+		}
+	case *SubplanValue:
+		if v.subplanner != nil {
+			parent := v.subplanner.parent
+			if mapped, ok := c.planners[parent]; ok {
+				parent = mapped
+			}
+			return &SubplanValue{subplanner: c.planner(v.subplanner, parent)}
+		}
+	}
+	return v
+}
+
+// SlotValue returns a value that reads state slot directly, for context the
+// caller writes into the state array before execution. The slot must lie
+// in the reserved range below the minimum set with WithSlotRange, or Plan
+// fails with ErrSlotNotReserved. typeStr is the ABI type of the contents.
+func (p *Planner) SlotValue(slot uint8, typeStr string) (Value, error) {
+	if int(slot) >= MaxStateSlots {
+		return nil, fmt.Errorf("%w: slot %d", ErrSlotNotReserved, slot)
+	}
+	abiType, err := abi.NewType(typeStr, "", nil)
+	if err != nil {
+		return nil, &EncodingError{Value: typeStr, Err: err}
+	}
+	return &SlotValue{slot: slot, abiType: abiType}, nil
+}
+
+// AddMutableLiteral returns a copy of lit with a private state slot, for
+// helper libraries that use a slot as scratch space and write into it during
+// execution. Unlike other literals it is never deduplicated with an identical
+// literal, and its slot is never recycled. Every use of the returned value
+// refers to the same slot, which Plan allocates before encoding any command,
+// so it exists even if no command reads it. Panics with ErrPlannerFrozen if
+// the planner is frozen.
+func (p *Planner) AddMutableLiteral(lit *LiteralValue) *LiteralValue {
+	if p.frozen {
+		panic(ErrPlannerFrozen)
+	}
+	mutable := &LiteralValue{abiType: lit.abiType, data: lit.data, mutable: true}
+	p.mutables = append(p.mutables, mutable)
+	return mutable
+}
+
+// Output marks rv, the return value of one of p's commands, as an output of
+// p when it runs as a subplan, so the parent planner may use rv in commands
+// added after AddSubplan. The subplan writes rv to the shared state, and the
+// command running it must return the updated state as bytes[], which then
+// replaces the parent's state; Plan fails with ErrInvalidSubplan otherwise.
+// Without Output, the parent can't use values computed in the subplan.
+func (p *Planner) Output(rv *ReturnValue) (*ReturnValue, error) {
+	if p.frozen {
+		return nil, ErrPlannerFrozen
+	}
+	if rv == nil {
+		return nil, ErrReturnValueNotVisible
+	}
+	for _, cmd := range p.commands {
+		if cmd == rv.command {
+			cmd.output = true
+			return rv, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: not a return value of this planner", ErrReturnValueNotVisible)
 }
 
 // State returns a StateValue for use in subplan calls.
@@ -159,57 +549,273 @@ func (p *Planner) ForEachCommand(fn func(int, *Command) bool) {
 
 // Plan compiles all commands into executable format.
 // Returns the encoded commands and initial state array.
+//
+// Compilation is O(n) in the total number of arguments across all commands:
+// visibility analysis and encoding each visit every argument once, and slot
+// lookups, literal deduplication, and slot expiry are map operations.
+// WithCommandDeduplication adds a scan over earlier side-effect-free
+// commands, which is O(n²) in the worst case.
 func (p *Planner) Plan(opts ...PlanOption) (*CompiledPlan, error) {
 	cfg := defaultPlanConfig()
 	for _, opt := range opts {
 		opt(cfg)
 	}
 
+	return p.compile(cfg, nil)
+}
+
+// PlanContext is like Plan but checks ctx before encoding each command,
+// including those of subplans, and aborts with ctx.Err() once ctx is done.
+// Together with WithMaxCommands it bounds the work spent compiling plans
+// built from untrusted input.
+func (p *Planner) PlanContext(ctx context.Context, opts ...PlanOption) (*CompiledPlan, error) {
+	cfg := defaultPlanConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.ctx = ctx
+
+	return p.compile(cfg, nil)
+}
+
+// PlanWithSalt is like Plan but mixes salt into the compiled plan's ID, so
+// that identical plans can be given distinct identifiers for signing or
+// approval flows. The salt does not change the commands or state sent to
+// the VM, and is not recorded by MarshalBinary.
+func (p *Planner) PlanWithSalt(salt [32]byte, opts ...PlanOption) (*CompiledPlan, error) {
+	cp, err := p.Plan(opts...)
+	if err != nil {
+		return nil, err
+	}
+	cp.salt = &salt
+	return cp, nil
+}
+
+// PreviewArgSlots returns the argument slots call would be encoded with if
+// it were added next, as ArgSlotsFor would report them after Plan with
+// default options. The planner is left unchanged: a clone with call
+// appended is compiled, so the slots Plan records on commands are not
+// touched. Slots of earlier values can differ from the current plan's,
+// since call may extend their lifetimes.
+func (p *Planner) PreviewArgSlots(call *Call) ([]uint8, error) {
+	c := &cloner{
+		commands: make(map[*Command]*Command),
+		planners: make(map[*Planner]*Planner),
+	}
+	preview := c.planner(p, p.parent)
+	preview.commands = append(preview.commands, &Command{
+		call:       c.call(call),
+		cmdType:    CommandTypeCall,
+		returnSlot: -1,
+	})
+
+	cp, err := preview.compile(defaultPlanConfig(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return cp.ArgSlotsFor(len(cp.Commands) - 1)
+}
+
+// CompileSubplanCommands compiles the planner's commands, as Plan does with
+// default options, and returns them as words for a bytes32[] subplan
+// argument, for wiring a subplan into a parent call by hand. The state is
+// not returned: the commands index the slots of this planner's own plan, so
+// the caller must arrange for the parent state to match. When the parent's
+// first command runs the subplan, this is the same encoding AddSubplan
+// embeds. The commands cannot reference return values of other planners.
+func (p *Planner) CompileSubplanCommands() ([][32]byte, error) {
+	cp, err := p.Plan()
+	if err != nil {
+		return nil, err
+	}
+	return cp.CommandsAsBytes32(), nil
+}
+
+// compile encodes the plan using cfg, substituting template parameters
+// from params. Plan passes nil params, so any ParamValue fails to encode.
+func (p *Planner) compile(cfg *planConfig, params map[string]*LiteralValue) (*CompiledPlan, error) {
+	var commands [][]byte
+	state, err := p.compileTo(cfg, params, func(cmd []byte) error {
+		commands = append(commands, cmd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CompiledPlan{
+		Commands: commands,
+		State:    state,
+		config:   cfg.snapshot(),
+	}
+
+	if cfg.maxCalldata > 0 {
+		if size := plan.calldataSize(); size > cfg.maxCalldata {
+			return nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrCalldataTooLarge, size, cfg.maxCalldata)
+		}
+	}
+
+	return plan, nil
+}
+
+// compileTo encodes the plan using cfg, passing each top-level command to
+// emit in order, and returns the final state. The visibility analysis runs
+// over the whole plan before the first command is emitted.
+func (p *Planner) compileTo(cfg *planConfig, params map[string]*LiteralValue, emit func([]byte) error) ([][]byte, error) {
 	if len(p.commands) > cfg.maxCommands {
 		return nil, ErrTooManyArguments
 	}
 
+	commands := p.commands
+	var aliases map[*Command]*Command
+	if cfg.dedupCommands {
+		commands, aliases = deduplicateCommands(p.commands)
+	}
+
+	if err := p.checkOwners(make(map[*Planner]bool)); err != nil {
+		return nil, err
+	}
+	for i, cmd := range p.commands[:max(len(p.commands)-1, 0)] {
+		if cmd.label == FeePaymentLabel {
+			return nil, cmd.planError(i, ErrFeePaymentNotLast)
+		}
+	}
+	if err := checkReturnShapes(commands, aliases, make(map[*Planner]bool)); err != nil {
+		return nil, err
+	}
+
 	// Phase 1: Visibility analysis
-	visibility := p.analyzeVisibility()
+	visibility := analyzeVisibility(commands, aliases)
 
 	// Phase 2: Build state and encode commands
 	state := newStateManager(cfg)
-	encoder := NewCommandEncoder()
+	state.commandAliases = aliases
+	state.params = params
 
-	encodedCommands := make([][]byte, 0, len(p.commands))
+	for _, lit := range p.mutables {
+		if _, err := state.allocateLiteral(lit); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.encodeCommands(commands, visibility, state, -1, emit); err != nil {
+		return nil, err
+	}
+
+	if err := state.verify(); err != nil {
+		return nil, err
+	}
+	if err := state.checkLiveness(commands); err != nil {
+		return nil, err
+	}
+
+	if cfg.terminator != nil {
+		if err := emit(cfg.encodeTerminator()); err != nil {
+			return nil, err
+		}
+	}
+
+	return state.finalize(), nil
+}
+
+// encodeCommands encodes commands against the shared state, passing each to
+// emit in order. parentIndex is -1
+// for the top-level plan. For a subplan it is the index of the top-level
+// command that runs it: the subplan's return slots stay live until that
+// command completes, and only the top level expires slots.
+func (p *Planner) encodeCommands(commands []*Command, visibility map[*Command]int, state *stateManager, parentIndex int, emit func([]byte) error) error {
+	cfg := state.config
+	encoder := NewCommandEncoderWithConfig(cfg.encoding)
+
+	var local map[*Command]bool
+	if parentIndex < 0 {
+		local = make(map[*Command]bool, len(commands))
+		for _, cmd := range commands {
+			local[cmd] = true
+		}
+	}
+
+	for i, cmd := range commands {
+		if cfg.ctx != nil {
+			if err := cfg.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		index := i
+		if parentIndex >= 0 {
+			index = parentIndex
+		}
+
+		if err := cmd.call.validate(); err != nil {
+			return cmd.planError(i, err)
+		}
+
+		if cfg.requireTrust && cmd.call.flags.CallType() == FlagDelegateCall &&
+			cmd.call.contract.trust == Untrusted {
+			return cmd.planError(i, ErrUntrustedDelegateCall)
+		}
+
+		writesState, err := cmd.writesState()
+		if err != nil {
+			return cmd.planError(i, err)
+		}
+		if local != nil {
+			if err := checkSubplanReads(cmd, local, state.commandAliases); err != nil {
+				return cmd.planError(i, err)
+			}
+		}
 
-	for i, cmd := range p.commands {
 		// Allocate return slot if this command's return value is used
-		if lastUsage, used := visibility[cmd]; used {
-			isDynamic := false
+		cmd.returnSlot = -1
+		lastUsage, used := visibility[cmd]
+		if writesState {
+			// The return value replaces the state and has no slot of its own.
+			used = false
+		}
+		switch cmd.capture {
+		case captureSuppress:
+			if used {
+				return cmd.planError(i, ErrReturnSuppressed)
+			}
+		case captureForce:
 			if cmd.call.HasReturnValue() {
-				isDynamic = isDynamicType(*cmd.call.ReturnType())
+				lastUsage, used = -1, true // Never expires
 			}
-			slot, err := state.allocateReturn(cmd, lastUsage, isDynamic)
+		}
+		if used {
+			slot, err := state.allocateReturn(cmd, lastUsage, cmd.returnsDynamic())
 			if err != nil {
-				return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+				return cmd.planError(i, err)
 			}
-			cmd.returnSlot = int(slot & ^uint8(DynamicSlotFlag))
+			cmd.returnSlot = int(slot &^ cfg.encoding.DynamicSlotFlag)
 		}
 
 		// Build argument slots
-		argSlots, err := p.buildArgSlots(cmd, state)
+		argSlots, err := p.buildArgSlots(cmd, state, visibility, index)
 		if err != nil {
-			return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+			return cmd.planError(i, err)
 		}
 
 		// Determine return slot
-		returnSlot := uint8(NoReturnSlot)
-		if cmd.returnSlot >= 0 {
+		returnSlot := cfg.encoding.NoReturnSlot
+		if writesState {
+			returnSlot = cfg.encoding.StateSlotMarker
+		} else if cmd.returnSlot >= 0 {
 			returnSlot = uint8(cmd.returnSlot)
-			if cmd.call.HasReturnValue() && isDynamicType(*cmd.call.ReturnType()) {
-				returnSlot |= DynamicSlotFlag
+			if cmd.returnsDynamic() {
+				returnSlot |= cfg.encoding.DynamicSlotFlag
 			}
 		}
 
 		// Encode command
 		isExtended := len(argSlots) > MaxStandardArgs
 		flags := cmd.call.computeFlags(isExtended)
+		if cmd.call.allowFail {
+			if cfg.encoding.AllowFailureFlag == 0 {
+				return cmd.planError(i, ErrAllowFailureUnsupported)
+			}
+			flags |= cfg.encoding.AllowFailureFlag
+		}
 
 		encoded, err := encoder.EncodeCommand(
 			cmd.call.Selector(),
@@ -219,37 +825,129 @@ func (p *Planner) Plan(opts ...PlanOption) (*CompiledPlan, error) {
 			cmd.call.contract.Address(),
 		)
 		if err != nil {
-			return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+			return cmd.planError(i, err)
+		}
+		if err := emit(encoded); err != nil {
+			return err
 		}
-		encodedCommands = append(encodedCommands, encoded)
 
 		// Expire slots after this command
-		state.expireSlots(i)
+		if parentIndex < 0 {
+			state.expireSlots(i)
+		}
+	}
+
+	return nil
+}
+
+// writesState reports whether the command's return value replaces the
+// planner state: ReplaceState calls, and calls running a subplan that has
+// outputs. The latter must return bytes[].
+func (c *Command) writesState() (bool, error) {
+	switch c.cmdType {
+	case CommandTypeRawCall:
+		return true, nil
+	case CommandTypeSubplan:
+	default:
+		return false, nil
+	}
+
+	hasOutputs := false
+	for _, arg := range c.call.Args() {
+		if sub, ok := arg.(*SubplanValue); ok && sub.subplanner != nil {
+			for _, cmd := range sub.subplanner.commands {
+				hasOutputs = hasOutputs || cmd.output
+			}
+		}
+	}
+	if !hasOutputs {
+		return false, nil
+	}
+	if got := c.returnTypeString(); got != "bytes[]" || len(c.call.method.Outputs) != 1 {
+		return false, fmt.Errorf("%w: subplan has outputs, so the call must return the updated state as bytes[], got %s",
+			ErrInvalidSubplan, got)
+	}
+	return true, nil
+}
+
+// checkSubplanReads verifies that a top-level command only reads return
+// values of top-level commands, local, or of subplan commands marked with
+// Output, since other subplan values are lost when the subplan finishes.
+func checkSubplanReads(cmd *Command, local map[*Command]bool, aliases map[*Command]*Command) error {
+	for _, arg := range cmd.call.values() {
+		rv, ok := arg.(*ReturnValue)
+		if !ok {
+			continue
+		}
+		producer := rv.command
+		if kept, aliased := aliases[producer]; aliased {
+			producer = kept
+		}
+		if !local[producer] && !producer.output {
+			return fmt.Errorf("%w: value from another planner; subplan values need Output", ErrReturnValueNotVisible)
+		}
+	}
+	return nil
+}
+
+// encodeSubplan compiles a subplan against the parent's state, so its
+// commands index the same slots as the parent, and stores the encoded
+// commands in state as a bytes32[] literal. index is the top-level command
+// that runs the subplan.
+func (p *Planner) encodeSubplan(sub *Planner, state *stateManager, visibility map[*Command]int, index int) (uint8, error) {
+	if state.activeSubplans[sub] {
+		return 0, ErrCyclicPlanner
 	}
+	state.activeSubplans[sub] = true
+	defer delete(state.activeSubplans, sub)
 
-	return &CompiledPlan{
-		Commands: encodedCommands,
-		State:    state.finalize(),
-	}, nil
+	var encoded [][]byte
+	err := p.encodeCommands(sub.commands, visibility, state, index, func(cmd []byte) error {
+		encoded = append(encoded, cmd)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSubplan, err)
+	}
+
+	words := (&CompiledPlan{Commands: encoded}).CommandsAsBytes32()
+	lit, err := NewLiteralFromType("bytes32[]", words)
+	if err != nil {
+		return 0, err
+	}
+	return state.allocateLiteral(lit)
 }
 
 // buildArgSlots builds the argument slot array for a command.
-func (p *Planner) buildArgSlots(cmd *Command, state *stateManager) ([]uint8, error) {
-	args := cmd.call.Args()
-	slots := make([]uint8, len(args))
+// index is the top-level command index, used when compiling subplan arguments.
+func (p *Planner) buildArgSlots(cmd *Command, state *stateManager, visibility map[*Command]int, index int) ([]uint8, error) {
+	args := cmd.call.values()
+	slots := make([]uint8, 0, len(args)+1)
 
-	for i, arg := range args {
-		slot, err := state.getSlotForValue(arg)
+	// The VM reads the ETH amount of a CALL_WITH_VALUE from the first slot
+	if cmd.call.flags.CallType() == FlagCallWithValue {
+		value := cmd.call.value
+		if value == nil {
+			value = new(big.Int)
+		}
+		slot, err := state.allocateLiteral(Uint256(value))
 		if err != nil {
 			return nil, err
 		}
-		slots[i] = slot
+		slots = append(slots, slot)
 	}
 
-	// If call has value, add it as an extra argument
-	if cmd.call.value != nil && cmd.call.value.Sign() > 0 {
-		valueLit := Uint256(cmd.call.value)
-		slot, err := state.allocateLiteral(valueLit)
+	for i, arg := range args {
+		var slot uint8
+		var err error
+		if sub, ok := arg.(*SubplanValue); ok {
+			slot, err = p.encodeSubplan(sub.subplanner, state, visibility, index)
+		} else {
+			slot, err = state.getSlotForValue(arg)
+		}
+		if errors.Is(err, ErrStateEntryTooLarge) {
+			return nil, &ArgumentError{Method: cmd.call.method.Name, Index: i, Err: err}
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -262,19 +960,238 @@ func (p *Planner) buildArgSlots(cmd *Command, state *stateManager) ([]uint8, err
 // analyzeVisibility determines the last command index that uses each command's return value.
 // Returns a map from command to its last usage index.
 func (p *Planner) analyzeVisibility() map[*Command]int {
+	return analyzeVisibility(p.commands, nil)
+}
+
+// analyzeVisibility computes last usages over an explicit command list.
+// Return values produced by an aliased command count as uses of the kept command,
+// and uses within a subplan count as uses by the command that runs it.
+func analyzeVisibility(commands []*Command, aliases map[*Command]*Command) map[*Command]int {
 	visibility := make(map[*Command]int)
 
-	for i, cmd := range p.commands {
-		for _, arg := range cmd.call.Args() {
-			if rv, ok := arg.(*ReturnValue); ok {
-				visibility[rv.command] = i
+	var mark func(cmds []*Command, at func(int) int, visited map[*Planner]bool)
+	mark = func(cmds []*Command, at func(int) int, visited map[*Planner]bool) {
+		for i, cmd := range cmds {
+			for _, arg := range cmd.call.values() {
+				switch v := arg.(type) {
+				case *ReturnValue:
+					producer := v.command
+					if kept, aliased := aliases[producer]; aliased {
+						producer = kept
+					}
+					visibility[producer] = at(i)
+				case *SubplanValue:
+					if v.subplanner == nil || visited[v.subplanner] {
+						continue
+					}
+					visited[v.subplanner] = true
+					// Uses inside a subplan happen while the parent command runs.
+					parent := at(i)
+					mark(v.subplanner.commands, func(int) int { return parent }, visited)
+					delete(visited, v.subplanner)
+				}
 			}
 		}
 	}
+	mark(commands, func(i int) int { return i }, make(map[*Planner]bool))
 
 	return visibility
 }
 
+// checkReturnShapes verifies that no return value is consumed with the wrong
+// shape: a dynamic return read as a static parameter only yields its first
+// word, and a static return read as dynamic is misinterpreted as an offset.
+// Invoke already requires every other argument to match its parameter type
+// exactly (see CanAssign); a return value can still differ because its slot
+// holds what the command stores, e.g. the whole tuple of a RawReturn call.
+// Since no parameter of an output's type takes that tuple, the outputs of a
+// RawReturn call fail with ErrRawReturnArgument. Subplans are checked as
+// well, each at most once.
+func checkReturnShapes(commands []*Command, aliases map[*Command]*Command, visited map[*Planner]bool) error {
+	index := make(map[*Command]int, len(commands))
+	for i, cmd := range commands {
+		index[cmd] = i
+	}
+
+	for i, cmd := range commands {
+		for j, arg := range cmd.call.Args() {
+			if sub, ok := arg.(*SubplanValue); ok && sub.subplanner != nil && !visited[sub.subplanner] {
+				visited[sub.subplanner] = true
+				if err := checkReturnShapes(sub.subplanner.commands, nil, visited); err != nil {
+					return cmd.planError(i, fmt.Errorf("%w: %w", ErrInvalidSubplan, err))
+				}
+				continue
+			}
+			rv, ok := arg.(*ReturnValue)
+			if !ok || j >= len(cmd.call.method.Inputs) {
+				continue
+			}
+			producer := rv.command
+			if kept, aliased := aliases[producer]; aliased {
+				producer = kept
+			}
+
+			err := checkArgShape(producer.returnsDynamic(), producer.returnTypeString(), cmd.call.method.Inputs[j].Type)
+			if err == nil && producer.call.rawReturn {
+				err = fmt.Errorf("%w: output %d of %s", ErrRawReturnArgument, rv.index, producer.call.method.Sig)
+			}
+			if err == nil {
+				continue
+			}
+			if at, found := index[producer]; found {
+				err = fmt.Errorf("return value of command %d: %w", at, err)
+			}
+			return cmd.planError(i, &ArgumentError{Method: cmd.call.method.Name, Index: j, Err: err})
+		}
+	}
+
+	return nil
+}
+
+// checkArgShape verifies that a value of type got, held in a dynamic slot
+// if dynamic is set, is passed as a parameter of the same shape: the VM
+// copies a dynamic slot as a length-prefixed tail but a static one as a
+// single head word, so a mismatch silently corrupts the call.
+func checkArgShape(dynamic bool, got string, expected abi.Type) error {
+	if dynamic == isDynamicType(expected) {
+		return nil
+	}
+	return &TypeMismatchError{Expected: expected.String(), Got: got}
+}
+
+// checkOwners verifies that the planner's commands, and those of its
+// subplans, only pass state and subplan values of the right planner: the
+// state of the planner itself or of an enclosing planner, which share one
+// state array, and subplans added to this planner with AddSubplan. Both
+// encode without naming their planner, so a mix-up would go unnoticed.
+func (p *Planner) checkOwners(visited map[*Planner]bool) error {
+	visited[p] = true
+	self := p.identity()
+	for i, cmd := range p.commands {
+		for j, arg := range cmd.call.Args() {
+			switch v := arg.(type) {
+			case *StateValue:
+				if !v.planner.encloses(self) {
+					return cmd.planError(i, fmt.Errorf("%w: argument %d is the state of another planner", ErrForeignValue, j))
+				}
+			case *SubplanValue:
+				if v.subplanner == nil || v.subplanner.parent != self {
+					return cmd.planError(i, fmt.Errorf("%w: argument %d is a subplan not added to this planner", ErrForeignValue, j))
+				}
+				if !visited[v.subplanner] {
+					if err := v.subplanner.checkOwners(visited); err != nil {
+						return fmt.Errorf("%w: %w", ErrInvalidSubplan, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// identity returns the planner that state and subplan values passed to p's
+// commands belong to: p itself, or the planner it was derived from.
+func (p *Planner) identity() *Planner {
+	if p.origin != nil {
+		return p.origin
+	}
+	return p
+}
+
+// encloses reports whether p is sub or one of its ancestors.
+func (p *Planner) encloses(sub *Planner) bool {
+	for q := sub; q != nil; q = q.parent {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// returnsDynamic reports whether the command writes a dynamic value to its
+// return slot. Raw returns are always stored as bytes.
+func (c *Command) returnsDynamic() bool {
+	if c.call.rawReturn {
+		return true
+	}
+	return c.call.HasReturnValue() && isDynamicType(*c.call.ReturnType())
+}
+
+// returnTypeString describes the value the command stores in its return slot.
+func (c *Command) returnTypeString() string {
+	if c.call.rawReturn {
+		return "bytes"
+	}
+	if !c.call.HasReturnValue() {
+		return "none"
+	}
+	return c.call.ReturnType().String()
+}
+
+// deduplicateCommands drops view and pure calls that repeat an earlier call.
+// It returns the remaining commands and a map from each dropped command to
+// the command whose return value replaces it. View calls are only reused
+// until the next side-effecting command, since that may change what they read.
+func deduplicateCommands(commands []*Command) ([]*Command, map[*Command]*Command) {
+	kept := make([]*Command, 0, len(commands))
+	aliases := make(map[*Command]*Command)
+	resolve := func(cmd *Command) *Command {
+		if k, ok := aliases[cmd]; ok {
+			return k
+		}
+		return cmd
+	}
+
+	var candidates []*Command
+	for _, cmd := range commands {
+		if !cmd.isSideEffectFree() {
+			kept = append(kept, cmd)
+			// Drop view results; pure results can never change.
+			pure := candidates[:0]
+			for _, c := range candidates {
+				if c.call.method.StateMutability == "pure" {
+					pure = append(pure, c)
+				}
+			}
+			candidates = pure
+			continue
+		}
+
+		var match *Command
+		for _, c := range candidates {
+			if c.call.equalResolving(cmd.call, resolve) {
+				match = c
+				break
+			}
+		}
+		if match != nil {
+			aliases[cmd] = match
+			continue
+		}
+
+		kept = append(kept, cmd)
+		candidates = append(candidates, cmd)
+	}
+
+	return kept, aliases
+}
+
+// isSideEffectFree reports whether the command is a plain view or pure call.
+func (c *Command) isSideEffectFree() bool {
+	if c.cmdType != CommandTypeCall {
+		return false
+	}
+	if c.call.value != nil && c.call.value.Sign() > 0 {
+		return false
+	}
+	switch c.call.method.StateMutability {
+	case "view", "pure":
+		return true
+	default:
+		return false
+	}
+}
+
 // checkCycle checks for cyclic planner references.
 func (p *Planner) checkCycle(sub *Planner) error {
 	visited := make(map[*Planner]bool)
@@ -314,6 +1231,40 @@ func validateSubplan(call *Call, sub *Planner) error {
 		return ErrInvalidSubplan
 	}
 
+	// The subplan must be passed exactly once, in a bytes32[] parameter, and
+	// the state at most once, in a bytes[] parameter, wherever those sit
+	// among any extra parameters.
+	subplanAt, stateAt := -1, -1
+	for i, arg := range call.args {
+		switch v := arg.(type) {
+		case *SubplanValue:
+			if subplanAt >= 0 {
+				return fmt.Errorf("%w: more than one subplan argument", ErrInvalidSubplan)
+			}
+			if v.subplanner != sub {
+				return fmt.Errorf("%w: argument %d is the subplan of a different planner", ErrInvalidSubplan, i)
+			}
+			subplanAt = i
+		case *StateValue:
+			if stateAt >= 0 {
+				return fmt.Errorf("%w: more than one state argument", ErrInvalidSubplan)
+			}
+			stateAt = i
+		}
+	}
+
+	if subplanAt < 0 {
+		return fmt.Errorf("%w: no subplan argument", ErrInvalidSubplan)
+	}
+	if got := call.method.Inputs[subplanAt].Type.String(); got != "bytes32[]" {
+		return fmt.Errorf("%w: subplan passed as argument %d of type %s, want bytes32[]", ErrInvalidSubplan, subplanAt, got)
+	}
+	if stateAt >= 0 {
+		if got := call.method.Inputs[stateAt].Type.String(); got != "bytes[]" {
+			return fmt.Errorf("%w: state passed as argument %d of type %s, want bytes[]", ErrInvalidSubplan, stateAt, got)
+		}
+	}
+
 	return nil
 }
 
@@ -321,6 +1272,24 @@ func validateSubplan(call *Call, sub *Planner) error {
 type CompiledPlan struct {
 	Commands [][]byte // Each command is 32 bytes (or 64 for extended)
 	State    [][]byte // Initial state array
+	config   PlanConfigSnapshot
+	salt     *[32]byte // Mixed into ID; see PlanWithSalt
+}
+
+// Config returns the options the plan was compiled with. It is the zero
+// value for plans that were not produced by Plan, such as decoded or
+// unmarshaled ones.
+func (cp *CompiledPlan) Config() PlanConfigSnapshot {
+	return cp.config
+}
+
+// encoding returns the sentinel values cp's commands were encoded with, the
+// defaults for plans that were not produced by Plan.
+func (cp *CompiledPlan) encoding() EncodingConfig {
+	if cp.config.Encoding == (EncodingConfig{}) {
+		return DefaultEncodingConfig()
+	}
+	return cp.config.Encoding
 }
 
 // CommandsAsBytes32 returns commands as [][32]byte for contract calls.
@@ -359,3 +1328,308 @@ func (cp *CompiledPlan) CommandCount() int {
 	}
 	return count
 }
+
+// ID returns a content hash identifying the compiled plan, for use as a
+// client-side cache key. It is keccak256 over the canonical serialization
+//
+//	count(commands) || len(cmd0) || cmd0 || ... || count(state) || len(s0) || s0 || ...
+//
+// where every count and length is a 4-byte big-endian integer. The length
+// prefixes keep an extended command distinct from two standard commands.
+// Plans that compile to identical bytes share an ID, unless they were
+// compiled with PlanWithSalt, in which case the 32-byte salt is appended.
+func (cp *CompiledPlan) ID() common.Hash {
+	var buf []byte
+	appendItems := func(items [][]byte) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(items)))
+		for _, item := range items {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(item)))
+			buf = append(buf, item...)
+		}
+	}
+	appendItems(cp.Commands)
+	appendItems(cp.State)
+	if cp.salt != nil {
+		buf = append(buf, cp.salt[:]...)
+	}
+
+	return crypto.Keccak256Hash(buf)
+}
+
+// ArgSlotsFor returns the encoded argument slots of logical command i,
+// including the dynamic flag on each slot. For CALL_WITH_VALUE commands the
+// first slot holds the ETH value.
+func (cp *CompiledPlan) ArgSlotsFor(i int) ([]uint8, error) {
+	if i < 0 || i >= len(cp.Commands) {
+		return nil, fmt.Errorf("%w: %d (plan has %d commands)", ErrCommandIndexOutOfRange, i, len(cp.Commands))
+	}
+
+	_, _, argSlots, _, _, err := DecodeCommandWithConfig(cp.Commands[i], cp.encoding())
+	if err != nil {
+		return nil, &PlanError{CommandIndex: i, Err: err}
+	}
+	return argSlots, nil
+}
+
+// calldataSize returns the length of the ABI-encoded
+// execute(bytes32[], bytes[]) calldata for the plan, without packing it:
+// the selector, two offsets, the commands array as a length word plus one
+// word per command word, and the state array as a length word plus one
+// offset per element and each element's length word and padded data.
+func (cp *CompiledPlan) calldataSize() int {
+	commandBytes := 0
+	for _, cmd := range cp.Commands {
+		commandBytes += (len(cmd) + 31) / 32 * 32
+	}
+	return calldataSize(commandBytes, cp.State)
+}
+
+// calldataSize returns the execute calldata size for commands totalling
+// commandBytes, padded to words, and state.
+func calldataSize(commandBytes int, state [][]byte) int {
+	size := 4 + 2*32
+
+	size += 32 + commandBytes
+
+	size += 32
+	for _, s := range state {
+		size += 32 + 32 + (len(s)+31)/32*32
+	}
+
+	return size
+}
+
+// AllowFailureCommands returns the indices of commands whose flags include
+// cfg.AllowFailureFlag, i.e. those the VM continues past if they revert.
+// Returns nil if cfg has no allow-failure flag.
+func (cp *CompiledPlan) AllowFailureCommands(cfg EncodingConfig) []int {
+	if cfg.AllowFailureFlag == 0 {
+		return nil
+	}
+
+	var indices []int
+	for i, cmd := range cp.Commands {
+		if len(cmd) > 4 && CallFlags(cmd[4])&cfg.AllowFailureFlag != 0 {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// DeadSlots returns the indices of state slots that no command reads or
+// writes, such as the padding left below WithSlotRange's minimum. A plan
+// compiled by Plan normally has none; any others indicate wasted state.
+// Commands of subplans are followed into their bytes32[] state literals,
+// recognised as the command-array argument of a call that also receives
+// the planner state.
+func (cp *CompiledPlan) DeadSlots() []uint8 {
+	used := make([]bool, len(cp.State))
+	cp.markUsedSlots(cp.Commands, used)
+
+	var dead []uint8
+	for i, u := range used {
+		if !u {
+			dead = append(dead, uint8(i))
+		}
+	}
+	return dead
+}
+
+// markUsedSlots records the slots referenced by commands, descending into
+// subplans. A subplan slot is only descended into the first time it is marked.
+func (cp *CompiledPlan) markUsedSlots(commands [][]byte, used []bool) {
+	enc := cp.encoding()
+	for _, cmd := range commands {
+		_, _, argSlots, returnSlot, _, err := DecodeCommandWithConfig(cmd, enc)
+		if err != nil {
+			continue
+		}
+
+		passesState := false
+		for _, slot := range argSlots {
+			if slot == enc.StateSlotMarker {
+				passesState = true
+			}
+		}
+
+		for _, slot := range argSlots {
+			index := int(enc.slotIndex(slot))
+			if slot == enc.StateSlotMarker || index >= len(used) || used[index] {
+				continue
+			}
+			used[index] = true
+			if passesState && enc.isDynamicSlot(slot) {
+				cp.markUsedSlots(subplanCommands(cp.State[index]), used)
+			}
+		}
+
+		if enc.storesReturn(returnSlot) {
+			if index := int(enc.slotIndex(returnSlot)); index < len(used) {
+				used[index] = true
+			}
+		}
+	}
+}
+
+// PeakSlotUsage returns the largest number of state slots live at once
+// while the plan executes, for judging how close a plan is to the slot
+// limit at its worst moment. A slot is live at a top-level command if the
+// command reads or writes it, or if it holds a value some later command
+// reads before the slot is overwritten. Accesses by subplan commands count
+// at the command executing the subplan. The result never exceeds
+// len(State), which also includes slots only needed before or after the
+// peak.
+func (cp *CompiledPlan) PeakSlotUsage() int {
+	accesses := make([][]slotAccess, len(cp.State))
+	visited := make([]bool, len(cp.State))
+	for i, cmd := range cp.Commands {
+		cp.recordAccesses(i, [][]byte{cmd}, accesses, visited)
+	}
+
+	peak := 0
+	for i := range cp.Commands {
+		live := 0
+		for _, events := range accesses {
+			if slotLiveAt(events, i) {
+				live++
+			}
+		}
+		peak = max(peak, live)
+	}
+	return peak
+}
+
+// slotAccess is a read or write of a state slot by a top-level command.
+type slotAccess struct {
+	command int
+	write   bool
+}
+
+// recordAccesses appends the slot accesses of commands, descending into
+// subplans, to accesses as made by top-level command index. A subplan slot
+// is only descended into the first time it is seen.
+func (cp *CompiledPlan) recordAccesses(index int, commands [][]byte, accesses [][]slotAccess, visited []bool) {
+	enc := cp.encoding()
+	for _, cmd := range commands {
+		_, _, argSlots, returnSlot, _, err := DecodeCommandWithConfig(cmd, enc)
+		if err != nil {
+			continue
+		}
+
+		passesState := slices.Contains(argSlots, enc.StateSlotMarker)
+		for _, slot := range argSlots {
+			i := int(enc.slotIndex(slot))
+			if slot == enc.StateSlotMarker || i >= len(accesses) {
+				continue
+			}
+			accesses[i] = append(accesses[i], slotAccess{command: index})
+			if passesState && enc.isDynamicSlot(slot) && !visited[i] {
+				visited[i] = true
+				cp.recordAccesses(index, subplanCommands(cp.State[i]), accesses, visited)
+			}
+		}
+
+		if enc.storesReturn(returnSlot) {
+			if i := int(enc.slotIndex(returnSlot)); i < len(accesses) {
+				accesses[i] = append(accesses[i], slotAccess{command: index, write: true})
+			}
+		}
+	}
+}
+
+// slotLiveAt reports whether a slot with the given accesses, in command
+// order, is live at top-level command i.
+func slotLiveAt(events []slotAccess, i int) bool {
+	for _, e := range events {
+		switch {
+		case e.command == i:
+			return true
+		case e.command > i:
+			// Live if the value held entering the gap is read next
+			return !e.write
+		}
+	}
+	return false
+}
+
+// LiteralAt returns the literal data held at a state slot, for inspecting
+// which constant a command argument refers to. The dynamic flag on slot is
+// ignored. ok is false if the slot is out of range or is written by some
+// command's return value, since its contents are then determined at runtime.
+func (cp *CompiledPlan) LiteralAt(slot uint8) (data []byte, ok bool) {
+	enc := cp.encoding()
+	index := enc.slotIndex(slot)
+	if int(index) >= len(cp.State) {
+		return nil, false
+	}
+
+	for _, cmd := range cp.Commands {
+		_, _, _, returnSlot, _, err := DecodeCommandWithConfig(cmd, enc)
+		if err != nil || !enc.storesReturn(returnSlot) {
+			continue
+		}
+		if enc.slotIndex(returnSlot) == index {
+			return nil, false
+		}
+	}
+
+	return cp.State[index], true
+}
+
+// RequiredValue returns the total ETH sent by CALL_WITH_VALUE commands,
+// including those of subplans, i.e. the minimum msg.value the execute
+// transaction must carry. A subplan's amounts are counted each time a
+// command runs it.
+// exact is false if some amount is read from a slot written by a command's
+// return value; such amounts are unknown at plan time and are not included.
+func (cp *CompiledPlan) RequiredValue() (total *big.Int, exact bool) {
+	total = new(big.Int)
+	exact = cp.addRequiredValue(cp.Commands, total, make(map[uint8]bool), make(map[uint8]bool))
+	return total, exact
+}
+
+// addRequiredValue adds the amounts sent by commands to total, descending
+// into subplans as they run, and reports whether every amount was known.
+// returnSlots holds the slots written by return values so far; active holds
+// the subplan slots being walked, so a malformed plan cannot recurse forever.
+func (cp *CompiledPlan) addRequiredValue(commands [][]byte, total *big.Int, returnSlots, active map[uint8]bool) bool {
+	enc := cp.encoding()
+	exact := true
+	for _, cmd := range commands {
+		_, flags, argSlots, returnSlot, _, err := DecodeCommandWithConfig(cmd, enc)
+		if err != nil {
+			continue
+		}
+
+		valueSlot, args, hasValue := SplitValueSlot(flags, argSlots)
+		if hasValue {
+			slot := enc.slotIndex(valueSlot)
+			switch {
+			case returnSlots[slot]:
+				exact = false
+			case int(slot) < len(cp.State):
+				total.Add(total, new(big.Int).SetBytes(cp.State[slot]))
+			}
+		}
+
+		// A subplan runs during the command, before its result is written
+		if slices.Contains(args, enc.StateSlotMarker) {
+			for _, slot := range args {
+				index := enc.slotIndex(slot)
+				if slot == enc.StateSlotMarker || !enc.isDynamicSlot(slot) || int(index) >= len(cp.State) || active[index] {
+					continue
+				}
+				active[index] = true
+				if !cp.addRequiredValue(subplanCommands(cp.State[index]), total, returnSlots, active) {
+					exact = false
+				}
+				delete(active, index)
+			}
+		}
+
+		if enc.storesReturn(returnSlot) {
+			returnSlots[enc.slotIndex(returnSlot)] = true
+		}
+	}
+	return exact
+}
@@ -1,7 +1,12 @@
 package weiroll
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"math/big"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -191,6 +196,64 @@ func TestPlannerAdd(t *testing.T) {
 			t.Errorf("Expected 3 commands, got %d", p.Len())
 		}
 	})
+
+	t.Run("overrides return type", func(t *testing.T) {
+		parsed, err := abi.JSON(strings.NewReader(`[
+			{"type":"function","name":"lookup","inputs":[],"outputs":[{"name":"","type":"bytes"}]},
+			{"type":"function","name":"lookupWord","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+			{"type":"function","name":"ping","inputs":[{"name":"target","type":"address"}],"outputs":[]}
+		]`))
+		if err != nil {
+			t.Fatalf("Failed to parse ABI: %v", err)
+		}
+		registry := NewContract(addr, parsed)
+		addressType, _ := abi.NewType("address", "", nil)
+
+		p := New()
+		raw := p.Add(registry.MustInvoke("lookup"))
+		if _, err := registry.Invoke("ping", raw); err == nil {
+			t.Fatal("Expected bytes return value to be rejected for an address parameter")
+		}
+
+		target := p.Add(registry.MustInvoke("lookup"), WithReturnType(addressType))
+		if target.Type().String() != "address" {
+			t.Fatalf("Expected address type, got %s", target.Type().String())
+		}
+		if !target.IsDynamic() {
+			t.Error("Expected the slot layout to follow the declared bytes output")
+		}
+		if _, err := registry.Invoke("ping", target); err != nil {
+			t.Fatalf("Expected overridden return value to be accepted, got %v", err)
+		}
+
+		// A static output overridden to another static type plans with the
+		// slot read unchanged.
+		p = New()
+		word := p.Add(registry.MustInvoke("lookupWord"), WithReturnType(addressType))
+		p.Add(registry.MustInvoke("ping", word))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, returnSlot, _, _ := DecodeCommand(plan.Commands[0])
+		_, _, argSlots, _, _, _ := DecodeCommand(plan.Commands[1])
+		if argSlots[0] != returnSlot {
+			t.Errorf("Expected address argument to read the return slot, got 0x%02x want 0x%02x", argSlots[0], returnSlot)
+		}
+	})
+
+	t.Run("labels command", func(t *testing.T) {
+		p := New()
+		p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)), WithLabel("sum"))
+		p.Add(contract.MustInvoke("noReturn", big.NewInt(3)), WithLabel("log"))
+		p.Add(contract.MustInvoke("add", big.NewInt(4), big.NewInt(5)))
+
+		for i, want := range []string{"sum", "log", ""} {
+			if got := p.CommandAt(i).Label(); got != want {
+				t.Errorf("Command %d: expected label %q, got %q", i, want, got)
+			}
+		}
+	})
 }
 
 func TestPlannerChaining(t *testing.T) {
@@ -213,425 +276,2433 @@ func TestPlannerChaining(t *testing.T) {
 	})
 }
 
-func TestPlannerAddSubplan(t *testing.T) {
+func TestPlanStaticCallDynamicReturn(t *testing.T) {
+	plannerABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	other := NewContract(addr, testABI())
+
+	tests := []struct {
+		name string
+		call *Call
+	}{
+		{"Static modifier", NewContract(addr, plannerABI).MustInvoke("getString").Static()},
+		{"static contract", NewContract(addr, plannerABI, WithStaticCalls()).MustInvoke("getString")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			str := p.Add(tt.call)
+			p.Add(other.MustInvoke("dynamicArgs", str, []byte{}))
+
+			plan, err := p.Plan()
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			_, flags, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+			if err != nil {
+				t.Fatalf("DecodeCommand failed: %v", err)
+			}
+			if flags.CallType() != FlagStaticCall {
+				t.Errorf("Expected STATICCALL, got %d", flags.CallType())
+			}
+			if !SlotIndex(returnSlot).IsDynamic() {
+				t.Errorf("Expected dynamic return slot, got 0x%02x", returnSlot)
+			}
+
+			argSlots, err := plan.ArgSlotsFor(1)
+			if err != nil {
+				t.Fatalf("ArgSlotsFor failed: %v", err)
+			}
+			if argSlots[0] != returnSlot {
+				t.Errorf("Expected consumer to read 0x%02x, got 0x%02x", returnSlot, argSlots[0])
+			}
+		})
+	}
+}
+
+func TestPlannerAddCapture(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	contract := NewContract(addr, testABI)
+	lib := NewLibrary(addr, testABI)
 
-	t.Run("adds subplan command", func(t *testing.T) {
+	t.Run("forces a slot for an unused value", func(t *testing.T) {
 		p := New()
-		sub := New()
-		sub.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-
-		call := contract.MustInvoke("execute", sub.Subplan(), p.State())
-		rv, err := p.AddSubplan(call, sub)
+		p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), true)
 
+		plan, err := p.Plan()
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		if rv == nil {
-			t.Error("Expected return value")
+		_, _, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
 		}
-		if p.Len() != 1 {
-			t.Errorf("Expected 1 command, got %d", p.Len())
+		if returnSlot == NoReturnSlot {
+			t.Error("Expected forced capture to allocate a return slot")
 		}
 	})
 
-	t.Run("returns error for nil subplan", func(t *testing.T) {
+	t.Run("raw return of a static value gets a dynamic slot", func(t *testing.T) {
 		p := New()
-		call := contract.MustInvoke("execute", p.Subplan(), p.State())
+		p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)).RawReturn(), true)
 
-		_, err := p.AddSubplan(call, nil)
-
-		if err != ErrInvalidSubplan {
-			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if !SlotIndex(returnSlot).IsDynamic() {
+			t.Errorf("Expected dynamic return slot for raw bytes, got 0x%02x", returnSlot)
 		}
 	})
 
-	t.Run("returns error for invalid call", func(t *testing.T) {
+	t.Run("forced slot is never recycled", func(t *testing.T) {
 		p := New()
-		sub := New()
-		// Using 'add' which doesn't accept bytes32[]
-		call := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
-
-		_, err := p.AddSubplan(call, sub)
+		pinned := p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), true)
+		p.Add(lib.MustInvoke("noReturn", pinned))
+		next := p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		p.Add(lib.MustInvoke("noReturn", next))
 
-		if err != ErrInvalidSubplan {
-			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, first, _, _ := DecodeCommand(plan.Commands[0])
+		_, _, _, second, _, _ := DecodeCommand(plan.Commands[2])
+		if first == second {
+			t.Errorf("Expected pinned slot %d not to be reused", first)
 		}
 	})
 
-	t.Run("detects cyclic reference", func(t *testing.T) {
+	t.Run("suppresses an unused value", func(t *testing.T) {
 		p := New()
-		call := contract.MustInvoke("execute", p.Subplan(), p.State())
+		p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), false)
 
-		_, err := p.AddSubplan(call, p)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, returnSlot, _, _ := DecodeCommand(plan.Commands[0])
+		if returnSlot != NoReturnSlot {
+			t.Errorf("Expected NoReturnSlot, got %d", returnSlot)
+		}
+	})
 
-		if err != ErrCyclicPlanner {
-			t.Errorf("Expected ErrCyclicPlanner, got %v", err)
+	t.Run("suppressed value that is used fails", func(t *testing.T) {
+		p := New()
+		sum := p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), false)
+		p.Add(lib.MustInvoke("noReturn", sum))
+
+		_, err := p.Plan()
+		if !errors.Is(err, ErrReturnSuppressed) {
+			t.Errorf("Expected ErrReturnSuppressed, got %v", err)
 		}
 	})
 }
 
-func TestPlannerReplaceState(t *testing.T) {
-	testABI := plannerTestABI()
+func TestPlannerSlotValue(t *testing.T) {
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	contract := NewContract(addr, testABI)
+	lib := NewLibrary(addr, plannerTestABI())
 
-	t.Run("adds state replacement call", func(t *testing.T) {
+	t.Run("wires reserved slot into argument", func(t *testing.T) {
 		p := New()
-		call := contract.MustInvoke("updateState")
-
-		err := p.ReplaceState(call)
+		ctx, err := p.SlotValue(1, "uint256")
+		if err != nil {
+			t.Fatalf("SlotValue failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("noReturn", ctx))
 
+		plan, err := p.Plan(WithSlotRange(2, MaxStateSlots-1))
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		if p.Len() != 1 {
-			t.Errorf("Expected 1 command, got %d", p.Len())
+		argSlots, err := plan.ArgSlotsFor(0)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+		if len(argSlots) != 1 || argSlots[0] != 1 {
+			t.Errorf("Expected argument slot 1, got %v", argSlots)
 		}
 	})
 
-	t.Run("returns error for void function", func(t *testing.T) {
+	t.Run("dynamic slot sets flag", func(t *testing.T) {
+		other := NewLibrary(addr, testABI())
 		p := New()
-		call := contract.MustInvoke("noReturn", big.NewInt(1))
-
-		err := p.ReplaceState(call)
+		ctx, err := p.SlotValue(0, "string")
+		if err != nil {
+			t.Fatalf("SlotValue failed: %v", err)
+		}
+		p.Add(other.MustInvoke("dynamicArgs", ctx, []byte{}))
 
-		if err != ErrNoReturnValue {
-			t.Errorf("Expected ErrNoReturnValue, got %v", err)
+		plan, err := p.Plan(WithSlotRange(1, MaxStateSlots-1))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		argSlots, _ := plan.ArgSlotsFor(0)
+		if argSlots[0] != DynamicSlotFlag {
+			t.Errorf("Expected dynamic slot 0, got 0x%02x", argSlots[0])
 		}
 	})
 
-	t.Run("returns error for wrong return type", func(t *testing.T) {
+	t.Run("type mismatch is rejected", func(t *testing.T) {
 		p := New()
-		call := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+		ctx, _ := p.SlotValue(0, "address")
+		if _, err := lib.Invoke("noReturn", ctx); err == nil {
+			t.Error("Expected type mismatch")
+		}
+	})
 
-		err := p.ReplaceState(call)
+	t.Run("slot outside reserved range", func(t *testing.T) {
+		p := New()
+		ctx, _ := p.SlotValue(3, "uint256")
+		p.Add(lib.MustInvoke("noReturn", ctx))
 
-		if err == nil {
-			t.Error("Expected error for wrong return type")
+		if _, err := p.Plan(WithSlotRange(2, MaxStateSlots-1)); !errors.Is(err, ErrSlotNotReserved) {
+			t.Errorf("Expected ErrSlotNotReserved, got %v", err)
 		}
+		if _, err := p.Plan(); !errors.Is(err, ErrSlotNotReserved) {
+			t.Errorf("Expected ErrSlotNotReserved without a range, got %v", err)
+		}
+	})
 
-		typeMismatch, ok := err.(*TypeMismatchError)
-		if !ok {
-			t.Fatalf("Expected *TypeMismatchError, got %T", err)
+	t.Run("invalid arguments", func(t *testing.T) {
+		p := New()
+		if _, err := p.SlotValue(MaxStateSlots, "uint256"); !errors.Is(err, ErrSlotNotReserved) {
+			t.Errorf("Expected ErrSlotNotReserved, got %v", err)
 		}
-		if typeMismatch.Expected != "bytes[]" {
-			t.Errorf("Expected 'bytes[]', got %q", typeMismatch.Expected)
+		var encErr *EncodingError
+		if _, err := p.SlotValue(0, "notatype"); !errors.As(err, &encErr) {
+			t.Errorf("Expected EncodingError, got %v", err)
 		}
 	})
 }
 
-func TestPlannerState(t *testing.T) {
-	p := New()
-	sv := p.State()
+func TestPlannerFreeze(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
 
-	if sv == nil {
-		t.Fatal("Expected StateValue to be non-nil")
-	}
-	if sv.planner != p {
-		t.Error("StateValue should reference parent planner")
+	newFrozen := func() *Planner {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Freeze()
+		return p
 	}
-}
 
-func TestPlannerSubplan(t *testing.T) {
-	p := New()
-	spv := p.Subplan()
+	t.Run("Plan still works", func(t *testing.T) {
+		p := newFrozen()
+		if !p.IsFrozen() {
+			t.Fatal("Expected planner to be frozen")
+		}
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if plan.CommandCount() != 1 {
+			t.Errorf("Expected 1 command, got %d", plan.CommandCount())
+		}
+	})
 
-	if spv == nil {
-		t.Fatal("Expected SubplanValue to be non-nil")
-	}
-	if spv.subplanner != p {
-		t.Error("SubplanValue should reference parent planner")
-	}
+	t.Run("error-returning methods fail", func(t *testing.T) {
+		p := newFrozen()
+		if _, err := p.AddNamed("x", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2))); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("AddNamed: expected ErrPlannerFrozen, got %v", err)
+		}
+		if err := p.ReplaceState(lib.MustInvoke("updateState")); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("ReplaceState: expected ErrPlannerFrozen, got %v", err)
+		}
+		sub := New()
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("AddSubplan: expected ErrPlannerFrozen, got %v", err)
+		}
+		if p.Len() != 1 {
+			t.Errorf("Expected 1 command, got %d", p.Len())
+		}
+	})
+
+	t.Run("Add panics", func(t *testing.T) {
+		p := newFrozen()
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, ErrPlannerFrozen) {
+				t.Errorf("Expected panic with ErrPlannerFrozen, got %v", err)
+			}
+		}()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	})
 }
 
-func TestPlannerLen(t *testing.T) {
+func TestPlannerRepeat(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	contract := NewContract(addr, testABI)
+	lib := NewLibrary(addr, testABI)
 
-	p := New()
-	if p.Len() != 0 {
-		t.Errorf("Expected 0, got %d", p.Len())
-	}
+	t.Run("unrolls loop with per-iteration arguments", func(t *testing.T) {
+		p := New()
+		results := p.Repeat(3, func(i int) *Call {
+			return lib.MustInvoke("add", big.NewInt(int64(i)), big.NewInt(10))
+		})
 
-	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-	if p.Len() != 1 {
-		t.Errorf("Expected 1, got %d", p.Len())
-	}
+		if p.Len() != 3 || len(results) != 3 {
+			t.Fatalf("Expected 3 commands and results, got %d and %d", p.Len(), len(results))
+		}
+		for i := 0; i < 3; i++ {
+			lit, ok := p.CommandAt(i).Call().Args()[0].(*LiteralValue)
+			if !ok {
+				t.Fatalf("Iteration %d: expected literal argument", i)
+			}
+			if got := new(big.Int).SetBytes(lit.Data()); got.Int64() != int64(i) {
+				t.Errorf("Iteration %d: expected argument %d, got %s", i, i, got)
+			}
+			if results[i] == nil || results[i].Command() != p.CommandAt(i) {
+				t.Errorf("Iteration %d: expected return value of command %d", i, i)
+			}
+		}
+	})
 
-	p.Add(contract.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
-	if p.Len() != 2 {
-		t.Errorf("Expected 2, got %d", p.Len())
-	}
+	t.Run("nil results for calls without return", func(t *testing.T) {
+		p := New()
+		results := p.Repeat(2, func(i int) *Call {
+			return lib.MustInvoke("noReturn", big.NewInt(int64(i)))
+		})
+		if len(results) != 2 || results[0] != nil || results[1] != nil {
+			t.Errorf("Expected two nil results, got %v", results)
+		}
+	})
+
+	t.Run("zero iterations", func(t *testing.T) {
+		p := New()
+		if results := p.Repeat(0, nil); len(results) != 0 || p.Len() != 0 {
+			t.Error("Expected no commands")
+		}
+	})
 }
 
-func TestPlannerCommandAt(t *testing.T) {
+func TestPlannerAddHook(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	contract := NewContract(addr, testABI)
+	lib := NewLibrary(addr, testABI)
 
-	p := New()
-	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-	p.Add(contract.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
+	t.Run("observes each command in order", func(t *testing.T) {
+		var seen []*Command
+		var lens []int
+		var p *Planner
+		p = New(WithAddHook(func(cmd *Command) {
+			seen = append(seen, cmd)
+			lens = append(lens, p.Len())
+		}))
 
-	t.Run("returns command at valid index", func(t *testing.T) {
-		cmd := p.CommandAt(0)
-		if cmd == nil {
-			t.Fatal("Expected command to be non-nil")
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if _, err := p.AddNamed("x", lib.MustInvoke("multiply", big.NewInt(3), big.NewInt(4))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
 		}
-		if cmd.call.Method().Name != "add" {
-			t.Errorf("Expected 'add', got %q", cmd.call.Method().Name)
+		if err := p.ReplaceState(lib.MustInvoke("updateState")); err != nil {
+			t.Fatalf("ReplaceState failed: %v", err)
 		}
 
-		cmd = p.CommandAt(1)
-		if cmd == nil {
-			t.Fatal("Expected command to be non-nil")
+		if len(seen) != p.Len() {
+			t.Fatalf("Expected %d hook calls, got %d", p.Len(), len(seen))
 		}
-		if cmd.call.Method().Name != "multiply" {
-			t.Errorf("Expected 'multiply', got %q", cmd.call.Method().Name)
+		for i, cmd := range seen {
+			if cmd != p.CommandAt(i) {
+				t.Errorf("Hook call %d: expected command %d", i, i)
+			}
+			if lens[i] != i+1 {
+				t.Errorf("Hook call %d: expected command already appended, planner had %d", i, lens[i])
+			}
 		}
 	})
 
-	t.Run("returns nil for negative index", func(t *testing.T) {
-		cmd := p.CommandAt(-1)
-		if cmd != nil {
-			t.Error("Expected nil for negative index")
+	t.Run("multiple hooks run in registration order", func(t *testing.T) {
+		var order []string
+		p := New(
+			WithAddHook(func(*Command) { order = append(order, "first") }),
+			WithAddHook(func(*Command) { order = append(order, "second") }),
+		)
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		if strings.Join(order, ",") != "first,second" {
+			t.Errorf("Expected [first second], got %v", order)
 		}
 	})
 
-	t.Run("returns nil for out of bounds", func(t *testing.T) {
-		cmd := p.CommandAt(100)
-		if cmd != nil {
-			t.Error("Expected nil for out of bounds index")
+	t.Run("sees add options", func(t *testing.T) {
+		var labels []string
+		p := New(WithAddHook(func(cmd *Command) {
+			labels = append(labels, cmd.Label())
+		}))
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), WithLabel("swap"))
+
+		if len(labels) != 1 || labels[0] != "swap" {
+			t.Errorf("Expected hook to see label swap, got %q", labels)
 		}
 	})
+
+	t.Run("policy hook can reject by panicking", func(t *testing.T) {
+		p := New(WithAddHook(func(cmd *Command) {
+			if cmd.Call().Method().Name == "noReturn" {
+				panic("noReturn is not allowed")
+			}
+		}))
+
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected hook to panic")
+			}
+		}()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+	})
 }
 
-func TestPlannerForEachCommand(t *testing.T) {
+func TestPlannerSourceTracking(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	contract := NewContract(addr, testABI)
-
-	p := New()
-	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-	p.Add(contract.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
-	p.Add(contract.MustInvoke("add", big.NewInt(5), big.NewInt(6)))
+	lib := NewLibrary(addr, testABI)
 
-	t.Run("iterates all commands", func(t *testing.T) {
-		count := 0
-		p.ForEachCommand(func(i int, cmd *Command) bool {
-			count++
-			return true
-		})
+	t.Run("Add records caller file and line", func(t *testing.T) {
+		p := New(WithSourceTracking())
+		_, _, expectedLine, _ := runtime.Caller(0)
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
 
-		if count != 3 {
-			t.Errorf("Expected 3 iterations, got %d", count)
+		file, line := p.CommandAt(0).Source()
+		if !strings.HasSuffix(file, "planner_test.go") {
+			t.Errorf("Expected planner_test.go, got %q", file)
+		}
+		if line != expectedLine+1 {
+			t.Errorf("Expected line %d, got %d", expectedLine+1, line)
 		}
 	})
 
-	t.Run("stops on false return", func(t *testing.T) {
-		count := 0
+	t.Run("other add methods record their caller", func(t *testing.T) {
+		p := New(WithSourceTracking())
+		p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), true)
+		if _, err := p.AddNamed("x", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		if err := p.ReplaceState(lib.MustInvoke("updateState")); err != nil {
+			t.Fatalf("ReplaceState failed: %v", err)
+		}
+
+		for i := 0; i < p.Len(); i++ {
+			if file, _ := p.CommandAt(i).Source(); !strings.HasSuffix(file, "planner_test.go") {
+				t.Errorf("Command %d: expected planner_test.go, got %q", i, file)
+			}
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		if file, line := p.CommandAt(0).Source(); file != "" || line != 0 {
+			t.Errorf("Expected no source, got %s:%d", file, line)
+		}
+	})
+
+	t.Run("PlanError includes source", func(t *testing.T) {
+		p := New(WithSourceTracking())
+		sum := p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), false)
+		p.Add(lib.MustInvoke("noReturn", sum))
+
+		_, err := p.Plan()
+		var planErr *PlanError
+		if !errors.As(err, &planErr) {
+			t.Fatalf("Expected PlanError, got %v", err)
+		}
+		if !strings.Contains(planErr.Source, "planner_test.go:") {
+			t.Errorf("Expected source location, got %q", planErr.Source)
+		}
+		if !strings.Contains(err.Error(), planErr.Source) {
+			t.Errorf("Expected error message to include source, got %q", err.Error())
+		}
+	})
+}
+
+func TestPlannerAddNamed(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("builds a chained plan via names", func(t *testing.T) {
+		p := New()
+		if _, err := p.AddNamed("sum", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		sum, err := p.Ref("sum")
+		if err != nil {
+			t.Fatalf("Ref failed: %v", err)
+		}
+		if _, err := p.AddNamed("product", lib.MustInvoke("multiply", sum, big.NewInt(10))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		product, err := p.Ref("product")
+		if err != nil {
+			t.Fatalf("Ref failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("noReturn", product))
+
+		if product.Command() != p.CommandAt(1) {
+			t.Error("Expected product to refer to the second command")
+		}
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if plan.CommandCount() != 3 {
+			t.Errorf("Expected 3 commands, got %d", plan.CommandCount())
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		p := New()
+		if _, err := p.AddNamed("x", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		_, err := p.AddNamed("x", lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		if !errors.Is(err, ErrNameInUse) {
+			t.Errorf("Expected ErrNameInUse, got %v", err)
+		}
+		if p.Len() != 1 {
+			t.Errorf("Expected rejected call not to be added, got %d commands", p.Len())
+		}
+	})
+
+	t.Run("call without return value", func(t *testing.T) {
+		p := New()
+		_, err := p.AddNamed("x", lib.MustInvoke("noReturn", big.NewInt(1)))
+		if !errors.Is(err, ErrNoReturnValue) {
+			t.Errorf("Expected ErrNoReturnValue, got %v", err)
+		}
+		if p.Len() != 0 {
+			t.Errorf("Expected rejected call not to be added, got %d commands", p.Len())
+		}
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		_, err := New().Ref("missing")
+		if !errors.Is(err, ErrNameNotFound) {
+			t.Errorf("Expected ErrNameNotFound, got %v", err)
+		}
+	})
+}
+
+func TestPlannerAddSubplan(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	t.Run("adds subplan command", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		call := contract.MustInvoke("execute", sub.Subplan(), p.State())
+		rv, err := p.AddSubplan(call, sub)
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if rv == nil {
+			t.Error("Expected return value")
+		}
+		if p.Len() != 1 {
+			t.Errorf("Expected 1 command, got %d", p.Len())
+		}
+	})
+
+	t.Run("returns error for nil subplan", func(t *testing.T) {
+		p := New()
+		call := contract.MustInvoke("execute", p.Subplan(), p.State())
+
+		_, err := p.AddSubplan(call, nil)
+
+		if err != ErrInvalidSubplan {
+			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		}
+	})
+
+	t.Run("returns error for invalid call", func(t *testing.T) {
+		p := New()
+		sub := New()
+		// Using 'add' which doesn't accept bytes32[]
+		call := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+
+		_, err := p.AddSubplan(call, sub)
+
+		if err != ErrInvalidSubplan {
+			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		}
+	})
+
+	t.Run("detects cyclic reference", func(t *testing.T) {
+		p := New()
+		call := contract.MustInvoke("execute", p.Subplan(), p.State())
+
+		_, err := p.AddSubplan(call, p)
+
+		if err != ErrCyclicPlanner {
+			t.Errorf("Expected ErrCyclicPlanner, got %v", err)
+		}
+	})
+}
+
+func TestPlannerSubplanSharedState(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	// subplanCommands decodes the bytes32[] literal holding a subplan.
+	subplanCommands := func(t *testing.T, plan *CompiledPlan, slot uint8) [][]byte {
+		t.Helper()
+		if !SlotIndex(slot).IsDynamic() {
+			t.Fatalf("Expected dynamic subplan slot, got 0x%02x", slot)
+		}
+		data := plan.State[SlotIndex(slot).Index()]
+		n := new(big.Int).SetBytes(data[:32]).Int64()
+		cmds := make([][]byte, n)
+		for i := range cmds {
+			cmds[i] = data[32+32*i : 64+32*i]
+		}
+		return cmds
+	}
+
+	t.Run("subplan reads a value the parent placed in a slot", func(t *testing.T) {
+		p := New()
+		v := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		sub := New()
+		w := sub.Add(lib.MustInvoke("multiply", v, big.NewInt(5)))
+		sub.Add(lib.MustInvoke("noReturn", w))
+
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Fatalf("Expected 2 top-level commands, got %d", plan.CommandCount())
+		}
+
+		_, _, _, vSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		execSlots, err := plan.ArgSlotsFor(1)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+		if execSlots[1] != StateSlotMarker {
+			t.Errorf("Expected state argument to use the state marker, got 0x%02x", execSlots[1])
+		}
+
+		subCmds := subplanCommands(t, plan, execSlots[0])
+		if len(subCmds) != 2 {
+			t.Fatalf("Expected 2 subplan commands, got %d", len(subCmds))
+		}
+
+		_, _, argSlots, wSlot, _, err := DecodeCommand(subCmds[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if argSlots[0] != vSlot {
+			t.Errorf("Expected subplan to read parent slot %d, got %d", vSlot, argSlots[0])
+		}
+		if int(SlotIndex(argSlots[1]).Index()) >= len(plan.State) ||
+			new(big.Int).SetBytes(plan.State[argSlots[1]]).Int64() != 5 {
+			t.Error("Expected subplan literal to be stored in the parent's state")
+		}
+		if wSlot == vSlot || wSlot == NoReturnSlot {
+			t.Errorf("Expected subplan return in its own slot, got %d (parent value in %d)", wSlot, vSlot)
+		}
+
+		_, _, argSlots, _, _, err = DecodeCommand(subCmds[1])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if argSlots[0] != wSlot {
+			t.Errorf("Expected second subplan command to read slot %d, got %d", wSlot, argSlots[0])
+		}
+	})
+
+	t.Run("parent value stays live through the subplan", func(t *testing.T) {
+		p := New()
+		v := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(9)))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", v))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Expected parent value used only in subplan to remain visible, got %v", err)
+		}
+	})
+
+	t.Run("subplan referencing a later value fails", func(t *testing.T) {
+		p := New()
+		sub := New()
+		later := New().Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		sub.Add(lib.MustInvoke("noReturn", later))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		_, err := p.Plan()
+		if !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Errorf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+	})
+}
+
+func TestPlannerAddMutableLiteral(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("gets its own slot beside an identical literal", func(t *testing.T) {
+		p := New()
+		scratch := p.AddMutableLiteral(Uint256(big.NewInt(5)))
+		p.Add(lib.MustInvoke("add", big.NewInt(5), scratch))
+		p.Add(lib.MustInvoke("multiply", scratch, big.NewInt(5)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		first, _ := plan.ArgSlotsFor(0)
+		second, _ := plan.ArgSlotsFor(1)
+		if first[0] == first[1] {
+			t.Errorf("Expected mutable literal in its own slot, both in %d", first[0])
+		}
+		if second[0] != first[1] || second[1] != first[0] {
+			t.Errorf("Expected the same slots reused across commands, got %v and %v", first, second)
+		}
+		if first[1] != 0 {
+			t.Errorf("Expected mutable slot allocated first, got %d", first[1])
+		}
+	})
+
+	t.Run("allocated even when unused", func(t *testing.T) {
+		p := New()
+		p.AddMutableLiteral(Bytes([]byte("scratch")))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.State) != 1 {
+			t.Errorf("Expected 1 state slot, got %d", len(plan.State))
+		}
+	})
+
+	t.Run("commands reading distinct mutable literals are not deduplicated", func(t *testing.T) {
+		p := New()
+		a := p.AddMutableLiteral(Uint256(big.NewInt(1)))
+		b := p.AddMutableLiteral(Uint256(big.NewInt(1)))
+		p.Add(lib.MustInvoke("add", a, big.NewInt(2)))
+		p.Add(lib.MustInvoke("add", b, big.NewInt(2)))
+
+		plan, err := p.Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Errorf("Expected 2 commands, got %d", plan.CommandCount())
+		}
+	})
+
+	t.Run("panics on frozen planner", func(t *testing.T) {
+		p := New()
+		p.Freeze()
+		defer func() {
+			if r := recover(); r != ErrPlannerFrozen {
+				t.Errorf("Expected ErrPlannerFrozen panic, got %v", r)
+			}
+		}()
+		p.AddMutableLiteral(Uint256(big.NewInt(1)))
+	})
+}
+
+func TestPlannerOutput(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, plannerTestABI())
+
+	t.Run("parent consumes a value computed in the subplan", func(t *testing.T) {
+		p := New()
+		sub := New()
+		product := sub.Add(lib.MustInvoke("multiply", big.NewInt(6), big.NewInt(7)))
+		out, err := sub.Output(product)
+		if err != nil {
+			t.Fatalf("Output failed: %v", err)
+		}
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("add", out, big.NewInt(1)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, execSlots, execReturn, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if execReturn != StateSlotMarker {
+			t.Errorf("Expected subplan command to replace state, got return slot 0x%02x", execReturn)
+		}
+
+		data := plan.State[SlotIndex(execSlots[0]).Index()]
+		_, _, _, productSlot, _, err := DecodeCommand(data[32:64])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if productSlot == NoReturnSlot {
+			t.Fatal("Expected subplan output to be stored")
+		}
+
+		argSlots, err := plan.ArgSlotsFor(1)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+		if argSlots[0] != productSlot {
+			t.Errorf("Expected parent to read slot %d, got %d", productSlot, argSlots[0])
+		}
+	})
+
+	t.Run("parent cannot read unmarked subplan values", func(t *testing.T) {
+		p := New()
+		sub := New()
+		product := sub.Add(lib.MustInvoke("multiply", big.NewInt(6), big.NewInt(7)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("add", product, big.NewInt(1)))
+
+		if _, err := p.Plan(); !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Errorf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+	})
+
+	t.Run("subplan call must return state", func(t *testing.T) {
+		runner := NewContract(addr, MustParseABI(`[
+			{"type":"function","name":"run","inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],"outputs":[]}
+		]`))
+
+		p := New()
+		sub := New()
+		if _, err := sub.Output(sub.Add(lib.MustInvoke("multiply", big.NewInt(6), big.NewInt(7)))); err != nil {
+			t.Fatalf("Output failed: %v", err)
+		}
+		if _, err := p.AddSubplan(runner.MustInvoke("run", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if _, err := p.Plan(); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		}
+	})
+
+	t.Run("rejects values of other planners", func(t *testing.T) {
+		other := New().Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if _, err := New().Output(other); !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Errorf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+	})
+
+	t.Run("rejects frozen planner", func(t *testing.T) {
+		sub := New()
+		product := sub.Add(lib.MustInvoke("multiply", big.NewInt(6), big.NewInt(7)))
+		sub.Freeze()
+		if _, err := sub.Output(product); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("Expected ErrPlannerFrozen, got %v", err)
+		}
+	})
+}
+
+func TestPlannerReplaceState(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	t.Run("adds state replacement call", func(t *testing.T) {
+		p := New()
+		call := contract.MustInvoke("updateState")
+
+		err := p.ReplaceState(call)
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if p.Len() != 1 {
+			t.Errorf("Expected 1 command, got %d", p.Len())
+		}
+	})
+
+	t.Run("encodes the state marker as return slot", func(t *testing.T) {
+		p := New()
+		if err := p.ReplaceState(contract.MustInvoke("updateState")); err != nil {
+			t.Fatalf("ReplaceState failed: %v", err)
+		}
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if returnSlot != StateSlotMarker {
+			t.Errorf("Expected return slot 0x%02x, got 0x%02x", StateSlotMarker, returnSlot)
+		}
+	})
+
+	t.Run("returns error for void function", func(t *testing.T) {
+		p := New()
+		call := contract.MustInvoke("noReturn", big.NewInt(1))
+
+		err := p.ReplaceState(call)
+
+		if err != ErrNoReturnValue {
+			t.Errorf("Expected ErrNoReturnValue, got %v", err)
+		}
+	})
+
+	t.Run("returns error for wrong return type", func(t *testing.T) {
+		p := New()
+		call := contract.MustInvoke("add", big.NewInt(1), big.NewInt(2))
+
+		err := p.ReplaceState(call)
+
+		if err == nil {
+			t.Error("Expected error for wrong return type")
+		}
+
+		typeMismatch, ok := err.(*TypeMismatchError)
+		if !ok {
+			t.Fatalf("Expected *TypeMismatchError, got %T", err)
+		}
+		if typeMismatch.Expected != "bytes[]" {
+			t.Errorf("Expected 'bytes[]', got %q", typeMismatch.Expected)
+		}
+	})
+
+	shapes := NewContract(addr, MustParseABI(`[
+		{"type":"function","name":"named","inputs":[],"outputs":[{"name":"newState","type":"bytes[]"}]},
+		{"type":"function","name":"multi","inputs":[],"outputs":[{"name":"","type":"bytes[]"},{"name":"","type":"uint256"}]},
+		{"type":"function","name":"single","inputs":[],"outputs":[{"name":"","type":"bytes"}]}
+	]`))
+
+	t.Run("accepts named bytes[] output", func(t *testing.T) {
+		p := New()
+		if err := p.ReplaceState(shapes.MustInvoke("named")); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("rejects multiple outputs", func(t *testing.T) {
+		p := New()
+		err := p.ReplaceState(shapes.MustInvoke("multi"))
+
+		typeMismatch, ok := err.(*TypeMismatchError)
+		if !ok {
+			t.Fatalf("Expected *TypeMismatchError, got %T", err)
+		}
+		if typeMismatch.Got != "(bytes[],uint256)" {
+			t.Errorf("Expected '(bytes[],uint256)', got %q", typeMismatch.Got)
+		}
+		if p.Len() != 0 {
+			t.Errorf("Expected no commands, got %d", p.Len())
+		}
+	})
+
+	t.Run("rejects bytes output", func(t *testing.T) {
+		p := New()
+		err := p.ReplaceState(shapes.MustInvoke("single"))
+
+		typeMismatch, ok := err.(*TypeMismatchError)
+		if !ok {
+			t.Fatalf("Expected *TypeMismatchError, got %T", err)
+		}
+		if typeMismatch.Got != "bytes" {
+			t.Errorf("Expected 'bytes', got %q", typeMismatch.Got)
+		}
+	})
+}
+
+func TestPlannerState(t *testing.T) {
+	p := New()
+	sv := p.State()
+
+	if sv == nil {
+		t.Fatal("Expected StateValue to be non-nil")
+	}
+	if sv.planner != p {
+		t.Error("StateValue should reference parent planner")
+	}
+}
+
+func TestPlannerSubplan(t *testing.T) {
+	p := New()
+	spv := p.Subplan()
+
+	if spv == nil {
+		t.Fatal("Expected SubplanValue to be non-nil")
+	}
+	if spv.subplanner != p {
+		t.Error("SubplanValue should reference parent planner")
+	}
+}
+
+func TestPlannerLen(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	p := New()
+	if p.Len() != 0 {
+		t.Errorf("Expected 0, got %d", p.Len())
+	}
+
+	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	if p.Len() != 1 {
+		t.Errorf("Expected 1, got %d", p.Len())
+	}
+
+	p.Add(contract.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+	if p.Len() != 2 {
+		t.Errorf("Expected 2, got %d", p.Len())
+	}
+}
+
+func TestPlannerCommandAt(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	p := New()
+	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(contract.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
+
+	t.Run("returns command at valid index", func(t *testing.T) {
+		cmd := p.CommandAt(0)
+		if cmd == nil {
+			t.Fatal("Expected command to be non-nil")
+		}
+		if cmd.call.Method().Name != "add" {
+			t.Errorf("Expected 'add', got %q", cmd.call.Method().Name)
+		}
+
+		cmd = p.CommandAt(1)
+		if cmd == nil {
+			t.Fatal("Expected command to be non-nil")
+		}
+		if cmd.call.Method().Name != "multiply" {
+			t.Errorf("Expected 'multiply', got %q", cmd.call.Method().Name)
+		}
+	})
+
+	t.Run("returns nil for negative index", func(t *testing.T) {
+		cmd := p.CommandAt(-1)
+		if cmd != nil {
+			t.Error("Expected nil for negative index")
+		}
+	})
+
+	t.Run("returns nil for out of bounds", func(t *testing.T) {
+		cmd := p.CommandAt(100)
+		if cmd != nil {
+			t.Error("Expected nil for out of bounds index")
+		}
+	})
+}
+
+func TestPlannerForEachCommand(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, testABI)
+
+	p := New()
+	p.Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(contract.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
+	p.Add(contract.MustInvoke("add", big.NewInt(5), big.NewInt(6)))
+
+	t.Run("iterates all commands", func(t *testing.T) {
+		count := 0
+		p.ForEachCommand(func(i int, cmd *Command) bool {
+			count++
+			return true
+		})
+
+		if count != 3 {
+			t.Errorf("Expected 3 iterations, got %d", count)
+		}
+	})
+
+	t.Run("stops on false return", func(t *testing.T) {
+		count := 0
 		p.ForEachCommand(func(i int, cmd *Command) bool {
 			count++
 			return i < 1 // Stop after second (index 1)
 		})
 
-		if count != 2 {
-			t.Errorf("Expected 2 iterations (stopped early), got %d", count)
+		if count != 2 {
+			t.Errorf("Expected 2 iterations (stopped early), got %d", count)
+		}
+	})
+
+	t.Run("provides correct indices", func(t *testing.T) {
+		indices := make([]int, 0, 3)
+		p.ForEachCommand(func(i int, cmd *Command) bool {
+			indices = append(indices, i)
+			return true
+		})
+
+		expected := []int{0, 1, 2}
+		for i, idx := range indices {
+			if idx != expected[i] {
+				t.Errorf("Expected index %d at position %d, got %d", expected[i], i, idx)
+			}
+		}
+	})
+}
+
+func TestPlannerPlan(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("compiles simple plan", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(200)))
+
+		plan, err := p.Plan()
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan == nil {
+			t.Fatal("Expected plan to be non-nil")
+		}
+		if len(plan.Commands) != 1 {
+			t.Errorf("Expected 1 command, got %d", len(plan.Commands))
+		}
+	})
+
+	t.Run("compiles chained plan", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+
+		plan, err := p.Plan()
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(plan.Commands) != 2 {
+			t.Errorf("Expected 2 commands, got %d", len(plan.Commands))
+		}
+	})
+
+	t.Run("respects max commands option", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+
+		_, err := p.Plan(WithMaxCommands(1))
+
+		if err == nil {
+			t.Error("Expected error for exceeding max commands")
+		}
+	})
+
+	t.Run("deduplicates identical literals", func(t *testing.T) {
+		p := New()
+		// Same value (100) used twice
+		p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(100)))
+
+		plan, err := p.Plan()
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		// Should only have 1 state slot due to deduplication
+		if len(plan.State) != 1 {
+			t.Errorf("Expected 1 state slot (deduplicated), got %d", len(plan.State))
+		}
+	})
+
+	t.Run("handles void functions", func(t *testing.T) {
+		contract := NewContract(addr, testABI)
+		p := New()
+		p.Add(contract.MustInvoke("noReturn", big.NewInt(42)))
+
+		plan, err := p.Plan()
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(plan.Commands) != 1 {
+			t.Errorf("Expected 1 command, got %d", len(plan.Commands))
+		}
+	})
+}
+
+// countdownContext reports cancellation after Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestPlannerPlanContext(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	p := New()
+	for i := int64(0); i < 10; i++ {
+		p.Add(lib.MustInvoke("add", big.NewInt(i), big.NewInt(1)))
+	}
+
+	t.Run("compiles with a live context", func(t *testing.T) {
+		plan, err := p.PlanContext(context.Background())
+		if err != nil {
+			t.Fatalf("PlanContext failed: %v", err)
+		}
+		want, _ := p.Plan()
+		if !equalByteSlices(plan.Commands, want.Commands) {
+			t.Error("Expected the same output as Plan")
+		}
+	})
+
+	t.Run("aborts partway when canceled", func(t *testing.T) {
+		ctx := &countdownContext{Context: context.Background(), n: 4}
+		plan, err := p.PlanContext(ctx)
+		if !errors.Is(err, context.Canceled) || plan != nil {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		if ctx.n != 0 {
+			t.Errorf("Expected compilation to stop after 4 commands, %d checks left", ctx.n)
+		}
+	})
+
+	t.Run("aborts after the deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+		if _, err := p.PlanContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
+
+func TestPlannerPlanWithSlotOptimization(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("optimization enabled recycles slots", func(t *testing.T) {
+		p := New()
+
+		// First return value only used in second command
+		first := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		second := p.Add(lib.MustInvoke("multiply", first, big.NewInt(10)))
+
+		// Second value used in third command
+		p.Add(lib.MustInvoke("multiply", second, big.NewInt(5)))
+
+		plan, err := p.Plan(WithSlotOptimization(true))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan == nil {
+			t.Fatal("Expected plan to be non-nil")
+		}
+	})
+
+	t.Run("optimization disabled uses more slots", func(t *testing.T) {
+		p := New()
+
+		first := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", first, big.NewInt(10)))
+
+		planOptimized, err := p.Plan(WithSlotOptimization(true))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		p2 := New()
+		first2 := p2.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p2.Add(lib.MustInvoke("multiply", first2, big.NewInt(10)))
+
+		planUnoptimized, err := p2.Plan(WithSlotOptimization(false))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Both should compile successfully
+		if planOptimized == nil || planUnoptimized == nil {
+			t.Fatal("Both plans should be non-nil")
+		}
+	})
+}
+
+func TestPlannerPlanWithCommandDeduplication(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("identical pure calls collapse to one command", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", a, b))
+
+		plan, err := p.Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Fatalf("Expected 2 commands, got %d", plan.CommandCount())
+		}
+
+		_, _, argSlots, _, _, err := DecodeCommand(plan.Commands[1])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if argSlots[0] != argSlots[1] {
+			t.Errorf("Expected both arguments to share slot, got %v", argSlots)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Errorf("Expected 2 commands, got %d", plan.CommandCount())
+		}
+	})
+
+	t.Run("side-effecting calls are kept", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+
+		plan, err := p.Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Errorf("Expected 2 commands, got %d", plan.CommandCount())
+		}
+	})
+
+	t.Run("view calls are not reused across side effects", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("getString"))
+		p.Add(lib.MustInvoke("getString"))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("getString"))
+
+		plan, err := p.Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan.CommandCount() != 3 {
+			t.Errorf("Expected 3 commands, got %d", plan.CommandCount())
+		}
+	})
+
+	t.Run("pure calls are reused across side effects", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		plan, err := p.Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if plan.CommandCount() != 2 {
+			t.Errorf("Expected 2 commands, got %d", plan.CommandCount())
+		}
+	})
+}
+
+func TestPlannerPlanValidatesCalls(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, plannerTestABI())
+	ext := NewContract(addr, plannerTestABI())
+
+	t.Run("library call with value", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(1)))
+
+		_, err := p.Plan()
+		if !errors.Is(err, ErrInvalidCallType) {
+			t.Fatalf("Expected ErrInvalidCallType, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 || planErr.Method != "add" {
+			t.Errorf("Expected PlanError for add at command 1, got %v", err)
+		}
+	})
+
+	t.Run("STATICCALL with value", func(t *testing.T) {
+		p := New()
+		p.Add(ext.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(1)).Static())
+
+		var planErr *PlanError
+		if _, err := p.Plan(); !errors.Is(err, ErrInvalidCallType) || !errors.As(err, &planErr) {
+			t.Errorf("Expected ErrInvalidCallType in a PlanError, got %v", err)
+		}
+	})
+
+	t.Run("inside subplan", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(1)).WithValue(big.NewInt(1)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if _, err := p.Plan(); !errors.Is(err, ErrInvalidCallType) {
+			t.Errorf("Expected ErrInvalidCallType, got %v", err)
+		}
+	})
+
+	t.Run("external call with value compiles", func(t *testing.T) {
+		p := New()
+		p.Add(ext.MustInvoke("add", big.NewInt(1), big.NewInt(2)).WithValue(big.NewInt(1)))
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestPlannerPlanReturnShapes(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, plannerTestABI())
+	dyn := NewLibrary(addr, testABI())
+
+	t.Run("bytes return consumed as uint256", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(0)))
+		out := p.Add(dyn.MustInvoke("dynamicArgs", "hello", []byte{1}))
+		call := lib.MustInvoke("multiply", big.NewInt(1), big.NewInt(2))
+		call.args[0] = out
+		p.Add(call)
+
+		_, err := p.Plan()
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("Expected TypeMismatchError, got %v", err)
+		}
+		if mismatch.Expected != "uint256" || mismatch.Got != "bytes" {
+			t.Errorf("Expected uint256/bytes mismatch, got %s/%s", mismatch.Expected, mismatch.Got)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 2 {
+			t.Errorf("Expected PlanError for consumer command 2, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "command 1") {
+			t.Errorf("Expected error to name producer command 1, got %q", err.Error())
+		}
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) || argErr.Index != 0 || argErr.Method != "multiply" {
+			t.Errorf("Expected ArgumentError for argument 0 of multiply, got %v", err)
+		}
+	})
+
+	t.Run("uint256 return consumed as bytes", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		call := dyn.MustInvoke("dynamicArgs", "hello", []byte{1})
+		call.args[1] = sum
+		p.Add(call)
+
+		_, err := p.Plan()
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("Expected TypeMismatchError, got %v", err)
+		}
+		if mismatch.Expected != "bytes" || mismatch.Got != "uint256" {
+			t.Errorf("Expected bytes/uint256 mismatch, got %s/%s", mismatch.Expected, mismatch.Got)
+		}
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) || argErr.Index != 1 {
+			t.Errorf("Expected ArgumentError for argument 1, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 {
+			t.Errorf("Expected PlanError for command 1, got %v", err)
+		}
+	})
+
+	t.Run("mismatch inside subplan", func(t *testing.T) {
+		p := New()
+		sub := New()
+		out := sub.Add(dyn.MustInvoke("dynamicArgs", "hello", []byte{1}))
+		call := lib.MustInvoke("multiply", big.NewInt(1), big.NewInt(2))
+		call.args[1] = out
+		sub.Add(call)
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		_, err := p.Plan()
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("Expected TypeMismatchError, got %v", err)
+		}
+		if mismatch.Expected != "uint256" || mismatch.Got != "bytes" {
+			t.Errorf("Expected uint256/bytes mismatch, got %s/%s", mismatch.Expected, mismatch.Got)
+		}
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) || argErr.Index != 1 {
+			t.Errorf("Expected ArgumentError for argument 1, got %v", err)
+		}
+		if !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+			t.Errorf("Expected PlanError for command 0, got %v", err)
+		}
+	})
+
+	t.Run("raw return consumed as uint256", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)).RawReturn())
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+
+		_, err := p.Plan()
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("Expected TypeMismatchError, got %v", err)
+		}
+	})
+
+	t.Run("matching shapes compile", func(t *testing.T) {
+		p := New()
+		out := p.Add(dyn.MustInvoke("dynamicArgs", "hello", []byte{1}))
+		p.Add(dyn.MustInvoke("dynamicArgs", "world", out))
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestCompiledPlan(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	p := New()
+	p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(200)))
+	p.Add(lib.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
+
+	plan, _ := p.Plan()
+
+	t.Run("CommandsAsBytes32 returns correct format", func(t *testing.T) {
+		commands := plan.CommandsAsBytes32()
+
+		if len(commands) != 2 {
+			t.Errorf("Expected 2 commands, got %d", len(commands))
+		}
+
+		for i, cmd := range commands {
+			// Each should be exactly 32 bytes
+			if len(cmd) != 32 {
+				t.Errorf("Command %d should be 32 bytes, got %d", i, len(cmd))
+			}
+		}
+	})
+
+	t.Run("StateAsBytes returns state", func(t *testing.T) {
+		state := plan.StateAsBytes()
+
+		if state == nil {
+			t.Fatal("Expected state to be non-nil")
+		}
+	})
+
+	t.Run("CommandCount returns logical count", func(t *testing.T) {
+		count := plan.CommandCount()
+
+		if count != 2 {
+			t.Errorf("Expected 2 commands, got %d", count)
+		}
+	})
+}
+
+func TestCompiledPlanConfig(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	p := New()
+	p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+	t.Run("records defaults", func(t *testing.T) {
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		cfg := plan.Config()
+		if !cfg.SlotOptimization || cfg.MaxCommands != 256 || cfg.MaxStateSlots != MaxStateSlots {
+			t.Errorf("Unexpected default snapshot %+v", cfg)
+		}
+		if cfg.Encoding != DefaultEncodingConfig() {
+			t.Errorf("Expected default encoding, got %+v", cfg.Encoding)
+		}
+	})
+
+	t.Run("reflects applied options", func(t *testing.T) {
+		plan, err := p.Plan(WithSlotOptimization(false), WithMaxStateSlots(64), WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		cfg := plan.Config()
+		if cfg.SlotOptimization {
+			t.Error("Expected slot optimization off")
+		}
+		if cfg.MaxStateSlots != 64 || !cfg.CommandDeduplication {
+			t.Errorf("Unexpected snapshot %+v", cfg)
+		}
+	})
+
+	t.Run("zero for decoded plans", func(t *testing.T) {
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		decoded, err := DecodePlan(plan.CommandsAsBytes32(), plan.State)
+		if err != nil {
+			t.Fatalf("DecodePlan failed: %v", err)
+		}
+		if decoded.Config() != (PlanConfigSnapshot{}) {
+			t.Errorf("Expected zero snapshot, got %+v", decoded.Config())
+		}
+	})
+}
+
+func TestCompiledPlanID(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	build := func(t *testing.T, b int64) *CompiledPlan {
+		t.Helper()
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(b)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		return plan
+	}
+
+	t.Run("identical plans share an ID", func(t *testing.T) {
+		if build(t, 2).ID() != build(t, 2).ID() {
+			t.Error("Expected identical plans to share an ID")
+		}
+	})
+
+	t.Run("different literal changes the ID", func(t *testing.T) {
+		if build(t, 2).ID() == build(t, 3).ID() {
+			t.Error("Expected different plans to have different IDs")
+		}
+	})
+
+	t.Run("command boundaries are part of the ID", func(t *testing.T) {
+		word := make([]byte, 32)
+		extended := &CompiledPlan{Commands: [][]byte{append(append([]byte{}, word...), word...)}}
+		standard := &CompiledPlan{Commands: [][]byte{word, word}}
+		if extended.ID() == standard.ID() {
+			t.Error("Expected one 64-byte command to differ from two 32-byte commands")
+		}
+	})
+
+	t.Run("salt changes the ID but not the encoding", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		first, err := p.PlanWithSalt([32]byte{1})
+		if err != nil {
+			t.Fatalf("PlanWithSalt failed: %v", err)
+		}
+		second, err := p.PlanWithSalt([32]byte{2})
+		if err != nil {
+			t.Fatalf("PlanWithSalt failed: %v", err)
+		}
+		unsalted, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		if first.ID() == second.ID() || first.ID() == unsalted.ID() {
+			t.Error("Expected salted plans to have distinct IDs")
+		}
+		again, _ := p.PlanWithSalt([32]byte{1})
+		if again.ID() != first.ID() {
+			t.Error("Expected the same salt to give the same ID")
+		}
+		if !equalByteSlices(first.Commands, second.Commands) || !equalByteSlices(first.State, unsalted.State) {
+			t.Error("Expected salt to leave commands and state unchanged")
+		}
+	})
+}
+
+func TestPlanDeterministic(t *testing.T) {
+	contractABI := MustParseABI(vectorABI)
+	lib := NewLibrary(vectorLibrary, contractABI)
+	ext := NewContract(vectorExternal, contractABI)
+
+	// build returns a fresh planner each time, so pointer-keyed maps in the
+	// state manager hash differently on every compilation.
+	build := func(t *testing.T) *Planner {
+		t.Helper()
+		p := New()
+		var sums []*ReturnValue
+		for i := int64(0); i < 8; i++ {
+			sums = append(sums, p.Add(lib.MustInvoke("add", big.NewInt(i), big.NewInt(i%3))))
+		}
+		// Several return values expire after the same command
+		total := p.Add(lib.MustInvoke("sum8", sums[0], sums[1], sums[2], sums[3], sums[4], sums[5], sums[6], sums[7]))
+		greeting := p.Add(lib.MustInvoke("concat", "hello, ", "world"))
+		p.Add(ext.MustInvoke("concat", greeting, "!").Static())
+		p.Add(lib.MustInvoke("add", total, big.NewInt(1)))
+		p.Add(lib.MustInvoke("add", total, big.NewInt(1))) // Deduplicated
+		p.Add(ext.MustInvoke("deposit").WithValue(big.NewInt(1e18)))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("add", total, big.NewInt(2)))
+		sub.Add(lib.MustInvoke("concat", "sub", "plan"))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		return p
+	}
+
+	want, err := build(t).Plan(WithCommandDeduplication())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		got, err := build(t).Plan(WithCommandDeduplication())
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if !equalByteSlices(got.Commands, want.Commands) || !equalByteSlices(got.State, want.State) {
+			t.Fatalf("Compilation %d differs from the first", i)
+		}
+	}
+}
+
+func TestCompiledPlanArgSlotsFor(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("chained command uses producer's return slot", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		slots, err := plan.ArgSlotsFor(1)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+		if len(slots) != 2 {
+			t.Fatalf("Expected 2 slots, got %v", slots)
+		}
+		if slots[0] != returnSlot {
+			t.Errorf("Expected first argument in return slot %d, got %d", returnSlot, slots[0])
+		}
+	})
+
+	t.Run("handles extended commands", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		argSlots := []uint8{0, 1, 2, 3, 4, 5, 6, 7}
+		plan := &CompiledPlan{
+			Commands: [][]byte{encoder.EncodeExtended([4]byte{}, FlagCall, argSlots, NoReturnSlot, addr)},
+		}
+
+		slots, err := plan.ArgSlotsFor(0)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+		if len(slots) != len(argSlots) {
+			t.Fatalf("Expected %d slots, got %v", len(argSlots), slots)
+		}
+		for i := range argSlots {
+			if slots[i] != argSlots[i] {
+				t.Errorf("Slot %d: expected %d, got %d", i, argSlots[i], slots[i])
+			}
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		plan := &CompiledPlan{}
+		for _, i := range []int{-1, 0} {
+			if _, err := plan.ArgSlotsFor(i); !errors.Is(err, ErrCommandIndexOutOfRange) {
+				t.Errorf("Index %d: expected ErrCommandIndexOutOfRange, got %v", i, err)
+			}
+		}
+	})
+}
+
+func TestPlannerPreviewArgSlots(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("reports the slot of an existing return value", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		before, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		call := lib.MustInvoke("multiply", sum, big.NewInt(3))
+		slots, err := p.PreviewArgSlots(call)
+		if err != nil {
+			t.Fatalf("PreviewArgSlots failed: %v", err)
+		}
+		// The planner is unchanged
+		if p.Len() != 1 {
+			t.Errorf("Expected 1 command, got %d", p.Len())
+		}
+		after, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if !equalByteSlices(after.Commands, before.Commands) || !equalByteSlices(after.State, before.State) {
+			t.Error("Expected preview to leave the plan unchanged")
+		}
+
+		// Adding the call for real gives the previewed slots
+		p.Add(call)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		added, _ := plan.ArgSlotsFor(1)
+		if !bytes.Equal(added, slots) {
+			t.Errorf("Expected previewed slots %v, got %v", slots, added)
+		}
+		_, _, _, returnSlot, _, _ := DecodeCommand(plan.Commands[0])
+		if len(slots) != 2 || slots[0] != returnSlot {
+			t.Errorf("Expected first argument in return slot %d, got %v", returnSlot, slots)
 		}
 	})
 
-	t.Run("provides correct indices", func(t *testing.T) {
-		indices := make([]int, 0, 3)
-		p.ForEachCommand(func(i int, cmd *Command) bool {
-			indices = append(indices, i)
-			return true
-		})
+	t.Run("leaves commands untouched", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		sub := New()
+		sub.Add(lib.MustInvoke("multiply", big.NewInt(4), big.NewInt(5)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
 
-		expected := []int{0, 1, 2}
-		for i, idx := range indices {
-			if idx != expected[i] {
-				t.Errorf("Expected index %d at position %d, got %d", expected[i], i, idx)
+		before := make([]Command, 0, 3)
+		for _, cmd := range append(slices.Clone(p.commands), sub.commands...) {
+			before = append(before, *cmd)
+		}
+		if _, err := p.PreviewArgSlots(lib.MustInvoke("multiply", sum, big.NewInt(3))); err != nil {
+			t.Fatalf("PreviewArgSlots failed: %v", err)
+		}
+		for i, cmd := range append(slices.Clone(p.commands), sub.commands...) {
+			if cmd.call != before[i].call || cmd.returnSlot != before[i].returnSlot {
+				t.Errorf("Command %d changed: return slot %d, was %d", i, cmd.returnSlot, before[i].returnSlot)
 			}
 		}
 	})
+
+	t.Run("reports compile errors", func(t *testing.T) {
+		p := New()
+		other := New()
+		foreign := other.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		_, err := p.PreviewArgSlots(lib.MustInvoke("multiply", foreign, big.NewInt(3)))
+		if !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Errorf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+	})
 }
 
-func TestPlannerPlan(t *testing.T) {
+func TestPlanMaxCalldataBytes(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
 	lib := NewLibrary(addr, testABI)
 
-	t.Run("compiles simple plan", func(t *testing.T) {
+	newPlanner := func() *Planner {
 		p := New()
-		p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(200)))
+		p.Add(lib.MustInvoke("getString"))
+		p.Add(lib.MustInvoke("execute", [][32]byte{{1}}, [][]byte{make([]byte, 40)}))
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+		return p
+	}
 
-		plan, err := p.Plan()
+	t.Run("size matches packed calldata", func(t *testing.T) {
+		plan, err := newPlanner().Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
 
+		bytes32Array, _ := abi.NewType("bytes32[]", "", nil)
+		bytesArray, _ := abi.NewType("bytes[]", "", nil)
+		args := abi.Arguments{{Type: bytes32Array}, {Type: bytesArray}}
+		packed, err := args.Pack(plan.CommandsAsBytes32(), plan.StateAsBytes())
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Pack failed: %v", err)
 		}
-		if plan == nil {
-			t.Fatal("Expected plan to be non-nil")
+
+		if size := plan.calldataSize(); size != 4+len(packed) {
+			t.Errorf("Expected %d bytes, got %d", 4+len(packed), size)
 		}
-		if len(plan.Commands) != 1 {
-			t.Errorf("Expected 1 command, got %d", len(plan.Commands))
+	})
+
+	t.Run("rejects plan over budget", func(t *testing.T) {
+		_, err := newPlanner().Plan(WithMaxCalldataBytes(256))
+		if !errors.Is(err, ErrCalldataTooLarge) {
+			t.Errorf("Expected ErrCalldataTooLarge, got %v", err)
 		}
 	})
 
-	t.Run("compiles chained plan", func(t *testing.T) {
-		p := New()
-		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+	t.Run("accepts plan within budget", func(t *testing.T) {
+		plan, err := newPlanner().Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
 
-		plan, err := p.Plan()
+		if _, err := newPlanner().Plan(WithMaxCalldataBytes(plan.calldataSize())); err != nil {
+			t.Errorf("Expected plan at exactly the budget to compile, got %v", err)
+		}
+	})
+}
 
+func TestPlanMaxStateEntryBytes(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), testABI())
+
+	p := New()
+	p.Add(lib.MustInvoke("dynamicArgs", "short", make([]byte, 200)))
+
+	t.Run("accepts oversized entry without a cap", func(t *testing.T) {
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("rejects oversized entry under the cap", func(t *testing.T) {
+		_, err := p.Plan(WithMaxStateEntryBytes(128))
+		if !errors.Is(err, ErrStateEntryTooLarge) {
+			t.Fatalf("Expected ErrStateEntryTooLarge, got %v", err)
+		}
+
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 0 || planErr.Method != "dynamicArgs" {
+			t.Errorf("Expected PlanError for command 0, got %v", err)
+		}
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) || argErr.Index != 1 {
+			t.Errorf("Expected ArgumentError for argument 1, got %v", err)
+		}
+	})
+
+	t.Run("accepts entry within the cap", func(t *testing.T) {
+		if _, err := p.Plan(WithMaxStateEntryBytes(256)); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestPlanTerminatorCommand(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	terminator := common.HexToAddress("0x00000000000000000000000000000000000000ee")
+	selector := [4]byte{0xde, 0xad, 0xbe, 0xef}
+
+	p := New()
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+
+	t.Run("appended as the last command", func(t *testing.T) {
+		plain, err := p.Plan()
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		if len(plan.Commands) != 2 {
-			t.Errorf("Expected 2 commands, got %d", len(plan.Commands))
+		plan, err := p.Plan(WithTerminatorCommand(selector, terminator))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.Commands) != 3 {
+			t.Fatalf("Expected 3 commands, got %d", len(plan.Commands))
+		}
+		if !equalByteSlices(plan.Commands[:2], plain.Commands) || !equalByteSlices(plan.State, plain.State) {
+			t.Error("Expected the planned commands and state to be unchanged")
+		}
+
+		sel, flags, argSlots, returnSlot, addr, err := DecodeCommand(plan.Commands[2])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if sel != selector || addr != terminator || flags != FlagStaticCall {
+			t.Errorf("Unexpected terminator %x", plan.Commands[2])
+		}
+		if len(argSlots) != 0 || returnSlot != NoReturnSlot {
+			t.Errorf("Expected no arguments or return slot, got %v -> 0x%02x", argSlots, returnSlot)
+		}
+		if cfg := plan.Config(); !bytes.Equal(cfg.Terminator[:], plan.Commands[2]) {
+			t.Error("Expected Config to record the terminator")
 		}
 	})
 
-	t.Run("respects max commands option", func(t *testing.T) {
+	t.Run("absent by default", func(t *testing.T) {
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.Commands) != 2 || plan.Config().Terminator != [CommandSize]byte{} {
+			t.Error("Expected no terminator")
+		}
+	})
+}
+
+func TestPlanAllowFailure(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	ext := NewContract(addr, testABI)
+
+	newPlanner := func() *Planner {
 		p := New()
-		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-		p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		p.Add(ext.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(ext.MustInvoke("noReturn", big.NewInt(2)).AllowFailure())
+		return p
+	}
 
-		_, err := p.Plan(WithMaxCommands(1))
+	t.Run("rejected by default encoding", func(t *testing.T) {
+		_, err := newPlanner().Plan()
+		if !errors.Is(err, ErrAllowFailureUnsupported) {
+			t.Fatalf("Expected ErrAllowFailureUnsupported, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 {
+			t.Errorf("Expected PlanError for command 1, got %v", err)
+		}
+	})
 
-		if err == nil {
-			t.Error("Expected error for exceeding max commands")
+	t.Run("flag is encoded and decodable", func(t *testing.T) {
+		cfg := DefaultEncodingConfig()
+		cfg.AllowFailureFlag = 0x20
+
+		plan, err := newPlanner().Plan(WithEncodingConstants(cfg))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		for i, expected := range []bool{false, true} {
+			_, flags, _, _, _, err := DecodeCommandWithConfig(plan.Commands[i], cfg)
+			if err != nil {
+				t.Fatalf("DecodeCommand failed: %v", err)
+			}
+			if got := flags&cfg.AllowFailureFlag != 0; got != expected {
+				t.Errorf("Command %d: expected allow-failure %v, got %v", i, expected, got)
+			}
+			if flags.CallType() != FlagCall {
+				t.Errorf("Command %d: expected CALL, got %d", i, flags.CallType())
+			}
+		}
+
+		indices := plan.AllowFailureCommands(cfg)
+		if len(indices) != 1 || indices[0] != 1 {
+			t.Errorf("Expected [1], got %v", indices)
+		}
+		if plan.AllowFailureCommands(DefaultEncodingConfig()) != nil {
+			t.Error("Expected no allow-failure commands without a flag")
 		}
 	})
+}
 
-	t.Run("deduplicates identical literals", func(t *testing.T) {
+func TestCompiledPlanDeadSlots(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	newPlanner := func() *Planner {
 		p := New()
-		// Same value (100) used twice
-		p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(100)))
+		acc := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		for i := 0; i < 5; i++ {
+			acc = p.Add(lib.MustInvoke("multiply", acc, big.NewInt(int64(i+3))))
+		}
+		p.Add(lib.MustInvoke("noReturn", acc))
+		return p
+	}
 
-		plan, err := p.Plan()
+	t.Run("optimized plan has none", func(t *testing.T) {
+		plan, err := newPlanner().Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if dead := plan.DeadSlots(); len(dead) != 0 {
+			t.Errorf("Expected no dead slots, got %v", dead)
+		}
+	})
 
+	t.Run("unoptimized plan has none but uses more slots", func(t *testing.T) {
+		optimized, _ := newPlanner().Plan()
+		plan, err := newPlanner().Plan(WithSlotOptimization(false))
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		// Should only have 1 state slot due to deduplication
-		if len(plan.State) != 1 {
-			t.Errorf("Expected 1 state slot (deduplicated), got %d", len(plan.State))
+		if dead := plan.DeadSlots(); len(dead) != 0 {
+			t.Errorf("Expected no dead slots, got %v", dead)
+		}
+		if len(plan.State) <= len(optimized.State) {
+			t.Errorf("Expected unoptimized state (%d) to exceed optimized (%d)", len(plan.State), len(optimized.State))
 		}
 	})
 
-	t.Run("handles void functions", func(t *testing.T) {
-		contract := NewContract(addr, testABI)
+	t.Run("detects padding and unreferenced slots", func(t *testing.T) {
+		plan, err := newPlanner().Plan(WithSlotRange(2, MaxStateSlots-1))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		plan.State = append(plan.State, make([]byte, 32))
+
+		dead := plan.DeadSlots()
+		expected := []uint8{0, 1, uint8(len(plan.State) - 1)}
+		if len(dead) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, dead)
+		}
+		for i := range expected {
+			if dead[i] != expected[i] {
+				t.Errorf("Expected %v, got %v", expected, dead)
+			}
+		}
+	})
+
+	t.Run("follows subplan commands", func(t *testing.T) {
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(12345)))
 		p := New()
-		p.Add(contract.MustInvoke("noReturn", big.NewInt(42)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
 
 		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if !stateContains(plan.State, big.NewInt(12345)) {
+			t.Fatal("Expected subplan literal in shared state")
+		}
+		if dead := plan.DeadSlots(); len(dead) != 0 {
+			t.Errorf("Expected no dead slots, got %v", dead)
+		}
+	})
+
+	t.Run("reads altered markers", func(t *testing.T) {
+		cfg := EncodingConfig{
+			DynamicSlotFlag: 0x40,
+			StateSlotMarker: 0x3E,
+			NoReturnSlot:    0x3D,
+			UnusedSlot:      0x3F,
+		}
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(12345)))
+		p := New()
+		p.Add(lib.MustInvoke("getString"))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
 
+		plan, err := p.Plan(WithEncodingConstants(cfg))
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		if len(plan.Commands) != 1 {
-			t.Errorf("Expected 1 command, got %d", len(plan.Commands))
+		if dead := plan.DeadSlots(); len(dead) != 0 {
+			t.Errorf("Expected no dead slots, got %v", dead)
 		}
 	})
 }
 
-func TestPlannerPlanWithSlotOptimization(t *testing.T) {
+func TestCompiledPlanPeakSlotUsage(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
 	lib := NewLibrary(addr, testABI)
 
-	t.Run("optimization enabled recycles slots", func(t *testing.T) {
+	// Each step reads the previous result and the same literal, so only
+	// three slots are ever live at once.
+	newPlanner := func() *Planner {
 		p := New()
+		acc := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(1)))
+		for i := 0; i < 5; i++ {
+			acc = p.Add(lib.MustInvoke("multiply", acc, big.NewInt(1)))
+		}
+		p.Add(lib.MustInvoke("noReturn", acc))
+		return p
+	}
 
-		// First return value only used in second command
-		first := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-		second := p.Add(lib.MustInvoke("multiply", first, big.NewInt(10)))
-
-		// Second value used in third command
-		p.Add(lib.MustInvoke("multiply", second, big.NewInt(5)))
-
-		plan, err := p.Plan(WithSlotOptimization(true))
+	t.Run("counts simultaneously live slots", func(t *testing.T) {
+		plan, err := newPlanner().Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if peak := plan.PeakSlotUsage(); peak != 3 {
+			t.Errorf("Expected peak of 3 slots, got %d", peak)
+		}
+		if peak := plan.PeakSlotUsage(); peak > len(plan.State) {
+			t.Errorf("Expected peak %d within state length %d", peak, len(plan.State))
+		}
+	})
 
+	t.Run("independent of slot recycling", func(t *testing.T) {
+		plan, err := newPlanner().Plan(WithSlotOptimization(false))
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
 		}
-		if plan == nil {
-			t.Fatal("Expected plan to be non-nil")
+		if peak := plan.PeakSlotUsage(); peak != 3 {
+			t.Errorf("Expected peak of 3 slots, got %d", peak)
+		}
+		if len(plan.State) != 7 {
+			t.Errorf("Expected 7 state slots, got %d", len(plan.State))
 		}
 	})
 
-	t.Run("optimization disabled uses more slots", func(t *testing.T) {
+	t.Run("below state length of recycled plan", func(t *testing.T) {
 		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		c := p.Add(lib.MustInvoke("multiply", a, b))
+		p.Add(lib.MustInvoke("noReturn", c))
 
-		first := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-		p.Add(lib.MustInvoke("multiply", first, big.NewInt(10)))
-
-		planOptimized, err := p.Plan(WithSlotOptimization(true))
+		plan, err := p.Plan()
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
+		}
+		// While a is computed, all four literals and a are live; c can't
+		// reuse the slots of a and b as the multiply still reads them.
+		if peak := plan.PeakSlotUsage(); peak != 5 {
+			t.Errorf("Expected peak of 5 slots, got %d", peak)
 		}
+		if len(plan.State) != 7 {
+			t.Errorf("Expected 7 state slots, got %d", len(plan.State))
+		}
+	})
 
-		p2 := New()
-		first2 := p2.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
-		p2.Add(lib.MustInvoke("multiply", first2, big.NewInt(10)))
+	t.Run("counts subplan slots", func(t *testing.T) {
+		sub := New()
+		sub.Add(lib.MustInvoke("add", big.NewInt(12345), big.NewInt(6789)))
+		p := New()
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
 
-		planUnoptimized, err := p2.Plan(WithSlotOptimization(false))
+		plan, err := p.Plan()
 		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if peak := plan.PeakSlotUsage(); peak != len(plan.State) {
+			t.Errorf("Expected all %d slots live, got %d", len(plan.State), peak)
 		}
+	})
 
-		// Both should compile successfully
-		if planOptimized == nil || planUnoptimized == nil {
-			t.Fatal("Both plans should be non-nil")
+	t.Run("empty plan", func(t *testing.T) {
+		plan, err := New().Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if peak := plan.PeakSlotUsage(); peak != 0 {
+			t.Errorf("Expected peak of 0, got %d", peak)
 		}
 	})
 }
 
-func TestCompiledPlan(t *testing.T) {
+func TestCompiledPlanLiteralAt(t *testing.T) {
 	testABI := plannerTestABI()
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
 	lib := NewLibrary(addr, testABI)
 
 	p := New()
-	p.Add(lib.MustInvoke("add", big.NewInt(100), big.NewInt(200)))
-	p.Add(lib.MustInvoke("multiply", big.NewInt(3), big.NewInt(4)))
+	sum := p.Add(lib.MustInvoke("add", Uint256(big.NewInt(100)), big.NewInt(2)))
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	t.Run("returns literal data", func(t *testing.T) {
+		slots, err := plan.ArgSlotsFor(0)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+
+		data, ok := plan.LiteralAt(slots[0])
+		if !ok {
+			t.Fatal("Expected a literal at the first argument slot")
+		}
+		expected := common.LeftPadBytes(big.NewInt(100).Bytes(), 32)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Expected %x, got %x", expected, data)
+		}
+	})
+
+	t.Run("return slots are runtime-determined", func(t *testing.T) {
+		_, _, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if _, ok := plan.LiteralAt(returnSlot); ok {
+			t.Error("Expected return slot not to report a literal")
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		if _, ok := plan.LiteralAt(uint8(len(plan.State))); ok {
+			t.Error("Expected out-of-range slot not to report a literal")
+		}
+	})
+}
+
+func TestCompiledPlanRequiredValue(t *testing.T) {
+	token := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), MustParseABI(testABIJSON))
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	t.Run("sums payable call values", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(1)).WithValue(big.NewInt(1000)))
+		p.Add(token.MustInvoke("getValue"))
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(2)).WithValue(big.NewInt(234)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		total, exact := plan.RequiredValue()
+		if total.Int64() != 1234 {
+			t.Errorf("Expected 1234, got %s", total)
+		}
+		if !exact {
+			t.Error("Expected literal values to be exact")
+		}
+	})
+
+	t.Run("zero without payable calls", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(1)))
 
-	plan, _ := p.Plan()
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
 
-	t.Run("CommandsAsBytes32 returns correct format", func(t *testing.T) {
-		commands := plan.CommandsAsBytes32()
+		total, exact := plan.RequiredValue()
+		if total.Sign() != 0 || !exact {
+			t.Errorf("Expected exact zero, got %s (exact=%v)", total, exact)
+		}
+	})
 
-		if len(commands) != 2 {
-			t.Errorf("Expected 2 commands, got %d", len(commands))
+	t.Run("flags values read from return slots", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		plan := &CompiledPlan{
+			Commands: [][]byte{
+				encoder.Encode([4]byte{}, FlagCall, nil, 0, recipient),
+				encoder.Encode([4]byte{}, FlagCallWithValue, []uint8{0, 1}, NoReturnSlot, recipient),
+			},
+			State: [][]byte{make([]byte, 32), make([]byte, 32)},
 		}
 
-		for i, cmd := range commands {
-			// Each should be exactly 32 bytes
-			if len(cmd) != 32 {
-				t.Errorf("Command %d should be 32 bytes, got %d", i, len(cmd))
-			}
+		if _, exact := plan.RequiredValue(); exact {
+			t.Error("Expected runtime value to be reported as inexact")
 		}
 	})
 
-	t.Run("StateAsBytes returns state", func(t *testing.T) {
-		state := plan.StateAsBytes()
+	t.Run("state replacement is not a return slot", func(t *testing.T) {
+		lib := NewLibrary(common.HexToAddress("0x1111111111111111111111111111111111111111"), plannerTestABI())
+		p := New()
+		if err := p.ReplaceState(lib.MustInvoke("updateState")); err != nil {
+			t.Fatalf("ReplaceState failed: %v", err)
+		}
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(1)).WithValue(big.NewInt(9)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if total, exact := plan.RequiredValue(); total.Int64() != 9 || !exact {
+			t.Errorf("Expected exact 9, got %s (exact=%v)", total, exact)
+		}
 
-		if state == nil {
-			t.Fatal("Expected state to be non-nil")
+		// The marker's low bits name slot 126, which must stay a literal
+		encoder := NewCommandEncoder()
+		state := make([][]byte, 127)
+		for i := range state {
+			state[i] = make([]byte, 32)
+		}
+		state[126][31] = 5
+		raw := &CompiledPlan{
+			Commands: [][]byte{
+				encoder.Encode([4]byte{}, FlagCall, nil, StateSlotMarker, recipient),
+				encoder.Encode([4]byte{}, FlagCallWithValue, []uint8{126}, NoReturnSlot, recipient),
+			},
+			State: state,
+		}
+		if total, exact := raw.RequiredValue(); total.Int64() != 5 || !exact {
+			t.Errorf("Expected exact 5, got %s (exact=%v)", total, exact)
 		}
 	})
 
-	t.Run("CommandCount returns logical count", func(t *testing.T) {
-		count := plan.CommandCount()
+	t.Run("includes payable subplan commands", func(t *testing.T) {
+		lib := NewLibrary(common.HexToAddress("0x1111111111111111111111111111111111111111"), plannerTestABI())
+		p := New()
+		sub := New()
+		sub.Add(token.MustInvoke("transfer", recipient, big.NewInt(1)).WithValue(big.NewInt(50)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(2)).WithValue(big.NewInt(7)))
 
-		if count != 2 {
-			t.Errorf("Expected 2 commands, got %d", count)
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if total, exact := plan.RequiredValue(); total.Int64() != 57 || !exact {
+			t.Errorf("Expected exact 57, got %s (exact=%v)", total, exact)
 		}
 	})
 }
@@ -674,6 +2745,64 @@ func TestValidateSubplan(t *testing.T) {
 			t.Errorf("Expected ErrInvalidSubplan, got %v", err)
 		}
 	})
+
+	callbackABI := MustParseABI(`[
+		{"type":"function","name":"execute","inputs":[
+			{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"},{"name":"caller","type":"address"}
+		],"outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"executeFor","inputs":[
+			{"name":"caller","type":"address"},{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}
+		],"outputs":[{"name":"","type":"bytes[]"}]},
+		{"type":"function","name":"executeTwice","inputs":[
+			{"name":"first","type":"bytes32[]"},{"name":"second","type":"bytes32[]"},{"name":"state","type":"bytes[]"}
+		],"outputs":[{"name":"","type":"bytes[]"}]}
+	]`)
+	callback := NewContract(addr, callbackABI)
+	caller := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	t.Run("accepts extra arguments around subplan and state", func(t *testing.T) {
+		p := New()
+		sub := New()
+		if err := validateSubplan(callback.MustInvoke("execute", sub.Subplan(), p.State(), caller), sub); err != nil {
+			t.Errorf("Expected trailing argument to be accepted, got %v", err)
+		}
+		if err := validateSubplan(callback.MustInvoke("executeFor", caller, sub.Subplan(), p.State()), sub); err != nil {
+			t.Errorf("Expected leading argument to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("rejects misplaced subplan and state", func(t *testing.T) {
+		p := New()
+		sub := New()
+		method := callbackABI.Methods["execute"]
+		callerArg := MustLiteralFromType("address", caller)
+
+		misplacedSubplan := &Call{contract: callback, method: method, args: []Value{callerArg, p.State(), sub.Subplan()}}
+		if err := validateSubplan(misplacedSubplan, sub); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan for subplan in an address parameter, got %v", err)
+		}
+
+		misplacedState := &Call{contract: callback, method: method, args: []Value{sub.Subplan(), callerArg, p.State()}}
+		if err := validateSubplan(misplacedState, sub); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan for state in an address parameter, got %v", err)
+		}
+	})
+
+	t.Run("rejects missing, repeated or foreign subplan", func(t *testing.T) {
+		p := New()
+		sub := New()
+		empty, _ := NewLiteralFromType("bytes32[]", [][32]byte{})
+
+		if err := validateSubplan(callback.MustInvoke("execute", empty, p.State(), caller), sub); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan without a subplan argument, got %v", err)
+		}
+		if err := validateSubplan(callback.MustInvoke("executeTwice", sub.Subplan(), sub.Subplan(), p.State()), sub); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan for a repeated subplan, got %v", err)
+		}
+		if err := validateSubplan(callback.MustInvoke("execute", New().Subplan(), p.State(), caller), sub); !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrInvalidSubplan for another planner's subplan, got %v", err)
+		}
+	})
 }
 
 func TestCheckCycle(t *testing.T) {
@@ -741,3 +2870,408 @@ func TestVisibilityAnalysis(t *testing.T) {
 		}
 	})
 }
+
+// BenchmarkPlanLargeLinear compiles a 200-command chain in which each
+// command consumes the previous command's return value.
+func BenchmarkPlanLargeLinear(b *testing.B) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	p := New()
+	acc := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	for i := 1; i < 200; i++ {
+		acc = p.Add(lib.MustInvoke("add", acc, big.NewInt(1)))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Plan(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPlannerCompileSubplanCommands(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("matches the embedded subplan", func(t *testing.T) {
+		sub := New()
+		w := sub.Add(lib.MustInvoke("multiply", big.NewInt(4), big.NewInt(5)))
+		sub.Add(lib.MustInvoke("noReturn", w))
+
+		words, err := sub.CompileSubplanCommands()
+		if err != nil {
+			t.Fatalf("CompileSubplanCommands failed: %v", err)
+		}
+
+		p := New()
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		slots, err := plan.ArgSlotsFor(0)
+		if err != nil {
+			t.Fatalf("ArgSlotsFor failed: %v", err)
+		}
+
+		lit, err := NewLiteralFromType("bytes32[]", words)
+		if err != nil {
+			t.Fatalf("NewLiteralFromType failed: %v", err)
+		}
+		if embedded := plan.State[SlotIndex(slots[0]).Index()]; !bytes.Equal(embedded, lit.Data()) {
+			t.Errorf("Expected embedded subplan %x, got %x", lit.Data(), embedded)
+		}
+	})
+
+	t.Run("reports compile errors", func(t *testing.T) {
+		p := New()
+		foreign := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", foreign))
+		if _, err := sub.CompileSubplanCommands(); !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Errorf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+	})
+}
+
+func TestPlannerValueOwners(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("rejects foreign state", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), New().State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		_, err := p.Plan()
+		var planErr *PlanError
+		if !errors.Is(err, ErrForeignValue) || !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+			t.Errorf("Expected ErrForeignValue for command 0, got %v", err)
+		}
+	})
+
+	t.Run("rejects subplan not added to the planner", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("execute", sub.Subplan(), p.State()))
+
+		if _, err := p.Plan(); !errors.Is(err, ErrForeignValue) {
+			t.Errorf("Expected ErrForeignValue, got %v", err)
+		}
+	})
+
+	t.Run("checks subplan commands", func(t *testing.T) {
+		p := New()
+		inner := New()
+		inner.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		sub := New()
+		if _, err := sub.AddSubplan(lib.MustInvoke("execute", inner.Subplan(), New().State()), inner); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		_, err := p.Plan()
+		if !errors.Is(err, ErrForeignValue) || !errors.Is(err, ErrInvalidSubplan) {
+			t.Errorf("Expected ErrForeignValue within ErrInvalidSubplan, got %v", err)
+		}
+	})
+
+	t.Run("accepts state of an enclosing planner", func(t *testing.T) {
+		p := New()
+		inner := New()
+		inner.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		sub := New()
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		if _, err := sub.AddSubplan(lib.MustInvoke("execute", inner.Subplan(), p.State()), inner); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Plan failed: %v", err)
+		}
+		if _, err := p.PreviewArgSlots(lib.MustInvoke("noReturn", big.NewInt(2))); err != nil {
+			t.Errorf("PreviewArgSlots failed: %v", err)
+		}
+		if _, err := p.Split(1, 127); err != nil {
+			t.Errorf("Split failed: %v", err)
+		}
+	})
+}
+
+func TestPlannerClone(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("clone is independent of the original", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+
+		clone := p.Clone()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(4)))
+
+		if clone.Len() != 2 || p.Len() != 3 {
+			t.Fatalf("Expected 2 cloned and 3 original commands, got %d and %d", clone.Len(), p.Len())
+		}
+		for i := 0; i < clone.Len(); i++ {
+			if clone.CommandAt(i) == p.CommandAt(i) || clone.CommandAt(i).Call() == p.CommandAt(i).Call() {
+				t.Errorf("Expected command %d to be reallocated", i)
+			}
+		}
+		rv, ok := clone.CommandAt(1).Call().Args()[0].(*ReturnValue)
+		if !ok || rv.Command() != clone.CommandAt(0) {
+			t.Fatal("Expected return value to refer to the cloned command")
+		}
+
+		plan, err := clone.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.Commands) != 2 {
+			t.Fatalf("Expected 2 commands, got %d", len(plan.Commands))
+		}
+		_, _, _, returnSlot, _, _ := DecodeCommand(plan.Commands[0])
+		argSlots, _ := plan.ArgSlotsFor(1)
+		if returnSlot == NoReturnSlot || argSlots[0] != returnSlot {
+			t.Errorf("Expected multiply to read slot %d, got %v", returnSlot, argSlots)
+		}
+
+		// Adding to the clone leaves the original alone
+		clone.Add(lib.MustInvoke("noReturn", big.NewInt(5)))
+		if p.Len() != 3 {
+			t.Errorf("Expected original to keep 3 commands, got %d", p.Len())
+		}
+	})
+
+	t.Run("rewires names and subplans", func(t *testing.T) {
+		p := New()
+		v, err := p.AddNamed("sum", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", v))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Freeze()
+
+		clone := p.Clone()
+		if clone.IsFrozen() {
+			t.Error("Expected clone not to be frozen")
+		}
+		named, err := clone.Ref("sum")
+		if err != nil || named.Command() != clone.CommandAt(0) {
+			t.Errorf("Expected name to refer to the cloned command, got %v", err)
+		}
+
+		args := clone.CommandAt(1).Call().Args()
+		subValue, ok := args[0].(*SubplanValue)
+		if !ok || subValue.subplanner == sub || subValue.subplanner.parent != clone {
+			t.Fatal("Expected subplan to be cloned with the clone as parent")
+		}
+		if state := args[1].(*StateValue); state.planner != clone {
+			t.Error("Expected state to belong to the clone")
+		}
+		if rv := subValue.subplanner.CommandAt(0).Call().Args()[0].(*ReturnValue); rv.Command() != clone.CommandAt(0) {
+			t.Error("Expected subplan return value to refer to the cloned command")
+		}
+
+		original, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		cloned, err := clone.Plan()
+		if err != nil {
+			t.Fatalf("Plan of clone failed: %v", err)
+		}
+		if !equalByteSlices(cloned.Commands, original.Commands) || !equalByteSlices(cloned.State, original.State) {
+			t.Error("Expected clone to compile to the same plan")
+		}
+	})
+}
+
+func TestPlannerRemoveAt(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("referenced command", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+
+		if err := p.RemoveAt(0); !errors.Is(err, ErrReturnValueStillReferenced) {
+			t.Fatalf("Expected ErrReturnValueStillReferenced, got %v", err)
+		}
+		if p.Len() != 2 {
+			t.Errorf("Expected planner to keep 2 commands, got %d", p.Len())
+		}
+	})
+
+	t.Run("referenced from subplan", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", sum))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if err := p.RemoveAt(0); !errors.Is(err, ErrReturnValueStillReferenced) {
+			t.Fatalf("Expected ErrReturnValueStillReferenced, got %v", err)
+		}
+	})
+
+	t.Run("unreferenced tail command", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+
+		if err := p.RemoveAt(1); err != nil {
+			t.Fatalf("RemoveAt failed: %v", err)
+		}
+		if p.Len() != 1 || p.CommandAt(0) != sum.Command() {
+			t.Fatalf("Expected only the add command to remain, got %d commands", p.Len())
+		}
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Plan failed: %v", err)
+		}
+	})
+
+	t.Run("drops names", func(t *testing.T) {
+		p := New()
+		if _, err := p.AddNamed("sum", lib.MustInvoke("add", big.NewInt(1), big.NewInt(2))); err != nil {
+			t.Fatalf("AddNamed failed: %v", err)
+		}
+		if err := p.RemoveAt(0); err != nil {
+			t.Fatalf("RemoveAt failed: %v", err)
+		}
+		if _, err := p.Ref("sum"); err == nil {
+			t.Error("Expected name of removed command to be dropped")
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		for _, i := range []int{-1, 1} {
+			if err := p.RemoveAt(i); !errors.Is(err, ErrCommandIndexOutOfRange) {
+				t.Errorf("Index %d: expected ErrCommandIndexOutOfRange, got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("frozen planner", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Freeze()
+		if err := p.RemoveAt(0); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("Expected ErrPlannerFrozen, got %v", err)
+		}
+	})
+}
+
+func TestPlannerInsertAt(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("middle of a chain", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", sum))
+
+		product, err := p.InsertAt(1, lib.MustInvoke("multiply", sum, big.NewInt(3)))
+		if err != nil {
+			t.Fatalf("InsertAt failed: %v", err)
+		}
+		if p.Len() != 3 || p.CommandAt(1) != product.Command() {
+			t.Fatalf("Expected inserted command at index 1 of 3, got %d commands", p.Len())
+		}
+		if rv, ok := p.CommandAt(2).Call().Args()[0].(*ReturnValue); !ok || rv != sum {
+			t.Fatal("Expected shifted command to keep reading the add result")
+		}
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		_, _, _, sumSlot, _, _ := DecodeCommand(plan.Commands[0])
+		for i := 1; i <= 2; i++ {
+			argSlots, _ := plan.ArgSlotsFor(i)
+			if argSlots[0] != sumSlot {
+				t.Errorf("Command %d: expected to read slot %d, got %v", i, sumSlot, argSlots)
+			}
+		}
+	})
+
+	t.Run("append at end", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if _, err := p.InsertAt(1, lib.MustInvoke("noReturn", sum)); err != nil {
+			t.Fatalf("InsertAt failed: %v", err)
+		}
+		if p.Len() != 2 {
+			t.Errorf("Expected 2 commands, got %d", p.Len())
+		}
+	})
+
+	t.Run("hooks see the final index", func(t *testing.T) {
+		var p *Planner
+		var indices []int
+		p = New(WithAddHook(func(cmd *Command) {
+			for i := 0; i < p.Len(); i++ {
+				if p.CommandAt(i) == cmd {
+					indices = append(indices, i)
+				}
+			}
+		}), WithSourceTracking())
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(2)))
+		if _, err := p.InsertAt(1, lib.MustInvoke("noReturn", big.NewInt(3))); err != nil {
+			t.Fatalf("InsertAt failed: %v", err)
+		}
+
+		if !slices.Equal(indices, []int{0, 1, 1}) {
+			t.Errorf("Expected hooks to see indices [0 1 1], got %v", indices)
+		}
+		if file, _ := p.CommandAt(1).Source(); !strings.HasSuffix(file, "planner_test.go") {
+			t.Errorf("Expected the inserted command attributed to the test, got %q", file)
+		}
+	})
+
+	t.Run("reads a later command", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		if _, err := p.InsertAt(0, lib.MustInvoke("noReturn", sum)); !errors.Is(err, ErrReturnValueNotVisible) {
+			t.Fatalf("Expected ErrReturnValueNotVisible, got %v", err)
+		}
+		if p.Len() != 1 {
+			t.Errorf("Expected planner to keep 1 command, got %d", p.Len())
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		p := New()
+		for _, i := range []int{-1, 1} {
+			if _, err := p.InsertAt(i, lib.MustInvoke("noReturn", big.NewInt(1))); !errors.Is(err, ErrCommandIndexOutOfRange) {
+				t.Errorf("Index %d: expected ErrCommandIndexOutOfRange, got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("frozen planner", func(t *testing.T) {
+		p := New()
+		p.Freeze()
+		if _, err := p.InsertAt(0, lib.MustInvoke("noReturn", big.NewInt(1))); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("Expected ErrPlannerFrozen, got %v", err)
+		}
+	})
+}
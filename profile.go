@@ -0,0 +1,33 @@
+package weiroll
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VMProfile describes a VM deployment by the plan options its version
+// needs, such as WithEncodingConstants for a fork's sentinel values or
+// WithMaxStateSlots for a smaller state array.
+type VMProfile []PlanOption
+
+// CompileAll compiles the plan once per named profile, for deployment
+// pipelines targeting several VM versions. Profiles are compiled in name
+// order, and the first that can't support the plan stops compilation with
+// an error naming it.
+func (p *Planner) CompileAll(profiles map[string]VMProfile) (map[string]*CompiledPlan, error) {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	plans := make(map[string]*CompiledPlan, len(profiles))
+	for _, name := range names {
+		plan, err := p.Plan(profiles[name]...)
+		if err != nil {
+			return nil, fmt.Errorf("weiroll: profile %q: %w", name, err)
+		}
+		plans[name] = plan
+	}
+	return plans, nil
+}
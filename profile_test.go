@@ -0,0 +1,60 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPlannerCompileAll(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	p := New()
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)).AllowFailure())
+
+	reference := DefaultEncodingConfig()
+	reference.AllowFailureFlag = 0x20
+	fork := reference
+	fork.NoReturnSlot = 0xFD
+	fork.AllowFailureFlag = 0x08
+
+	t.Run("compiles each compatible profile", func(t *testing.T) {
+		plans, err := p.CompileAll(map[string]VMProfile{
+			"v1": {WithEncodingConstants(reference)},
+			"v2": {WithEncodingConstants(fork)},
+		})
+		if err != nil {
+			t.Fatalf("CompileAll failed: %v", err)
+		}
+		if len(plans) != 2 {
+			t.Fatalf("Expected 2 plans, got %d", len(plans))
+		}
+
+		_, flags1, _, _, _, _ := DecodeCommand(plans["v1"].Commands[1])
+		_, flags2, _, ret2, _, _ := DecodeCommand(plans["v2"].Commands[1])
+		if flags1&0x20 == 0 || flags2&0x08 == 0 {
+			t.Errorf("Expected profile-specific failure flags, got 0x%02x and 0x%02x", flags1, flags2)
+		}
+		if ret2 != 0xFD {
+			t.Errorf("Expected v2 no-return slot 0xfd, got 0x%02x", ret2)
+		}
+	})
+
+	t.Run("names the incompatible profile", func(t *testing.T) {
+		_, err := p.CompileAll(map[string]VMProfile{
+			"v1":     {WithEncodingConstants(reference)},
+			"v2":     {WithEncodingConstants(fork)},
+			"legacy": {},
+		})
+		if !errors.Is(err, ErrAllowFailureUnsupported) {
+			t.Fatalf("Expected ErrAllowFailureUnsupported, got %v", err)
+		}
+		if !strings.Contains(err.Error(), `profile "legacy"`) {
+			t.Errorf("Expected error to name the profile, got %q", err.Error())
+		}
+	})
+}
@@ -0,0 +1,65 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ExecutionSummary describes what an executed plan did on-chain. See
+// CompiledPlan.ParseReceipt.
+type ExecutionSummary struct {
+	Succeeded bool
+	GasUsed   uint64
+	Logs      []LogAttribution // In emission order
+}
+
+// LogAttribution is a log of an execution receipt with the command that
+// most likely emitted it.
+type LogAttribution struct {
+	Log *types.Log
+
+	// CommandIndex is the top-level command the log is attributed to, or
+	// -1 if it was emitted before any command could be identified.
+	CommandIndex int
+
+	// Direct is true if the command calls the log's address itself. A log
+	// from any other address, such as a contract the command's target
+	// calls in turn or a library running in the VM's context through
+	// DELEGATECALL, is attributed to the command running at that point.
+	Direct bool
+}
+
+// ParseReceipt correlates the logs of a receipt for executing cp with the
+// commands that likely emitted them, by matching each log's address against
+// the targets from ExpectedCalls. Logs are emitted in execution order, so a
+// log is attributed to the first command at or after the last attributed
+// one that calls its address; commands whose target is only known at
+// runtime are never matched directly. cp must have been compiled from
+// sourcePlanner without options that drop commands.
+func (cp *CompiledPlan) ParseReceipt(receipt *types.Receipt, sourcePlanner *Planner) (ExecutionSummary, error) {
+	calls, err := cp.ExpectedCalls(sourcePlanner)
+	if err != nil {
+		return ExecutionSummary{}, err
+	}
+
+	summary := ExecutionSummary{
+		Succeeded: receipt.Status == types.ReceiptStatusSuccessful,
+		GasUsed:   receipt.GasUsed,
+		Logs:      make([]LogAttribution, len(receipt.Logs)),
+	}
+	current := -1
+	for i, log := range receipt.Logs {
+		attribution := LogAttribution{Log: log, CommandIndex: current}
+		for j := max(current, 0); j < len(calls); j++ {
+			call := calls[j]
+			if call.Target == log.Address && !call.TargetRuntime && call.CallType != FlagDelegateCall {
+				attribution.CommandIndex, attribution.Direct = j, true
+				current = j
+				break
+			}
+		}
+		summary.Logs[i] = attribution
+	}
+	return summary, nil
+}
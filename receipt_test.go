@@ -0,0 +1,89 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestCompiledPlanParseReceipt(t *testing.T) {
+	libAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	tokenA := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	tokenB := common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	other := common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc")
+	lib := NewLibrary(libAddr, plannerTestABI())
+
+	p := New()
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(NewContract(tokenA, plannerTestABI()).MustInvoke("noReturn", sum))
+	p.Add(NewContract(tokenB, plannerTestABI()).MustInvoke("noReturn", sum))
+	p.Add(NewContract(tokenA, plannerTestABI()).MustInvoke("noReturn", big.NewInt(4)))
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	t.Run("attributes logs to commands", func(t *testing.T) {
+		receipt := &types.Receipt{
+			Status:  types.ReceiptStatusSuccessful,
+			GasUsed: 123456,
+			Logs: []*types.Log{
+				{Address: tokenA},
+				{Address: other}, // Emitted by a contract tokenA calls
+				{Address: tokenB},
+				{Address: tokenA},
+			},
+		}
+
+		summary, err := plan.ParseReceipt(receipt, p)
+		if err != nil {
+			t.Fatalf("ParseReceipt failed: %v", err)
+		}
+		if !summary.Succeeded || summary.GasUsed != 123456 {
+			t.Errorf("Expected successful execution using 123456 gas, got %+v", summary)
+		}
+
+		expected := []struct {
+			index  int
+			direct bool
+		}{{1, true}, {1, false}, {2, true}, {3, true}}
+		if len(summary.Logs) != len(expected) {
+			t.Fatalf("Expected %d logs, got %d", len(expected), len(summary.Logs))
+		}
+		for i, e := range expected {
+			got := summary.Logs[i]
+			if got.Log != receipt.Logs[i] || got.CommandIndex != e.index || got.Direct != e.direct {
+				t.Errorf("Log %d: expected command %d (direct %v), got %d (direct %v)",
+					i, e.index, e.direct, got.CommandIndex, got.Direct)
+			}
+		}
+	})
+
+	t.Run("leaves logs before any match unattributed", func(t *testing.T) {
+		receipt := &types.Receipt{Logs: []*types.Log{{Address: other}, {Address: libAddr}}}
+
+		summary, err := plan.ParseReceipt(receipt, p)
+		if err != nil {
+			t.Fatalf("ParseReceipt failed: %v", err)
+		}
+		if summary.Succeeded {
+			t.Error("Expected failed status to be reported")
+		}
+		for i, log := range summary.Logs {
+			if log.CommandIndex != -1 || log.Direct {
+				t.Errorf("Log %d: expected no command, got %d", i, log.CommandIndex)
+			}
+		}
+	})
+
+	t.Run("rejects mismatched planner", func(t *testing.T) {
+		if _, err := plan.ParseReceipt(&types.Receipt{}, New()); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package weiroll
+
+import "fmt"
+
+// SafePlanner wraps a Planner for building plans from untrusted input
+// without panics. It records the first error from AddInvoke; later calls
+// return that error without adding anything, and Plan fails with it.
+type SafePlanner struct {
+	planner *Planner
+	err     error
+}
+
+// NewSafePlanner creates a SafePlanner around a new Planner.
+func NewSafePlanner(opts ...PlannerOption) *SafePlanner {
+	return &SafePlanner{planner: New(opts...)}
+}
+
+// AddInvoke invokes method on contract with args and adds the call to the
+// plan, returning its return value (nil if the method has none). It
+// returns the first recorded error instead if an earlier add failed, and
+// ErrPlannerFrozen rather than panicking if the planner is frozen. A panic
+// of an add hook (see WithAddHook) is recovered as ErrAddHookPanicked,
+// wrapping the panic value if it is an error; the rejected command stays
+// in the planner, but Plan fails with the recorded error.
+func (s *SafePlanner) AddInvoke(contract *Contract, method string, args ...any) (rv *ReturnValue, err error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.planner.frozen {
+		s.err = ErrPlannerFrozen
+		return nil, s.err
+	}
+	call, err := contract.Invoke(method, args...)
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if cause, ok := r.(error); ok {
+				s.err = fmt.Errorf("%w: %w", ErrAddHookPanicked, cause)
+			} else {
+				s.err = fmt.Errorf("%w: %v", ErrAddHookPanicked, r)
+			}
+			rv, err = nil, s.err
+		}
+	}()
+	return s.planner.add(call, addConfig{}), nil
+}
+
+// Err returns the first error recorded by AddInvoke, or nil.
+func (s *SafePlanner) Err() error {
+	return s.err
+}
+
+// Planner returns the underlying planner, for values such as State and for
+// methods SafePlanner doesn't wrap.
+func (s *SafePlanner) Planner() *Planner {
+	return s.planner
+}
+
+// Plan compiles the underlying planner, or returns the first recorded error.
+func (s *SafePlanner) Plan(opts ...PlanOption) (*CompiledPlan, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.planner.Plan(opts...)
+}
@@ -0,0 +1,106 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestSafePlanner(t *testing.T) {
+	contract := NewLibrary(common.HexToAddress("0x1111111111111111111111111111111111111111"), plannerTestABI())
+
+	t.Run("builds a plan", func(t *testing.T) {
+		s := NewSafePlanner()
+		sum, err := s.AddInvoke(contract, "add", big.NewInt(1), big.NewInt(2))
+		if err != nil {
+			t.Fatalf("AddInvoke failed: %v", err)
+		}
+		if _, err := s.AddInvoke(contract, "multiply", sum, big.NewInt(3)); err != nil {
+			t.Fatalf("AddInvoke failed: %v", err)
+		}
+
+		plan, err := s.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.Commands) != 2 || s.Planner().Len() != 2 {
+			t.Errorf("Expected 2 commands, got %d", len(plan.Commands))
+		}
+	})
+
+	t.Run("returns errors instead of panicking", func(t *testing.T) {
+		s := NewSafePlanner()
+		_, err := s.AddInvoke(contract, "ad", big.NewInt(1), big.NewInt(2))
+		var notFound *MethodNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Fatalf("Expected MethodNotFoundError, got %v", err)
+		}
+
+		// Later adds short-circuit with the first error
+		if _, err := s.AddInvoke(contract, "add", big.NewInt(1), big.NewInt(2)); !errors.As(err, &notFound) {
+			t.Errorf("Expected first error to be returned, got %v", err)
+		}
+		if s.Planner().Len() != 0 {
+			t.Errorf("Expected no commands after an error, got %d", s.Planner().Len())
+		}
+		if s.Err() != err {
+			t.Errorf("Expected Err to return the first error, got %v", s.Err())
+		}
+		if _, planErr := s.Plan(); planErr != err {
+			t.Errorf("Expected Plan to return the first error, got %v", planErr)
+		}
+	})
+
+	t.Run("records argument errors", func(t *testing.T) {
+		s := NewSafePlanner()
+		_, err := s.AddInvoke(contract, "add", big.NewInt(1))
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) {
+			t.Errorf("Expected ArgumentError, got %v", err)
+		}
+	})
+
+	t.Run("recovers add hook panics", func(t *testing.T) {
+		errRejected := errors.New("rejected")
+		s := NewSafePlanner(WithAddHook(func(cmd *Command) {
+			if cmd.Call().Method().Name == "noReturn" {
+				panic(errRejected)
+			}
+		}))
+		if _, err := s.AddInvoke(contract, "add", big.NewInt(1), big.NewInt(2)); err != nil {
+			t.Fatalf("AddInvoke failed: %v", err)
+		}
+
+		rv, err := s.AddInvoke(contract, "noReturn", big.NewInt(1))
+		if !errors.Is(err, ErrAddHookPanicked) || !errors.Is(err, errRejected) {
+			t.Fatalf("Expected ErrAddHookPanicked wrapping the hook's error, got %v", err)
+		}
+		if rv != nil {
+			t.Error("Expected no return value")
+		}
+		if _, planErr := s.Plan(); planErr != err {
+			t.Errorf("Expected Plan to return the hook error, got %v", planErr)
+		}
+	})
+
+	t.Run("recovers non-error hook panics", func(t *testing.T) {
+		s := NewSafePlanner(WithAddHook(func(*Command) {
+			panic("not allowed")
+		}))
+		_, err := s.AddInvoke(contract, "add", big.NewInt(1), big.NewInt(2))
+		if !errors.Is(err, ErrAddHookPanicked) || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("Expected ErrAddHookPanicked with the panic value, got %v", err)
+		}
+	})
+
+	t.Run("frozen planner", func(t *testing.T) {
+		s := NewSafePlanner()
+		s.Planner().Freeze()
+		if _, err := s.AddInvoke(contract, "add", big.NewInt(1), big.NewInt(2)); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("Expected ErrPlannerFrozen, got %v", err)
+		}
+	})
+}
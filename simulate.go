@@ -0,0 +1,112 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// executeSelector is the selector of the VM's execute(bytes32[],bytes[]).
+var executeSelector = crypto.Keccak256([]byte("execute(bytes32[],bytes[])"))[:4]
+
+// SimulateOption configures Simulate.
+type SimulateOption func(*simulateConfig)
+
+// simulateConfig holds configuration for Simulate.
+type simulateConfig struct {
+	commandTimeout time.Duration
+}
+
+// WithCommandTimeout bounds each step of Simulate, so that a slow call is
+// reported as a SimulationTimeoutError naming its command rather than
+// using up the whole context deadline. Zero means no per-step limit.
+func WithCommandTimeout(d time.Duration) SimulateOption {
+	return func(c *simulateConfig) {
+		c.commandTimeout = d
+	}
+}
+
+// SimulationTimeoutError indicates that Simulate ran out of time, and how
+// far it got.
+type SimulationTimeoutError struct {
+	CommandIndex int // Command added by the step that timed out
+	Commands     int // Commands in the plan
+	Err          error
+}
+
+func (e *SimulationTimeoutError) Error() string {
+	return fmt.Sprintf("weiroll: simulation timed out at command %d (%d of %d commands completed): %v",
+		e.CommandIndex, e.CommandIndex, e.Commands, e.Err)
+}
+
+func (e *SimulationTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Simulate executes the plan with eth_call on the VM at vmAddr, sent from
+// from, one step at a time: step i calls execute with the first i+1
+// commands and the full state, forwarding the ETH value those commands
+// send. Each step re-executes the commands before it, so the first step to
+// fail identifies the command it adds. A revert is returned as a PlanError
+// for that command; running out of time, whether ctx's deadline or
+// WithCommandTimeout, returns a SimulationTimeoutError.
+func (cp *CompiledPlan) Simulate(
+	ctx context.Context,
+	caller ethereum.ContractCaller,
+	vmAddr, from common.Address,
+	opts ...SimulateOption,
+) error {
+	cfg := &simulateConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	for i := range cp.Commands {
+		step := &CompiledPlan{Commands: cp.Commands[:i+1], State: cp.State}
+		data, err := step.executeCalldata()
+		if err != nil {
+			return &PlanError{CommandIndex: i, Err: err}
+		}
+		msg := ethereum.CallMsg{From: from, To: &vmAddr, Data: data}
+		if value, _ := step.RequiredValue(); value.Sign() > 0 {
+			msg.Value = value
+		}
+
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.commandTimeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, cfg.commandTimeout)
+		}
+		_, err = caller.CallContract(stepCtx, msg, nil)
+		timedOut := errors.Is(stepCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err != nil {
+			if timedOut {
+				return &SimulationTimeoutError{CommandIndex: i, Commands: len(cp.Commands), Err: err}
+			}
+			return &PlanError{CommandIndex: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// executeCalldata packs the plan as a call to execute(bytes32[],bytes[]).
+func (cp *CompiledPlan) executeCalldata() ([]byte, error) {
+	bytes32Array, _ := abi.NewType("bytes32[]", "", nil)
+	bytesArray, _ := abi.NewType("bytes[]", "", nil)
+	args := abi.Arguments{{Type: bytes32Array}, {Type: bytesArray}}
+
+	packed, err := args.Pack(cp.CommandsAsBytes32(), cp.StateAsBytes())
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, executeSelector...), packed...), nil
+}
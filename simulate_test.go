@@ -0,0 +1,113 @@
+//go:build rpc
+
+package weiroll
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stepCaller records eth_calls and blocks or fails on one of them.
+type stepCaller struct {
+	msgs   []ethereum.CallMsg
+	block  int // 1-based call to block until the context ends, 0 for none
+	revert int // 1-based call to fail, 0 for none
+}
+
+func (c *stepCaller) CallContract(ctx context.Context, msg ethereum.CallMsg, _ *big.Int) ([]byte, error) {
+	c.msgs = append(c.msgs, msg)
+	switch len(c.msgs) {
+	case c.block:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case c.revert:
+		return nil, errors.New("execution reverted")
+	}
+	return nil, nil
+}
+
+func TestCompiledPlanSimulate(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	ext := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), plannerTestABI())
+	vmAddr := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	from := common.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	p := New()
+	p.Add(ext.MustInvoke("noReturn", big.NewInt(4)).WithValue(big.NewInt(7)))
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	t.Run("runs one step per command", func(t *testing.T) {
+		caller := &stepCaller{}
+		if err := plan.Simulate(context.Background(), caller, vmAddr, from); err != nil {
+			t.Fatalf("Simulate failed: %v", err)
+		}
+		if len(caller.msgs) != 3 {
+			t.Fatalf("Expected 3 steps, got %d", len(caller.msgs))
+		}
+		for i, msg := range caller.msgs {
+			if *msg.To != vmAddr || msg.From != from {
+				t.Errorf("Step %d: expected call from %s to %s", i, from.Hex(), vmAddr.Hex())
+			}
+			if i > 0 && len(msg.Data) <= len(caller.msgs[i-1].Data) {
+				t.Errorf("Step %d: expected more calldata than the previous step", i)
+			}
+		}
+		for i, msg := range caller.msgs {
+			if msg.Value == nil || msg.Value.Cmp(big.NewInt(7)) != 0 {
+				t.Errorf("Step %d: expected the payable command's 7 wei, got %v", i, msg.Value)
+			}
+		}
+	})
+
+	t.Run("reports the command that timed out", func(t *testing.T) {
+		caller := &stepCaller{block: 2}
+		err := plan.Simulate(context.Background(), caller, vmAddr, from, WithCommandTimeout(10*time.Millisecond))
+
+		var timeout *SimulationTimeoutError
+		if !errors.As(err, &timeout) {
+			t.Fatalf("Expected SimulationTimeoutError, got %v", err)
+		}
+		if timeout.CommandIndex != 1 || timeout.Commands != 3 {
+			t.Errorf("Expected timeout at command 1 of 3, got %d of %d", timeout.CommandIndex, timeout.Commands)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected error to wrap context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("overall deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		caller := &stepCaller{block: 1}
+
+		var timeout *SimulationTimeoutError
+		if err := plan.Simulate(ctx, caller, vmAddr, from); !errors.As(err, &timeout) || timeout.CommandIndex != 0 {
+			t.Errorf("Expected SimulationTimeoutError at command 0, got %v", err)
+		}
+	})
+
+	t.Run("reports the command that reverted", func(t *testing.T) {
+		caller := &stepCaller{revert: 3}
+		err := plan.Simulate(context.Background(), caller, vmAddr, from)
+
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 2 {
+			t.Errorf("Expected PlanError for command 2, got %v", err)
+		}
+		var timeout *SimulationTimeoutError
+		if errors.As(err, &timeout) {
+			t.Error("Expected a revert not to be reported as a timeout")
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package weiroll
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Split partitions the plan into sequential planners that each compile within
+// maxCommands commands and maxSlots state slots. Cuts are only made where no
+// return value crosses the boundary, so each part can run as its own
+// transaction. Returns ErrNoSafeSplit if some part cannot be made to fit.
+// Only the command and slot limits make a part too long; any other
+// compilation error is returned wrapped.
+//
+// The returned planners share commands with p and should not be modified.
+func (p *Planner) Split(maxCommands, maxSlots int) ([]*Planner, error) {
+	if maxCommands <= 0 || maxSlots <= 0 {
+		return nil, fmt.Errorf("%w: limits must be positive", ErrNoSafeSplit)
+	}
+
+	// lastUse[i] is the last command index reading command i's return value,
+	// counting a read inside a subplan at the command executing it.
+	index := make(map[*Command]int, len(p.commands))
+	lastUse := make([]int, len(p.commands))
+	for i, cmd := range p.commands {
+		index[cmd] = i
+		lastUse[i] = i
+	}
+	p.walkTopLevel(func(i int, cmd *Command) {
+		for _, arg := range cmd.call.values() {
+			if rv, ok := arg.(*ReturnValue); ok {
+				if producer, found := index[rv.command]; found && lastUse[producer] < i {
+					lastUse[producer] = i
+				}
+			}
+		}
+	})
+
+	cfg := defaultPlanConfig()
+	cfg.maxCommands = maxCommands
+	if maxSlots < cfg.maxStateSlots {
+		cfg.maxStateSlots = maxSlots
+	}
+
+	var parts []*Planner
+	for start := 0; start < len(p.commands); {
+		best := -1
+		reach := start // Furthest last use of any value produced in this part
+		for end := start + 1; end <= len(p.commands) && end-start <= maxCommands; end++ {
+			if lastUse[end-1] > reach {
+				reach = lastUse[end-1]
+			}
+			if reach >= end {
+				continue // A value produced in [start, end) is still needed later
+			}
+			part := &Planner{commands: p.commands[start:end], origin: p.identity()}
+			if _, err := part.compile(cfg, nil); err != nil {
+				if errors.Is(err, ErrSlotExhausted) || errors.Is(err, ErrTooManyArguments) {
+					break
+				}
+				return nil, fmt.Errorf("weiroll: compiling commands %d to %d: %w", start, end-1, err)
+			}
+			best = end
+		}
+
+		if best < 0 {
+			return nil, &PlanError{
+				CommandIndex: start,
+				Method:       p.commands[start].call.method.Name,
+				Err:          ErrNoSafeSplit,
+			}
+		}
+
+		parts = append(parts, &Planner{commands: p.commands[start:best:best], origin: p.identity()})
+		start = best
+	}
+
+	return parts, nil
+}
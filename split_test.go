@@ -0,0 +1,132 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPlannerSplit(t *testing.T) {
+	testABI := plannerTestABI()
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, testABI)
+
+	t.Run("splits independent commands into compilable halves", func(t *testing.T) {
+		p := New()
+		for i := 0; i < 10; i++ {
+			p.Add(lib.MustInvoke("noReturn", big.NewInt(int64(i))))
+		}
+
+		parts, err := p.Split(5, MaxStateSlots)
+		if err != nil {
+			t.Fatalf("Split failed: %v", err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("Expected 2 parts, got %d", len(parts))
+		}
+		for i, part := range parts {
+			if part.Len() != 5 {
+				t.Errorf("Part %d: expected 5 commands, got %d", i, part.Len())
+			}
+			if _, err := part.Plan(WithMaxCommands(5)); err != nil {
+				t.Errorf("Part %d failed to compile: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("respects slot limit", func(t *testing.T) {
+		p := New()
+		for i := 0; i < 4; i++ {
+			p.Add(lib.MustInvoke("noReturn", big.NewInt(int64(i))))
+		}
+
+		parts, err := p.Split(10, 2)
+		if err != nil {
+			t.Fatalf("Split failed: %v", err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("Expected 2 parts, got %d", len(parts))
+		}
+		for i, part := range parts {
+			plan, err := part.Plan(WithMaxStateSlots(2))
+			if err != nil {
+				t.Fatalf("Part %d failed to compile: %v", i, err)
+			}
+			if len(plan.State) > 2 {
+				t.Errorf("Part %d uses %d slots", i, len(plan.State))
+			}
+		}
+	})
+
+	t.Run("never cuts across a return value", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(3)))
+		p.Add(lib.MustInvoke("noReturn", a))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(4)))
+
+		parts, err := p.Split(3, MaxStateSlots)
+		if err != nil {
+			t.Fatalf("Split failed: %v", err)
+		}
+		if len(parts) != 2 || parts[0].Len() != 3 || parts[1].Len() != 1 {
+			t.Fatalf("Expected parts of 3 and 1 commands, got %d parts", len(parts))
+		}
+	})
+
+	t.Run("never cuts a value from a subplan reading it", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(0)))
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(3)))
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", a))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(4)))
+
+		parts, err := p.Split(3, MaxStateSlots)
+		if err != nil {
+			t.Fatalf("Split failed: %v", err)
+		}
+		if len(parts) != 3 || parts[0].Len() != 1 || parts[1].Len() != 3 || parts[2].Len() != 1 {
+			t.Fatalf("Expected parts of 1, 3 and 1 commands, got %d parts", len(parts))
+		}
+	})
+
+	t.Run("errors when no safe split exists", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(3)))
+		p.Add(lib.MustInvoke("noReturn", a))
+
+		_, err := p.Split(2, MaxStateSlots)
+		if !errors.Is(err, ErrNoSafeSplit) {
+			t.Errorf("Expected ErrNoSafeSplit, got %v", err)
+		}
+	})
+
+	t.Run("returns errors other than limits", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("noReturn", big.NewInt(1)))
+		p.Add(lib.MustInvoke("noReturn", Param("amount", "uint256")))
+
+		_, err := p.Split(2, MaxStateSlots)
+		if !errors.Is(err, ErrUnboundParam) {
+			t.Errorf("Expected ErrUnboundParam, got %v", err)
+		}
+		if errors.Is(err, ErrNoSafeSplit) {
+			t.Errorf("Expected the compile error rather than ErrNoSafeSplit, got %v", err)
+		}
+	})
+
+	t.Run("rejects non-positive limits", func(t *testing.T) {
+		_, err := New().Split(0, 10)
+		if !errors.Is(err, ErrNoSafeSplit) {
+			t.Errorf("Expected ErrNoSafeSplit, got %v", err)
+		}
+	})
+}
@@ -2,56 +2,102 @@ package weiroll
 
 import (
 	"encoding/hex"
+	"fmt"
 )
 
 // stateManager handles slot allocation, deduplication, and recycling.
 type stateManager struct {
-	state            [][]byte          // The state array
-	literalSlotMap   map[string]uint8  // Literal hash -> slot for deduplication
-	returnSlotMap    map[*Command]uint8 // Command -> its return slot
-	freeSlots        []uint8           // Recycled slots available for reuse
-	stateExpirations map[int][]uint8   // Command index -> slots freed after it
-	config           *planConfig       // Plan configuration
-	nextSlot         uint8             // Next slot to allocate
+	state            [][]byte                 // The state array
+	literalSlotMap   map[string]uint8         // Literal bytes -> slot for deduplication
+	mutableSlotMap   map[*LiteralValue]uint8  // Mutable literal -> its private slot
+	returnSlotMap    map[*Command]uint8       // Command -> its return slot
+	commandAliases   map[*Command]*Command    // Deduplicated command -> kept command
+	params           map[string]*LiteralValue // Template parameter bindings
+	dynamicSlots     map[uint8]bool           // Slots holding dynamic literals
+	activeSubplans   map[*Planner]bool        // Subplans being encoded, for cycle detection
+	freeSlots        []uint8                  // Recycled slots available for reuse
+	stateExpirations map[int][]uint8          // Command index -> slots freed after it
+	config           *planConfig              // Plan configuration
+	nextSlot         uint8                    // Next slot to allocate
 }
 
 // newStateManager creates a new state manager.
 func newStateManager(config *planConfig) *stateManager {
 	return &stateManager{
-		state:            make([][]byte, 0, 32),
+		state:            make([][]byte, config.minSlot, max(32, int(config.minSlot))),
 		literalSlotMap:   make(map[string]uint8),
+		mutableSlotMap:   make(map[*LiteralValue]uint8),
 		returnSlotMap:    make(map[*Command]uint8),
 		freeSlots:        make([]uint8, 0),
 		stateExpirations: make(map[int][]uint8),
+		dynamicSlots:     make(map[uint8]bool),
+		activeSubplans:   make(map[*Planner]bool),
 		config:           config,
-		nextSlot:         0,
+		nextSlot:         config.minSlot,
 	}
 }
 
 // allocateLiteral adds a literal to state, with deduplication.
 // Returns the slot index (with dynamic flag if applicable).
+// Static literals must be exactly one 32-byte word.
 func (sm *stateManager) allocateLiteral(lit *LiteralValue) (uint8, error) {
-	// Create a key for deduplication
-	key := hex.EncodeToString(lit.data)
+	if !lit.IsDynamic() && len(lit.data) != 32 {
+		return 0, &EncodingError{
+			Value: lit,
+			Err:   fmt.Errorf("%w: %s literal holds %d bytes", ErrStaticSlotSize, lit.abiType.String(), len(lit.data)),
+		}
+	}
+	if limit := sm.config.maxEntry; limit > 0 && len(lit.data) > limit {
+		return 0, &EncodingError{
+			Value: lit,
+			Err:   fmt.Errorf("%w: %s literal holds %d bytes (limit %d)", ErrStateEntryTooLarge, lit.abiType.String(), len(lit.data), limit),
+		}
+	}
+
+	// Use the raw bytes as the deduplication key unless a hasher is
+	// configured; converting to string copies once and avoids
+	// hex-encoding every literal. Mutable and private literals are keyed by
+	// identity.
+	private := lit.mutable || sm.config.private[lit]
+	var key string
+	var slot uint8
+	var exists bool
+	switch {
+	case private:
+		slot, exists = sm.mutableSlotMap[lit]
+	case sm.config.literalKey != nil:
+		key = sm.config.literalKey(lit.data)
+		slot, exists = sm.literalSlotMap[key]
+	default:
+		key = string(lit.data)
+		slot, exists = sm.literalSlotMap[key]
+	}
 
 	// Check for existing identical literal
-	if slot, exists := sm.literalSlotMap[key]; exists {
+	if exists {
 		if lit.IsDynamic() {
-			return slot | DynamicSlotFlag, nil
+			return slot | sm.config.encoding.DynamicSlotFlag, nil
 		}
 		return slot, nil
 	}
 
-	slot, err := sm.allocateSlot()
+	// Literals never take a recycled slot: an earlier command has written
+	// its return value there by the time a later command reads the literal.
+	slot, err := sm.newSlot()
 	if err != nil {
 		return 0, err
 	}
 
 	sm.state[slot] = lit.data
-	sm.literalSlotMap[key] = slot
+	if private {
+		sm.mutableSlotMap[lit] = slot
+	} else {
+		sm.literalSlotMap[key] = slot
+	}
 
 	if lit.IsDynamic() {
-		return slot | DynamicSlotFlag, nil
+		sm.dynamicSlots[slot] = true
+		return slot | sm.config.encoding.DynamicSlotFlag, nil
 	}
 	return slot, nil
 }
@@ -72,21 +118,35 @@ func (sm *stateManager) allocateReturn(cmd *Command, lastUsage int, isDynamic bo
 	}
 
 	if isDynamic {
-		return slot | DynamicSlotFlag, nil
+		return slot | sm.config.encoding.DynamicSlotFlag, nil
 	}
 	return slot, nil
 }
 
 // allocateSlot gets a free slot, either from recycled pool or new.
+// Recycled slots are reused lowest index first, which keeps live values
+// packed toward the start of the state array.
 func (sm *stateManager) allocateSlot() (uint8, error) {
 	// Try to reuse a freed slot (if optimization enabled)
 	if sm.config.optimizeSlots && len(sm.freeSlots) > 0 {
-		slot := sm.freeSlots[len(sm.freeSlots)-1]
-		sm.freeSlots = sm.freeSlots[:len(sm.freeSlots)-1]
+		lowest := 0
+		for i, slot := range sm.freeSlots {
+			if slot < sm.freeSlots[lowest] {
+				lowest = i
+			}
+		}
+		slot := sm.freeSlots[lowest]
+		last := len(sm.freeSlots) - 1
+		sm.freeSlots[lowest] = sm.freeSlots[last]
+		sm.freeSlots = sm.freeSlots[:last]
 		return slot, nil
 	}
 
-	// Allocate new slot
+	return sm.newSlot()
+}
+
+// newSlot appends a slot that no command has used.
+func (sm *stateManager) newSlot() (uint8, error) {
 	if int(sm.nextSlot) >= sm.config.maxStateSlots {
 		return 0, ErrSlotExhausted
 	}
@@ -120,28 +180,116 @@ func (sm *stateManager) getSlotForValue(v Value) (uint8, error) {
 		return sm.allocateLiteral(val)
 
 	case *ReturnValue:
-		slot, exists := sm.returnSlotMap[val.command]
+		cmd := val.command
+		if kept, aliased := sm.commandAliases[cmd]; aliased {
+			cmd = kept
+		}
+		slot, exists := sm.returnSlotMap[cmd]
 		if !exists {
 			return 0, ErrReturnValueNotVisible
 		}
 		if val.IsDynamic() {
-			return slot | DynamicSlotFlag, nil
+			return slot | sm.config.encoding.DynamicSlotFlag, nil
 		}
 		return slot, nil
 
+	case *ParamValue:
+		lit, bound := sm.params[val.name]
+		if !bound {
+			return 0, fmt.Errorf("%w: %q", ErrUnboundParam, val.name)
+		}
+		return sm.allocateLiteral(lit)
+
+	case *SlotValue:
+		if val.slot >= sm.config.minSlot {
+			return 0, fmt.Errorf("%w: slot %d (reserved slots end at %d)", ErrSlotNotReserved, val.slot, sm.config.minSlot)
+		}
+		if val.IsDynamic() {
+			return val.slot | sm.config.encoding.DynamicSlotFlag, nil
+		}
+		return val.slot, nil
+
 	case *StateValue:
-		return StateSlotMarker, nil
+		return sm.config.encoding.StateSlotMarker, nil
 
 	case *SubplanValue:
 		// Subplan commands are encoded separately
 		// This returns a placeholder that will be replaced
-		return StateSlotMarker, nil
+		return sm.config.encoding.StateSlotMarker, nil
 
 	default:
 		return 0, &EncodingError{Value: v, Err: ErrReturnValueNotVisible}
 	}
 }
 
+// verify checks that every static literal slot holds exactly one 32-byte
+// word, since the VM reads static slots without a length prefix.
+// Dynamic slots and unfilled return slots are exempt.
+func (sm *stateManager) verify() error {
+	for i, data := range sm.state {
+		if data == nil || sm.dynamicSlots[uint8(i)] {
+			continue
+		}
+		if len(data) != 32 {
+			return fmt.Errorf("%w: slot %d holds %d bytes", ErrStaticSlotSize, i, len(data))
+		}
+	}
+	return nil
+}
+
+// checkLiveness verifies the slot optimizer's invariant that a return value
+// is never read after its slot was reused. It walks commands in execution
+// order, descending into subplans where the command running them executes,
+// and fails if an argument's slot was last written by a different command.
+func (sm *stateManager) checkLiveness(commands []*Command) error {
+	writer := make(map[uint8]*Command)
+	visited := make(map[*Planner]bool)
+
+	var visit func(cmd *Command) error
+	visit = func(cmd *Command) error {
+		args := cmd.call.values()
+		for _, arg := range args {
+			rv, ok := arg.(*ReturnValue)
+			if !ok {
+				continue
+			}
+			producer := rv.command
+			if kept, aliased := sm.commandAliases[producer]; aliased {
+				producer = kept
+			}
+			slot, ok := sm.returnSlotMap[producer]
+			if ok && writer[slot] != producer {
+				return fmt.Errorf("%w: slot %d", ErrStaleSlotRead, slot)
+			}
+		}
+
+		for _, arg := range args {
+			sub, ok := arg.(*SubplanValue)
+			if !ok || sub.subplanner == nil || visited[sub.subplanner] {
+				continue
+			}
+			visited[sub.subplanner] = true
+			for _, subCmd := range sub.subplanner.commands {
+				if err := visit(subCmd); err != nil {
+					return err
+				}
+			}
+		}
+
+		if slot, ok := sm.returnSlotMap[cmd]; ok {
+			writer[slot] = cmd
+		}
+		return nil
+	}
+
+	for i, cmd := range commands {
+		if err := visit(cmd); err != nil {
+			return cmd.planError(i, err)
+		}
+	}
+	return nil
+}
+
 // finalize returns the completed state array as hex-encoded strings.
 func (sm *stateManager) finalize() [][]byte {
 	result := make([][]byte, len(sm.state))
@@ -1,10 +1,12 @@
 package weiroll
 
 import (
+	"errors"
 	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestNewStateManager(t *testing.T) {
@@ -58,6 +60,26 @@ func TestAllocateLiteral(t *testing.T) {
 		}
 	})
 
+	t.Run("deduplicates with custom hasher", func(t *testing.T) {
+		config := defaultPlanConfig()
+		WithLiteralHasher(keccakLiteralKey)(config)
+		sm := newStateManager(config)
+
+		slot1, _ := sm.allocateLiteral(Uint256(big.NewInt(42)))
+		slot2, _ := sm.allocateLiteral(Uint256(big.NewInt(42)))
+		slot3, _ := sm.allocateLiteral(Uint256(big.NewInt(43)))
+
+		if slot1 != slot2 {
+			t.Errorf("Expected identical literals to share slot %d, got %d", slot1, slot2)
+		}
+		if slot3 == slot1 {
+			t.Error("Expected different literals to use different slots")
+		}
+		if _, ok := sm.literalSlotMap[keccakLiteralKey(Uint256(big.NewInt(42)).Data())]; !ok {
+			t.Error("Expected literal to be keyed by the hasher")
+		}
+	})
+
 	t.Run("deduplicates identical literals", func(t *testing.T) {
 		config := defaultPlanConfig()
 		sm := newStateManager(config)
@@ -127,6 +149,23 @@ func TestAllocateLiteral(t *testing.T) {
 		}
 	})
 
+	t.Run("rejects malformed static literal", func(t *testing.T) {
+		sm := newStateManager(defaultPlanConfig())
+		lit := &LiteralValue{abiType: Uint256(big.NewInt(1)).Type(), data: make([]byte, 64)}
+
+		_, err := sm.allocateLiteral(lit)
+		var encErr *EncodingError
+		if !errors.As(err, &encErr) {
+			t.Fatalf("Expected EncodingError, got %v", err)
+		}
+		if !errors.Is(err, ErrStaticSlotSize) {
+			t.Errorf("Expected ErrStaticSlotSize, got %v", err)
+		}
+		if len(sm.state) != 0 {
+			t.Errorf("Expected no slot to be allocated, got %d", len(sm.state))
+		}
+	})
+
 	t.Run("returns error when slots exhausted", func(t *testing.T) {
 		config := defaultPlanConfig()
 		config.maxStateSlots = 2
@@ -264,6 +303,54 @@ func TestAllocateSlot(t *testing.T) {
 		}
 	})
 
+	t.Run("reuses lowest freed slot first", func(t *testing.T) {
+		config := defaultPlanConfig()
+		sm := newStateManager(config)
+
+		for i := 0; i < 5; i++ {
+			sm.allocateSlot()
+		}
+
+		// Slots freed in this order would be handed out as 1, 0, 3 by a
+		// LIFO pool; lowest-first yields 0, 1, 3.
+		sm.freeSlots = append(sm.freeSlots, 3, 0, 1)
+
+		var got []uint8
+		for i := 0; i < 3; i++ {
+			slot, err := sm.allocateSlot()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			got = append(got, slot)
+		}
+
+		expected := []uint8{0, 1, 3}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Fatalf("Expected allocation order %v, got %v", expected, got)
+			}
+		}
+	})
+
+	t.Run("keeps peak state size low", func(t *testing.T) {
+		config := defaultPlanConfig()
+		sm := newStateManager(config)
+
+		for i := 0; i < 4; i++ {
+			sm.allocateSlot()
+		}
+		sm.freeSlots = append(sm.freeSlots, 0, 3)
+
+		// A LIFO pool would hand out slot 3 here.
+		slot, _ := sm.allocateSlot()
+		if slot != 0 {
+			t.Errorf("Expected slot 0, got %d", slot)
+		}
+		if len(sm.freeSlots) != 1 || sm.freeSlots[0] != 3 {
+			t.Errorf("Expected slot 3 to remain free, got %v", sm.freeSlots)
+		}
+	})
+
 	t.Run("ignores freed slots when optimization disabled", func(t *testing.T) {
 		config := defaultPlanConfig()
 		config.optimizeSlots = false
@@ -306,6 +393,72 @@ func TestAllocateSlot(t *testing.T) {
 	})
 }
 
+func TestAllocateSlotRange(t *testing.T) {
+	t.Run("allocates within range", func(t *testing.T) {
+		config := defaultPlanConfig()
+		WithSlotRange(3, 5)(config)
+		sm := newStateManager(config)
+
+		for expected := uint8(3); expected <= 5; expected++ {
+			slot, err := sm.allocateSlot()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if slot != expected {
+				t.Errorf("Expected slot %d, got %d", expected, slot)
+			}
+		}
+
+		if _, err := sm.allocateSlot(); !errors.Is(err, ErrSlotExhausted) {
+			t.Errorf("Expected ErrSlotExhausted, got %v", err)
+		}
+	})
+
+	t.Run("finalized state is contiguous from zero", func(t *testing.T) {
+		config := defaultPlanConfig()
+		WithSlotRange(40, 50)(config)
+		sm := newStateManager(config)
+
+		slot, err := sm.allocateLiteral(Uint256(big.NewInt(7)))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if slot != 40 {
+			t.Errorf("Expected slot 40, got %d", slot)
+		}
+
+		state := sm.finalize()
+		if len(state) != 41 {
+			t.Fatalf("Expected 41 state entries, got %d", len(state))
+		}
+		for i := 0; i < 40; i++ {
+			if len(state[i]) != 32 || new(big.Int).SetBytes(state[i]).Sign() != 0 {
+				t.Errorf("Expected zero-filled slot %d", i)
+			}
+		}
+	})
+
+	t.Run("plan fails when range fills", func(t *testing.T) {
+		lib := NewLibrary(common.Address{}, plannerTestABI())
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+		if _, err := p.Plan(WithSlotRange(10, 10)); !errors.Is(err, ErrSlotExhausted) {
+			t.Errorf("Expected ErrSlotExhausted, got %v", err)
+		}
+		plan, err := p.Plan(WithSlotRange(10, 11))
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		argSlots, _ := plan.ArgSlotsFor(0)
+		for _, s := range argSlots {
+			if s < 10 || s > 11 {
+				t.Errorf("Expected slots within 10-11, got %v", argSlots)
+			}
+		}
+	})
+}
+
 func TestExpireSlots(t *testing.T) {
 	t.Run("frees slots at expiration", func(t *testing.T) {
 		config := defaultPlanConfig()
@@ -457,6 +610,131 @@ func TestGetSlotForValue(t *testing.T) {
 	})
 }
 
+func TestVerify(t *testing.T) {
+	t.Run("accepts well-formed state", func(t *testing.T) {
+		sm := newStateManager(defaultPlanConfig())
+		if _, err := sm.allocateLiteral(Uint256(big.NewInt(1))); err != nil {
+			t.Fatalf("allocateLiteral failed: %v", err)
+		}
+		if _, err := sm.allocateLiteral(String("a string longer than thirty-two bytes")); err != nil {
+			t.Fatalf("allocateLiteral failed: %v", err)
+		}
+		if _, err := sm.allocateReturn(&Command{}, 0, false); err != nil {
+			t.Fatalf("allocateReturn failed: %v", err)
+		}
+
+		if err := sm.verify(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("detects corrupted static slot", func(t *testing.T) {
+		sm := newStateManager(defaultPlanConfig())
+		slot, err := sm.allocateLiteral(Uint256(big.NewInt(1)))
+		if err != nil {
+			t.Fatalf("allocateLiteral failed: %v", err)
+		}
+		sm.state[slot] = make([]byte, 16)
+
+		if err := sm.verify(); !errors.Is(err, ErrStaticSlotSize) {
+			t.Errorf("Expected ErrStaticSlotSize, got %v", err)
+		}
+	})
+
+	t.Run("dynamic slots are exempt", func(t *testing.T) {
+		sm := newStateManager(defaultPlanConfig())
+		slot, err := sm.allocateLiteral(Bytes([]byte{1, 2, 3}))
+		if err != nil {
+			t.Fatalf("allocateLiteral failed: %v", err)
+		}
+		sm.state[SlotIndex(slot).Index()] = make([]byte, 16)
+
+		if err := sm.verify(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("plan rejects oversized static literal", func(t *testing.T) {
+		lib := NewLibrary(common.HexToAddress("0x01"), MustParseABI(`[{
+			"name": "pair",
+			"type": "function",
+			"stateMutability": "pure",
+			"inputs": [{"name": "xs", "type": "uint256[2]"}],
+			"outputs": []
+		}]`))
+
+		p := New()
+		p.Add(lib.MustInvoke("pair", [2]*big.Int{big.NewInt(1), big.NewInt(2)}))
+
+		if _, err := p.Plan(); !errors.Is(err, ErrStaticSlotSize) {
+			t.Errorf("Expected ErrStaticSlotSize, got %v", err)
+		}
+	})
+}
+
+func TestCheckLiveness(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	t.Run("optimized plans pass", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("add", a, big.NewInt(3)))
+		c := p.Add(lib.MustInvoke("add", b, big.NewInt(4)))
+		d := p.Add(lib.MustInvoke("multiply", a, c))
+
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", d))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		if _, err := p.Plan(WithSlotOptimization(true)); err != nil {
+			t.Errorf("Expected valid plan, got %v", err)
+		}
+	})
+
+	t.Run("detects a read of a reused slot", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		p.Add(lib.MustInvoke("multiply", a, b))
+
+		// Give both return values the same slot, as a faulty optimizer
+		// might, so b overwrites a before the final command reads it.
+		sm := newStateManager(defaultPlanConfig())
+		sm.returnSlotMap[a.command] = 5
+		sm.returnSlotMap[b.command] = 5
+
+		err := sm.checkLiveness(p.commands)
+		if !errors.Is(err, ErrStaleSlotRead) {
+			t.Fatalf("Expected ErrStaleSlotRead, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 2 {
+			t.Errorf("Expected PlanError for command 2, got %v", err)
+		}
+	})
+
+	t.Run("detects a stale read inside a subplan", func(t *testing.T) {
+		p := New()
+		a := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		b := p.Add(lib.MustInvoke("add", big.NewInt(3), big.NewInt(4)))
+		sub := New()
+		sub.Add(lib.MustInvoke("noReturn", a))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		sm := newStateManager(defaultPlanConfig())
+		sm.returnSlotMap[a.command] = 0
+		sm.returnSlotMap[b.command] = 0
+
+		if err := sm.checkLiveness(p.commands); !errors.Is(err, ErrStaleSlotRead) {
+			t.Errorf("Expected ErrStaleSlotRead, got %v", err)
+		}
+	})
+}
+
 func TestFinalize(t *testing.T) {
 	t.Run("returns empty state for no allocations", func(t *testing.T) {
 		config := defaultPlanConfig()
@@ -575,6 +853,42 @@ func TestSlotRecyclingIntegration(t *testing.T) {
 			t.Errorf("Expected reused slot 0, got %d", newSlot)
 		}
 	})
+
+	t.Run("literals never take recycled slots", func(t *testing.T) {
+		config := defaultPlanConfig()
+		config.optimizeSlots = true
+		sm := newStateManager(config)
+
+		sm.allocateReturn(&Command{}, 0, false)
+		sm.expireSlots(0)
+
+		slot, err := sm.allocateLiteral(Uint256(big.NewInt(99)))
+		if err != nil {
+			t.Fatalf("allocateLiteral failed: %v", err)
+		}
+		if slot == 0 {
+			t.Error("Expected literal in a fresh slot, not the recycled return slot")
+		}
+		if len(sm.freeSlots) != 1 {
+			t.Errorf("Expected recycled slot to stay free, got %v", sm.freeSlots)
+		}
+	})
+
+	t.Run("mutable literals get private slots", func(t *testing.T) {
+		sm := newStateManager(defaultPlanConfig())
+		shared := Uint256(big.NewInt(7))
+		mutable := New().AddMutableLiteral(shared)
+
+		sharedSlot, _ := sm.allocateLiteral(shared)
+		mutableSlot, _ := sm.allocateLiteral(mutable)
+		again, _ := sm.allocateLiteral(mutable)
+		if mutableSlot == sharedSlot {
+			t.Error("Expected mutable literal not to be deduplicated")
+		}
+		if again != mutableSlot {
+			t.Errorf("Expected repeated use to share slot %d, got %d", mutableSlot, again)
+		}
+	})
 }
 
 func TestDynamicValueSlots(t *testing.T) {
@@ -622,3 +936,38 @@ func TestDynamicValueSlots(t *testing.T) {
 		}
 	})
 }
+
+// keccakLiteralKey is a hash-based literal deduplication key.
+func keccakLiteralKey(data []byte) string {
+	return string(crypto.Keccak256(data))
+}
+
+// BenchmarkLiteralDeduplication compiles a plan with many large distinct
+// literals, comparing raw-byte keys with hashed keys.
+func BenchmarkLiteralDeduplication(b *testing.B) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), testABI())
+
+	p := New()
+	for i := 0; i < 60; i++ {
+		data := make([]byte, 8192)
+		data[0] = byte(i)
+		p.Add(lib.MustInvoke("dynamicArgs", "", data))
+	}
+
+	for _, bc := range []struct {
+		name string
+		opts []PlanOption
+	}{
+		{"raw", nil},
+		{"keccak", []PlanOption{WithLiteralHasher(keccakLiteralKey)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Plan(bc.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package weiroll
+
+import "fmt"
+
+// CommandWriter receives the encoded commands of PlanStream.
+type CommandWriter interface {
+	// WriteCommand is called once per top-level command, in order, with
+	// its 32-byte (or 64-byte extended) encoding. The slice is not reused.
+	WriteCommand(cmd []byte) error
+}
+
+// StateResult is the part of a compiled plan that PlanStream returns
+// rather than writes.
+type StateResult struct {
+	State        [][]byte
+	CommandCount int // Number of commands written
+}
+
+// PlanStream is like Plan but writes each command to w as soon as it is
+// encoded instead of collecting them, for generating very large plans. The
+// visibility analysis still runs over the whole plan first, and the state
+// is only known at the end, so it is returned once every command has been
+// written. On error, commands already written must be discarded. Errors
+// from w are returned as is.
+func (p *Planner) PlanStream(w CommandWriter, opts ...PlanOption) (*StateResult, error) {
+	cfg := defaultPlanConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	count, commandBytes := 0, 0
+	state, err := p.compileTo(cfg, nil, func(cmd []byte) error {
+		count++
+		commandBytes += (len(cmd) + 31) / 32 * 32
+		return w.WriteCommand(cmd)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.maxCalldata > 0 {
+		if size := calldataSize(commandBytes, state); size > cfg.maxCalldata {
+			return nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrCalldataTooLarge, size, cfg.maxCalldata)
+		}
+	}
+
+	return &StateResult{State: state, CommandCount: count}, nil
+}
@@ -0,0 +1,151 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// commandCollector is a CommandWriter that keeps every command.
+type commandCollector struct {
+	commands [][]byte
+	failAt   int // Fail the write of this command, if positive
+}
+
+func (c *commandCollector) WriteCommand(cmd []byte) error {
+	if c.failAt > 0 && len(c.commands)+1 == c.failAt {
+		return errors.New("write failed")
+	}
+	c.commands = append(c.commands, cmd)
+	return nil
+}
+
+// commandCounter is a CommandWriter that only counts commands.
+type commandCounter int
+
+func (c *commandCounter) WriteCommand([]byte) error {
+	*c++
+	return nil
+}
+
+// buildStreamPlan builds a chain of n commands that each read the previous
+// command's return value. Plans over 256 commands need WithMaxCommands.
+func buildStreamPlan(n int) *Planner {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+	p := New()
+	acc := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	for i := 1; i < n; i++ {
+		acc = p.Add(lib.MustInvoke("add", acc, big.NewInt(int64(i%8))))
+	}
+	return p
+}
+
+func TestPlannerPlanStream(t *testing.T) {
+	t.Run("matches Plan", func(t *testing.T) {
+		p := buildStreamPlan(50)
+		opts := []PlanOption{WithTerminatorCommand([4]byte{0xde, 0xad, 0xbe, 0xef}, common.HexToAddress("0x1111111111111111111111111111111111111111"))}
+
+		plan, err := p.Plan(opts...)
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		var w commandCollector
+		result, err := p.PlanStream(&w, opts...)
+		if err != nil {
+			t.Fatalf("PlanStream failed: %v", err)
+		}
+
+		if result.CommandCount != len(plan.Commands) {
+			t.Errorf("Expected %d commands, got %d", len(plan.Commands), result.CommandCount)
+		}
+		if !equalByteSlices(w.commands, plan.Commands) {
+			t.Error("Expected streamed commands to match Plan")
+		}
+		if !equalByteSlices(result.State, plan.State) {
+			t.Error("Expected streamed state to match Plan")
+		}
+	})
+
+	t.Run("returns writer errors", func(t *testing.T) {
+		p := buildStreamPlan(5)
+		w := commandCollector{failAt: 3}
+		if _, err := p.PlanStream(&w); err == nil || err.Error() != "write failed" {
+			t.Errorf("Expected writer error, got %v", err)
+		}
+		if len(w.commands) != 2 {
+			t.Errorf("Expected 2 commands written before the error, got %d", len(w.commands))
+		}
+	})
+
+	t.Run("applies calldata limit", func(t *testing.T) {
+		p := buildStreamPlan(5)
+		var w commandCounter
+		if _, err := p.PlanStream(&w, WithMaxCalldataBytes(100)); !errors.Is(err, ErrCalldataTooLarge) {
+			t.Errorf("Expected ErrCalldataTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("uses less memory than Plan", func(t *testing.T) {
+		p := buildStreamPlan(2000)
+		opt := WithMaxCommands(2000)
+		allocated := func(compile func()) uint64 {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			compile()
+			runtime.ReadMemStats(&after)
+			return after.TotalAlloc - before.TotalAlloc
+		}
+
+		var plan *CompiledPlan
+		planBytes := allocated(func() {
+			var err error
+			if plan, err = p.Plan(opt); err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+		})
+		var w commandCounter
+		streamBytes := allocated(func() {
+			if _, err := p.PlanStream(&w, opt); err != nil {
+				t.Fatalf("PlanStream failed: %v", err)
+			}
+		})
+
+		if int(w) != len(plan.Commands) {
+			t.Errorf("Expected %d commands, got %d", len(plan.Commands), w)
+		}
+		if streamBytes >= planBytes {
+			t.Errorf("Expected PlanStream to allocate less than Plan, got %d vs %d bytes", streamBytes, planBytes)
+		}
+		t.Logf("Plan: %d bytes, PlanStream: %d bytes", planBytes, streamBytes)
+	})
+}
+
+// BenchmarkPlanStream compares the memory of Plan and PlanStream for a
+// 2000-command chain, streaming to a writer that keeps nothing.
+func BenchmarkPlanStream(b *testing.B) {
+	p := buildStreamPlan(2000)
+	opt := WithMaxCommands(2000)
+
+	b.Run("plan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Plan(opt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var w commandCounter
+			if _, err := p.PlanStream(&w, opt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
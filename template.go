@@ -0,0 +1,233 @@
+package weiroll
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// PlanTemplate is a planner whose command structure is fixed but whose
+// ParamValue placeholders are filled in at instantiation time.
+//
+// Build the planner as usual, passing Param("amount", "uint256") wherever a
+// literal would go, then call Instantiate once per parameter set.
+type PlanTemplate struct {
+	planner *Planner
+	params  map[string]abi.Type
+	opts    []PlanOption
+}
+
+// NewPlanTemplate creates a template from a planner containing ParamValue
+// arguments, including those of commands in subplans. The options are
+// applied on every instantiation.
+// Returns an error if one name is used with two different types.
+func NewPlanTemplate(p *Planner, opts ...PlanOption) (*PlanTemplate, error) {
+	params := make(map[string]abi.Type)
+
+	var err error
+	p.walkTopLevel(func(i int, cmd *Command) {
+		if err != nil {
+			return
+		}
+		for _, arg := range cmd.call.values() {
+			param, ok := arg.(*ParamValue)
+			if !ok {
+				continue
+			}
+			if existing, seen := params[param.name]; seen && existing.String() != param.abiType.String() {
+				err = &PlanError{
+					CommandIndex: i,
+					Method:       cmd.call.method.Name,
+					Err: &TypeMismatchError{
+						Expected: existing.String(),
+						Got:      param.abiType.String(),
+					},
+				}
+				return
+			}
+			params[param.name] = param.abiType
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlanTemplate{
+		planner: p,
+		params:  params,
+		opts:    opts,
+	}, nil
+}
+
+// Params returns the template's parameter names in sorted order.
+func (t *PlanTemplate) Params() []string {
+	names := make([]string, 0, len(t.params))
+	for name := range t.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Instantiate binds every parameter to a concrete value and compiles the plan.
+// Values are converted like any other literal argument. Every parameter must
+// be bound, and names that are not parameters of the template are rejected.
+func (t *PlanTemplate) Instantiate(values map[string]any) (*CompiledPlan, error) {
+	bound := make(map[string]*LiteralValue, len(t.params))
+
+	for name, abiType := range t.params {
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnboundParam, name)
+		}
+		lit, err := NewLiteral(abiType, value)
+		if err != nil {
+			return nil, fmt.Errorf("weiroll: parameter %q: %w", name, err)
+		}
+		bound[name] = lit
+	}
+
+	for name := range values {
+		if _, ok := t.params[name]; !ok {
+			return nil, fmt.Errorf("weiroll: unknown template parameter %q", name)
+		}
+	}
+
+	cfg := defaultPlanConfig()
+	for _, opt := range t.opts {
+		opt(cfg)
+	}
+
+	return t.planner.compile(cfg, bound)
+}
+
+// CompiledTemplate is a compiled plan whose literal state slots can be
+// rebound to new values without recompiling, for parameter sweeps where
+// only literal values change between runs. Create one with
+// Planner.CompileTemplate.
+type CompiledTemplate struct {
+	plan     *CompiledPlan
+	literals map[int]abi.Type      // Rebindable slot to the type of its literal
+	slots    map[*LiteralValue]int // Literal argument to its slot
+	cfg      *planConfig
+}
+
+// CompileTemplate compiles the plan like Plan and records which state slots
+// hold literal arguments of top-level commands, so that CompiledTemplate
+// can rebind them. Each of those literals gets a slot of its own rather
+// than sharing one with an equal literal, so rebinding it changes nothing
+// else. It fails with ErrPlanMismatch if WithCommandDeduplication drops
+// commands.
+func (p *Planner) CompileTemplate(opts ...PlanOption) (*CompiledTemplate, error) {
+	cfg := defaultPlanConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.private = make(map[*LiteralValue]bool)
+	for _, cmd := range p.commands {
+		for _, v := range cmd.call.values() {
+			if lit, ok := v.(*LiteralValue); ok {
+				cfg.private[lit] = true
+			}
+		}
+	}
+	cp, err := p.compile(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	n := len(cp.Commands)
+	if cfg.terminator != nil {
+		n--
+	}
+	if n != p.Len() {
+		return nil, fmt.Errorf("%w: %d compiled commands, %d planned",
+			ErrPlanMismatch, n, p.Len())
+	}
+
+	t := &CompiledTemplate{
+		plan:     cp,
+		literals: make(map[int]abi.Type),
+		slots:    make(map[*LiteralValue]int),
+		cfg:      cfg,
+	}
+	enc := cp.encoding()
+	for i, cmd := range p.commands {
+		_, flags, argSlots, _, _, err := DecodeCommandWithConfig(cp.Commands[i], enc)
+		if err != nil {
+			return nil, cmd.planError(i, err)
+		}
+		_, argSlots, _ = SplitValueSlot(flags, argSlots)
+		for j, v := range cmd.call.values() {
+			if lit, ok := v.(*LiteralValue); ok && j < len(argSlots) {
+				slot := int(enc.slotIndex(argSlots[j]))
+				t.literals[slot] = lit.abiType
+				t.slots[lit] = slot
+			}
+		}
+	}
+	return t, nil
+}
+
+// Plan returns the plan as originally compiled.
+func (t *CompiledTemplate) Plan() *CompiledPlan {
+	return t.plan
+}
+
+// LiteralSlots returns the rebindable state slots in ascending order.
+func (t *CompiledTemplate) LiteralSlots() []int {
+	slots := make([]int, 0, len(t.literals))
+	for slot := range t.literals {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// SlotOf returns the state slot holding lit, which must be a literal
+// argument of a top-level command of the compiled planner. Only arguments
+// passing this same *LiteralValue share the slot.
+func (t *CompiledTemplate) SlotOf(lit *LiteralValue) (int, bool) {
+	slot, ok := t.slots[lit]
+	return slot, ok
+}
+
+// Rebind returns a copy of the plan with the given state slots set to new
+// values, converted like any other literal argument to the type of the
+// literal originally in the slot. The commands are shared with the
+// original plan, and the other slots are unchanged. Slots not returned by
+// LiteralSlots are rejected with ErrNonLiteralArgument.
+func (t *CompiledTemplate) Rebind(literals map[int]any) (*CompiledPlan, error) {
+	state := make([][]byte, len(t.plan.State))
+	copy(state, t.plan.State)
+
+	for slot, value := range literals {
+		abiType, ok := t.literals[slot]
+		if !ok {
+			return nil, fmt.Errorf("%w: slot %d", ErrNonLiteralArgument, slot)
+		}
+		lit, err := NewLiteral(abiType, value)
+		if err != nil {
+			return nil, fmt.Errorf("weiroll: slot %d: %w", slot, err)
+		}
+		if limit := t.cfg.maxEntry; limit > 0 && len(lit.data) > limit {
+			return nil, &EncodingError{
+				Value: lit,
+				Err:   fmt.Errorf("%w: %s literal holds %d bytes (limit %d)", ErrStateEntryTooLarge, abiType.String(), len(lit.data), limit),
+			}
+		}
+		state[slot] = lit.data
+	}
+
+	plan := &CompiledPlan{
+		Commands: t.plan.Commands,
+		State:    state,
+		config:   t.plan.config,
+	}
+	if t.cfg.maxCalldata > 0 {
+		if size := plan.calldataSize(); size > t.cfg.maxCalldata {
+			return nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrCalldataTooLarge, size, t.cfg.maxCalldata)
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,335 @@
+package weiroll
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPlanTemplate(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	lib := NewLibrary(addr, plannerTestABI())
+
+	newTemplate := func(t *testing.T) *PlanTemplate {
+		t.Helper()
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", Param("amount", "uint256"), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, Param("amount", "uint256")))
+
+		tmpl, err := NewPlanTemplate(p)
+		if err != nil {
+			t.Fatalf("NewPlanTemplate failed: %v", err)
+		}
+		return tmpl
+	}
+
+	t.Run("instantiates distinct plans", func(t *testing.T) {
+		tmpl := newTemplate(t)
+
+		planA, err := tmpl.Instantiate(map[string]any{"amount": big.NewInt(100)})
+		if err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+		planB, err := tmpl.Instantiate(map[string]any{"amount": big.NewInt(200)})
+		if err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+
+		if len(planA.Commands) != len(planB.Commands) {
+			t.Fatal("Expected the same command structure")
+		}
+		for i := range planA.Commands {
+			if !bytes.Equal(planA.Commands[i], planB.Commands[i]) {
+				t.Errorf("Command %d should not depend on parameter values", i)
+			}
+		}
+
+		if !stateContains(planA.State, big.NewInt(100)) || stateContains(planA.State, big.NewInt(200)) {
+			t.Error("Expected only amount 100 in first plan's state")
+		}
+		if !stateContains(planB.State, big.NewInt(200)) || stateContains(planB.State, big.NewInt(100)) {
+			t.Error("Expected only amount 200 in second plan's state")
+		}
+	})
+
+	t.Run("matches an equivalent literal plan", func(t *testing.T) {
+		tmpl := newTemplate(t)
+		fromTemplate, err := tmpl.Instantiate(map[string]any{"amount": big.NewInt(7)})
+		if err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(7), big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(7)))
+		direct, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		if len(fromTemplate.State) != len(direct.State) {
+			t.Fatalf("Expected %d state slots, got %d", len(direct.State), len(fromTemplate.State))
+		}
+		for i := range direct.Commands {
+			if !bytes.Equal(fromTemplate.Commands[i], direct.Commands[i]) {
+				t.Errorf("Command %d mismatch", i)
+			}
+		}
+	})
+
+	t.Run("params are listed", func(t *testing.T) {
+		tmpl := newTemplate(t)
+		params := tmpl.Params()
+		if len(params) != 1 || params[0] != "amount" {
+			t.Errorf("Expected [amount], got %v", params)
+		}
+	})
+
+	t.Run("missing parameter", func(t *testing.T) {
+		tmpl := newTemplate(t)
+		_, err := tmpl.Instantiate(map[string]any{})
+		if !errors.Is(err, ErrUnboundParam) {
+			t.Errorf("Expected ErrUnboundParam, got %v", err)
+		}
+	})
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		tmpl := newTemplate(t)
+		_, err := tmpl.Instantiate(map[string]any{"amount": big.NewInt(1), "amonut": big.NewInt(1)})
+		if err == nil {
+			t.Error("Expected error for unknown parameter")
+		}
+	})
+
+	t.Run("conflicting parameter types", func(t *testing.T) {
+		other := NewLibrary(addr, testABI())
+		p := New()
+		p.Add(lib.MustInvoke("add", Param("x", "uint256"), big.NewInt(1)))
+		p.Add(other.MustInvoke("dynamicArgs", Param("x", "string"), []byte{}))
+
+		_, err := NewPlanTemplate(p)
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("Expected TypeMismatchError, got %v", err)
+		}
+	})
+
+	t.Run("binds parameters inside subplans", func(t *testing.T) {
+		p := New()
+		sub := New()
+		sub.Add(lib.MustInvoke("add", Param("fee", "uint256"), big.NewInt(2)))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		tmpl, err := NewPlanTemplate(p)
+		if err != nil {
+			t.Fatalf("NewPlanTemplate failed: %v", err)
+		}
+		if params := tmpl.Params(); len(params) != 1 || params[0] != "fee" {
+			t.Fatalf("Expected [fee], got %v", params)
+		}
+		plan, err := tmpl.Instantiate(map[string]any{"fee": big.NewInt(42)})
+		if err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+		if !stateContains(plan.State, big.NewInt(42)) {
+			t.Error("Expected the subplan parameter in state")
+		}
+	})
+
+	t.Run("conflicting parameter types across a subplan", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", Param("x", "uint256"), big.NewInt(1)))
+		sub := New()
+		sub.Add(NewLibrary(addr, testABI()).MustInvoke("dynamicArgs", Param("x", "string"), []byte{}))
+		if _, err := p.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), p.State()), sub); err != nil {
+			t.Fatalf("AddSubplan failed: %v", err)
+		}
+
+		_, err := NewPlanTemplate(p)
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 {
+			t.Fatalf("Expected a PlanError at command 1, got %v", err)
+		}
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("Expected TypeMismatchError, got %v", err)
+		}
+	})
+
+	t.Run("Plan rejects unbound parameters", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", Param("amount", "uint256"), big.NewInt(2)))
+
+		_, err := p.Plan()
+		if !errors.Is(err, ErrUnboundParam) {
+			t.Errorf("Expected ErrUnboundParam, got %v", err)
+		}
+	})
+}
+
+// stateContains reports whether any state slot holds the given uint256.
+func stateContains(state [][]byte, v *big.Int) bool {
+	for _, slot := range state {
+		if len(slot) == 32 && new(big.Int).SetBytes(slot).Cmp(v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompiledTemplate(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	newTemplate := func(t *testing.T, amount *LiteralValue) (*Planner, *CompiledTemplate) {
+		t.Helper()
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", amount, big.NewInt(2)))
+		p.Add(lib.MustInvoke("multiply", sum, big.NewInt(3)))
+
+		tmpl, err := p.CompileTemplate()
+		if err != nil {
+			t.Fatalf("CompileTemplate failed: %v", err)
+		}
+		return p, tmpl
+	}
+
+	t.Run("rebind matches a full compile", func(t *testing.T) {
+		amount := Uint256(big.NewInt(100))
+		_, tmpl := newTemplate(t, amount)
+		slot, ok := tmpl.SlotOf(amount)
+		if !ok {
+			t.Fatal("Expected amount to have a slot")
+		}
+
+		rebound, err := tmpl.Rebind(map[int]any{slot: big.NewInt(500)})
+		if err != nil {
+			t.Fatalf("Rebind failed: %v", err)
+		}
+		_, fresh := newTemplate(t, Uint256(big.NewInt(500)))
+		expected := fresh.Plan()
+		if !equalByteSlices(rebound.Commands, expected.Commands) || !equalByteSlices(rebound.State, expected.State) {
+			t.Error("Expected rebound plan to match a plan compiled with the new literal")
+		}
+
+		// The original plan is unchanged
+		if !stateContains(tmpl.Plan().State, big.NewInt(100)) || stateContains(tmpl.Plan().State, big.NewInt(500)) {
+			t.Error("Expected original plan state to keep amount 100")
+		}
+	})
+
+	t.Run("lists literal slots", func(t *testing.T) {
+		_, tmpl := newTemplate(t, Uint256(big.NewInt(100)))
+		if slots := tmpl.LiteralSlots(); len(slots) != 3 {
+			t.Errorf("Expected 3 literal slots, got %v", slots)
+		}
+	})
+
+	t.Run("rejects wrong types", func(t *testing.T) {
+		amount := Uint256(big.NewInt(100))
+		_, tmpl := newTemplate(t, amount)
+		slot, _ := tmpl.SlotOf(amount)
+
+		_, err := tmpl.Rebind(map[int]any{slot: "not a number"})
+		var encErr *EncodingError
+		if !errors.As(err, &encErr) {
+			t.Errorf("Expected EncodingError, got %v", err)
+		}
+	})
+
+	t.Run("rebinding leaves equal literals alone", func(t *testing.T) {
+		ext := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), plannerTestABI())
+		amount := Uint256(big.NewInt(1))
+		other := Uint256(big.NewInt(1))
+		p := New()
+		p.Add(ext.MustInvoke("add", amount, big.NewInt(2)).WithValue(big.NewInt(1)))
+		p.Add(lib.MustInvoke("add", other, big.NewInt(3)))
+
+		tmpl, err := p.CompileTemplate()
+		if err != nil {
+			t.Fatalf("CompileTemplate failed: %v", err)
+		}
+		slot, _ := tmpl.SlotOf(amount)
+		if otherSlot, _ := tmpl.SlotOf(other); otherSlot == slot {
+			t.Fatalf("Expected equal literals in separate slots, both got %d", slot)
+		}
+
+		rebound, err := tmpl.Rebind(map[int]any{slot: big.NewInt(500)})
+		if err != nil {
+			t.Fatalf("Rebind failed: %v", err)
+		}
+		if total, _ := rebound.RequiredValue(); total.Int64() != 1 {
+			t.Errorf("Expected the ETH value to stay 1, got %s", total)
+		}
+		otherSlot, _ := tmpl.SlotOf(other)
+		if got := new(big.Int).SetBytes(rebound.State[otherSlot]); got.Int64() != 1 {
+			t.Errorf("Expected the other literal to stay 1, got %s", got)
+		}
+	})
+
+	t.Run("rejects non-literal slots", func(t *testing.T) {
+		p, tmpl := newTemplate(t, Uint256(big.NewInt(100)))
+		_, _, _, returnSlot, _, _ := DecodeCommand(tmpl.Plan().Commands[0])
+		if _, err := tmpl.Rebind(map[int]any{int(returnSlot): big.NewInt(1)}); !errors.Is(err, ErrNonLiteralArgument) {
+			t.Errorf("Expected ErrNonLiteralArgument, got %v", err)
+		}
+		if p.Len() != 2 {
+			t.Errorf("Expected 2 commands, got %d", p.Len())
+		}
+	})
+}
+
+// BenchmarkCompiledTemplateRebind compares rebinding one literal of a
+// 30-command plan against building and compiling the plan again, over
+// 1000 parameter sets per iteration.
+func BenchmarkCompiledTemplateRebind(b *testing.B) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	build := func(amount *LiteralValue) *Planner {
+		p := New()
+		acc := p.Add(lib.MustInvoke("add", amount, big.NewInt(1)))
+		for i := 1; i < 30; i++ {
+			acc = p.Add(lib.MustInvoke("multiply", acc, big.NewInt(int64(i+1))))
+		}
+		return p
+	}
+
+	amounts := make([]*big.Int, 1000)
+	for i := range amounts {
+		amounts[i] = big.NewInt(int64(i) * 1e6)
+	}
+
+	b.Run("rebind", func(b *testing.B) {
+		amount := Uint256(big.NewInt(0))
+		tmpl, err := build(amount).CompileTemplate()
+		if err != nil {
+			b.Fatal(err)
+		}
+		slot, _ := tmpl.SlotOf(amount)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, a := range amounts {
+				if _, err := tmpl.Rebind(map[int]any{slot: a}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("recompile", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, a := range amounts {
+				if _, err := build(Uint256(a)).Plan(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,296 @@
+package weiroll
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AddBalanceTransfer adds the read-then-transfer pattern for an ERC20 token:
+// a STATICCALL to balanceOf(holder) followed by a CALL to
+// transfer(to, balance) that spends exactly the balance read. Both calls are
+// made directly on the token, so holder is normally the VM itself.
+//
+// token must be an external contract whose ABI has balanceOf(address)
+// returning uint256 and transfer(address,uint256); the call types are set
+// explicitly regardless of the contract's defaults. Returns the balance
+// for use by later commands.
+func (p *Planner) AddBalanceTransfer(token *Contract, holder, to Value) (*ReturnValue, error) {
+	if p.frozen {
+		return nil, ErrPlannerFrozen
+	}
+	if token.contractType == Library {
+		return nil, fmt.Errorf("%w: token must not be a library", ErrInvalidCallType)
+	}
+	if err := checkTokenMethod(token, "balanceOf", "balanceOf(address)", "uint256"); err != nil {
+		return nil, err
+	}
+	if err := checkTokenMethod(token, "transfer", "transfer(address,uint256)", ""); err != nil {
+		return nil, err
+	}
+
+	read, err := token.Invoke("balanceOf", holder)
+	if err != nil {
+		return nil, err
+	}
+	read.flags = (read.flags &^ FlagCallTypeMask) | FlagStaticCall
+
+	// The transfer is built before anything is added so that a bad
+	// argument leaves the planner untouched.
+	balance := &ReturnValue{abiType: *read.ReturnType()}
+	write, err := token.Invoke("transfer", to, balance)
+	if err != nil {
+		return nil, err
+	}
+	write.flags = (write.flags &^ FlagCallTypeMask) | FlagCall
+
+	balance.command = p.add(read, addConfig{}).command
+	p.add(write, addConfig{})
+	return balance, nil
+}
+
+// FeePaymentLabel is the label of commands added by AddFeePayment.
+const FeePaymentLabel = "fee"
+
+// AddFeePayment appends a CALL to transfer(feeCollector, amount) on token,
+// labelled FeePaymentLabel, for relayer-executed plans that pay their fee
+// from the VM. Call it after all other commands: Plan fails with
+// ErrFeePaymentNotLast if any command follows the fee.
+//
+// token must be an external contract whose ABI has
+// transfer(address,uint256); the call type is set explicitly regardless of
+// the contract's defaults.
+func (p *Planner) AddFeePayment(token *Contract, feeCollector common.Address, amount Value) error {
+	if p.frozen {
+		return ErrPlannerFrozen
+	}
+	if token.contractType == Library {
+		return fmt.Errorf("%w: token must not be a library", ErrInvalidCallType)
+	}
+	if err := checkTokenMethod(token, "transfer", "transfer(address,uint256)", ""); err != nil {
+		return err
+	}
+
+	call, err := token.Invoke("transfer", feeCollector, amount)
+	if err != nil {
+		return err
+	}
+	call.flags = (call.flags &^ FlagCallTypeMask) | FlagCall
+
+	p.add(call, addConfig{label: FeePaymentLabel})
+	return nil
+}
+
+// PermitArgs are the arguments of an EIP-2612
+// permit(owner, spender, value, deadline, v, r, s) call, with the signature
+// split into its components.
+type PermitArgs struct {
+	Owner    common.Address
+	Spender  common.Address
+	Value    *big.Int
+	Deadline *big.Int
+	V        uint8
+	R        [32]byte
+	S        [32]byte
+}
+
+// AddPermit adds a CALL to the EIP-2612
+// permit(address,address,uint256,uint256,uint8,bytes32,bytes32) method of
+// token, with every argument a literal, so that a later command can spend
+// the approved allowance without a separate approval transaction. token
+// must be an external contract with that method; the call type is set
+// explicitly regardless of the contract's defaults.
+func (p *Planner) AddPermit(token *Contract, args PermitArgs) error {
+	if p.frozen {
+		return ErrPlannerFrozen
+	}
+	if token.contractType == Library {
+		return fmt.Errorf("%w: token must not be a library", ErrInvalidCallType)
+	}
+	if err := checkTokenMethod(token, "permit", "permit(address,address,uint256,uint256,uint8,bytes32,bytes32)", ""); err != nil {
+		return err
+	}
+
+	call, err := token.Invoke("permit", args.Owner, args.Spender, args.Value, args.Deadline, args.V, args.R, args.S)
+	if err != nil {
+		return err
+	}
+	call.flags = (call.flags &^ FlagCallTypeMask) | FlagCall
+
+	p.add(call, addConfig{})
+	return nil
+}
+
+// checkTokenMethod verifies that token has method name with the given
+// signature and, if output is non-empty, a single return value of that type.
+func checkTokenMethod(token *Contract, name, sig, output string) error {
+	method, ok := token.abi.Methods[name]
+	if !ok {
+		return token.methodNotFound(name)
+	}
+	if method.Sig != sig {
+		return &TypeMismatchError{Expected: sig, Got: method.Sig}
+	}
+	if output == "" {
+		return nil
+	}
+	if len(method.Outputs) != 1 {
+		return &TypeMismatchError{Expected: output, Got: fmt.Sprintf("%d return values", len(method.Outputs))}
+	}
+	if got := method.Outputs[0].Type.String(); got != output {
+		return &TypeMismatchError{Expected: output, Got: got}
+	}
+	return nil
+}
+
+// Approval is an ERC20 allowance a plan grants or depends on. See
+// Planner.RequiredApprovals.
+type Approval struct {
+	// CommandIndex is the top-level command making the call; for a call
+	// inside a subplan, the command that executes the subplan.
+	CommandIndex int
+
+	Token common.Address // Zero for a token whose address is only known at runtime
+
+	// Owner is the from address of a transferFrom call. It is zero for an
+	// approve call, whose owner is the VM executing the plan, or if the
+	// address is only known at runtime.
+	Owner common.Address
+
+	// Spender is the spender of an approve call. It is zero for a
+	// transferFrom call, whose spender is the VM executing the plan, or if
+	// the address is only known at runtime.
+	Spender common.Address
+
+	Amount *big.Int // nil if the amount is only known at runtime
+
+	// Explicit is true if the plan grants the approval itself with approve,
+	// and false if it calls transferFrom and so needs Owner to have
+	// approved the VM before execution.
+	Explicit bool
+}
+
+// RequiredApprovals scans the plan, including subplans, for ERC20
+// approve(address,uint256) and transferFrom(address,address,uint256)
+// calls, in command order. Methods are recognized by signature, so any
+// contract with a matching method is treated as a token.
+func (p *Planner) RequiredApprovals() []Approval {
+	var approvals []Approval
+	p.walkTopLevel(func(index int, cmd *Command) {
+		call := cmd.call
+		args := call.args
+		switch call.method.Sig {
+		case "approve(address,uint256)":
+			approvals = append(approvals, Approval{
+				CommandIndex: index,
+				Token:        call.contract.Address(),
+				Spender:      literalAddress(args[0]),
+				Amount:       literalUint(args[1]),
+				Explicit:     true,
+			})
+		case "transferFrom(address,address,uint256)":
+			approvals = append(approvals, Approval{
+				CommandIndex: index,
+				Token:        call.contract.Address(),
+				Owner:        literalAddress(args[0]),
+				Amount:       literalUint(args[2]),
+			})
+		}
+	})
+	return approvals
+}
+
+// FlowDirection is the kind of token movement a TokenFlow describes.
+type FlowDirection uint8
+
+const (
+	// FlowOut is a transfer from the VM to Counterparty.
+	FlowOut FlowDirection = iota
+
+	// FlowPull is a transferFrom moving tokens from Counterparty to
+	// Recipient, spending Counterparty's allowance to the VM.
+	FlowPull
+
+	// FlowApproval is an approve letting Counterparty spend the VM's tokens.
+	FlowApproval
+)
+
+// TokenFlow is a token movement or allowance in a plan. See
+// Planner.TokenFlows.
+type TokenFlow struct {
+	// CommandIndex is the top-level command making the call; for a call
+	// inside a subplan, the command that executes the subplan.
+	CommandIndex int
+
+	Direction FlowDirection
+
+	// Counterparty is the recipient of a transfer, the owner of a
+	// transferFrom, or the spender of an approve. It is zero if the
+	// address is only known at runtime.
+	Counterparty common.Address
+
+	// Recipient is the to address of a transferFrom, zero otherwise or if
+	// it is only known at runtime.
+	Recipient common.Address
+
+	Amount *big.Int // nil if the amount is only known at runtime
+}
+
+// TokenFlows scans the plan, including subplans, for ERC20
+// transfer(address,uint256), transferFrom(address,address,uint256) and
+// approve(address,uint256) calls on token, in command order. Calls on
+// dynamic contracts are not matched, since their address isn't known.
+func (p *Planner) TokenFlows(token common.Address) []TokenFlow {
+	var flows []TokenFlow
+	p.walkTopLevel(func(index int, cmd *Command) {
+		call := cmd.call
+		if call.contract.IsDynamic() || call.contract.Address() != token {
+			return
+		}
+		args := call.args
+		switch call.method.Sig {
+		case "transfer(address,uint256)":
+			flows = append(flows, TokenFlow{
+				CommandIndex: index,
+				Direction:    FlowOut,
+				Counterparty: literalAddress(args[0]),
+				Amount:       literalUint(args[1]),
+			})
+		case "transferFrom(address,address,uint256)":
+			flows = append(flows, TokenFlow{
+				CommandIndex: index,
+				Direction:    FlowPull,
+				Counterparty: literalAddress(args[0]),
+				Recipient:    literalAddress(args[1]),
+				Amount:       literalUint(args[2]),
+			})
+		case "approve(address,uint256)":
+			flows = append(flows, TokenFlow{
+				CommandIndex: index,
+				Direction:    FlowApproval,
+				Counterparty: literalAddress(args[0]),
+				Amount:       literalUint(args[1]),
+			})
+		}
+	})
+	return flows
+}
+
+// literalAddress returns the address held by a literal, or the zero address
+// for a value only known at runtime.
+func literalAddress(v Value) common.Address {
+	if lit, ok := v.(*LiteralValue); ok {
+		return common.BytesToAddress(lit.data)
+	}
+	return common.Address{}
+}
+
+// literalUint returns the integer held by a literal, or nil for a value only
+// known at runtime.
+func literalUint(v Value) *big.Int {
+	if lit, ok := v.(*LiteralValue); ok {
+		return new(big.Int).SetBytes(lit.data)
+	}
+	return nil
+}
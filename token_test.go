@@ -0,0 +1,407 @@
+package weiroll
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const erc20TestABIJSON = `[
+	{"type":"function","name":"balanceOf","stateMutability":"view",
+	 "inputs":[{"name":"account","type":"address"}],
+	 "outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"transfer","stateMutability":"nonpayable",
+	 "inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],
+	 "outputs":[{"name":"","type":"bool"}]}
+]`
+
+func TestPlannerAddBalanceTransfer(t *testing.T) {
+	tokenAddr := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	vm := MustLiteralFromType("address", common.HexToAddress("0x1111111111111111111111111111111111111111"))
+	to := MustLiteralFromType("address", common.HexToAddress("0x2222222222222222222222222222222222222222"))
+
+	t.Run("static read feeds regular transfer", func(t *testing.T) {
+		// WithStaticCalls would otherwise make the transfer a STATICCALL.
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON), WithStaticCalls())
+		p := New()
+		balance, err := p.AddBalanceTransfer(token, vm, to)
+		if err != nil {
+			t.Fatalf("AddBalanceTransfer failed: %v", err)
+		}
+		if p.Len() != 2 {
+			t.Fatalf("Expected 2 commands, got %d", p.Len())
+		}
+		if balance.Command() != p.CommandAt(0) {
+			t.Error("Expected balance to come from the read command")
+		}
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, readFlags, _, returnSlot, _, err := DecodeCommand(plan.Commands[0])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if readFlags.CallType() != FlagStaticCall {
+			t.Errorf("Expected read to be STATICCALL, got %d", readFlags.CallType())
+		}
+
+		_, writeFlags, argSlots, _, _, err := DecodeCommand(plan.Commands[1])
+		if err != nil {
+			t.Fatalf("DecodeCommand failed: %v", err)
+		}
+		if writeFlags.CallType() != FlagCall {
+			t.Errorf("Expected write to be CALL, got %d", writeFlags.CallType())
+		}
+		if len(argSlots) != 2 || argSlots[1] != returnSlot {
+			t.Errorf("Expected transfer amount from slot %d, got %v", returnSlot, argSlots)
+		}
+	})
+
+	t.Run("rejects library token", func(t *testing.T) {
+		token := NewLibrary(tokenAddr, MustParseABI(erc20TestABIJSON))
+		_, err := New().AddBalanceTransfer(token, vm, to)
+		if !errors.Is(err, ErrInvalidCallType) {
+			t.Errorf("Expected ErrInvalidCallType, got %v", err)
+		}
+	})
+
+	t.Run("rejects non-ERC20 ABI", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(testABIJSON))
+		p := New()
+		_, err := p.AddBalanceTransfer(token, vm, to)
+
+		var notFound *MethodNotFoundError
+		if !errors.As(err, &notFound) || notFound.Method != "balanceOf" {
+			t.Errorf("Expected MethodNotFoundError for balanceOf, got %v", err)
+		}
+		if p.Len() != 0 {
+			t.Error("Expected planner to be unchanged")
+		}
+	})
+
+	t.Run("rejects wrong argument types", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON))
+		p := New()
+		_, err := p.AddBalanceTransfer(token, vm, MustLiteralFromType("uint256", 1))
+
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) {
+			t.Errorf("Expected ArgumentError, got %v", err)
+		}
+		if p.Len() != 0 {
+			t.Error("Expected planner to be unchanged")
+		}
+	})
+}
+
+func TestPlannerAddFeePayment(t *testing.T) {
+	tokenAddr := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	collector := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	to := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	t.Run("appends labelled fee transfer last", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON), WithStaticCalls())
+		p := New()
+		p.Add(token.MustInvoke("transfer", to, big.NewInt(1000)))
+		if err := p.AddFeePayment(token, collector, Uint256(big.NewInt(5))); err != nil {
+			t.Fatalf("AddFeePayment failed: %v", err)
+		}
+		if p.Len() != 2 {
+			t.Fatalf("Expected 2 commands, got %d", p.Len())
+		}
+
+		fee := p.CommandAt(1)
+		if fee.Label() != FeePaymentLabel || p.CommandAt(0).Label() != "" {
+			t.Errorf("Expected only the fee command to be labelled, got %q and %q", p.CommandAt(0).Label(), fee.Label())
+		}
+		if fee.Call().Flags().CallType() != FlagCall {
+			t.Errorf("Expected fee to be CALL, got %d", fee.Call().Flags().CallType())
+		}
+
+		flows := p.TokenFlows(tokenAddr)
+		if len(flows) != 2 || flows[1].Counterparty != collector || flows[1].Amount.Int64() != 5 {
+			t.Errorf("Expected fee of 5 to the collector last, got %+v", flows)
+		}
+
+		desc := p.Describe()
+		if desc.Commands[1].Label != FeePaymentLabel {
+			t.Errorf("Expected description label %q, got %q", FeePaymentLabel, desc.Commands[1].Label)
+		}
+		if md := p.ToMarkdown(); !strings.Contains(md, "| 1 (fee) |") {
+			t.Errorf("Expected markdown to show the fee label, got:\n%s", md)
+		}
+	})
+
+	t.Run("Plan rejects commands after the fee", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON))
+		p := New()
+		if err := p.AddFeePayment(token, collector, Uint256(big.NewInt(5))); err != nil {
+			t.Fatalf("AddFeePayment failed: %v", err)
+		}
+		if _, err := p.Plan(); err != nil {
+			t.Fatalf("Plan failed with the fee last: %v", err)
+		}
+
+		p.Add(token.MustInvoke("transfer", to, big.NewInt(1000)))
+		_, err := p.Plan()
+		if !errors.Is(err, ErrFeePaymentNotLast) {
+			t.Fatalf("Expected ErrFeePaymentNotLast, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+			t.Errorf("Expected PlanError for command 0, got %v", err)
+		}
+	})
+
+	t.Run("add hooks see the fee label", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON))
+		var labels []string
+		p := New(WithAddHook(func(cmd *Command) {
+			labels = append(labels, cmd.Label())
+		}))
+		if err := p.AddFeePayment(token, collector, Uint256(big.NewInt(5))); err != nil {
+			t.Fatalf("AddFeePayment failed: %v", err)
+		}
+		if len(labels) != 1 || labels[0] != FeePaymentLabel {
+			t.Errorf("Expected hook to see label %q, got %q", FeePaymentLabel, labels)
+		}
+	})
+
+	t.Run("rejects non-ERC20 ABI", func(t *testing.T) {
+		token := NewContract(tokenAddr, plannerTestABI())
+		p := New()
+		err := p.AddFeePayment(token, collector, Uint256(big.NewInt(5)))
+
+		var notFound *MethodNotFoundError
+		if !errors.As(err, &notFound) || notFound.Method != "transfer" {
+			t.Errorf("Expected MethodNotFoundError for transfer, got %v", err)
+		}
+		if p.Len() != 0 {
+			t.Error("Expected planner to be unchanged")
+		}
+	})
+
+	t.Run("frozen planner", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON))
+		p := New()
+		p.Freeze()
+		if err := p.AddFeePayment(token, collector, Uint256(big.NewInt(5))); !errors.Is(err, ErrPlannerFrozen) {
+			t.Errorf("Expected ErrPlannerFrozen, got %v", err)
+		}
+	})
+}
+
+func TestPlannerRequiredApprovals(t *testing.T) {
+	const approvalABIJSON = `[
+		{"type":"function","name":"balanceOf","stateMutability":"view",
+		 "inputs":[{"name":"account","type":"address"}],
+		 "outputs":[{"name":"","type":"uint256"}]},
+		{"type":"function","name":"approve","stateMutability":"nonpayable",
+		 "inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],
+		 "outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"transferFrom","stateMutability":"nonpayable",
+		 "inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],
+		 "outputs":[{"name":"","type":"bool"}]}
+	]`
+	dai := NewContract(common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"), MustParseABI(approvalABIJSON))
+	usdc := NewContract(common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), MustParseABI(approvalABIJSON))
+	router := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
+	user := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	vm := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	t.Run("reports explicit and implicit approvals", func(t *testing.T) {
+		p := New()
+		p.Add(usdc.MustInvoke("transferFrom", user, vm, big.NewInt(500)))
+		p.Add(dai.MustInvoke("approve", router, big.NewInt(1000)))
+
+		approvals := p.RequiredApprovals()
+		if len(approvals) != 2 {
+			t.Fatalf("Expected 2 approvals, got %d: %+v", len(approvals), approvals)
+		}
+
+		implicit := approvals[0]
+		if implicit.CommandIndex != 0 || implicit.Explicit || implicit.Token != usdc.Address() ||
+			implicit.Owner != user || implicit.Spender != (common.Address{}) || implicit.Amount.Int64() != 500 {
+			t.Errorf("Unexpected transferFrom approval %+v", implicit)
+		}
+
+		explicit := approvals[1]
+		if explicit.CommandIndex != 1 || !explicit.Explicit || explicit.Token != dai.Address() ||
+			explicit.Spender != router || explicit.Owner != (common.Address{}) || explicit.Amount.Int64() != 1000 {
+			t.Errorf("Unexpected approve approval %+v", explicit)
+		}
+	})
+
+	t.Run("runtime amount is nil", func(t *testing.T) {
+		p := New()
+		balance := p.Add(dai.MustInvoke("balanceOf", vm))
+		p.Add(dai.MustInvoke("approve", router, balance))
+
+		approvals := p.RequiredApprovals()
+		if len(approvals) != 1 || approvals[0].Amount != nil {
+			t.Errorf("Expected one approval with unknown amount, got %+v", approvals)
+		}
+	})
+
+	t.Run("ignores other calls", func(t *testing.T) {
+		p := New()
+		p.Add(dai.MustInvoke("balanceOf", vm))
+		if approvals := p.RequiredApprovals(); len(approvals) != 0 {
+			t.Errorf("Expected no approvals, got %+v", approvals)
+		}
+	})
+}
+
+func TestPlannerTokenFlows(t *testing.T) {
+	const flowABIJSON = `[
+		{"type":"function","name":"balanceOf","stateMutability":"view",
+		 "inputs":[{"name":"account","type":"address"}],
+		 "outputs":[{"name":"","type":"uint256"}]},
+		{"type":"function","name":"transfer","stateMutability":"nonpayable",
+		 "inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],
+		 "outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"approve","stateMutability":"nonpayable",
+		 "inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],
+		 "outputs":[{"name":"","type":"bool"}]}
+	]`
+	dai := NewContract(common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"), MustParseABI(flowABIJSON))
+	usdc := NewContract(common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), MustParseABI(flowABIJSON))
+	router := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
+	user := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	vm := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	t.Run("reports transfer and approve", func(t *testing.T) {
+		p := New()
+		p.Add(dai.MustInvoke("approve", router, big.NewInt(1000)))
+		p.Add(usdc.MustInvoke("transfer", user, big.NewInt(7)))
+		p.Add(dai.MustInvoke("transfer", user, big.NewInt(250)))
+
+		flows := p.TokenFlows(dai.Address())
+		if len(flows) != 2 {
+			t.Fatalf("Expected 2 flows, got %d: %+v", len(flows), flows)
+		}
+
+		approval := flows[0]
+		if approval.CommandIndex != 0 || approval.Direction != FlowApproval ||
+			approval.Counterparty != router || approval.Amount.Int64() != 1000 {
+			t.Errorf("Unexpected approve flow %+v", approval)
+		}
+
+		transfer := flows[1]
+		if transfer.CommandIndex != 2 || transfer.Direction != FlowOut ||
+			transfer.Counterparty != user || transfer.Amount.Int64() != 250 {
+			t.Errorf("Unexpected transfer flow %+v", transfer)
+		}
+	})
+
+	t.Run("runtime amount is nil", func(t *testing.T) {
+		p := New()
+		balance := p.Add(dai.MustInvoke("balanceOf", vm))
+		p.Add(dai.MustInvoke("transfer", user, balance))
+
+		flows := p.TokenFlows(dai.Address())
+		if len(flows) != 1 || flows[0].Amount != nil || flows[0].Counterparty != user {
+			t.Errorf("Expected one transfer with unknown amount, got %+v", flows)
+		}
+	})
+}
+
+func TestPlannerAddPermit(t *testing.T) {
+	const permitABIJSON = `[
+		{"type":"function","name":"permit","stateMutability":"nonpayable",
+		 "inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"},
+		           {"name":"value","type":"uint256"},{"name":"deadline","type":"uint256"},
+		           {"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],
+		 "outputs":[]}
+	]`
+	tokenAddr := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	args := PermitArgs{
+		Owner:    common.HexToAddress("0x3333333333333333333333333333333333333333"),
+		Spender:  common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"),
+		Value:    big.NewInt(1000),
+		Deadline: big.NewInt(1700000000),
+		V:        27,
+		R:        common.HexToHash("0x0101010101010101010101010101010101010101010101010101010101010101"),
+		S:        common.HexToHash("0x0202020202020202020202020202020202020202020202020202020202020202"),
+	}
+
+	t.Run("adds permit with typed literals", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(permitABIJSON), WithStaticCalls())
+		p := New()
+		if err := p.AddPermit(token, args); err != nil {
+			t.Fatalf("AddPermit failed: %v", err)
+		}
+		if p.Len() != 1 {
+			t.Fatalf("Expected 1 command, got %d", p.Len())
+		}
+
+		call := p.CommandAt(0).Call()
+		if call.Flags().CallType() != FlagCall {
+			t.Errorf("Expected permit to be CALL, got %d", call.Flags().CallType())
+		}
+		expected := []struct {
+			typ  string
+			data []byte
+		}{
+			{"address", common.LeftPadBytes(args.Owner.Bytes(), 32)},
+			{"address", common.LeftPadBytes(args.Spender.Bytes(), 32)},
+			{"uint256", common.LeftPadBytes(args.Value.Bytes(), 32)},
+			{"uint256", common.LeftPadBytes(args.Deadline.Bytes(), 32)},
+			{"uint8", common.LeftPadBytes([]byte{27}, 32)},
+			{"bytes32", args.R[:]},
+			{"bytes32", args.S[:]},
+		}
+		callArgs := call.Args()
+		if len(callArgs) != len(expected) {
+			t.Fatalf("Expected %d arguments, got %d", len(expected), len(callArgs))
+		}
+		for i, want := range expected {
+			lit, ok := callArgs[i].(*LiteralValue)
+			if !ok {
+				t.Errorf("Argument %d: expected a literal, got %T", i, callArgs[i])
+				continue
+			}
+			if lit.Type().String() != want.typ || !bytes.Equal(lit.Data(), want.data) {
+				t.Errorf("Argument %d: expected %s %x, got %s %x", i, want.typ, want.data, lit.Type().String(), lit.Data())
+			}
+		}
+
+		if _, err := p.Plan(); err != nil {
+			t.Errorf("Plan failed: %v", err)
+		}
+	})
+
+	t.Run("rejects token without permit", func(t *testing.T) {
+		token := NewContract(tokenAddr, MustParseABI(erc20TestABIJSON))
+		p := New()
+		var notFound *MethodNotFoundError
+		if err := p.AddPermit(token, args); !errors.As(err, &notFound) || notFound.Method != "permit" {
+			t.Errorf("Expected MethodNotFoundError for permit, got %v", err)
+		}
+		if p.Len() != 0 {
+			t.Error("Expected planner to be unchanged")
+		}
+	})
+
+	t.Run("rejects non-standard permit", func(t *testing.T) {
+		// DAI's permit(holder, spender, nonce, expiry, allowed, v, r, s)
+		token := NewContract(tokenAddr, MustParseABI(`[
+			{"type":"function","name":"permit","stateMutability":"nonpayable",
+			 "inputs":[{"name":"holder","type":"address"},{"name":"spender","type":"address"},
+			           {"name":"nonce","type":"uint256"},{"name":"expiry","type":"uint256"},{"name":"allowed","type":"bool"},
+			           {"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],
+			 "outputs":[]}
+		]`))
+		var mismatch *TypeMismatchError
+		if err := New().AddPermit(token, args); !errors.As(err, &mismatch) {
+			t.Errorf("Expected TypeMismatchError, got %v", err)
+		}
+	})
+}
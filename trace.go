@@ -0,0 +1,272 @@
+package weiroll
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ExpectedCall describes the call a command makes when the VM executes it,
+// for comparison against an execution trace.
+type ExpectedCall struct {
+	Target        common.Address
+	TargetRuntime bool // Target comes from a return value of a dynamic contract
+	CallType      CallFlags
+	Selector      [4]byte
+	Args          []ExpectedArg
+	Value         *big.Int // ETH value for CALL_WITH_VALUE (nil if none)
+}
+
+// ExpectedArg is one argument of an ExpectedCall.
+type ExpectedArg struct {
+	Type abi.Type
+
+	// Data is the argument's state slot contents, or nil if Runtime is set.
+	Data []byte
+
+	// Runtime is true if the argument is only known during execution
+	// (a return value, the planner state, or a caller-filled slot).
+	Runtime bool
+}
+
+// ExpectedCalls returns the call each command makes, using sourcePlanner for
+// argument types and cp's state for literal data. cp must have been compiled
+// from sourcePlanner without options that drop commands.
+func (cp *CompiledPlan) ExpectedCalls(sourcePlanner *Planner) ([]ExpectedCall, error) {
+	if len(cp.Commands) != sourcePlanner.Len() {
+		return nil, fmt.Errorf("%w: %d compiled commands, %d planned",
+			ErrPlanMismatch, len(cp.Commands), sourcePlanner.Len())
+	}
+
+	calls := make([]ExpectedCall, len(cp.Commands))
+	for i, encoded := range cp.Commands {
+		cmd := sourcePlanner.commands[i]
+		selector, flags, argSlots, _, address, err := DecodeCommandWithConfig(encoded, cp.encoding())
+		if err != nil {
+			return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+		}
+		if selector != cmd.call.Selector() || address != cmd.call.contract.Address() {
+			return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: ErrPlanMismatch}
+		}
+
+		call := ExpectedCall{
+			Target:   address,
+			CallType: flags.CallType(),
+			Selector: selector,
+		}
+		valueSlot, argSlots, hasValue := SplitValueSlot(flags, argSlots)
+		if flags.HasDynamicTarget() {
+			if len(argSlots) == 0 {
+				return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: ErrPlanMismatch}
+			}
+			if _, ok := cmd.call.contract.addressValue.(*ReturnValue); ok {
+				call.TargetRuntime = true
+			} else {
+				data, err := cp.slotData(argSlots[0])
+				if err != nil {
+					return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+				}
+				call.Target = common.BytesToAddress(data)
+			}
+			argSlots = argSlots[1:]
+		}
+
+		args := cmd.call.Args()
+		call.Args = make([]ExpectedArg, len(args))
+		if len(argSlots) != len(args) {
+			return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: ErrPlanMismatch}
+		}
+
+		for j, arg := range args {
+			expected := ExpectedArg{Type: arg.Type()}
+			switch arg.(type) {
+			case *ReturnValue, *StateValue, *SlotValue:
+				expected.Runtime = true
+			default:
+				data, err := cp.slotData(argSlots[j])
+				if err != nil {
+					return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+				}
+				expected.Data = data
+			}
+			call.Args[j] = expected
+		}
+		if hasValue {
+			data, err := cp.slotData(valueSlot)
+			if err != nil {
+				return nil, &PlanError{CommandIndex: i, Method: cmd.call.method.Name, Err: err}
+			}
+			call.Value = new(big.Int).SetBytes(data)
+		}
+
+		calls[i] = call
+	}
+
+	return calls, nil
+}
+
+// slotData returns the state contents for an encoded argument slot.
+func (cp *CompiledPlan) slotData(slot uint8) ([]byte, error) {
+	index := int(cp.encoding().slotIndex(slot))
+	if index >= len(cp.State) {
+		return nil, fmt.Errorf("%w: slot %d beyond state length %d", ErrInvalidCommand, index, len(cp.State))
+	}
+	return cp.State[index], nil
+}
+
+// SlotChange describes a state slot whose contents differ between a plan's
+// initial state and the state after execution.
+type SlotChange struct {
+	Slot   uint8
+	Before []byte // nil if the slot was added during execution
+	After  []byte // nil if the slot was removed during execution
+
+	// Type is the slot's ABI type as last assigned by the plan, or nil if
+	// unknown. BeforeValue and AfterValue are the contents decoded with Type,
+	// or nil if the type is unknown or the contents don't decode.
+	Type        *abi.Type
+	BeforeValue any
+	AfterValue  any
+}
+
+// StateDiff compares cp's initial state with final, the state array
+// returned by executing it (e.g. from an eth_call), and reports the slots
+// that changed in slot order. Slot types come from sourcePlanner: a slot
+// written by a command's return value has that value's type, and otherwise
+// the type of the literal stored in it. cp must have been compiled from
+// sourcePlanner without options that drop commands.
+func (cp *CompiledPlan) StateDiff(sourcePlanner *Planner, final [][]byte) ([]SlotChange, error) {
+	types, err := cp.slotTypes(sourcePlanner)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []SlotChange
+	for i := 0; i < max(len(cp.State), len(final)); i++ {
+		var before, after []byte
+		if i < len(cp.State) {
+			before = cp.State[i]
+		}
+		if i < len(final) {
+			after = final[i]
+		}
+		if i < len(cp.State) && i < len(final) && bytes.Equal(before, after) {
+			continue
+		}
+
+		change := SlotChange{Slot: uint8(i), Before: before, After: after}
+		if t, ok := types[uint8(i)]; ok {
+			change.Type = &t
+			change.BeforeValue = decodeSlot(t, before)
+			change.AfterValue = decodeSlot(t, after)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// slotTypes maps state slots to the ABI type of the last value the plan
+// assigns them. Return values take precedence over literals, since they
+// overwrite the slot during execution.
+func (cp *CompiledPlan) slotTypes(sourcePlanner *Planner) (map[uint8]abi.Type, error) {
+	if len(cp.Commands) != sourcePlanner.Len() {
+		return nil, fmt.Errorf("%w: %d compiled commands, %d planned",
+			ErrPlanMismatch, len(cp.Commands), sourcePlanner.Len())
+	}
+
+	enc := cp.encoding()
+	literals := make(map[uint8]abi.Type)
+	returns := make(map[uint8]abi.Type)
+	for i, encoded := range cp.Commands {
+		cmd := sourcePlanner.commands[i]
+		_, flags, argSlots, returnSlot, _, err := DecodeCommandWithConfig(encoded, enc)
+		if err != nil {
+			return nil, cmd.planError(i, err)
+		}
+		_, argSlots, _ = SplitValueSlot(flags, argSlots)
+
+		values := cmd.call.values()
+		for j, v := range values {
+			if j >= len(argSlots) {
+				break
+			}
+			switch v.(type) {
+			case *LiteralValue, *ParamValue:
+				literals[enc.slotIndex(argSlots[j])] = v.Type()
+			}
+		}
+
+		if enc.storesReturn(returnSlot) && cmd.call.HasReturnValue() && !cmd.call.rawReturn {
+			returns[enc.slotIndex(returnSlot)] = *cmd.call.ReturnType()
+		}
+	}
+
+	for slot, t := range returns {
+		literals[slot] = t
+	}
+	return literals, nil
+}
+
+// AssertOutputType checks that the last top-level command writing slot
+// produces a value of type typeStr, so that a caller decoding that slot of
+// the final state gets what it expects. It fails with a PlanError wrapping
+// a TypeMismatchError if the types differ, or if a later command replaces
+// the whole state, and with ErrNoReturnValue if no command writes the slot.
+// Raw returns produce bytes. cp must have been compiled from sourcePlanner
+// without options that drop or add commands.
+func (cp *CompiledPlan) AssertOutputType(sourcePlanner *Planner, slot uint8, typeStr string) error {
+	expected, err := abi.NewType(typeStr, "", nil)
+	if err != nil {
+		return &EncodingError{Value: typeStr, Err: err}
+	}
+	if len(cp.Commands) != sourcePlanner.Len() {
+		return fmt.Errorf("%w: %d compiled commands, %d planned",
+			ErrPlanMismatch, len(cp.Commands), sourcePlanner.Len())
+	}
+
+	enc := cp.encoding()
+	writer, replaces := -1, false
+	for i, encoded := range cp.Commands {
+		_, _, _, returnSlot, _, err := DecodeCommandWithConfig(encoded, enc)
+		if err != nil {
+			return sourcePlanner.commands[i].planError(i, err)
+		}
+		if returnSlot == enc.StateSlotMarker || (returnSlot != enc.NoReturnSlot && enc.slotIndex(returnSlot) == slot) {
+			writer, replaces = i, returnSlot == enc.StateSlotMarker
+		}
+	}
+	if writer < 0 {
+		return fmt.Errorf("%w: no command writes slot %d", ErrNoReturnValue, slot)
+	}
+
+	cmd := sourcePlanner.commands[writer]
+	got := cmd.returnTypeString()
+	if replaces {
+		got = "replaced state"
+	}
+	if got != expected.String() {
+		return cmd.planError(writer, &TypeMismatchError{Expected: expected.String(), Got: got})
+	}
+	return nil
+}
+
+// decodeSlot decodes state slot contents of the given type, restoring the
+// offset word the VM omits for dynamic types. Returns nil on failure.
+func decodeSlot(t abi.Type, data []byte) any {
+	if data == nil {
+		return nil
+	}
+	encoded := data
+	if isDynamicType(t) {
+		encoded = (&LiteralValue{abiType: t, data: data}).FullABIEncoding()
+	}
+	values, err := abi.Arguments{{Type: t}}.Unpack(encoded)
+	if err != nil || len(values) != 1 {
+		return nil
+	}
+	return values[0]
+}
@@ -0,0 +1,262 @@
+package weiroll
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCompiledPlanExpectedCalls(t *testing.T) {
+	tokenAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	token := NewContract(tokenAddr, MustParseABI(testABIJSON))
+
+	t.Run("marks return values as runtime-resolved", func(t *testing.T) {
+		p := New()
+		amount := p.Add(token.MustInvoke("getValue"))
+		p.Add(token.MustInvoke("transfer", recipient, amount))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		calls, err := plan.ExpectedCalls(p)
+		if err != nil {
+			t.Fatalf("ExpectedCalls failed: %v", err)
+		}
+		if len(calls) != 2 {
+			t.Fatalf("Expected 2 calls, got %d", len(calls))
+		}
+
+		transfer := calls[1]
+		if transfer.Target != tokenAddr {
+			t.Errorf("Expected target %s, got %s", tokenAddr.Hex(), transfer.Target.Hex())
+		}
+		if transfer.CallType != FlagCall {
+			t.Errorf("Expected CALL, got %d", transfer.CallType)
+		}
+		if transfer.Selector != token.MustInvoke("transfer", recipient, big.NewInt(0)).Selector() {
+			t.Errorf("Unexpected selector %x", transfer.Selector)
+		}
+		if len(transfer.Args) != 2 {
+			t.Fatalf("Expected 2 args, got %d", len(transfer.Args))
+		}
+		if transfer.Args[0].Runtime || !bytes.Equal(transfer.Args[0].Data, common.LeftPadBytes(recipient.Bytes(), 32)) {
+			t.Errorf("Expected recipient literal, got %+v", transfer.Args[0])
+		}
+		if !transfer.Args[1].Runtime || transfer.Args[1].Data != nil {
+			t.Errorf("Expected amount to be runtime-resolved, got %+v", transfer.Args[1])
+		}
+		if transfer.Value != nil {
+			t.Errorf("Expected no value, got %s", transfer.Value)
+		}
+	})
+
+	t.Run("includes ETH value", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(5)).WithValue(big.NewInt(1000)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		calls, err := plan.ExpectedCalls(p)
+		if err != nil {
+			t.Fatalf("ExpectedCalls failed: %v", err)
+		}
+		if calls[0].CallType != FlagCallWithValue {
+			t.Errorf("Expected CALL_WITH_VALUE, got %d", calls[0].CallType)
+		}
+		if calls[0].Value == nil || calls[0].Value.Int64() != 1000 {
+			t.Errorf("Expected value 1000, got %v", calls[0].Value)
+		}
+		if len(calls[0].Args) != 2 {
+			t.Errorf("Expected value slot not to be reported as an argument, got %d args", len(calls[0].Args))
+		}
+	})
+
+	t.Run("separates ETH value from a dynamic target", func(t *testing.T) {
+		dyn, err := NewDynamicContract(Address(tokenAddr), MustParseABI(testABIJSON))
+		if err != nil {
+			t.Fatalf("NewDynamicContract failed: %v", err)
+		}
+		p := New()
+		p.Add(dyn.MustInvoke("transfer", recipient, big.NewInt(5)).WithValue(big.NewInt(1000)))
+
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		calls, err := plan.ExpectedCalls(p)
+		if err != nil {
+			t.Fatalf("ExpectedCalls failed: %v", err)
+		}
+		if calls[0].Target != tokenAddr {
+			t.Errorf("Expected target %s, got %s", tokenAddr.Hex(), calls[0].Target.Hex())
+		}
+		if calls[0].Value == nil || calls[0].Value.Int64() != 1000 {
+			t.Errorf("Expected value 1000, got %v", calls[0].Value)
+		}
+		if len(calls[0].Args) != 2 || calls[0].Args[1].Data == nil || new(big.Int).SetBytes(calls[0].Args[1].Data).Int64() != 5 {
+			t.Errorf("Expected arguments after the target, got %+v", calls[0].Args)
+		}
+	})
+
+	t.Run("rejects a different planner", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("transfer", recipient, big.NewInt(5)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		other := New()
+		other.Add(token.MustInvoke("getValue"))
+		if _, err := plan.ExpectedCalls(other); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+
+		if _, err := plan.ExpectedCalls(New()); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+	})
+}
+
+func TestCompiledPlanStateDiff(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	p := New()
+	sum := p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), true)
+	p.AddCapture(lib.MustInvoke("getString"), true)
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	_, _, _, sumSlot, _, _ := DecodeCommand(plan.Commands[0])
+	_, _, _, strSlot, _, _ := DecodeCommand(plan.Commands[1])
+	sumIndex := SlotIndex(sumSlot).Index()
+	strIndex := SlotIndex(strSlot).Index()
+
+	final := append([][]byte(nil), plan.StateAsBytes()...)
+	final[sumIndex] = common.LeftPadBytes(big.NewInt(3).Bytes(), 32)
+	final[strIndex] = MustLiteralFromType("string", "hello").Data()
+
+	t.Run("reports changed slots with decoded values", func(t *testing.T) {
+		changes, err := plan.StateDiff(p, final)
+		if err != nil {
+			t.Fatalf("StateDiff failed: %v", err)
+		}
+		if len(changes) != 2 {
+			t.Fatalf("Expected 2 changes, got %d", len(changes))
+		}
+
+		byIndex := map[uint8]SlotChange{changes[0].Slot: changes[0], changes[1].Slot: changes[1]}
+		sumChange, ok := byIndex[sumIndex]
+		if !ok || sumChange.Type == nil || sumChange.Type.String() != "uint256" {
+			t.Fatalf("Expected uint256 change at slot %d, got %+v", sumIndex, changes)
+		}
+		if v, ok := sumChange.AfterValue.(*big.Int); !ok || v.Int64() != 3 {
+			t.Errorf("Expected after value 3, got %v", sumChange.AfterValue)
+		}
+		if v, ok := sumChange.BeforeValue.(*big.Int); !ok || v.Sign() != 0 {
+			t.Errorf("Expected before value 0, got %v", sumChange.BeforeValue)
+		}
+
+		strChange := byIndex[strIndex]
+		if strChange.AfterValue != "hello" {
+			t.Errorf("Expected after value hello, got %v", strChange.AfterValue)
+		}
+		if strChange.Type == nil || strChange.Type.String() != "string" {
+			t.Errorf("Expected string type, got %v", strChange.Type)
+		}
+	})
+
+	t.Run("unchanged state has no diff", func(t *testing.T) {
+		changes, err := plan.StateDiff(p, plan.StateAsBytes())
+		if err != nil {
+			t.Fatalf("StateDiff failed: %v", err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("Expected no changes, got %+v", changes)
+		}
+	})
+
+	t.Run("reports added slots without type", func(t *testing.T) {
+		extended := append(append([][]byte(nil), plan.StateAsBytes()...), []byte{1})
+		changes, err := plan.StateDiff(p, extended)
+		if err != nil {
+			t.Fatalf("StateDiff failed: %v", err)
+		}
+		if len(changes) != 1 || changes[0].Before != nil || changes[0].Type != nil {
+			t.Errorf("Expected one untyped added slot, got %+v", changes)
+		}
+	})
+
+	t.Run("rejects mismatched planner", func(t *testing.T) {
+		if _, err := plan.StateDiff(New(), final); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+	})
+}
+
+func TestCompiledPlanAssertOutputType(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), plannerTestABI())
+
+	p := New()
+	p.AddCapture(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)), true)
+	p.AddCapture(lib.MustInvoke("getString"), true)
+
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	_, _, _, sumSlot, _, _ := DecodeCommand(plan.Commands[0])
+	_, _, _, strSlot, _, _ := DecodeCommand(plan.Commands[1])
+	sumIndex := SlotIndex(sumSlot).Index()
+	strIndex := SlotIndex(strSlot).Index()
+
+	t.Run("accepts matching types", func(t *testing.T) {
+		if err := plan.AssertOutputType(p, sumIndex, "uint256"); err != nil {
+			t.Errorf("Expected uint256 output, got %v", err)
+		}
+		if err := plan.AssertOutputType(p, strIndex, "string"); err != nil {
+			t.Errorf("Expected string output, got %v", err)
+		}
+	})
+
+	t.Run("rejects a mismatched type", func(t *testing.T) {
+		err := plan.AssertOutputType(p, sumIndex, "bool")
+		var mismatch *TypeMismatchError
+		if !errors.As(err, &mismatch) || mismatch.Expected != "bool" || mismatch.Got != "uint256" {
+			t.Fatalf("Expected bool/uint256 TypeMismatchError, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 0 {
+			t.Errorf("Expected PlanError for command 0, got %v", err)
+		}
+	})
+
+	t.Run("rejects an unwritten slot", func(t *testing.T) {
+		if err := plan.AssertOutputType(p, 100, "uint256"); !errors.Is(err, ErrNoReturnValue) {
+			t.Errorf("Expected ErrNoReturnValue, got %v", err)
+		}
+	})
+
+	t.Run("rejects an invalid type", func(t *testing.T) {
+		var encErr *EncodingError
+		if err := plan.AssertOutputType(p, sumIndex, "notatype"); !errors.As(err, &encErr) {
+			t.Errorf("Expected EncodingError, got %v", err)
+		}
+	})
+
+	t.Run("rejects a mismatched planner", func(t *testing.T) {
+		if err := plan.AssertOutputType(New(), sumIndex, "uint256"); !errors.Is(err, ErrPlanMismatch) {
+			t.Errorf("Expected ErrPlanMismatch, got %v", err)
+		}
+	})
+}
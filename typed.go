@@ -0,0 +1,72 @@
+package weiroll
+
+import (
+	"fmt"
+)
+
+// Typed call constructors. Method0 through Method4 bind a contract method to
+// a Go function with a fixed number of typed parameters, so arity mistakes
+// are caught by the compiler:
+//
+//	transfer := weiroll.Method2[common.Address, Value](token, "transfer")
+//	planner.Add(transfer(recipient, amount))
+//
+// The method is looked up and its arity checked when the wrapper is created;
+// both the constructor and the returned function panic on error, like
+// MustInvoke. Argument types may be any Go type accepted by Invoke, or Value.
+
+// Method0 returns a constructor for a method taking no arguments.
+func Method0(c *Contract, name string) func() *Call {
+	mustArity(c, name, 0)
+	return func() *Call {
+		return c.MustInvoke(name)
+	}
+}
+
+// Method1 returns a constructor for a method taking one argument.
+func Method1[A any](c *Contract, name string) func(A) *Call {
+	mustArity(c, name, 1)
+	return func(a A) *Call {
+		return c.MustInvoke(name, a)
+	}
+}
+
+// Method2 returns a constructor for a method taking two arguments.
+func Method2[A, B any](c *Contract, name string) func(A, B) *Call {
+	mustArity(c, name, 2)
+	return func(a A, b B) *Call {
+		return c.MustInvoke(name, a, b)
+	}
+}
+
+// Method3 returns a constructor for a method taking three arguments.
+func Method3[A, B, C any](c *Contract, name string) func(A, B, C) *Call {
+	mustArity(c, name, 3)
+	return func(a A, b B, cc C) *Call {
+		return c.MustInvoke(name, a, b, cc)
+	}
+}
+
+// Method4 returns a constructor for a method taking four arguments.
+func Method4[A, B, C, D any](c *Contract, name string) func(A, B, C, D) *Call {
+	mustArity(c, name, 4)
+	return func(a A, b B, cc C, d D) *Call {
+		return c.MustInvoke(name, a, b, cc, d)
+	}
+}
+
+// mustArity panics if the contract lacks the method or it takes a different
+// number of arguments than the typed wrapper.
+func mustArity(c *Contract, name string, n int) {
+	method, ok := c.abi.Methods[name]
+	if !ok {
+		panic(c.methodNotFound(name))
+	}
+	if len(method.Inputs) != n {
+		panic(&ArgumentError{
+			Method: name,
+			Index:  n,
+			Err:    fmt.Errorf("method takes %d arguments, wrapper takes %d", len(method.Inputs), n),
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package weiroll
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTypedMethods(t *testing.T) {
+	tokenAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	token := NewContract(tokenAddr, MustParseABI(testABIJSON))
+
+	t.Run("Method2 builds typed calls", func(t *testing.T) {
+		transfer := Method2[common.Address, *big.Int](token, "transfer")
+		call := transfer(recipient, big.NewInt(100))
+
+		expected := token.MustInvoke("transfer", recipient, big.NewInt(100))
+		if !call.equal(expected) {
+			t.Error("Expected typed call to match MustInvoke")
+		}
+	})
+
+	t.Run("Method2 accepts Value arguments", func(t *testing.T) {
+		p := New()
+		amount := p.Add(token.MustInvoke("getValue"))
+
+		transfer := Method2[common.Address, Value](token, "transfer")
+		call := transfer(recipient, amount)
+		if call.Args()[1] != Value(amount) {
+			t.Error("Expected return value to be passed through")
+		}
+	})
+
+	t.Run("Method0 builds typed calls", func(t *testing.T) {
+		getValue := Method0(token, "getValue")
+		if getValue().Method().Name != "getValue" {
+			t.Error("Expected getValue call")
+		}
+	})
+
+	t.Run("panics on arity mismatch", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic for wrong arity")
+			}
+		}()
+		Method1[common.Address](token, "transfer")
+	})
+
+	t.Run("panics on unknown method", func(t *testing.T) {
+		defer func() {
+			r := recover()
+			if _, ok := r.(*MethodNotFoundError); !ok {
+				t.Errorf("Expected MethodNotFoundError panic, got %v", r)
+			}
+		}()
+		Method0(token, "missing")
+	})
+}
@@ -1,7 +1,9 @@
 package weiroll
 
 import (
+	"fmt"
 	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
@@ -29,6 +31,7 @@ type Value interface {
 type LiteralValue struct {
 	abiType abi.Type
 	data    []byte
+	mutable bool // Has a private slot; see Planner.AddMutableLiteral
 }
 
 func (v *LiteralValue) isValue() {}
@@ -48,11 +51,29 @@ func (v *LiteralValue) Data() []byte {
 	return v.data
 }
 
+// FullABIEncoding returns the literal as a complete single-argument ABI
+// encoding. Data omits the leading offset word for dynamic types, since the
+// VM stores them as [length][data]; this restores it so the result can be
+// decoded with abi.Arguments.Unpack or used in standalone calldata.
+// For static types it is the same as Data.
+func (v *LiteralValue) FullABIEncoding() []byte {
+	if !v.IsDynamic() {
+		return v.data
+	}
+	encoded := make([]byte, 32, 32+len(v.data))
+	encoded[31] = 0x20
+	return append(encoded, v.data...)
+}
+
 // ReturnValue represents the output of a previously added command.
 type ReturnValue struct {
 	command *Command
 	abiType abi.Type
 	index   int // For multi-return functions, index into outputs
+
+	// override is the type reported by Type, if set; see WithReturnType.
+	// abiType still determines the slot layout.
+	override *abi.Type
 }
 
 func (v *ReturnValue) isValue() {}
@@ -64,6 +85,9 @@ func (v *ReturnValue) IsDynamic() bool {
 
 // Type returns the ABI type of this return value.
 func (v *ReturnValue) Type() abi.Type {
+	if v.override != nil {
+		return *v.override
+	}
 	return v.abiType
 }
 
@@ -77,6 +101,37 @@ func (v *ReturnValue) Command() *Command {
 	return v.command
 }
 
+// Index returns the position among the command's outputs of the value, as
+// given to At.
+func (v *ReturnValue) Index() int {
+	return v.index
+}
+
+// At returns the i-th output of the command producing v, for functions with
+// several return values. All outputs of a command share its return slot,
+// since the VM stores what the call returns as a whole.
+//
+// The VM only stores a lone return value as is, so At requires a single
+// output and i == 0, or a call made with RawReturn, which fails with
+// ErrTupleReturnRequired otherwise. With RawReturn the slot holds the whole
+// ABI-encoded tuple as bytes: the result carries output i's type for
+// decoding and description only, and Plan fails with ErrRawReturnArgument
+// if it is passed to a call.
+func (v *ReturnValue) At(i int) (*ReturnValue, error) {
+	outputs := v.command.call.method.Outputs
+	if i < 0 || i >= len(outputs) {
+		return nil, fmt.Errorf("%w: output %d of %d", ErrOutputIndexOutOfRange, i, len(outputs))
+	}
+	if len(outputs) > 1 && !v.command.call.rawReturn {
+		return nil, fmt.Errorf("%w: %s returns %d values", ErrTupleReturnRequired, v.command.call.method.Sig, len(outputs))
+	}
+	return &ReturnValue{
+		command: v.command,
+		abiType: outputs[i].Type,
+		index:   i,
+	}, nil
+}
+
 // StateValue represents the current planner state array.
 // Used for subplan integration where the state needs to be passed to callbacks.
 type StateValue struct {
@@ -130,6 +185,82 @@ func (v *SubplanValue) Planner() *Planner {
 	return v.subplanner
 }
 
+// ParamValue is a named placeholder for a literal supplied when a
+// PlanTemplate is instantiated.
+type ParamValue struct {
+	name    string
+	abiType abi.Type
+}
+
+func (v *ParamValue) isValue() {}
+
+// IsDynamic returns true if the parameter has a dynamic ABI type.
+func (v *ParamValue) IsDynamic() bool {
+	return isDynamicType(v.abiType)
+}
+
+// Type returns the ABI type of this parameter.
+func (v *ParamValue) Type() abi.Type {
+	return v.abiType
+}
+
+// Data returns nil (parameter data is supplied at instantiation).
+func (v *ParamValue) Data() []byte {
+	return nil
+}
+
+// Name returns the parameter name.
+func (v *ParamValue) Name() string {
+	return v.name
+}
+
+// SlotValue refers directly to a state slot filled by the caller before
+// execution rather than by a command. See Planner.SlotValue.
+type SlotValue struct {
+	slot    uint8
+	abiType abi.Type
+}
+
+func (v *SlotValue) isValue() {}
+
+// IsDynamic returns true if the slot holds a dynamic ABI type.
+func (v *SlotValue) IsDynamic() bool {
+	return isDynamicType(v.abiType)
+}
+
+// Type returns the ABI type of the slot contents.
+func (v *SlotValue) Type() abi.Type {
+	return v.abiType
+}
+
+// Data returns nil (slot contents are supplied by the caller).
+func (v *SlotValue) Data() []byte {
+	return nil
+}
+
+// Slot returns the state slot index.
+func (v *SlotValue) Slot() uint8 {
+	return v.slot
+}
+
+// NewParam creates a template parameter placeholder using an ABI type string.
+func NewParam(name, typeStr string) (*ParamValue, error) {
+	abiType, err := abi.NewType(typeStr, "", nil)
+	if err != nil {
+		return nil, &EncodingError{Value: name, Err: err}
+	}
+	return &ParamValue{name: name, abiType: abiType}, nil
+}
+
+// Param is like NewParam but panics on error.
+func Param(name, typeStr string) *ParamValue {
+	v, err := NewParam(name, typeStr)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
 // isDynamicType checks if an ABI type is dynamic (variable-length encoding).
 func isDynamicType(t abi.Type) bool {
 	switch t.T {
@@ -158,11 +289,19 @@ func isDynamicType(t abi.Type) bool {
 //   - string (for string)
 //   - bool (for bool)
 //   - common.Hash (for bytes32)
+//
+// Integer values must fit the type's size, or NewLiteral returns an
+// EncodingError wrapping ErrIntegerOutOfRange.
 func NewLiteral(abiType abi.Type, value any) (*LiteralValue, error) {
 	args := abi.Arguments{{Type: abiType}}
 
 	// Handle special conversions
 	convertedValue := convertToABIType(value, abiType)
+	if n, ok := convertedValue.(*big.Int); ok && n != nil {
+		if err := checkIntegerRange(n, abiType); err != nil {
+			return nil, &EncodingError{Value: value, Err: err}
+		}
+	}
 
 	data, err := args.Pack(convertedValue)
 	if err != nil {
@@ -209,6 +348,30 @@ func MustLiteralFromType(typeStr string, value any) *LiteralValue {
 	return v
 }
 
+// checkIntegerRange verifies that n fits an integer type of abiType.Size
+// bits. Other types are not checked.
+func checkIntegerRange(n *big.Int, abiType abi.Type) error {
+	var lo, hi *big.Int
+	switch abiType.T {
+	case abi.UintTy:
+		lo = new(big.Int)
+		hi = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(abiType.Size)), big.NewInt(1))
+	case abi.IntTy:
+		lo = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(abiType.Size-1)))
+		hi = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(abiType.Size-1)), big.NewInt(1))
+	default:
+		return nil
+	}
+
+	if n.Cmp(hi) > 0 {
+		return fmt.Errorf("%w: %s exceeds %s max %s", ErrIntegerOutOfRange, n, abiType, hi)
+	}
+	if n.Cmp(lo) < 0 {
+		return fmt.Errorf("%w: %s is below %s min %s", ErrIntegerOutOfRange, n, abiType, lo)
+	}
+	return nil
+}
+
 // convertToABIType handles common Go type conversions for ABI encoding.
 func convertToABIType(value any, abiType abi.Type) any {
 	switch v := value.(type) {
@@ -237,11 +400,55 @@ func Int256(v *big.Int) *LiteralValue {
 	return MustLiteralFromType("int256", v)
 }
 
+// Ratio creates a uint256 fixed-point literal numerator/denominator scaled
+// by 10^scale, e.g. Ratio(1, 2, 18) is 5e17. The result is computed exactly
+// as numerator * 10^scale / denominator and rounded down, like Solidity
+// integer division. A zero denominator fails with ErrDivisionByZero, and a
+// negative result or one that overflows uint256 with ErrIntegerOutOfRange.
+func Ratio(numerator, denominator *big.Int, scale int) (*LiteralValue, error) {
+	if numerator == nil || denominator == nil {
+		return nil, &EncodingError{Value: nil, Err: ErrNilArgument}
+	}
+	if denominator.Sign() == 0 {
+		return nil, &EncodingError{Value: numerator, Err: ErrDivisionByZero}
+	}
+	if scale < 0 {
+		return nil, &EncodingError{Value: scale, Err: fmt.Errorf("%w: negative scale %d", ErrIntegerOutOfRange, scale)}
+	}
+
+	scaled := new(big.Int).Mul(numerator, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	return NewLiteralFromType("uint256", new(big.Int).Quo(scaled, denominator))
+}
+
 // Address creates an address literal from a common.Address.
 func Address(v common.Address) *LiteralValue {
 	return MustLiteralFromType("address", v)
 }
 
+// AddressFromString creates an address literal from a hex string.
+// Unlike Address(common.HexToAddress(s)), it rejects malformed input: the
+// string must be "0x" followed by exactly 40 hex digits. Mixed-case input is
+// treated as EIP-55 checksummed and must match the checksum; all-lowercase or
+// all-uppercase input is accepted without a checksum.
+func AddressFromString(s string) (*LiteralValue, error) {
+	if !common.IsHexAddress(s) || !has0xPrefix(s) {
+		return nil, &EncodingError{Value: s, Err: fmt.Errorf("%w: %q is not a 0x-prefixed 20-byte hex address", ErrInvalidAddress, s)}
+	}
+
+	addr := common.HexToAddress(s)
+	digits := s[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && addr.Hex() != s {
+		return nil, &EncodingError{Value: s, Err: fmt.Errorf("%w: %q has an invalid EIP-55 checksum (expected %s)", ErrInvalidAddress, s, addr.Hex())}
+	}
+
+	return NewLiteralFromType("address", addr)
+}
+
+// has0xPrefix reports whether s starts with "0x" or "0X".
+func has0xPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
 // Bytes32 creates a bytes32 literal from a common.Hash or [32]byte.
 func Bytes32(v common.Hash) *LiteralValue {
 	return MustLiteralFromType("bytes32", v)
@@ -262,17 +469,98 @@ func Bytes(v []byte) *LiteralValue {
 	return MustLiteralFromType("bytes", v)
 }
 
+// EncodedCall returns a bytes literal holding the full calldata of call,
+// its selector followed by its ABI-encoded arguments, for passing a call as
+// data to a dispatcher or multicall contract. Every argument must be a
+// literal; otherwise EncodedCall returns an ArgumentError wrapping
+// ErrNonLiteralArgument. Any ETH value attached to call is not included.
+func EncodedCall(call *Call) (*LiteralValue, error) {
+	literals := make([]*LiteralValue, len(call.args))
+	headSize := 0
+	for i, arg := range call.args {
+		lit, ok := arg.(*LiteralValue)
+		if !ok {
+			return nil, &ArgumentError{
+				Method: call.method.Name,
+				Index:  i,
+				Err:    fmt.Errorf("%w: got %T", ErrNonLiteralArgument, arg),
+			}
+		}
+		literals[i] = lit
+		if lit.IsDynamic() {
+			headSize += 32
+		} else {
+			headSize += len(lit.data)
+		}
+	}
+
+	// Static arguments are encoded in place; dynamic ones as an offset into
+	// the tail, where their Data (which omits the offset word) is appended.
+	selector := call.Selector()
+	head := append([]byte{}, selector[:]...)
+	var tail []byte
+	for _, lit := range literals {
+		if !lit.IsDynamic() {
+			head = append(head, lit.data...)
+			continue
+		}
+		offset := common.LeftPadBytes(big.NewInt(int64(headSize+len(tail))).Bytes(), 32)
+		head = append(head, offset...)
+		tail = append(tail, lit.data...)
+	}
+
+	return NewLiteralFromType("bytes", append(head, tail...))
+}
+
+// valuesEqual reports whether two values refer to the same data.
+// Return values are resolved through resolve before comparing their producers.
+func valuesEqual(a, b Value, resolve func(*Command) *Command) bool {
+	switch av := a.(type) {
+	case *LiteralValue:
+		bv, ok := b.(*LiteralValue)
+		if ok && (av.mutable || bv.mutable) {
+			return av == bv
+		}
+		return ok && av.abiType.String() == bv.abiType.String() && string(av.data) == string(bv.data)
+	case *ReturnValue:
+		bv, ok := b.(*ReturnValue)
+		return ok && av.index == bv.index && resolve(av.command) == resolve(bv.command)
+	case *StateValue:
+		bv, ok := b.(*StateValue)
+		return ok && av.planner == bv.planner
+	case *SubplanValue:
+		bv, ok := b.(*SubplanValue)
+		return ok && av.subplanner == bv.subplanner
+	case *ParamValue:
+		bv, ok := b.(*ParamValue)
+		return ok && av.name == bv.name && av.abiType.String() == bv.abiType.String()
+	case *SlotValue:
+		bv, ok := b.(*SlotValue)
+		return ok && av.slot == bv.slot && av.abiType.String() == bv.abiType.String()
+	default:
+		return false
+	}
+}
+
 // isValue checks if a value implements the Value interface.
 func isValue(v any) bool {
 	_, ok := v.(Value)
 	return ok
 }
 
+// CanAssign reports whether v can be passed as an argument of paramType.
+// A value is assignable only if its ABI type matches exactly; no implicit
+// widening or narrowing is performed. This is the check Invoke applies to
+// Value arguments.
+func CanAssign(v Value, paramType abi.Type) bool {
+	return v != nil && v.Type().String() == paramType.String()
+}
+
 // toValue converts any value to a Value, creating a LiteralValue if needed.
 func toValue(v any, expectedType abi.Type) (Value, error) {
 	if val, ok := v.(Value); ok {
 		// Type checking
-		if val.Type().String() != expectedType.String() {
+		if !CanAssign(val, expectedType) {
 			return nil, &TypeMismatchError{
 				Expected: expectedType.String(),
 				Got:      val.Type().String(),
@@ -1,7 +1,11 @@
 package weiroll
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -252,6 +256,59 @@ func TestNewLiteralFromType(t *testing.T) {
 			t.Error("Expected error for invalid type string")
 		}
 	})
+
+	t.Run("integer range", func(t *testing.T) {
+		two128 := new(big.Int).Lsh(big.NewInt(1), 128)
+		max128 := new(big.Int).Sub(two128, big.NewInt(1))
+		two255 := new(big.Int).Lsh(big.NewInt(1), 255)
+		two256 := new(big.Int).Lsh(big.NewInt(1), 256)
+
+		tests := []struct {
+			name    string
+			typeStr string
+			value   *big.Int
+			wantErr bool
+		}{
+			{"uint128 max", "uint128", max128, false},
+			{"uint128 over max", "uint128", two128, true},
+			{"uint256 over max", "uint256", two256, true},
+			{"uint256 negative", "uint256", big.NewInt(-1), true},
+			{"int256 min", "int256", new(big.Int).Neg(two255), false},
+			{"int256 below min", "int256", new(big.Int).Sub(new(big.Int).Neg(two255), big.NewInt(1)), true},
+			{"int256 over max", "int256", two255, true},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := NewLiteralFromType(tt.typeStr, tt.value)
+				if !tt.wantErr {
+					if err != nil {
+						t.Errorf("Unexpected error: %v", err)
+					}
+					return
+				}
+				if !errors.Is(err, ErrIntegerOutOfRange) {
+					t.Fatalf("Expected ErrIntegerOutOfRange, got %v", err)
+				}
+				var encErr *EncodingError
+				if !errors.As(err, &encErr) {
+					t.Errorf("Expected *EncodingError, got %T", err)
+				}
+			})
+		}
+	})
+
+	t.Run("range error names value and max", func(t *testing.T) {
+		two128 := new(big.Int).Lsh(big.NewInt(1), 128)
+		_, err := NewLiteralFromType("uint128", two128)
+		if err == nil {
+			t.Fatal("Expected error")
+		}
+		max128 := new(big.Int).Sub(two128, big.NewInt(1))
+		if !strings.Contains(err.Error(), two128.String()) || !strings.Contains(err.Error(), "uint128 max "+max128.String()) {
+			t.Errorf("Expected value and max in message, got %q", err.Error())
+		}
+	})
 }
 
 func TestMustLiteralFromType(t *testing.T) {
@@ -487,6 +544,41 @@ func TestToValue(t *testing.T) {
 	})
 }
 
+func TestCanAssign(t *testing.T) {
+	uintType, _ := abi.NewType("uint256", "", nil)
+	uint128Type, _ := abi.NewType("uint128", "", nil)
+	bytesArrayType, _ := abi.NewType("bytes[]", "", nil)
+	lib := NewLibrary(common.Address{}, plannerTestABI())
+
+	p := New()
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	str := p.Add(lib.MustInvoke("getString"))
+
+	tests := []struct {
+		name      string
+		value     Value
+		paramType abi.Type
+		expected  bool
+	}{
+		{"matching literal", Uint256(big.NewInt(1)), uintType, true},
+		{"matching return value", sum, uintType, true},
+		{"mismatched return value", str, uintType, false},
+		{"narrower width", MustLiteralFromType("uint128", big.NewInt(1)), uintType, false},
+		{"wider width", sum, uint128Type, false},
+		{"state as bytes[]", p.State(), bytesArrayType, true},
+		{"matching param", Param("x", "uint256"), uintType, true},
+		{"nil value", nil, uintType, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanAssign(tt.value, tt.paramType); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestConvertToABIType(t *testing.T) {
 	abiType, _ := abi.NewType("uint256", "", nil)
 
@@ -568,6 +660,69 @@ func TestIsValue(t *testing.T) {
 	})
 }
 
+func TestAddressFromString(t *testing.T) {
+	t.Run("valid checksummed address", func(t *testing.T) {
+		const dai = "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+		lit, err := AddressFromString(dai)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := Address(common.HexToAddress(dai))
+		if string(lit.Data()) != string(expected.Data()) {
+			t.Error("Expected literal to match Address()")
+		}
+	})
+
+	t.Run("valid lowercase and uppercase addresses", func(t *testing.T) {
+		for _, s := range []string{
+			"0x6b175474e89094c44da98b954eedeac495271d0f",
+			"0x6B175474E89094C44DA98B954EEDEAC495271D0F",
+		} {
+			if _, err := AddressFromString(s); err != nil {
+				t.Errorf("Expected %q to be accepted, got %v", s, err)
+			}
+		}
+	})
+
+	t.Run("wrong length", func(t *testing.T) {
+		for _, s := range []string{
+			"0x6B175474E89094C44Da98b954EedeAC495271d0",
+			"0x6B175474E89094C44Da98b954EedeAC495271d0F00",
+			"",
+		} {
+			_, err := AddressFromString(s)
+			if !errors.Is(err, ErrInvalidAddress) {
+				t.Errorf("Expected ErrInvalidAddress for %q, got %v", s, err)
+			}
+		}
+	})
+
+	t.Run("invalid characters", func(t *testing.T) {
+		_, err := AddressFromString("0x6B175474E89094C44Da98b954EesedcDAE6Bf26z")
+		if !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress, got %v", err)
+		}
+	})
+
+	t.Run("missing 0x prefix", func(t *testing.T) {
+		_, err := AddressFromString("6B175474E89094C44Da98b954EedeAC495271d0F")
+		if !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress, got %v", err)
+		}
+	})
+
+	t.Run("bad checksum", func(t *testing.T) {
+		_, err := AddressFromString("0x6b175474E89094C44Da98b954EedeAC495271d0F")
+		if !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress, got %v", err)
+		}
+		var encErr *EncodingError
+		if !errors.As(err, &encErr) {
+			t.Errorf("Expected EncodingError, got %T", err)
+		}
+	})
+}
+
 func TestLiteralValueDataEncoding(t *testing.T) {
 	t.Run("uint256 encoding", func(t *testing.T) {
 		lit := Uint256(big.NewInt(256))
@@ -623,6 +778,41 @@ func TestLiteralValueDataEncoding(t *testing.T) {
 	})
 }
 
+func TestLiteralValueFullABIEncoding(t *testing.T) {
+	t.Run("string round-trips through Unpack", func(t *testing.T) {
+		for _, str := range []string{"", "hello", strings.Repeat("x", 100)} {
+			lit := MustLiteralFromType("string", str)
+			args := abi.Arguments{{Type: lit.Type()}}
+
+			unpacked, err := args.Unpack(lit.FullABIEncoding())
+			if err != nil {
+				t.Fatalf("Unpack failed for %q: %v", str, err)
+			}
+			if unpacked[0].(string) != str {
+				t.Errorf("Expected %q, got %q", str, unpacked[0])
+			}
+		}
+	})
+
+	t.Run("matches Pack for dynamic types", func(t *testing.T) {
+		lit := MustLiteralFromType("bytes", []byte{1, 2, 3})
+		packed, err := abi.Arguments{{Type: lit.Type()}}.Pack([]byte{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+		if !bytes.Equal(lit.FullABIEncoding(), packed) {
+			t.Errorf("Expected %x, got %x", packed, lit.FullABIEncoding())
+		}
+	})
+
+	t.Run("static types are unchanged", func(t *testing.T) {
+		lit := Uint256(big.NewInt(42))
+		if !bytes.Equal(lit.FullABIEncoding(), lit.Data()) {
+			t.Error("Expected static encoding to equal Data")
+		}
+	})
+}
+
 func TestDynamicTypeDataEncoding(t *testing.T) {
 	t.Run("string skips offset", func(t *testing.T) {
 		lit := String("hello")
@@ -644,3 +834,317 @@ func TestDynamicTypeDataEncoding(t *testing.T) {
 		}
 	})
 }
+
+func TestDynamicArrayLiteralEncoding(t *testing.T) {
+	addressArray, _ := abi.NewType("address[]", "", nil)
+	addressesOf := func(n int) []common.Address {
+		addrs := make([]common.Address, n)
+		for i := range addrs {
+			addrs[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		}
+		return addrs
+	}
+
+	for _, n := range []int{0, 1, 2, 3, 5} {
+		t.Run(fmt.Sprintf("%d addresses", n), func(t *testing.T) {
+			path := addressesOf(n)
+			lit, err := NewLiteral(addressArray, path)
+			if err != nil {
+				t.Fatalf("NewLiteral failed: %v", err)
+			}
+
+			// Length prefix followed by one padded word per address, no offset
+			data := lit.Data()
+			if len(data) != 32*(n+1) {
+				t.Fatalf("Expected %d bytes, got %d", 32*(n+1), len(data))
+			}
+			if got := new(big.Int).SetBytes(data[:32]); got.Int64() != int64(n) {
+				t.Errorf("Expected length prefix %d, got %s", n, got)
+			}
+			for i, a := range path {
+				word := data[32*(i+1) : 32*(i+2)]
+				if !bytes.Equal(word, common.LeftPadBytes(a.Bytes(), 32)) {
+					t.Errorf("Word %d: expected %x, got %x", i, a, word)
+				}
+			}
+
+			// The VM prepends the offset word when building calldata
+			encoded := append(common.LeftPadBytes([]byte{0x20}, 32), data...)
+			values, err := abi.Arguments{{Type: addressArray}}.Unpack(encoded)
+			if err != nil {
+				t.Fatalf("Unpack failed: %v", err)
+			}
+			decoded, ok := values[0].([]common.Address)
+			if !ok || len(decoded) != n {
+				t.Fatalf("Expected %d addresses, got %v", n, values[0])
+			}
+			for i := range path {
+				if decoded[i] != path[i] {
+					t.Errorf("Address %d: expected %s, got %s", i, path[i].Hex(), decoded[i].Hex())
+				}
+			}
+		})
+	}
+
+	t.Run("stored in state as a dynamic slot", func(t *testing.T) {
+		router := NewContract(common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"), MustParseABI(`[{
+			"type": "function",
+			"name": "swapExactTokensForTokens",
+			"inputs": [
+				{"name": "amountIn", "type": "uint256"},
+				{"name": "amountOutMin", "type": "uint256"},
+				{"name": "path", "type": "address[]"},
+				{"name": "to", "type": "address"},
+				{"name": "deadline", "type": "uint256"}
+			],
+			"outputs": [{"name": "amounts", "type": "uint256[]"}]
+		}]`))
+		path := addressesOf(3)
+
+		p := New()
+		p.Add(router.MustInvoke("swapExactTokensForTokens", big.NewInt(1), big.NewInt(0), path, path[0], big.NewInt(2)))
+		plan, err := p.Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+
+		_, _, argSlots, _, _, _ := DecodeCommand(plan.Commands[0])
+		slot := SlotIndex(argSlots[2])
+		if !slot.IsDynamic() {
+			t.Fatal("Expected path argument to use a dynamic slot")
+		}
+		entry := plan.State[slot.Index()]
+		values, err := abi.Arguments{{Type: addressArray}}.Unpack(append(common.LeftPadBytes([]byte{0x20}, 32), entry...))
+		if err != nil {
+			t.Fatalf("Unpack failed: %v", err)
+		}
+		if decoded := values[0].([]common.Address); len(decoded) != 3 || decoded[2] != path[2] {
+			t.Errorf("Expected path %v, got %v", path, decoded)
+		}
+	})
+}
+
+func TestRatio(t *testing.T) {
+	t.Run("scales a ratio", func(t *testing.T) {
+		lit, err := Ratio(big.NewInt(1), big.NewInt(2), 18)
+		if err != nil {
+			t.Fatalf("Ratio failed: %v", err)
+		}
+		want, _ := new(big.Int).SetString("500000000000000000", 10)
+		if got := new(big.Int).SetBytes(lit.Data()); got.Cmp(want) != 0 {
+			t.Errorf("Expected 5e17, got %s", got)
+		}
+		if lit.Type().String() != "uint256" {
+			t.Errorf("Expected uint256, got %s", lit.Type().String())
+		}
+	})
+
+	t.Run("rounds down", func(t *testing.T) {
+		lit, err := Ratio(big.NewInt(2), big.NewInt(3), 6)
+		if err != nil {
+			t.Fatalf("Ratio failed: %v", err)
+		}
+		if got := new(big.Int).SetBytes(lit.Data()); got.Int64() != 666666 {
+			t.Errorf("Expected 666666, got %s", got)
+		}
+	})
+
+	t.Run("rejects zero denominator", func(t *testing.T) {
+		_, err := Ratio(big.NewInt(1), big.NewInt(0), 18)
+		var encErr *EncodingError
+		if !errors.Is(err, ErrDivisionByZero) || !errors.As(err, &encErr) {
+			t.Errorf("Expected EncodingError wrapping ErrDivisionByZero, got %v", err)
+		}
+	})
+
+	t.Run("rejects out of range results", func(t *testing.T) {
+		if _, err := Ratio(big.NewInt(-1), big.NewInt(2), 18); !errors.Is(err, ErrIntegerOutOfRange) {
+			t.Errorf("Expected ErrIntegerOutOfRange for a negative result, got %v", err)
+		}
+		if _, err := Ratio(big.NewInt(1), big.NewInt(1), 78); !errors.Is(err, ErrIntegerOutOfRange) {
+			t.Errorf("Expected ErrIntegerOutOfRange for an overflowing result, got %v", err)
+		}
+		if _, err := Ratio(big.NewInt(1), big.NewInt(1), -1); !errors.Is(err, ErrIntegerOutOfRange) {
+			t.Errorf("Expected ErrIntegerOutOfRange for a negative scale, got %v", err)
+		}
+	})
+
+	t.Run("rejects nil", func(t *testing.T) {
+		if _, err := Ratio(nil, big.NewInt(1), 18); !errors.Is(err, ErrNilArgument) {
+			t.Errorf("Expected ErrNilArgument, got %v", err)
+		}
+	})
+}
+
+func TestEncodedCall(t *testing.T) {
+	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := NewContract(addr, MustParseABI(`[{
+		"type": "function",
+		"name": "mixed",
+		"inputs": [
+			{"name": "amount", "type": "uint256"},
+			{"name": "memo", "type": "string"},
+			{"name": "to", "type": "address"},
+			{"name": "data", "type": "bytes"}
+		],
+		"outputs": [{"name": "", "type": "uint256"}]
+	}]`))
+	method := contract.ABI().Methods["mixed"]
+
+	t.Run("round-trips selector and arguments", func(t *testing.T) {
+		to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		call := contract.MustInvoke("mixed", big.NewInt(42), "hello, world", to, []byte{1, 2, 3})
+
+		lit, err := EncodedCall(call)
+		if err != nil {
+			t.Fatalf("EncodedCall failed: %v", err)
+		}
+		if lit.Type().String() != "bytes" {
+			t.Errorf("Expected bytes literal, got %s", lit.Type())
+		}
+
+		bytesType, _ := abi.NewType("bytes", "", nil)
+		unpacked, err := abi.Arguments{{Type: bytesType}}.Unpack(lit.FullABIEncoding())
+		if err != nil {
+			t.Fatalf("Unpack bytes failed: %v", err)
+		}
+		calldata := unpacked[0].([]byte)
+
+		if !bytes.Equal(calldata[:4], method.ID) {
+			t.Errorf("Expected selector %x, got %x", method.ID, calldata[:4])
+		}
+		args, err := method.Inputs.Unpack(calldata[4:])
+		if err != nil {
+			t.Fatalf("Unpack arguments failed: %v", err)
+		}
+		if args[0].(*big.Int).Int64() != 42 {
+			t.Errorf("Expected amount 42, got %v", args[0])
+		}
+		if args[1].(string) != "hello, world" {
+			t.Errorf("Expected memo, got %v", args[1])
+		}
+		if args[2].(common.Address) != to {
+			t.Errorf("Expected to %s, got %v", to.Hex(), args[2])
+		}
+		if !bytes.Equal(args[3].([]byte), []byte{1, 2, 3}) {
+			t.Errorf("Expected data 010203, got %x", args[3])
+		}
+
+		expected, err := method.Inputs.Pack(big.NewInt(42), "hello, world", to, []byte{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Pack failed: %v", err)
+		}
+		if !bytes.Equal(calldata[4:], expected) {
+			t.Errorf("Expected calldata to match abi packing:\n got %x\nwant %x", calldata[4:], expected)
+		}
+	})
+
+	t.Run("rejects non-literal arguments", func(t *testing.T) {
+		p := New()
+		sum := p.Add(NewLibrary(addr, plannerTestABI()).MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		call := contract.MustInvoke("mixed", sum, "memo", addr, []byte{})
+
+		_, err := EncodedCall(call)
+		if !errors.Is(err, ErrNonLiteralArgument) {
+			t.Fatalf("Expected ErrNonLiteralArgument, got %v", err)
+		}
+		var argErr *ArgumentError
+		if !errors.As(err, &argErr) || argErr.Index != 0 {
+			t.Errorf("Expected ArgumentError for argument 0, got %v", err)
+		}
+	})
+}
+
+func TestReturnValueAt(t *testing.T) {
+	contract := NewContract(common.HexToAddress("0x1234567890123456789012345678901234567890"), testABI())
+
+	t.Run("indexes raw return outputs", func(t *testing.T) {
+		p := New()
+		pair := p.AddCapture(contract.MustInvoke("multiReturn").RawReturn(), true)
+
+		second, err := pair.At(1)
+		if err != nil {
+			t.Fatalf("At failed: %v", err)
+		}
+		if second.Index() != 1 || second.Type().String() != "bool" || second.Command() != pair.Command() {
+			t.Errorf("Expected output 1 of the same command as bool, got index %d type %s", second.Index(), second.Type().String())
+		}
+		first, err := pair.At(0)
+		if err != nil {
+			t.Fatalf("At failed: %v", err)
+		}
+		if first.Type().String() != "uint256" {
+			t.Errorf("Expected uint256, got %s", first.Type().String())
+		}
+
+		// Every output resolves to the command's one return slot
+		if _, err := p.Plan(); err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		sm := newStateManager(defaultPlanConfig())
+		if _, err := sm.allocateReturn(pair.Command(), -1, false); err != nil {
+			t.Fatalf("allocateReturn failed: %v", err)
+		}
+		slot0, err0 := sm.getSlotForValue(first)
+		slot1, err1 := sm.getSlotForValue(second)
+		if err0 != nil || err1 != nil || slot0 != slot1 {
+			t.Errorf("Expected outputs to share a slot, got %d (%v) and %d (%v)", slot0, err0, slot1, err1)
+		}
+	})
+
+	t.Run("single output at zero", func(t *testing.T) {
+		sum := New().Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		rv, err := sum.At(0)
+		if err != nil || rv.Type().String() != "uint256" {
+			t.Errorf("Expected uint256 output 0, got %v", err)
+		}
+	})
+
+	t.Run("rejects out of range index", func(t *testing.T) {
+		sum := New().Add(contract.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		for _, i := range []int{-1, 1} {
+			if _, err := sum.At(i); !errors.Is(err, ErrOutputIndexOutOfRange) {
+				t.Errorf("At(%d): expected ErrOutputIndexOutOfRange, got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("requires raw return for multiple outputs", func(t *testing.T) {
+		pair := New().Add(contract.MustInvoke("multiReturn"))
+		if _, err := pair.At(1); !errors.Is(err, ErrTupleReturnRequired) {
+			t.Errorf("Expected ErrTupleReturnRequired, got %v", err)
+		}
+	})
+
+	t.Run("rejects raw return output as argument", func(t *testing.T) {
+		pool := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), MustParseABI(`[{
+			"type": "function",
+			"name": "pair",
+			"stateMutability": "view",
+			"inputs": [],
+			"outputs": [{"name": "", "type": "uint256"}, {"name": "", "type": "bytes"}]
+		}, {
+			"type": "function",
+			"name": "consume",
+			"inputs": [{"name": "data", "type": "bytes"}],
+			"outputs": []
+		}]`))
+
+		p := New()
+		pair := p.Add(pool.MustInvoke("pair").RawReturn())
+		data, err := pair.At(1)
+		if err != nil {
+			t.Fatalf("At failed: %v", err)
+		}
+		p.Add(pool.MustInvoke("consume", data))
+
+		_, err = p.Plan()
+		if !errors.Is(err, ErrRawReturnArgument) {
+			t.Fatalf("Expected ErrRawReturnArgument, got %v", err)
+		}
+		var planErr *PlanError
+		if !errors.As(err, &planErr) || planErr.CommandIndex != 1 {
+			t.Errorf("Expected PlanError for command 1, got %v", err)
+		}
+	})
+}
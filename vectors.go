@@ -0,0 +1,185 @@
+package weiroll
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// TestVector is a plan together with the commands and state it compiles to
+// with default options, for checking a weiroll VM implementation's decoding
+// against this planner's encoding.
+type TestVector struct {
+	Name        string
+	Description string
+	Planner     *Planner
+	Commands    [][]byte // Expected CompiledPlan.Commands
+	State       [][]byte // Expected CompiledPlan.State
+}
+
+// vectorABI is the ABI of the contract every test vector calls.
+const vectorABI = `[
+	{"type":"function","name":"add","stateMutability":"pure","inputs":[{"name":"a","type":"uint256"},{"name":"b","type":"uint256"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"concat","stateMutability":"pure","inputs":[{"name":"a","type":"string"},{"name":"b","type":"string"}],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"deposit","stateMutability":"payable","inputs":[],"outputs":[]},
+	{"type":"function","name":"depositFor","stateMutability":"payable","inputs":[{"name":"recipient","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[]},
+	{"type":"function","name":"sum8","stateMutability":"pure","inputs":[
+		{"name":"a","type":"uint256"},{"name":"b","type":"uint256"},{"name":"c","type":"uint256"},{"name":"d","type":"uint256"},
+		{"name":"e","type":"uint256"},{"name":"f","type":"uint256"},{"name":"g","type":"uint256"},{"name":"h","type":"uint256"}
+	],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"execute","inputs":[{"name":"commands","type":"bytes32[]"},{"name":"state","type":"bytes[]"}],"outputs":[{"name":"","type":"bytes[]"}]}
+]`
+
+var (
+	vectorLibrary  = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	vectorExternal = common.HexToAddress("0x2222222222222222222222222222222222222222")
+)
+
+// TestVectors returns a curated set of plans and their expected compiled
+// output, covering literals, return value chaining, dynamic types, value
+// transfer, extended commands and subplans. Each call returns fresh
+// planners, so callers may modify them.
+func TestVectors() []TestVector {
+	contractABI := MustParseABI(vectorABI)
+	lib := NewLibrary(vectorLibrary, contractABI)
+	ext := NewContract(vectorExternal, contractABI)
+
+	literals := New()
+	literals.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+
+	chaining := New()
+	sum := chaining.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	chaining.Add(ext.MustInvoke("add", sum, big.NewInt(3)))
+
+	dynamic := New()
+	greeting := dynamic.Add(lib.MustInvoke("concat", "hello, ", "world"))
+	dynamic.Add(ext.MustInvoke("concat", greeting, "!").Static())
+
+	value := New()
+	value.Add(ext.MustInvoke("deposit").WithValue(big.NewInt(1e18)))
+
+	valueArgs := New()
+	valueArgs.Add(ext.MustInvoke("depositFor", vectorLibrary, big.NewInt(5)).WithValue(big.NewInt(1e18)))
+
+	extended := New()
+	extended.Add(lib.MustInvoke("sum8",
+		big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4),
+		big.NewInt(5), big.NewInt(6), big.NewInt(7), big.NewInt(8)))
+
+	subplan := New()
+	sub := New()
+	sub.Add(lib.MustInvoke("add", big.NewInt(4), big.NewInt(5)))
+	if _, err := subplan.AddSubplan(lib.MustInvoke("execute", sub.Subplan(), subplan.State()), sub); err != nil {
+		panic(err)
+	}
+
+	return []TestVector{
+		{
+			Name:        "literals",
+			Description: "delegatecall with two static literal arguments and an unused return value",
+			Planner:     literals,
+			Commands: hexList(
+				"0x771602f7000001ffffffffff1111111111111111111111111111111111111111",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000000000000000001",
+				"0x0000000000000000000000000000000000000000000000000000000000000002",
+			),
+		},
+		{
+			Name:        "chaining",
+			Description: "a return value stored in state and passed to a later call",
+			Planner:     chaining,
+			Commands: hexList(
+				"0x771602f7000102ffffffff001111111111111111111111111111111111111111",
+				"0x771602f7010003ffffffffff2222222222222222222222222222222222222222",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000000000000000000",
+				"0x0000000000000000000000000000000000000000000000000000000000000001",
+				"0x0000000000000000000000000000000000000000000000000000000000000002",
+				"0x0000000000000000000000000000000000000000000000000000000000000003",
+			),
+		},
+		{
+			Name:        "dynamic",
+			Description: "dynamic string literals and return value, with the slot flag set, and a staticcall",
+			Planner:     dynamic,
+			Commands: hexList(
+				"0x89c19ddb008182ffffffff801111111111111111111111111111111111111111",
+				"0x89c19ddb028083ffffffffff2222222222222222222222222222222222222222",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000000000000000000",
+				"0x000000000000000000000000000000000000000000000000000000000000000768656c6c6f2c2000000000000000000000000000000000000000000000000000",
+				"0x0000000000000000000000000000000000000000000000000000000000000005776f726c64000000000000000000000000000000000000000000000000000000",
+				"0x00000000000000000000000000000000000000000000000000000000000000012100000000000000000000000000000000000000000000000000000000000000",
+			),
+		},
+		{
+			Name:        "value",
+			Description: "call with ETH value, passed in the first argument slot",
+			Planner:     value,
+			Commands: hexList(
+				"0xd0e30db00300ffffffffffff2222222222222222222222222222222222222222",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000de0b6b3a7640000",
+			),
+		},
+		{
+			Name:        "value with arguments",
+			Description: "payable call whose value slot precedes its two argument slots",
+			Planner:     valueArgs,
+			Commands: hexList(
+				"0x2f4f21e203000102ffffffff2222222222222222222222222222222222222222",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000de0b6b3a7640000",
+				"0x0000000000000000000000001111111111111111111111111111111111111111",
+				"0x0000000000000000000000000000000000000000000000000000000000000005",
+			),
+		},
+		{
+			Name:        "extended",
+			Description: "eight arguments, which need the 64-byte extended command form",
+			Planner:     extended,
+			Commands: hexList(
+				"0xecce14af40000102030405ff11111111111111111111111111111111111111110607ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000000000000000001",
+				"0x0000000000000000000000000000000000000000000000000000000000000002",
+				"0x0000000000000000000000000000000000000000000000000000000000000003",
+				"0x0000000000000000000000000000000000000000000000000000000000000004",
+				"0x0000000000000000000000000000000000000000000000000000000000000005",
+				"0x0000000000000000000000000000000000000000000000000000000000000006",
+				"0x0000000000000000000000000000000000000000000000000000000000000007",
+				"0x0000000000000000000000000000000000000000000000000000000000000008",
+			),
+		},
+		{
+			Name:        "subplan",
+			Description: "a nested plan passed as a bytes32[] literal alongside the state marker",
+			Planner:     subplan,
+			Commands: hexList(
+				"0xde792d5f0082feffffffffff1111111111111111111111111111111111111111",
+			),
+			State: hexList(
+				"0x0000000000000000000000000000000000000000000000000000000000000004",
+				"0x0000000000000000000000000000000000000000000000000000000000000005",
+				"0x0000000000000000000000000000000000000000000000000000000000000001771602f7000001ffffffffff1111111111111111111111111111111111111111",
+			),
+		},
+	}
+}
+
+// hexList decodes a list of 0x-prefixed hex strings.
+func hexList(items ...string) [][]byte {
+	out := make([][]byte, len(items))
+	for i, s := range items {
+		out[i] = hexutil.MustDecode(s)
+	}
+	return out
+}
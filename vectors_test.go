@@ -0,0 +1,63 @@
+package weiroll
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTestVectors(t *testing.T) {
+	vectors := TestVectors()
+
+	t.Run("recompiling reproduces stored output", func(t *testing.T) {
+		for _, v := range vectors {
+			plan, err := v.Planner.Plan()
+			if err != nil {
+				t.Fatalf("%s: Plan failed: %v", v.Name, err)
+			}
+			if !equalByteSlices(plan.Commands, v.Commands) {
+				t.Errorf("%s: commands mismatch:\n got %x\nwant %x", v.Name, plan.Commands, v.Commands)
+			}
+			if !equalByteSlices(plan.State, v.State) {
+				t.Errorf("%s: state mismatch:\n got %x\nwant %x", v.Name, plan.State, v.State)
+			}
+		}
+	})
+
+	t.Run("stored commands decode", func(t *testing.T) {
+		for _, v := range vectors {
+			for i, cmd := range v.Commands {
+				if _, _, _, _, _, err := DecodeCommand(cmd); err != nil {
+					t.Errorf("%s: command %d: %v", v.Name, i, err)
+				}
+			}
+		}
+	})
+
+	t.Run("names are unique", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, v := range vectors {
+			if seen[v.Name] {
+				t.Errorf("Duplicate vector %q", v.Name)
+			}
+			seen[v.Name] = true
+		}
+	})
+
+	t.Run("returns fresh planners", func(t *testing.T) {
+		if TestVectors()[0].Planner == vectors[0].Planner {
+			t.Error("Expected a new planner on each call")
+		}
+	})
+}
+
+func equalByteSlices(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
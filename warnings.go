@@ -0,0 +1,118 @@
+package weiroll
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// WarningCategory classifies a Warning.
+type WarningCategory string
+
+const (
+	// WarningDeadCall marks a view or pure call whose return value nothing
+	// uses or captures, so the command has no effect.
+	WarningDeadCall WarningCategory = "dead-call"
+
+	// WarningRedundantCall marks a view or pure call that repeats an earlier
+	// one, which WithCommandDeduplication would drop.
+	WarningRedundantCall WarningCategory = "redundant-call"
+
+	// WarningUnsafeStatic marks a STATICCALL of a method not declared view
+	// or pure, which reverts if the method writes state.
+	WarningUnsafeStatic WarningCategory = "unsafe-static"
+
+	// WarningZeroAddress marks a call whose target or a literal address
+	// argument is the zero address.
+	WarningZeroAddress WarningCategory = "zero-address"
+
+	// WarningInfiniteApproval marks an approve(address,uint256) call whose
+	// amount is the max uint256 literal, an unlimited allowance that stays
+	// exploitable if the spender is compromised.
+	WarningInfiniteApproval WarningCategory = "infinite-approval"
+)
+
+// Warning is a non-fatal issue found by PlanWithWarnings or Lint.
+type Warning struct {
+	Category     WarningCategory
+	CommandIndex int
+	Message      string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("command %d: %s: %s", w.CommandIndex, w.Category, w.Message)
+}
+
+// PlanWithWarnings is like Plan but also returns non-fatal issues with the
+// top-level commands, in command order. Fatal issues still fail
+// compilation, in which case no warnings are returned.
+func (p *Planner) PlanWithWarnings(opts ...PlanOption) (*CompiledPlan, []Warning, error) {
+	cp, err := p.Plan(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cp, p.warnings(), nil
+}
+
+// Lint returns the warnings PlanWithWarnings would report, without
+// compiling the plan.
+func (p *Planner) Lint() []Warning {
+	return p.warnings()
+}
+
+// warnings collects the warnings for the top-level commands.
+func (p *Planner) warnings() []Warning {
+	used := make(map[*Command]bool)
+	p.walkCommands(func(cmd *Command) {
+		for _, v := range cmd.call.values() {
+			if rv, ok := v.(*ReturnValue); ok {
+				used[rv.command] = true
+			}
+		}
+	})
+
+	indices := make(map[*Command]int, len(p.commands))
+	for i, cmd := range p.commands {
+		indices[cmd] = i
+	}
+	_, aliases := deduplicateCommands(p.commands)
+
+	var warnings []Warning
+	warn := func(category WarningCategory, i int, format string, args ...any) {
+		warnings = append(warnings, Warning{Category: category, CommandIndex: i, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, cmd := range p.commands {
+		call := cmd.call
+		sig := call.method.Sig
+
+		if kept, ok := aliases[cmd]; ok {
+			warn(WarningRedundantCall, i, "%s repeats command %d", sig, indices[kept])
+		} else if cmd.isSideEffectFree() && !used[cmd] && cmd.capture != captureForce {
+			warn(WarningDeadCall, i, "result of %s %s call is unused", call.method.StateMutability, sig)
+		}
+
+		if call.flags.CallType() == FlagStaticCall &&
+			call.method.StateMutability != "view" && call.method.StateMutability != "pure" {
+			warn(WarningUnsafeStatic, i, "%s is %s but called with STATICCALL", sig, call.method.StateMutability)
+		}
+
+		if !call.contract.IsDynamic() && call.contract.Address() == (common.Address{}) {
+			warn(WarningZeroAddress, i, "%s targets the zero address", sig)
+		}
+		for j, arg := range call.args {
+			lit, ok := arg.(*LiteralValue)
+			if ok && lit.abiType.String() == "address" && common.BytesToAddress(lit.data) == (common.Address{}) {
+				warn(WarningZeroAddress, i, "argument %d (%s) of %s is the zero address", j, call.method.Inputs[j].Name, sig)
+			}
+		}
+
+		if sig == "approve(address,uint256)" {
+			if amount := literalUint(call.args[1]); amount != nil && amount.Cmp(math.MaxBig256) == 0 {
+				warn(WarningInfiniteApproval, i, "%s grants an unlimited allowance", sig)
+			}
+		}
+	}
+	return warnings
+}
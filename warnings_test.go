@@ -0,0 +1,117 @@
+package weiroll
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+func TestPlannerPlanWithWarnings(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1111111111111111111111111111111111111111"), plannerTestABI())
+	token := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), MustParseABI(testABIJSON))
+
+	t.Run("reports dead call and zero address", func(t *testing.T) {
+		p := New()
+		p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(token.MustInvoke("transfer", common.Address{}, big.NewInt(100)))
+
+		plan, warnings, err := p.PlanWithWarnings()
+		if err != nil {
+			t.Fatalf("PlanWithWarnings failed: %v", err)
+		}
+		if len(plan.Commands) != 2 {
+			t.Errorf("Expected 2 commands, got %d", len(plan.Commands))
+		}
+		if len(warnings) != 2 {
+			t.Fatalf("Expected 2 warnings, got %v", warnings)
+		}
+		if w := warnings[0]; w.Category != WarningDeadCall || w.CommandIndex != 0 {
+			t.Errorf("Expected dead call warning for command 0, got %v", w)
+		}
+		if w := warnings[1]; w.Category != WarningZeroAddress || w.CommandIndex != 1 {
+			t.Errorf("Expected zero address warning for command 1, got %v", w)
+		}
+	})
+
+	t.Run("used and captured results are not dead", func(t *testing.T) {
+		p := New()
+		sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.AddCapture(lib.MustInvoke("multiply", sum, big.NewInt(3)), true)
+
+		_, warnings, err := p.PlanWithWarnings()
+		if err != nil {
+			t.Fatalf("PlanWithWarnings failed: %v", err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("Expected no warnings, got %v", warnings)
+		}
+	})
+
+	t.Run("reports redundant and unsafe static calls", func(t *testing.T) {
+		p := New()
+		first := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		second := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(token.MustInvoke("transfer", common.HexToAddress("0x3333333333333333333333333333333333333333"), first).Static())
+		p.Add(token.MustInvoke("transfer", common.HexToAddress("0x3333333333333333333333333333333333333333"), second))
+
+		_, warnings, err := p.PlanWithWarnings()
+		if err != nil {
+			t.Fatalf("PlanWithWarnings failed: %v", err)
+		}
+		if len(warnings) != 2 {
+			t.Fatalf("Expected 2 warnings, got %v", warnings)
+		}
+		if w := warnings[0]; w.Category != WarningRedundantCall || w.CommandIndex != 1 {
+			t.Errorf("Expected redundant call warning for command 1, got %v", w)
+		}
+		if w := warnings[1]; w.Category != WarningUnsafeStatic || w.CommandIndex != 2 {
+			t.Errorf("Expected unsafe static warning for command 2, got %v", w)
+		}
+	})
+
+	t.Run("fatal errors return no warnings", func(t *testing.T) {
+		p := New()
+		foreign := New().Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+		p.Add(lib.MustInvoke("add", foreign, big.NewInt(0)))
+
+		plan, warnings, err := p.PlanWithWarnings()
+		if !errors.Is(err, ErrReturnValueNotVisible) || plan != nil || warnings != nil {
+			t.Errorf("Expected ErrReturnValueNotVisible only, got %v, %v", err, warnings)
+		}
+	})
+}
+
+func TestPlannerLint(t *testing.T) {
+	const approveABIJSON = `[
+		{"type":"function","name":"approve","stateMutability":"nonpayable",
+		 "inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],
+		 "outputs":[{"name":"","type":"bool"}]}
+	]`
+	token := NewContract(common.HexToAddress("0x2222222222222222222222222222222222222222"), MustParseABI(approveABIJSON))
+	router := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
+
+	t.Run("flags infinite approvals", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("approve", router, big.NewInt(1000)))
+		p.Add(token.MustInvoke("approve", router, math.MaxBig256))
+
+		warnings := p.Lint()
+		if len(warnings) != 1 {
+			t.Fatalf("Expected 1 warning, got %v", warnings)
+		}
+		if w := warnings[0]; w.Category != WarningInfiniteApproval || w.CommandIndex != 1 {
+			t.Errorf("Expected infinite approval warning for command 1, got %v", w)
+		}
+	})
+
+	t.Run("exact approval is clean", func(t *testing.T) {
+		p := New()
+		p.Add(token.MustInvoke("approve", router, new(big.Int).Sub(math.MaxBig256, big.NewInt(1))))
+		if warnings := p.Lint(); len(warnings) != 0 {
+			t.Errorf("Expected no warnings, got %v", warnings)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package weiroll
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// weirollJSONPlan is the plan layout used by weiroll.js: commands as
+// bytes32 words and state as byte strings, all 0x-prefixed lowercase hex.
+type weirollJSONPlan struct {
+	Commands []string `json:"commands"`
+	State    []string `json:"state"`
+}
+
+// ToWeirollJSON encodes the plan as {"commands": [...], "state": [...]},
+// the format produced by weiroll.js. Extended commands appear as two
+// consecutive command words, as in CommandsAsBytes32.
+func (cp *CompiledPlan) ToWeirollJSON() ([]byte, error) {
+	words := cp.CommandsAsBytes32()
+	out := weirollJSONPlan{
+		Commands: make([]string, len(words)),
+		State:    make([]string, len(cp.State)),
+	}
+	for i, word := range words {
+		out.Commands[i] = hexutil.Encode(word[:])
+	}
+	for i, s := range cp.State {
+		out.State[i] = hexutil.Encode(s)
+	}
+	return json.Marshal(out)
+}
+
+// MarshalJSON implements json.Marshaler using the ToWeirollJSON format, for
+// storing plans or sending them to a frontend. Like MarshalBinary, it
+// keeps only the commands and state, not Config or a salt.
+func (cp *CompiledPlan) MarshalJSON() ([]byte, error) {
+	return cp.ToWeirollJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the format written
+// by MarshalJSON. An extended command's two words are joined back into one
+// 64-byte command, and empty state entries become nil, as in a compiled
+// plan's unfilled return slots. Unlike DecodePlan it doesn't check slot
+// references, so plans for VMs with other encodings round-trip. Malformed
+// input returns an error wrapping ErrInvalidJSONPlan and leaves the plan
+// unchanged.
+func (cp *CompiledPlan) UnmarshalJSON(data []byte) error {
+	var in weirollJSONPlan
+	if err := json.Unmarshal(data, &in); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidJSONPlan, err)
+	}
+
+	commands := make([][]byte, 0, len(in.Commands))
+	for i := 0; i < len(in.Commands); i++ {
+		cmd, err := decodeCommandWord(in.Commands, i)
+		if err != nil {
+			return err
+		}
+		if CallFlags(cmd[4]).IsExtended() {
+			if i+1 >= len(in.Commands) {
+				return fmt.Errorf("%w: command %d: extended command missing argument word", ErrInvalidJSONPlan, len(commands))
+			}
+			i++
+			word, err := decodeCommandWord(in.Commands, i)
+			if err != nil {
+				return err
+			}
+			cmd = append(cmd, word...)
+		}
+		commands = append(commands, cmd)
+	}
+
+	state := make([][]byte, len(in.State))
+	for i, s := range in.State {
+		entry, err := hexutil.Decode(s)
+		if err != nil {
+			return fmt.Errorf("%w: state %d: %w", ErrInvalidJSONPlan, i, err)
+		}
+		if len(entry) > 0 {
+			state[i] = entry
+		}
+	}
+
+	cp.Commands = commands
+	cp.State = state
+	return nil
+}
+
+// decodeCommandWord decodes the i-th command word of a JSON plan, which
+// must be exactly 32 bytes.
+func decodeCommandWord(words []string, i int) ([]byte, error) {
+	word, err := hexutil.Decode(words[i])
+	if err != nil {
+		return nil, fmt.Errorf("%w: command word %d: %w", ErrInvalidJSONPlan, i, err)
+	}
+	if len(word) != CommandSize {
+		return nil, fmt.Errorf("%w: command word %d holds %d bytes", ErrInvalidJSONPlan, i, len(word))
+	}
+	return word, nil
+}
@@ -0,0 +1,183 @@
+package weiroll
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	_ json.Marshaler   = (*CompiledPlan)(nil)
+	_ json.Unmarshaler = (*CompiledPlan)(nil)
+)
+
+func TestCompiledPlanToWeirollJSON(t *testing.T) {
+	addr := common.HexToAddress("0xABCDEF0123456789ABCDEF0123456789ABCDEF01")
+	lib := NewLibrary(addr, plannerTestABI())
+
+	p := New()
+	sum := p.Add(lib.MustInvoke("add", big.NewInt(1), big.NewInt(2)))
+	p.Add(lib.MustInvoke("multiply", sum, big.NewInt(10)))
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	data, err := plan.ToWeirollJSON()
+	if err != nil {
+		t.Fatalf("ToWeirollJSON failed: %v", err)
+	}
+
+	var parsed struct {
+		Commands []string `json:"commands"`
+		State    []string `json:"state"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	t.Run("uses lowercase 0x hex", func(t *testing.T) {
+		for _, s := range append(append([]string{}, parsed.Commands...), parsed.State...) {
+			if !strings.HasPrefix(s, "0x") || s != strings.ToLower(s) {
+				t.Errorf("Expected 0x-prefixed lowercase hex, got %q", s)
+			}
+		}
+	})
+
+	t.Run("round-trips through DecodePlan", func(t *testing.T) {
+		commands := make([][32]byte, len(parsed.Commands))
+		for i, s := range parsed.Commands {
+			word, err := hexutil.Decode(s)
+			if err != nil || len(word) != 32 {
+				t.Fatalf("Command %d: invalid word %q", i, s)
+			}
+			copy(commands[i][:], word)
+		}
+		state := make([][]byte, len(parsed.State))
+		for i, s := range parsed.State {
+			if state[i], err = hexutil.Decode(s); err != nil {
+				t.Fatalf("State %d: invalid hex %q", i, s)
+			}
+		}
+
+		decoded, err := DecodePlan(commands, state)
+		if err != nil {
+			t.Fatalf("DecodePlan failed: %v", err)
+		}
+		if decoded.ID() != plan.ID() {
+			t.Error("Expected round-tripped plan to equal the original")
+		}
+	})
+
+	t.Run("splits extended commands into words", func(t *testing.T) {
+		encoder := NewCommandEncoder()
+		ext := &CompiledPlan{
+			Commands: [][]byte{encoder.EncodeExtended([4]byte{}, FlagCall, make([]uint8, 7), NoReturnSlot, addr)},
+			State:    [][]byte{{}},
+		}
+		data, err := ext.ToWeirollJSON()
+		if err != nil {
+			t.Fatalf("ToWeirollJSON failed: %v", err)
+		}
+		if !strings.Contains(string(data), `"state":["0x"]`) {
+			t.Errorf("Expected empty state entry as 0x, got %s", data)
+		}
+		var out struct{ Commands []string }
+		if err := json.Unmarshal(data, &out); err != nil || len(out.Commands) != 2 {
+			t.Errorf("Expected 2 command words, got %v (%v)", out.Commands, err)
+		}
+	})
+}
+
+func TestCompiledPlanJSON(t *testing.T) {
+	lib := NewLibrary(common.HexToAddress("0x1234567890123456789012345678901234567890"), MustParseABI(vectorABI))
+
+	p := New()
+	p.Add(lib.MustInvoke("concat", "hello, ", "world"))
+	p.Add(lib.MustInvoke("sum8",
+		big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4),
+		big.NewInt(5), big.NewInt(6), big.NewInt(7), big.NewInt(8)))
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	t.Run("uses the weiroll.js format", func(t *testing.T) {
+		expected, err := plan.ToWeirollJSON()
+		if err != nil {
+			t.Fatalf("ToWeirollJSON failed: %v", err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Expected %s, got %s", expected, data)
+		}
+	})
+
+	t.Run("round-trips byte-exact", func(t *testing.T) {
+		var decoded CompiledPlan
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if len(decoded.Commands) != 2 || len(decoded.Commands[1]) != ExtendedCommandSize {
+			t.Fatalf("Expected one standard and one extended command, got %d commands", len(decoded.Commands))
+		}
+
+		words, expectedWords := decoded.CommandsAsBytes32(), plan.CommandsAsBytes32()
+		if len(words) != len(expectedWords) {
+			t.Fatalf("Expected %d command words, got %d", len(expectedWords), len(words))
+		}
+		for i := range words {
+			if words[i] != expectedWords[i] {
+				t.Errorf("Command word %d differs", i)
+			}
+		}
+		if !equalByteSlices(decoded.StateAsBytes(), plan.StateAsBytes()) {
+			t.Error("Expected decoded state to equal the original")
+		}
+		if decoded.ID() != plan.ID() {
+			t.Error("Expected decoded plan to have the original ID")
+		}
+	})
+
+	t.Run("keeps empty state slots", func(t *testing.T) {
+		var decoded CompiledPlan
+		if err := json.Unmarshal([]byte(`{"commands":[],"state":["0x","0x01"]}`), &decoded); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if len(decoded.State) != 2 || decoded.State[0] != nil || !bytes.Equal(decoded.State[1], []byte{1}) {
+			t.Errorf("Expected [nil 0x01], got %x", decoded.State)
+		}
+	})
+
+	t.Run("rejects malformed input", func(t *testing.T) {
+		word := hexutil.Encode(make([]byte, 32))
+		extended := make([]byte, 32)
+		extended[4] = byte(FlagCall | FlagExtendedCommand)
+
+		for name, input := range map[string]string{
+			"not json":              `[1, 2]`,
+			"bad hex":               `{"commands":["0xzz"],"state":[]}`,
+			"short word":            `{"commands":["0x01"],"state":[]}`,
+			"missing extended word": `{"commands":["` + hexutil.Encode(extended) + `"],"state":[]}`,
+			"bad state hex":         `{"commands":["` + word + `"],"state":["01"]}`,
+		} {
+			decoded := CompiledPlan{Commands: [][]byte{{1}}}
+			if err := json.Unmarshal([]byte(input), &decoded); !errors.Is(err, ErrInvalidJSONPlan) {
+				t.Errorf("%s: expected ErrInvalidJSONPlan, got %v", name, err)
+			}
+			if len(decoded.Commands) != 1 {
+				t.Errorf("%s: expected plan to be left unchanged", name)
+			}
+		}
+	})
+}